@@ -0,0 +1,85 @@
+package airports
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/arinc424"
+)
+
+// Airport is the records of an airport, grouped by type in the order they
+// appear in the data.
+type Airport struct {
+	Airport    *arinc424.Airport
+	Runways    []*arinc424.Runway
+	Localizers []*arinc424.Localizer
+	GLS        []*arinc424.GLS
+	Waypoints  []*arinc424.TerminalWaypoint
+	// Procedures are the legs of every SID, STAR and approach.
+	Procedures []*arinc424.Procedure
+	PathPoints []*arinc424.PathPoint
+	MSAs       []*arinc424.MSA
+	// Other are the records without a field above, such as continuation
+	// records that follow no primary record.
+	Other []arinc424.Record
+}
+
+// Read reads the records of the airport icao from r, ignoring those of
+// other airports. It returns ErrNoAirport if there is no reference point
+// record for icao.
+func Read(r io.Reader, icao string) (*Airport, error) {
+	a := &Airport{}
+	rd := arinc424.NewReader(r)
+	for {
+		rec, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read records: %v", err)
+		}
+		a.add(rec, icao)
+	}
+	if a.Airport == nil {
+		return nil, ErrNoAirport
+	}
+	return a, nil
+}
+
+func (a *Airport) add(rec arinc424.Record, icao string) {
+	if arinc424.AirportOf(rec) != icao {
+		return
+	}
+	switch r := rec.(type) {
+	case *arinc424.Airport:
+		a.Airport = r
+	case *arinc424.Runway:
+		a.Runways = append(a.Runways, r)
+	case *arinc424.Localizer:
+		a.Localizers = append(a.Localizers, r)
+	case *arinc424.GLS:
+		a.GLS = append(a.GLS, r)
+	case *arinc424.TerminalWaypoint:
+		a.Waypoints = append(a.Waypoints, r)
+	case *arinc424.Procedure:
+		a.Procedures = append(a.Procedures, r)
+	case *arinc424.PathPoint:
+		a.PathPoints = append(a.PathPoints, r)
+	case *arinc424.MSA:
+		a.MSAs = append(a.MSAs, r)
+	default:
+		a.Other = append(a.Other, r)
+	}
+}
+
+// Legs returns the legs of the procedures with the identifier id, such as
+// L28L, in order.
+func (a *Airport) Legs(id string) []*arinc424.Procedure {
+	var legs []*arinc424.Procedure
+	for _, p := range a.Procedures {
+		if p.Ident == id {
+			legs = append(legs, p)
+		}
+	}
+	return legs
+}
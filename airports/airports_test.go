@@ -0,0 +1,324 @@
+package airports
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/arinc424"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/blob/blobtest"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+)
+
+// testData returns the processed data of KHWD followed by the reference
+// point of KBFI and another runway of KHWD.
+func testData(t *testing.T) string {
+	t.Helper()
+	b, err := ioutil.ReadFile("../pipeline/want_processed.txt")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	data := string(b)
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	return data + strings.Join([]string{
+		strings.Replace(strings.Replace(lines[5], "KHWDK2", "KBFIK1", 1), "HAYWARD EXECUTIVE", "BOEING FIELD/KING", 1),
+		strings.Replace(lines[96], "RW28R", "RW99X", 1),
+	}, "\n") + "\n"
+}
+
+func TestBuild(t *testing.T) {
+	ix, err := Build(strings.NewReader(testData(t)))
+	if err != nil {
+		t.Fatalf("Build() = _, %v want _, <nil>", err)
+	}
+	const line = 133
+	want := &Index{Airports: map[string]*Entry{
+		"KHWD": {ICAO: "KHWD", Name: "HAYWARD EXECUTIVE", Ranges: []Range{{Offset: 5 * line, Length: 98 * line}, {Offset: 104 * line, Length: line}}},
+		"KBFI": {ICAO: "KBFI", Name: "BOEING FIELD/KING", Ranges: []Range{{Offset: 103 * line, Length: line}}},
+	}}
+	if diff := cmp.Diff(want, ix); diff != "" {
+		t.Errorf("Build() returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestBuildUnparsableAirport(t *testing.T) {
+	// A reference point with a truncated name is still indexed.
+	data := strings.Replace(testData(t), "BOEING FIELD/KING", "BOEING", 1)
+	ix, err := Build(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Build() = _, %v want _, <nil>", err)
+	}
+	if e := ix.Airports["KBFI"]; e == nil || e.Name != "" {
+		t.Errorf("Build() indexed KBFI as %+v want an entry without a name", e)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ix := &Index{Airports: map[string]*Entry{
+		"KHWD":  {ICAO: "KHWD", Name: "HAYWARD EXECUTIVE"},
+		"KHAF":  {ICAO: "KHAF", Name: "HALF MOON BAY"},
+		"KOAK":  {ICAO: "KOAK", Name: "METROPOLITAN OAKLAND INTL"},
+		"KHWDX": {ICAO: "KHWDX", Name: "KHWD HELIPORT"},
+	}}
+	for _, tc := range []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{query: "khwd", limit: 10, want: []string{"KHWD", "KHWDX"}},
+		{query: "KH", limit: 10, want: []string{"KHAF", "KHWD", "KHWDX"}},
+		{query: "KH", limit: 2, want: []string{"KHAF", "KHWD"}},
+		{query: "oakland", limit: 10, want: []string{"KOAK"}},
+		{query: " ", limit: 10},
+		{query: "KJFK", limit: 10},
+	} {
+		var got []string
+		for _, e := range ix.Search(tc.query, tc.limit) {
+			got = append(got, e.ICAO)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Search(%q, %d) returned diff (-want +got):\n%s", tc.query, tc.limit, diff)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	a, err := Read(strings.NewReader(testData(t)), "KHWD")
+	if err != nil {
+		t.Fatalf("Read() = _, %v want _, <nil>", err)
+	}
+	got := map[string]int{
+		"runways":     len(a.Runways),
+		"localizers":  len(a.Localizers),
+		"gls":         len(a.GLS),
+		"waypoints":   len(a.Waypoints),
+		"procedures":  len(a.Procedures),
+		"path points": len(a.PathPoints),
+		"msas":        len(a.MSAs),
+		"other":       len(a.Other),
+	}
+	want := map[string]int{
+		"runways":     5,
+		"localizers":  1,
+		"gls":         0,
+		"waypoints":   16,
+		"procedures":  70,
+		"path points": 1,
+		"msas":        2,
+		"other":       0,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Read() returned unexpected records (-want +got):\n%s", diff)
+	}
+	if got, want := a.Airport.Name, "HAYWARD EXECUTIVE"; got != want {
+		t.Errorf("Read() returned airport %q want %q", got, want)
+	}
+	if got, want := len(a.Legs("R28L")), 19; got != want {
+		t.Errorf("Legs(%q) returned %d legs want %d", "R28L", got, want)
+	}
+
+	if _, err := Read(strings.NewReader(testData(t)), "KJFK"); err != ErrNoAirport {
+		t.Errorf("Read() of missing airport = _, %v want _, %v", err, ErrNoAirport)
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := &blobtest.Storage{Objects: map[string]string{"FAACIFP18-06-18-2020_processed": testData(t)}}
+	c := &db.Cycle{Name: "06/18/2020", Processed: "FAACIFP18-06-18-2020_processed", Checksum: "abc"}
+
+	// The first store builds the index, which the second one reads.
+	for i := 0; i < 2; i++ {
+		st := &Store{Storage: s}
+		a, err := st.Airport(ctx, c, "KHWD")
+		if err != nil {
+			t.Fatalf("Airport() = _, %v want _, <nil>", err)
+		}
+		if got, want := len(a.Runways), 5; got != want {
+			t.Errorf("Airport() returned %d runways want %d", got, want)
+		}
+		if _, err := st.Airport(ctx, c, "KJFK"); err != ErrNoAirport {
+			t.Errorf("Airport() of missing airport = _, %v want _, %v", err, ErrNoAirport)
+		}
+	}
+
+	// Reprocessing the cycle changes its checksum, so its index is built
+	// again rather than read from the index of the old data.
+	reprocessed := *c
+	reprocessed.Checksum = "def"
+	st := &Store{Storage: s}
+	if _, err := st.Airport(ctx, &reprocessed, "KHWD"); err != nil {
+		t.Fatalf("Airport() of reprocessed cycle = _, %v want _, <nil>", err)
+	}
+	want := []string{
+		"index/06-18-2020/abc.json",
+		"FAACIFP18-06-18-2020_processed",
+		"index/06-18-2020/abc.json",
+		"index/06-18-2020/def.json",
+		"FAACIFP18-06-18-2020_processed",
+	}
+	if diff := cmp.Diff(want, s.Reads); diff != "" {
+		t.Errorf("Store read unexpected objects (-want +got):\n%s", diff)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	data := testData(t)
+	s := &blobtest.Storage{}
+	p := &Publisher{Storage: s}
+	a := &pipeline.Artifact{
+		Cycle:        "06/18/2020",
+		Checksum:     "abc",
+		Data:         strings.NewReader(data),
+		Size:         int64(len(data)),
+		OriginalData: strings.NewReader(data),
+		OriginalSize: int64(len(data)),
+	}
+	if err := p.Publish(context.Background(), a); err != nil {
+		t.Fatalf("Publish() = %v want <nil>", err)
+	}
+	st := &Store{Storage: s}
+	for _, name := range []string{"index/06-18-2020/abc.json", "index/06-18-2020_original.json"} {
+		ix, err := st.read(context.Background(), name)
+		if err != nil {
+			t.Fatalf("could not read published index %s: %v", name, err)
+		}
+		if got, want := len(ix.Airports), 2; got != want {
+			t.Errorf("Publish() indexed %d airports in %s want %d", got, name, want)
+		}
+	}
+	if got := s.Objects["original/FAACIFP18_original_06-18-2020"]; got != data {
+		t.Errorf("Publish() stored original data of %d bytes want %d", len(got), len(data))
+	}
+}
+
+func TestOriginalWithoutArchive(t *testing.T) {
+	ctx := context.Background()
+	data := testData(t)
+	s := &blobtest.Storage{}
+	p := &Publisher{Storage: s}
+	// The records of the second cycle are at other offsets than those of
+	// the first.
+	for cycle, original := range map[string]string{
+		"06/18/2020": data,
+		"07/16/2020": data[:strings.Index(data, "\n")+1] + data,
+	} {
+		if err := p.Publish(ctx, &pipeline.Artifact{
+			Cycle:        cycle,
+			Data:         strings.NewReader(data),
+			Size:         int64(len(data)),
+			OriginalData: strings.NewReader(original),
+			OriginalSize: int64(len(original)),
+		}); err != nil {
+			t.Fatalf("Publish() = %v want <nil>", err)
+		}
+	}
+
+	// Neither cycle records its archive.
+	st := &Store{Storage: s}
+	want, err := st.Original(ctx, &db.Cycle{Name: "06/18/2020"}, "KHWD")
+	if err != nil {
+		t.Fatalf("Original() = _, %v want _, <nil>", err)
+	}
+	got, err := st.Original(ctx, &db.Cycle{Name: "07/16/2020"}, "KHWD")
+	if err != nil {
+		t.Fatalf("Original() of other cycle = _, %v want _, <nil>", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Original() of other cycle diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestStoreEvictsIndexes(t *testing.T) {
+	st := &Store{}
+	var reads int
+	read := func() (*Index, error) {
+		reads++
+		return &Index{}, nil
+	}
+	for i := 0; i <= maxIndexes; i++ {
+		if _, err := st.load(fmt.Sprint(i), read); err != nil {
+			t.Fatalf("load() = _, %v want _, <nil>", err)
+		}
+	}
+	if _, err := st.load(fmt.Sprint(maxIndexes), read); err != nil {
+		t.Fatalf("load() = _, %v want _, <nil>", err)
+	}
+	if _, err := st.load("0", read); err != nil {
+		t.Fatalf("load() = _, %v want _, <nil>", err)
+	}
+	if got, want := reads, maxIndexes+2; got != want {
+		t.Errorf("load() read %d indexes want %d", got, want)
+	}
+	if got := len(st.indexes); got != maxIndexes {
+		t.Errorf("Store kept %d indexes want %d", got, maxIndexes)
+	}
+}
+
+func TestOriginalAndCompareLocalizers(t *testing.T) {
+	ctx := context.Background()
+	archive, err := ioutil.ReadFile("../pipeline/original.zip")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	s := &blobtest.Storage{Objects: map[string]string{
+		"FAACIFP18-06-18-2020":           string(archive),
+		"FAACIFP18-06-18-2020_processed": testData(t),
+	}}
+	c := &db.Cycle{Name: "06/18/2020", Original: "FAACIFP18-06-18-2020", Processed: "FAACIFP18-06-18-2020_processed"}
+
+	// The first store indexes the archive, which the second one does not
+	// read again.
+	var original *Airport
+	for i := 0; i < 2; i++ {
+		st := &Store{Storage: s}
+		if original, err = st.Original(ctx, c, "KHWD"); err != nil {
+			t.Fatalf("Original() = _, %v want _, <nil>", err)
+		}
+		if _, err := st.Original(ctx, c, "KJFK"); err != ErrNoAirport {
+			t.Errorf("Original() of missing airport = _, %v want _, %v", err, ErrNoAirport)
+		}
+	}
+	want := []string{
+		"index/06-18-2020_original.json",
+		"FAACIFP18-06-18-2020",
+		"index/06-18-2020_original.json",
+	}
+	if diff := cmp.Diff(want, s.Reads); diff != "" {
+		t.Errorf("Store read unexpected objects (-want +got):\n%s", diff)
+	}
+	st := &Store{Storage: s}
+	processed, err := st.Airport(ctx, c, "KHWD")
+	if err != nil {
+		t.Fatalf("Airport() = _, %v want _, <nil>", err)
+	}
+
+	got := CompareLocalizers(original, processed)
+	wantComparisons := []*LocalizerComparison{{
+		Ident:           "IHWD",
+		Category:        "0",
+		Runway:          "RW28L",
+		Original:        arinc424.Bearing{Degrees: 287.9},
+		OriginalTrue:    302.9,
+		HasOriginalTrue: true,
+		Enhanced:        303.05,
+		HasEnhanced:     true,
+	}}
+	if diff := cmp.Diff(wantComparisons, got, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+		t.Errorf("CompareLocalizers() returned diff (-want +got):\n%s", diff)
+	}
+	if d := got[0].Difference(); math.Abs(d-0.15) > 1e-9 {
+		t.Errorf("Difference() = %v want 0.15", d)
+	}
+
+	got = CompareLocalizers(original, &Airport{Airport: processed.Airport})
+	if len(got) != 1 || !got[0].Removed || got[0].HasEnhanced {
+		t.Errorf("CompareLocalizers() without processed localizers = %+v want IHWD removed", got)
+	}
+}
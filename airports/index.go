@@ -0,0 +1,224 @@
+// Package airports indexes the processed and original data of a cycle by
+// airport, so that the records of one airport can be read without the whole
+// file.
+package airports
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/arinc424"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+)
+
+// ErrNoAirport is returned for airports that are not in a cycle.
+var ErrNoAirport = errors.New("no such airport")
+
+// Index locates the records of each airport in the processed data of a
+// cycle.
+type Index struct {
+	// Airports are the airports by ICAO identifier.
+	Airports map[string]*Entry `json:"airports"`
+}
+
+// Entry is an airport in an Index.
+type Entry struct {
+	ICAO string `json:"icao"`
+	Name string `json:"name"`
+	// Ranges are where the records of the airport are in the processed
+	// data. CIFP data lists the records of each airport together, so there
+	// is usually only one.
+	Ranges []Range `json:"ranges"`
+}
+
+// Range is a range of bytes.
+type Range struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// Build indexes the CIFP data read from r.
+func Build(r io.Reader) (*Index, error) {
+	ix := &Index{Airports: make(map[string]*Entry)}
+	br := bufio.NewReader(r)
+	var offset int64
+	var last *Entry
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not read data: %v", err)
+		}
+		e := ix.entry(strings.TrimRight(line, "\r\n"))
+		switch {
+		case e == nil:
+		case e == last:
+			e.Ranges[len(e.Ranges)-1].Length += int64(len(line))
+		default:
+			e.Ranges = append(e.Ranges, Range{Offset: offset, Length: int64(len(line))})
+		}
+		// Airports whose record cannot be parsed are still indexed, without
+		// a name.
+		if e != nil && e.Name == "" && line[12] == 'A' {
+			if a, ok := parseAirport(line); ok {
+				e.Name = a.Name
+			}
+		}
+		last = e
+		offset += int64(len(line))
+	}
+	return ix, nil
+}
+
+func parseAirport(line string) (*arinc424.Airport, bool) {
+	rec, err := arinc424.Parse(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		return nil, false
+	}
+	a, ok := rec.(*arinc424.Airport)
+	return a, ok
+}
+
+// entry returns the entry of the airport of line, adding it if it is new, or
+// nil if line is not a record of an airport.
+func (ix *Index) entry(line string) *Entry {
+	icao := airportOf(line)
+	if icao == "" {
+		return nil
+	}
+	e, ok := ix.Airports[icao]
+	if !ok {
+		e = &Entry{ICAO: icao}
+		ix.Airports[icao] = e
+	}
+	return e
+}
+
+// airportOf returns the airport of line, or "" if line is not a record of an
+// airport.
+func airportOf(line string) string {
+	if len(line) < 13 || line[0] != 'S' || line[4] != 'P' || line[5] != ' ' {
+		return ""
+	}
+	return strings.TrimSpace(line[6:10])
+}
+
+// Search returns the airports whose identifier starts with query, or whose
+// name contains it, ignoring case, sorted by identifier. It returns at most
+// limit airports.
+func (ix *Index) Search(query string, limit int) []*Entry {
+	query = strings.ToUpper(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+	var found []*Entry
+	for icao, e := range ix.Airports {
+		if strings.HasPrefix(icao, query) || strings.Contains(strings.ToUpper(e.Name), query) {
+			found = append(found, e)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		// Exact matches come first, so that searching for KHWD finds it
+		// among airports named after it.
+		if (found[i].ICAO == query) != (found[j].ICAO == query) {
+			return found[i].ICAO == query
+		}
+		return found[i].ICAO < found[j].ICAO
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found
+}
+
+// ObjectName returns the name in storage of the index of the processed data
+// of cycle with the specified checksum, so that the index of a reprocessed
+// cycle is never used with its new data. Cycles processed before checksums
+// were recorded have one index named after the cycle alone.
+func ObjectName(cycle, checksum string) string {
+	name := strings.Replace(cycle, "/", "-", -1)
+	if checksum != "" {
+		name += "/" + checksum
+	}
+	return "index/" + name + ".json"
+}
+
+// OriginalObjectName returns the name in storage of the original data of
+// cycle, extracted from its archive so that it can be read by range.
+func OriginalObjectName(cycle string) string {
+	return "original/FAACIFP18_original_" + strings.Replace(cycle, "/", "-", -1)
+}
+
+// OriginalIndexName returns the name in storage of the index of the original
+// data of cycle.
+func OriginalIndexName(cycle string) string {
+	return "index/" + strings.Replace(cycle, "/", "-", -1) + "_original.json"
+}
+
+type objectCreator interface {
+	NewObject(_ context.Context, fileName string) io.WriteCloser
+}
+
+// Publisher is a pipeline.Publisher that stores the index of the processed
+// data of each cycle, and its original data with an index of its own.
+type Publisher struct {
+	Storage objectCreator
+}
+
+// Publish stores the indexes and the original data of a.
+func (p *Publisher) Publish(ctx context.Context, a *pipeline.Artifact) error {
+	ix, err := Build(a.Reader())
+	if err != nil {
+		return fmt.Errorf("could not index processed data: %v", err)
+	}
+	if err := writeIndex(ctx, p.Storage, ObjectName(a.Cycle, a.Checksum), ix); err != nil {
+		return err
+	}
+	logging.Infof(ctx, "Indexed %d airports of %s.", len(ix.Airports), a.Cycle)
+	if a.OriginalData == nil {
+		return nil
+	}
+	if _, err := writeOriginal(ctx, p.Storage, a.Cycle, a.OriginalReader()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeOriginal stores the original data of cycle read from r, and its
+// index, which it returns.
+func writeOriginal(ctx context.Context, s objectCreator, cycle string, r io.Reader) (*Index, error) {
+	w := s.NewObject(ctx, OriginalObjectName(cycle))
+	ix, err := Build(io.TeeReader(r, w))
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("could not index original data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("could not write original data: %v", err)
+	}
+	if err := writeIndex(ctx, s, OriginalIndexName(cycle), ix); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+func writeIndex(ctx context.Context, s objectCreator, name string, ix *Index) error {
+	w := s.NewObject(ctx, name)
+	if err := json.NewEncoder(w).Encode(ix); err != nil {
+		w.Close()
+		return fmt.Errorf("could not write index: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("could not write index: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package airports
+
+import (
+	"math"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/arinc424"
+)
+
+// LocalizerComparison compares a localizer in the original data of a cycle
+// with the same localizer in its processed data.
+type LocalizerComparison struct {
+	Ident    string
+	Category string
+	// Runway is the runway the localizer serves, such as RW28L.
+	Runway string
+	// Original is the bearing published by the FAA, usually magnetic.
+	Original arinc424.Bearing
+	// OriginalTrue is Original relative to true north, using the magnetic
+	// variation of the airport. It is only set if HasOriginalTrue is.
+	OriginalTrue    float64
+	HasOriginalTrue bool
+	// Enhanced is the true bearing the enhancer computed from the final
+	// approach fix. It is only set if HasEnhanced is; the enhancer skips
+	// localizers without an approach.
+	Enhanced    float64
+	HasEnhanced bool
+	// Removed is set for duplicate localizers that were removed from the
+	// processed data.
+	Removed bool
+}
+
+// Difference returns how many degrees Enhanced is clockwise of
+// OriginalTrue, between -180 and 180.
+func (l *LocalizerComparison) Difference() float64 {
+	d := math.Mod(l.Enhanced-l.OriginalTrue, 360)
+	if d >= 180 {
+		d -= 360
+	} else if d < -180 {
+		d += 360
+	}
+	return d
+}
+
+// CompareLocalizers compares the localizers of an airport in the original
+// data of a cycle with those in its processed data, in the order of the
+// original data.
+func CompareLocalizers(original, processed *Airport) []*LocalizerComparison {
+	variation, varErr := arinc424.ParseMagneticVariation(original.Airport.MagneticVariation)
+	used := make(map[*arinc424.Localizer]bool)
+	var res []*LocalizerComparison
+	for _, o := range original.Localizers {
+		c := &LocalizerComparison{Ident: o.Ident, Category: o.Category, Runway: o.Runway, Removed: true}
+		if b, err := o.MagneticBearing(); err == nil {
+			c.Original = b
+			switch {
+			case b.True:
+				c.OriginalTrue, c.HasOriginalTrue = b.Degrees, true
+			case varErr == nil:
+				c.OriginalTrue, c.HasOriginalTrue = math.Mod(b.Degrees+variation+360, 360), true
+			}
+		}
+		// Duplicates share an identifier, so localizers are matched by
+		// their runway and category too.
+		for _, p := range processed.Localizers {
+			if used[p] || p.Ident != o.Ident || p.Runway != o.Runway || p.Category != o.Category {
+				continue
+			}
+			used[p] = true
+			c.Removed = false
+			if b, ok, err := p.TrueBearing(); err == nil && ok {
+				c.Enhanced, c.HasEnhanced = b, true
+			}
+			break
+		}
+		res = append(res, c)
+	}
+	return res
+}
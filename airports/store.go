@@ -0,0 +1,208 @@
+package airports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+)
+
+// maxIndexes is how many indexes a Store keeps in memory.
+const maxIndexes = 8
+
+type storageClient interface {
+	NewObject(_ context.Context, fileName string) io.WriteCloser
+	NewReader(_ context.Context, fileName string) (io.ReadCloser, error)
+	NewRangeReader(_ context.Context, fileName string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Store reads the airports of cycles from storage using their indexes.
+// Indexes are kept in memory once read, and built from the data of cycles
+// that were processed before they were indexed.
+type Store struct {
+	Storage storageClient
+
+	mu sync.Mutex
+	// indexes are the most recently read indexes, by the name and checksum
+	// of the data they index, so that reprocessed cycles are read again.
+	indexes map[string]*Index
+	// order has the keys of indexes, oldest first.
+	order []string
+	// loading has a channel for each index being loaded, which is closed
+	// once it is, so that concurrent requests only load it once.
+	loading map[string]chan struct{}
+}
+
+// load returns the index cached as key, calling read to get it if it is not
+// cached.
+func (s *Store) load(key string, read func() (*Index, error)) (*Index, error) {
+	s.mu.Lock()
+	for {
+		if ix, ok := s.indexes[key]; ok {
+			s.mu.Unlock()
+			return ix, nil
+		}
+		done, ok := s.loading[key]
+		if !ok {
+			break
+		}
+		s.mu.Unlock()
+		<-done
+		s.mu.Lock()
+	}
+	if s.loading == nil {
+		s.loading = make(map[string]chan struct{})
+	}
+	done := make(chan struct{})
+	s.loading[key] = done
+	s.mu.Unlock()
+
+	ix, err := read()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.loading, key)
+	close(done)
+	if err != nil {
+		return nil, err
+	}
+	if s.indexes == nil {
+		s.indexes = make(map[string]*Index)
+	}
+	if len(s.order) == maxIndexes {
+		delete(s.indexes, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.indexes[key] = ix
+	s.order = append(s.order, key)
+	return ix, nil
+}
+
+// Index returns the index of c.
+func (s *Store) Index(ctx context.Context, c *db.Cycle) (*Index, error) {
+	return s.load(c.Processed+"@"+c.Checksum, func() (*Index, error) {
+		ix, err := s.read(ctx, ObjectName(c.Name, c.Checksum))
+		if err == nil {
+			return ix, nil
+		}
+		logging.Infof(ctx, "Indexing %s, since its index could not be read: %v", c.Name, err)
+		return s.build(ctx, c)
+	})
+}
+
+func (s *Store) read(ctx context.Context, name string) (*Index, error) {
+	r, err := s.Storage.NewReader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	ix := &Index{}
+	if err := json.NewDecoder(r).Decode(ix); err != nil {
+		return nil, fmt.Errorf("could not decode index: %v", err)
+	}
+	return ix, nil
+}
+
+func (s *Store) build(ctx context.Context, c *db.Cycle) (*Index, error) {
+	r, err := s.Storage.NewReader(ctx, c.Processed)
+	if err != nil {
+		return nil, fmt.Errorf("could not open processed data: %v", err)
+	}
+	defer r.Close()
+	ix, err := Build(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not index processed data: %v", err)
+	}
+	// The index can be built again if it could not be stored.
+	if err := writeIndex(ctx, s.Storage, ObjectName(c.Name, c.Checksum), ix); err != nil {
+		logging.Warningf(ctx, "Could not store index of %s: %v", c.Name, err)
+	}
+	return ix, nil
+}
+
+// originalIndex returns the index of the original data of c.
+func (s *Store) originalIndex(ctx context.Context, c *db.Cycle) (*Index, error) {
+	// Cycles imported without their original archive all have the same
+	// empty Original, so the cycle name keeps their indexes apart.
+	return s.load("original:"+c.Name+"@"+c.Original, func() (*Index, error) {
+		ix, err := s.read(ctx, OriginalIndexName(c.Name))
+		if err == nil {
+			return ix, nil
+		}
+		logging.Infof(ctx, "Indexing original data of %s, since its index could not be read: %v", c.Name, err)
+		return s.buildOriginal(ctx, c)
+	})
+}
+
+// buildOriginal extracts and indexes the original archive of a cycle that
+// was published before original data was indexed, storing both so that this
+// is only done once.
+func (s *Store) buildOriginal(ctx context.Context, c *db.Cycle) (*Index, error) {
+	r, err := s.Storage.NewReader(ctx, c.Original)
+	if err != nil {
+		return nil, fmt.Errorf("could not open original data: %v", err)
+	}
+	defer r.Close()
+	archive, err := ioutil.TempFile("", "original")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	size, err := io.Copy(archive, r)
+	if err != nil {
+		return nil, fmt.Errorf("could not download original data: %v", err)
+	}
+	cifp, err := pipeline.Zip{}.Extract(ctx, archive, size)
+	if err != nil {
+		return nil, err
+	}
+	defer cifp.Close()
+	return writeOriginal(ctx, s.Storage, c.Name, cifp)
+}
+
+// readAirport reads the records of icao from the object name, which is
+// indexed by ix.
+func (s *Store) readAirport(ctx context.Context, ix *Index, name, icao string) (*Airport, error) {
+	e, ok := ix.Airports[icao]
+	if !ok {
+		return nil, ErrNoAirport
+	}
+	var readers []io.Reader
+	for _, rg := range e.Ranges {
+		r, err := s.Storage.NewRangeReader(ctx, name, rg.Offset, rg.Length)
+		if err != nil {
+			return nil, fmt.Errorf("could not open %s: %v", name, err)
+		}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	return Read(io.MultiReader(readers...), icao)
+}
+
+// Airport returns the records of the airport icao in c, or ErrNoAirport if
+// it has none.
+func (s *Store) Airport(ctx context.Context, c *db.Cycle, icao string) (*Airport, error) {
+	ix, err := s.Index(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return s.readAirport(ctx, ix, c.Processed, icao)
+}
+
+// Original returns the records of the airport icao in the original data of
+// c, as published by the FAA, or ErrNoAirport if it has none.
+func (s *Store) Original(ctx context.Context, c *db.Cycle, icao string) (*Airport, error) {
+	ix, err := s.originalIndex(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return s.readAirport(ctx, ix, OriginalObjectName(c.Name), icao)
+}
@@ -0,0 +1,207 @@
+// Package arinc424 parses the ARINC 424 records of CIFP data into Go types
+// and formats them back into 132 column lines.
+//
+// Each record type lists its fields with the columns they occupy, 1-indexed
+// as in the standard, in an arinc struct tag. Fields hold the text of their
+// columns without trailing spaces, so that formatting a parsed record gives
+// back the same line. Methods such as Position decode the fields that need
+// it. Columns the standard reserves are written as spaces.
+package arinc424
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// LineLength is the length of every record, without its line break.
+const LineLength = 132
+
+// Record is one of the record types of this package.
+type Record interface {
+	record()
+}
+
+// Parse parses a line of CIFP data. Records of the sections and subsections
+// this package has no type for are returned as *Unknown.
+func Parse(line string) (Record, error) {
+	if len(line) != LineLength {
+		return nil, fmt.Errorf("record has %d characters, want %d", len(line), LineLength)
+	}
+	r := newRecord(line)
+	switch r := r.(type) {
+	case *Unknown:
+		r.Line = line
+	case *Continuation:
+		decode(reflect.ValueOf(&r.Common).Elem(), line, 0)
+		r.decode(line)
+	default:
+		decode(reflect.ValueOf(r).Elem(), line, 0)
+	}
+	return r, nil
+}
+
+// Format returns the line of r, without the lines of its continuation
+// records.
+func Format(r Record) (string, error) {
+	if u, ok := r.(*Unknown); ok {
+		if len(u.Line) != LineLength {
+			return "", fmt.Errorf("record has %d characters, want %d", len(u.Line), LineLength)
+		}
+		return u.Line, nil
+	}
+	b := []byte(strings.Repeat(" ", LineLength))
+	v := reflect.ValueOf(r).Elem()
+	if c, ok := r.(*Continuation); ok {
+		v = reflect.ValueOf(&c.Common).Elem()
+		if err := c.encode(b); err != nil {
+			return "", err
+		}
+	}
+	if err := encode(v, b, 0); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// continuationColumns maps airport subsections to the column of the
+// continuation record number in their records.
+var continuationColumns = map[byte]int{
+	'A': 22, 'C': 22, 'G': 22, 'I': 22, 'T': 22,
+	'P': 27,
+	'D': 39, 'E': 39, 'F': 39, 'S': 39,
+}
+
+// isAirport reports whether line is a record of the airport section.
+func isAirport(line string) bool {
+	return line[0] != 'H' && line[4] == 'P' && line[5] == ' '
+}
+
+// isPrimary reports whether a continuation record number is that of a
+// primary record, which is 0, or 1 if continuation records follow it.
+func isPrimary(n byte) bool {
+	return n == '0' || n == '1'
+}
+
+func newRecord(line string) Record {
+	if strings.HasPrefix(line, "HDR") {
+		if line[3:5] == "01" {
+			return &Header{}
+		}
+		return &HeaderText{}
+	}
+	if !isAirport(line) {
+		return &Unknown{}
+	}
+	col, ok := continuationColumns[line[12]]
+	if !ok {
+		return &Unknown{}
+	}
+	if !isPrimary(line[col-1]) {
+		return &Continuation{}
+	}
+	switch line[12] {
+	case 'A':
+		return &Airport{}
+	case 'C':
+		return &TerminalWaypoint{}
+	case 'D', 'E', 'F':
+		return &Procedure{}
+	case 'G':
+		return &Runway{}
+	case 'I':
+		return &Localizer{}
+	case 'P':
+		return &PathPoint{}
+	case 'S':
+		return &MSA{}
+	case 'T':
+		return &GLS{}
+	}
+	return &Unknown{}
+}
+
+// columns parses an arinc struct tag, such as "14-18" or "5".
+func columns(tag string) (first, last int) {
+	parts := strings.SplitN(tag, "-", 2)
+	first, err := strconv.Atoi(parts[0])
+	if err != nil {
+		panic(fmt.Sprintf("invalid arinc tag %q", tag))
+	}
+	last = first
+	if len(parts) == 2 {
+		if last, err = strconv.Atoi(parts[1]); err != nil {
+			panic(fmt.Sprintf("invalid arinc tag %q", tag))
+		}
+	}
+	return first, last
+}
+
+// decode sets the fields of the struct v from line, whose columns are
+// offset by offset from those in the struct tags.
+func decode(v reflect.Value, line string, offset int) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		tag, ok := f.Tag.Lookup("arinc")
+		if !ok {
+			if f.Anonymous && fv.Kind() == reflect.Struct {
+				decode(fv, line, offset)
+			}
+			continue
+		}
+		first, last := columns(tag)
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(strings.TrimRight(line[offset+first-1:offset+last], " "))
+		case reflect.Array:
+			width := (last - first + 1) / fv.Len()
+			for j := 0; j < fv.Len(); j++ {
+				decode(fv.Index(j), line, offset+first-1+j*width)
+			}
+		}
+	}
+}
+
+// encode writes the fields of the struct v into b, the inverse of decode.
+func encode(v reflect.Value, b []byte, offset int) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		tag, ok := f.Tag.Lookup("arinc")
+		if !ok {
+			if f.Anonymous && fv.Kind() == reflect.Struct {
+				if err := encode(fv, b, offset); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		first, last := columns(tag)
+		switch fv.Kind() {
+		case reflect.String:
+			if err := put(b, offset+first, offset+last, f.Name, fv.String()); err != nil {
+				return err
+			}
+		case reflect.Array:
+			width := (last - first + 1) / fv.Len()
+			for j := 0; j < fv.Len(); j++ {
+				if err := encode(fv.Index(j), b, offset+first-1+j*width); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// put writes s into the 1-indexed columns first to last of b, padding it
+// with spaces.
+func put(b []byte, first, last int, name, s string) error {
+	if width := last - first + 1; len(s) > width {
+		return fmt.Errorf("%s %q does not fit in %d columns", name, s, width)
+	}
+	copy(b[first-1:last], s)
+	return nil
+}
@@ -0,0 +1,404 @@
+package arinc424
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func readTestData(t *testing.T) string {
+	t.Helper()
+	b, err := ioutil.ReadFile("../pipeline/want_processed.txt")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	return string(b)
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := readTestData(t)
+
+	for i, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		r, err := Parse(line)
+		if err != nil {
+			t.Fatalf("Parse() of line %d = _, %v want _, <nil>", i+1, err)
+		}
+		if u, ok := r.(*Unknown); ok {
+			t.Errorf("Parse() of line %d = %+v want a known record", i+1, u)
+		}
+		got, err := Format(r)
+		if err != nil {
+			t.Fatalf("Format() of line %d = _, %v want _, <nil>", i+1, err)
+		}
+		if diff := cmp.Diff(line, got); diff != "" {
+			t.Errorf("Format(Parse()) of line %d returned diff (-want +got):\n%s", i+1, diff)
+		}
+	}
+
+	recs, err := ReadAll(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadAll() = _, %v want _, <nil>", err)
+	}
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	for _, r := range recs {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("Write() = %v want <nil>", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() = %v want <nil>", err)
+	}
+	if diff := cmp.Diff(data, b.String()); diff != "" {
+		t.Errorf("Write(ReadAll()) returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestParse(t *testing.T) {
+	common := func(subsection, number string) Common {
+		return Common{RecordType: "S", Area: "USA", Section: "P", Airport: "KHWD", Region: "K2", Subsection: subsection, RecordNumber: number, Cycle: "1212"}
+	}
+	for _, tc := range []struct {
+		line string
+		want Record
+	}{
+		{
+			line: "HDR01FAACIFP18      001P013203804972003  06-FEB-202013:41:57  U.S.A. DOT FAA                                                252E2B62",
+			want: &Header{Label: "HDR", Number: "01", FileName: "FAACIFP18", Version: "001", Production: "P", RecordLength: "0132", RecordCount: "0380497", Cycle: "2003", CreationDate: "06-FEB-2020", CreationTime: "13:41:57", Supplier: "U.S.A. DOT FAA", CRC: "252E2B62"},
+		},
+		{
+			line: "HDR02                                 FEDERAL AVIATION ADMINISTRATION                                                               ",
+			want: &HeaderText{Label: "HDR", Number: "02", Text: "                                 FEDERAL AVIATION ADMINISTRATION"},
+		},
+		{
+			line: "SUSAP KHWDK2AHWD     0     056YHN37393214W122071825E015000052         1800018000C    MNAR    HAYWARD EXECUTIVE             107981608",
+			want: &Airport{
+				Primary:              Primary{Common: Common{RecordType: "S", Area: "USA", Section: "P", Airport: "KHWD", Region: "K2", Subsection: "A", RecordNumber: "10798", Cycle: "1608"}},
+				IATA:                 "HWD",
+				ContinuationNumber:   "0",
+				LongestRunway:        "056",
+				IFR:                  "Y",
+				LongestRunwaySurface: "H",
+				Latitude:             "N37393214",
+				Longitude:            "W122071825",
+				MagneticVariation:    "E0150",
+				Elevation:            "00052",
+				TransitionAltitude:   "18000",
+				TransitionLevel:      "18000",
+				PublicMilitary:       "C",
+				MagneticTrue:         "M",
+				Datum:                "NAR",
+				Name:                 "HAYWARD EXECUTIVE",
+			},
+		},
+		{
+			line: "SUSAP KHWDK2GRW28L   0056942840 N37391866W122065313         -0017200050067635150RIHWD0                                     108881707",
+			want: &Runway{
+				Primary:                 Primary{Common: Common{RecordType: "S", Area: "USA", Section: "P", Airport: "KHWD", Region: "K2", Subsection: "G", RecordNumber: "10888", Cycle: "1707"}},
+				Ident:                   "RW28L",
+				ContinuationNumber:      "0",
+				Length:                  "05694",
+				Bearing:                 "2840",
+				Latitude:                "N37391866",
+				Longitude:               "W122065313",
+				EllipsoidHeight:         "-00172",
+				ThresholdElevation:      "00050",
+				DisplacedThreshold:      "0676",
+				ThresholdCrossingHeight: "35",
+				Width:                   "150",
+				TCHIndicator:            "R",
+				Localizer:               "IHWD",
+				LocalizerClass:          "0",
+			},
+		},
+		{
+			line: "SUSAP KHWDK2IIHWD0   111150RW28LN37394620W1220746752879                   0109     0500   E0150                            108901212",
+			want: &Localizer{
+				Primary:            Primary{Common: common("I", "10890")},
+				Ident:              "IHWD",
+				Category:           "0",
+				ContinuationNumber: "1",
+				Frequency:          "11150",
+				Runway:             "RW28L",
+				Latitude:           "N37394620",
+				Longitude:          "W122074675",
+				Bearing:            "2879",
+				LocalizerPosition:  "0109",
+				Width:              "0500",
+				Declination:        "E0150",
+			},
+		},
+		{
+			line: "SUSAP KHWDK2IIHWD0   2S                            30305N                                                                  108901212",
+			want: &Continuation{Common: common("I", "10890"), Ident: "IHWD0", Number: "2", Application: "S", Data: "                            30305N"},
+		},
+		{
+			line: "SUSAP KHWDK2CBOGRE K20    W     N37372195W122023769                       E0133     NAR           BOGRE                    107992002",
+			want: &TerminalWaypoint{
+				Primary:            Primary{Common: Common{RecordType: "S", Area: "USA", Section: "P", Airport: "KHWD", Region: "K2", Subsection: "C", RecordNumber: "10799", Cycle: "2002"}},
+				Ident:              "BOGRE",
+				WaypointRegion:     "K2",
+				ContinuationNumber: "0",
+				Type:               "W",
+				Latitude:           "N37372195",
+				Longitude:          "W122023769",
+				MagneticVariation:  "E0133",
+				Datum:              "NAR",
+				Name:               "BOGRE",
+			},
+		},
+		{
+			line: "SUSAP KHWDK2FL28L  L      020FERNEK2PC0E  F    CF IHWDK2      1079007428800053PI  + 02500                 OAK   K2D 0 DS   108521310",
+			want: &Procedure{
+				Primary:                     Primary{Common: Common{RecordType: "S", Area: "USA", Section: "P", Airport: "KHWD", Region: "K2", Subsection: "F", RecordNumber: "10852", Cycle: "1310"}},
+				Ident:                       "L28L",
+				RouteType:                   "L",
+				Sequence:                    "020",
+				Fix:                         "FERNE",
+				FixRegion:                   "K2",
+				FixSection:                  "P",
+				FixSubsection:               "C",
+				ContinuationNumber:          "0",
+				DescriptionCode:             "E  F",
+				PathTerminator:              "CF",
+				RecommendedNavaid:           "IHWD",
+				RecommendedNavaidRegion:     "K2",
+				Theta:                       "1079",
+				Rho:                         "0074",
+				Course:                      "2880",
+				Distance:                    "0053",
+				RecommendedNavaidSection:    "P",
+				RecommendedNavaidSubsection: "I",
+				AltitudeDescription:         "+",
+				Altitude:                    "02500",
+				CenterFix:                   "OAK",
+				CenterFixRegion:             "K2",
+				CenterFixSection:            "D",
+				GNSSFMSIndicator:            "0",
+				RouteQualifier1:             "D",
+				RouteQualifier2:             "S",
+			},
+		},
+		{
+			line: "SUSAP KHWDK2PR28L  RW28L001 0000W28A0N3739186640W12206531315-001720310N3740030660W12208304530106751224000350F40050040227B2E108911212",
+			want: &PathPoint{
+				Primary:                   Primary{Common: common("P", "10891")},
+				Approach:                  "R28L",
+				Runway:                    "RW28L",
+				OperationType:             "00",
+				ContinuationNumber:        "1",
+				SBASProvider:              "00",
+				ReferencePathDataSelector: "00",
+				ReferencePath:             "W28A",
+				ApproachPerformance:       "0",
+				Latitude:                  "N3739186640",
+				Longitude:                 "W12206531315",
+				EllipsoidHeight:           "-00172",
+				GlidePathAngle:            "0310",
+				FPAPLatitude:              "N3740030660",
+				FPAPLongitude:             "W12208304530",
+				CourseWidth:               "10675",
+				LengthOffset:              "1224",
+				TCH:                       "000350",
+				TCHUnits:                  "F",
+				HAL:                       "400",
+				VAL:                       "500",
+				CRC:                       "40227B2E",
+			},
+		},
+		{
+			line: "SUSAP KHWDK2SOAK  K2D                 0   1703500512535017003825                                                       M   108931212",
+			want: &MSA{
+				Primary:            Primary{Common: common("S", "10893")},
+				Center:             "OAK",
+				CenterRegion:       "K2",
+				CenterSection:      "D",
+				ContinuationNumber: "0",
+				Sectors: [7]Sector{
+					{From: "170", To: "350", Altitude: "051", Radius: "25"},
+					{From: "350", To: "170", Altitude: "038", Radius: "25"},
+				},
+				MagneticTrue: "M",
+			},
+		},
+		{
+			line: "SUSAD        OAK K2011380VDHW N37433434W122131317    N37433434W122131317E0170000802     NARMETROPOLITAN OAKLAND            109102002",
+			want: &Unknown{Line: "SUSAD        OAK K2011380VDHW N37433434W122131317    N37433434W122131317E0170000802     NARMETROPOLITAN OAKLAND            109102002"},
+		},
+	} {
+		got, err := Parse(tc.line)
+		if err != nil {
+			t.Errorf("Parse(%q) = _, %v want _, <nil>", tc.line, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Parse(%q) returned diff (-want +got):\n%s", tc.line, diff)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{"", "SUSAP KHWDK2AHWD", strings.Repeat(" ", LineLength+1)} {
+		if _, err := Parse(line); err == nil {
+			t.Errorf("Parse(%q) = _, <nil> want _, non-nil", line)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	g := &GLS{
+		Primary:            Primary{Common: Common{RecordType: "S", Area: "USA", Section: "P", Airport: "KHWD", Region: "K2", Subsection: "T", RecordNumber: "00001", Cycle: "2003"}},
+		Ident:              "G28A",
+		Category:           "1",
+		ContinuationNumber: "0",
+		Channel:            "21234",
+		Runway:             "RW28L",
+		Bearing:            "2879",
+		Latitude:           "N37394620",
+		Longitude:          "W122074675",
+		Station:            "KHWD",
+		MagneticVariation:  "E0150",
+		StationElevation:   "00052",
+	}
+	line, err := Format(g)
+	if err != nil {
+		t.Fatalf("Format() = _, %v want _, <nil>", err)
+	}
+	if len(line) != LineLength {
+		t.Errorf("Format() returned %d characters want %d", len(line), LineLength)
+	}
+	got, err := Parse(line)
+	if err != nil {
+		t.Fatalf("Parse(%q) = _, %v want _, <nil>", line, err)
+	}
+	if diff := cmp.Diff(Record(g), got); diff != "" {
+		t.Errorf("Parse(Format()) returned diff (-want +got):\n%s", diff)
+	}
+
+	g.Ident = "G28AX"
+	if _, err := Format(g); err == nil {
+		t.Errorf("Format() of an ident too long = _, <nil> want _, non-nil")
+	}
+	if _, err := Format(&Continuation{Common: Common{Subsection: "X"}}); err == nil {
+		t.Errorf("Format() of a continuation of subsection X = _, <nil> want _, non-nil")
+	}
+}
+
+func TestReaderContinuations(t *testing.T) {
+	recs, err := ReadAll(strings.NewReader(readTestData(t)))
+	if err != nil {
+		t.Fatalf("ReadAll() = _, %v want _, <nil>", err)
+	}
+	got := make(map[string]int)
+	for _, r := range recs {
+		if c, ok := r.(*Continuation); ok {
+			t.Errorf("ReadAll() returned continuation %+v on its own", c)
+		}
+		p, ok := r.(primaryRecord)
+		if !ok || len(p.primary().Continuations) == 0 {
+			continue
+		}
+		line, err := Format(r)
+		if err != nil {
+			t.Fatalf("Format() = _, %v want _, <nil>", err)
+		}
+		got[strings.TrimRight(line[:43], " ")] = len(p.primary().Continuations)
+	}
+	want := map[string]int{
+		"SUSAP KHWDK2IIHWD0   111150RW28LN37394620W1": 1,
+		"SUSAP KHWDK2PR28L  RW28L001 0000W28A0N37391": 1,
+		"SUSAP KHWDK2FR28L  R      020SUDGEK2PC1E  F": 1,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ReadAll() attached unexpected continuations (-want +got):\n%s", diff)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		s       string
+		parse   func(string) (float64, error)
+		want    float64
+		wantErr bool
+	}{
+		{s: "N37393214", parse: ParseLatitude, want: 37.658928},
+		{s: "S33565700", parse: ParseLatitude, want: -33.949167},
+		{s: "N3739186640", parse: ParseLatitude, want: 37.655185},
+		{s: "W122071825", parse: ParseLongitude, want: -122.121736},
+		{s: "E151104600", parse: ParseLongitude, want: 151.179444},
+		{s: "W12206531315", parse: ParseLongitude, want: -122.114759},
+		{s: "W122071825", parse: ParseLatitude, wantErr: true},
+		{s: "N37603214", parse: ParseLatitude, wantErr: true},
+		{s: "N373932", parse: ParseLatitude, wantErr: true},
+		{s: "", parse: ParseLatitude, wantErr: true},
+		{s: "         ", parse: ParseLatitude, wantErr: true},
+	} {
+		got, err := tc.parse(tc.s)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parse(%q) = _, %v want error %t", tc.s, err, tc.wantErr)
+			continue
+		}
+		if math.Abs(got-tc.want) > 1e-6 {
+			t.Errorf("parse(%q) = %v, _ want %v, _", tc.s, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		s       string
+		want    Bearing
+		wantErr bool
+	}{
+		{s: "2879", want: Bearing{Degrees: 287.9}},
+		{s: "0000", want: Bearing{Degrees: 0}},
+		{s: "287T", want: Bearing{Degrees: 287, True: true}},
+		{s: "3600", wantErr: true},
+		{s: "287", wantErr: true},
+		{s: "28 9", wantErr: true},
+	} {
+		got, err := ParseBearing(tc.s)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseBearing(%q) = _, %v want error %t", tc.s, err, tc.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("ParseBearing(%q) returned diff (-want +got):\n%s", tc.s, diff)
+		}
+	}
+
+	for _, tc := range []struct {
+		s       string
+		want    float64
+		wantErr bool
+	}{
+		{s: "E0150", want: 15},
+		{s: "W0125", want: -12.5},
+		{s: "T0000", want: 0},
+		{s: "X0150", wantErr: true},
+		{s: "E015", wantErr: true},
+	} {
+		got, err := ParseMagneticVariation(tc.s)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseMagneticVariation(%q) = _, %v want error %t", tc.s, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseMagneticVariation(%q) = %v, _ want %v, _", tc.s, got, tc.want)
+		}
+	}
+
+	l := &Localizer{Frequency: "11150"}
+	if got, err := l.FrequencyMHz(); err != nil || got != 111.5 {
+		t.Errorf("FrequencyMHz() = %v, %v want 111.5, <nil>", got, err)
+	}
+	if _, ok, err := l.TrueBearing(); ok || err != nil {
+		t.Errorf("TrueBearing() without continuation = _, %t, %v want _, false, <nil>", ok, err)
+	}
+	l.Continuations = []*Continuation{{Number: "2", Application: "S", Data: "                            30305N"}}
+	if got, ok, err := l.TrueBearing(); !ok || err != nil || math.Abs(got-303.05) > 1e-9 {
+		t.Errorf("TrueBearing() = %v, %t, %v want 303.05, true, <nil>", got, ok, err)
+	}
+}
@@ -0,0 +1,111 @@
+package arinc424
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseLatitude parses a latitude such as N37393214, which is 37°39'32.14"
+// north, into decimal degrees. The high precision latitudes of path points,
+// such as N3739186640, are also accepted.
+func ParseLatitude(s string) (float64, error) {
+	return parseCoordinate(s, 'N', 'S', 2)
+}
+
+// ParseLongitude parses a longitude such as W122071825, which is
+// 122°07'18.25" west, into decimal degrees. The high precision longitudes of
+// path points, such as W12206531315, are also accepted.
+func ParseLongitude(s string) (float64, error) {
+	return parseCoordinate(s, 'E', 'W', 3)
+}
+
+func parseCoordinate(s string, pos, neg byte, degDigits int) (float64, error) {
+	// Seconds have two decimals, or four at high precision.
+	decimals := len(s) - 1 - degDigits - 4
+	if (decimals != 2 && decimals != 4) || (s[0] != pos && s[0] != neg) {
+		return 0, fmt.Errorf("invalid coordinate %q", s)
+	}
+	deg, err1 := strconv.Atoi(s[1 : 1+degDigits])
+	min, err2 := strconv.Atoi(s[1+degDigits : 3+degDigits])
+	sec, err3 := parseNumber(s[3+degDigits:], decimals)
+	if err1 != nil || err2 != nil || err3 != nil || min >= 60 || sec >= 60 {
+		return 0, fmt.Errorf("invalid coordinate %q", s)
+	}
+	v := float64(deg) + float64(min)/60 + sec/3600
+	if s[0] == neg {
+		v = -v
+	}
+	return v, nil
+}
+
+func position(lat, lon string) (float64, float64, error) {
+	la, err := ParseLatitude(lat)
+	if err != nil {
+		return 0, 0, err
+	}
+	lo, err := ParseLongitude(lon)
+	if err != nil {
+		return 0, 0, err
+	}
+	return la, lo, nil
+}
+
+// parseNumber parses digits with an implied decimal point before the last
+// decimals of them, such as 11150 with 2 decimals, which is 111.5.
+func parseNumber(s string, decimals int) (float64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	v := float64(n)
+	for i := 0; i < decimals; i++ {
+		v /= 10
+	}
+	return v, nil
+}
+
+// Bearing is a course or bearing in degrees.
+type Bearing struct {
+	Degrees float64
+	// True is set for bearings relative to true north, which are used in
+	// areas where magnetic bearings are unreliable.
+	True bool
+}
+
+// ParseBearing parses a bearing such as 2879, which is 287.9° magnetic, or
+// 287T, which is 287° true.
+func ParseBearing(s string) (Bearing, error) {
+	if len(s) == 4 && s[3] == 'T' {
+		d, err := parseNumber(s[:3], 0)
+		if err != nil || d >= 360 {
+			return Bearing{}, fmt.Errorf("invalid bearing %q", s)
+		}
+		return Bearing{Degrees: d, True: true}, nil
+	}
+	d, err := parseNumber(s, 1)
+	if len(s) != 4 || err != nil || d >= 360 {
+		return Bearing{}, fmt.Errorf("invalid bearing %q", s)
+	}
+	return Bearing{Degrees: d}, nil
+}
+
+// ParseMagneticVariation parses a magnetic variation such as E0150, which is
+// 15° east, into degrees that are positive east of true north. T0000, used
+// where bearings are true, is 0.
+func ParseMagneticVariation(s string) (float64, error) {
+	if len(s) != 5 || (s[0] != 'E' && s[0] != 'W' && s[0] != 'T') {
+		return 0, fmt.Errorf("invalid magnetic variation %q", s)
+	}
+	v, err := parseNumber(s[1:], 1)
+	if err != nil || v > 180 {
+		return 0, fmt.Errorf("invalid magnetic variation %q", s)
+	}
+	if s[0] == 'W' {
+		v = -v
+	}
+	return v, nil
+}
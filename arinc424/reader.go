@@ -0,0 +1,148 @@
+package arinc424
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// primaryRecord is implemented by the records that embed Primary.
+type primaryRecord interface {
+	primary() *Primary
+}
+
+// Reader reads the records of CIFP data, attaching continuation records to
+// the primary record they follow.
+type Reader struct {
+	sc   *bufio.Scanner
+	line int
+	// next is the record after the one last returned, read to find its
+	// continuation records.
+	next     Record
+	nextLine string
+	err      error
+}
+
+// NewReader returns a Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{sc: bufio.NewScanner(r)}
+}
+
+// Line returns the line number of the last line read.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Read returns the next record, or io.EOF at the end of the data.
+// Continuation records are only returned on their own if they do not follow
+// a primary record with the same identity.
+func (r *Reader) Read() (Record, error) {
+	if r.next == nil && r.err == nil {
+		r.next, r.nextLine, r.err = r.readLine()
+	}
+	rec, line := r.next, r.nextLine
+	if rec == nil {
+		return nil, r.err
+	}
+	r.next, r.nextLine = nil, ""
+	p, ok := rec.(primaryRecord)
+	if !ok {
+		return rec, nil
+	}
+	col := continuationColumns[line[12]]
+	for r.err == nil {
+		next, nextLine, err := r.readLine()
+		if err != nil {
+			r.err = err
+			break
+		}
+		c, ok := next.(*Continuation)
+		if !ok || nextLine[:col-1] != line[:col-1] {
+			r.next, r.nextLine = next, nextLine
+			break
+		}
+		p.primary().Continuations = append(p.primary().Continuations, c)
+	}
+	return rec, nil
+}
+
+func (r *Reader) readLine() (Record, string, error) {
+	if !r.sc.Scan() {
+		if err := r.sc.Err(); err != nil {
+			return nil, "", fmt.Errorf("could not read data: %v", err)
+		}
+		return nil, "", io.EOF
+	}
+	r.line++
+	line := r.sc.Text()
+	rec, err := Parse(line)
+	if err != nil {
+		return nil, "", fmt.Errorf("line %d: %v", r.line, err)
+	}
+	return rec, line, nil
+}
+
+// ReadAll reads every record of r.
+func ReadAll(r io.Reader) ([]Record, error) {
+	rd := NewReader(r)
+	var recs []Record
+	for {
+		rec, err := rd.Read()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// Writer writes records as lines of CIFP data.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write writes r followed by its continuation records.
+func (w *Writer) Write(r Record) error {
+	lines, err := Lines(r)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if _, err := w.w.WriteString(l + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lines returns the line of r followed by those of its continuation
+// records.
+func Lines(r Record) ([]string, error) {
+	line, err := Format(r)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{line}
+	if p, ok := r.(primaryRecord); ok {
+		for _, c := range p.primary().Continuations {
+			line, err := Format(c)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,414 @@
+package arinc424
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Header is the first header record of a file, HDR01.
+type Header struct {
+	Label        string `arinc:"1-3" json:"label"`
+	Number       string `arinc:"4-5" json:"number"`
+	FileName     string `arinc:"6-20" json:"file_name"`
+	Version      string `arinc:"21-23" json:"version"`
+	Production   string `arinc:"24" json:"production"`
+	RecordLength string `arinc:"25-28" json:"record_length"`
+	RecordCount  string `arinc:"29-35" json:"record_count"`
+	Cycle        string `arinc:"36-39" json:"cycle"`
+	CreationDate string `arinc:"42-52" json:"creation_date"`
+	CreationTime string `arinc:"53-60" json:"creation_time"`
+	Supplier     string `arinc:"63-78" json:"supplier,omitempty"`
+	Customer     string `arinc:"79-94" json:"customer,omitempty"`
+	PartNumber   string `arinc:"95-114" json:"part_number,omitempty"`
+	CRC          string `arinc:"125-132" json:"crc"`
+}
+
+func (*Header) record() {}
+
+// HeaderText is a header record after the first, which the FAA fills with
+// free text such as the effective date of the cycle.
+type HeaderText struct {
+	Label  string `arinc:"1-3" json:"label"`
+	Number string `arinc:"4-5" json:"number"`
+	Text   string `arinc:"6-132" json:"text"`
+}
+
+func (*HeaderText) record() {}
+
+// Common holds the fields that every record of the airport section has.
+type Common struct {
+	// RecordType is S for standard records and T for tailored ones.
+	RecordType string `arinc:"1" json:"record_type"`
+	// Area is the customer or area code, such as USA.
+	Area       string `arinc:"2-4" json:"area"`
+	Section    string `arinc:"5" json:"section"`
+	Airport    string `arinc:"7-10" json:"airport"`
+	Region     string `arinc:"11-12" json:"region"`
+	Subsection string `arinc:"13" json:"subsection"`
+	// RecordNumber is the number of the record in its file.
+	RecordNumber string `arinc:"124-128" json:"record_number"`
+	// Cycle is the AIRAC cycle the record was last changed in, such as 2003.
+	Cycle string `arinc:"129-132" json:"cycle"`
+}
+
+func (*Common) record() {}
+
+func (c *Common) common() *Common { return c }
+
+// AirportOf returns the airport of a record of the airport section, or ""
+// for other records.
+func AirportOf(r Record) string {
+	if c, ok := r.(interface{ common() *Common }); ok {
+		return c.common().Airport
+	}
+	return ""
+}
+
+// Primary holds what primary records have in common, besides their fields.
+type Primary struct {
+	Common
+	// Continuations are the continuation records that follow the primary
+	// record. Only Reader sets them.
+	Continuations []*Continuation `json:"continuations,omitempty"`
+}
+
+func (p *Primary) primary() *Primary { return p }
+
+// Airport is an airport reference point record, subsection A.
+type Airport struct {
+	Primary
+	IATA                    string `arinc:"14-16" json:"iata,omitempty"`
+	ContinuationNumber      string `arinc:"22" json:"continuation_number"`
+	SpeedLimitAltitude      string `arinc:"23-27" json:"speed_limit_altitude,omitempty"`
+	LongestRunway           string `arinc:"28-30" json:"longest_runway"`
+	IFR                     string `arinc:"31" json:"ifr"`
+	LongestRunwaySurface    string `arinc:"32" json:"longest_runway_surface,omitempty"`
+	Latitude                string `arinc:"33-41" json:"latitude"`
+	Longitude               string `arinc:"42-51" json:"longitude"`
+	MagneticVariation       string `arinc:"52-56" json:"magnetic_variation"`
+	Elevation               string `arinc:"57-61" json:"elevation"`
+	SpeedLimit              string `arinc:"62-64" json:"speed_limit,omitempty"`
+	RecommendedNavaid       string `arinc:"65-68" json:"recommended_navaid,omitempty"`
+	RecommendedNavaidRegion string `arinc:"69-70" json:"recommended_navaid_region,omitempty"`
+	TransitionAltitude      string `arinc:"71-75" json:"transition_altitude,omitempty"`
+	TransitionLevel         string `arinc:"76-80" json:"transition_level,omitempty"`
+	PublicMilitary          string `arinc:"81" json:"public_military"`
+	TimeZone                string `arinc:"82-84" json:"time_zone,omitempty"`
+	DaylightSaving          string `arinc:"85" json:"daylight_saving,omitempty"`
+	MagneticTrue            string `arinc:"86" json:"magnetic_true,omitempty"`
+	Datum                   string `arinc:"87-89" json:"datum,omitempty"`
+	Name                    string `arinc:"94-123" json:"name"`
+}
+
+// Position returns the coordinates of the airport reference point.
+func (a *Airport) Position() (lat, lon float64, err error) {
+	return position(a.Latitude, a.Longitude)
+}
+
+// Runway is a runway record, subsection G.
+type Runway struct {
+	Primary
+	Ident                   string `arinc:"14-18" json:"ident"`
+	ContinuationNumber      string `arinc:"22" json:"continuation_number"`
+	Length                  string `arinc:"23-27" json:"length"`
+	Bearing                 string `arinc:"28-31" json:"bearing"`
+	Latitude                string `arinc:"33-41" json:"latitude"`
+	Longitude               string `arinc:"42-51" json:"longitude"`
+	Gradient                string `arinc:"52-56" json:"gradient,omitempty"`
+	EllipsoidHeight         string `arinc:"61-66" json:"ellipsoid_height,omitempty"`
+	ThresholdElevation      string `arinc:"67-71" json:"threshold_elevation"`
+	DisplacedThreshold      string `arinc:"72-75" json:"displaced_threshold,omitempty"`
+	ThresholdCrossingHeight string `arinc:"76-77" json:"threshold_crossing_height,omitempty"`
+	Width                   string `arinc:"78-80" json:"width,omitempty"`
+	TCHIndicator            string `arinc:"81" json:"tch_indicator,omitempty"`
+	Localizer               string `arinc:"82-85" json:"localizer,omitempty"`
+	LocalizerClass          string `arinc:"86" json:"localizer_class,omitempty"`
+	Stopway                 string `arinc:"87-90" json:"stopway,omitempty"`
+	SecondLocalizer         string `arinc:"91-94" json:"second_localizer,omitempty"`
+	SecondLocalizerClass    string `arinc:"95" json:"second_localizer_class,omitempty"`
+	Description             string `arinc:"102-123" json:"description,omitempty"`
+}
+
+// Position returns the coordinates of the runway threshold.
+func (r *Runway) Position() (lat, lon float64, err error) {
+	return position(r.Latitude, r.Longitude)
+}
+
+// MagneticBearing returns the magnetic bearing of the runway.
+func (r *Runway) MagneticBearing() (Bearing, error) {
+	return ParseBearing(r.Bearing)
+}
+
+// Localizer is a localizer and glide slope record, subsection I.
+type Localizer struct {
+	Primary
+	Ident                        string `arinc:"14-17" json:"ident"`
+	Category                     string `arinc:"18" json:"category"`
+	ContinuationNumber           string `arinc:"22" json:"continuation_number"`
+	Frequency                    string `arinc:"23-27" json:"frequency"`
+	Runway                       string `arinc:"28-32" json:"runway"`
+	Latitude                     string `arinc:"33-41" json:"latitude"`
+	Longitude                    string `arinc:"42-51" json:"longitude"`
+	Bearing                      string `arinc:"52-55" json:"bearing"`
+	GlideSlopeLatitude           string `arinc:"56-64" json:"glide_slope_latitude,omitempty"`
+	GlideSlopeLongitude          string `arinc:"65-74" json:"glide_slope_longitude,omitempty"`
+	LocalizerPosition            string `arinc:"75-78" json:"localizer_position,omitempty"`
+	PositionReference            string `arinc:"79" json:"position_reference,omitempty"`
+	GlideSlopePosition           string `arinc:"80-83" json:"glide_slope_position,omitempty"`
+	Width                        string `arinc:"84-87" json:"width,omitempty"`
+	GlideSlopeAngle              string `arinc:"88-90" json:"glide_slope_angle,omitempty"`
+	Declination                  string `arinc:"91-95" json:"declination,omitempty"`
+	GlideSlopeTCH                string `arinc:"96-97" json:"glide_slope_tch,omitempty"`
+	GlideSlopeElevation          string `arinc:"98-102" json:"glide_slope_elevation,omitempty"`
+	SupportingFacility           string `arinc:"103-106" json:"supporting_facility,omitempty"`
+	SupportingFacilityRegion     string `arinc:"107-108" json:"supporting_facility_region,omitempty"`
+	SupportingFacilitySection    string `arinc:"109" json:"supporting_facility_section,omitempty"`
+	SupportingFacilitySubsection string `arinc:"110" json:"supporting_facility_subsection,omitempty"`
+}
+
+// Position returns the coordinates of the localizer antenna.
+func (l *Localizer) Position() (lat, lon float64, err error) {
+	return position(l.Latitude, l.Longitude)
+}
+
+// MagneticBearing returns the bearing of the localizer course.
+func (l *Localizer) MagneticBearing() (Bearing, error) {
+	return ParseBearing(l.Bearing)
+}
+
+// FrequencyMHz returns the frequency of the localizer in MHz.
+func (l *Localizer) FrequencyMHz() (float64, error) {
+	f, err := parseNumber(l.Frequency, 2)
+	if err != nil {
+		return 0, fmt.Errorf("invalid frequency %q", l.Frequency)
+	}
+	return f, nil
+}
+
+// TrueBearing returns the true bearing of the localizer course in its
+// simulation continuation record, which the enhancer computes from the final
+// approach fix, and false if the localizer has none.
+func (l *Localizer) TrueBearing() (float64, bool, error) {
+	for _, c := range l.Continuations {
+		if c.Application != "S" {
+			continue
+		}
+		// Data starts at column 24, and the bearing is in columns 52-56.
+		if len(c.Data) < 33 {
+			return 0, false, nil
+		}
+		s := c.Data[28:33]
+		b, err := parseNumber(s, 2)
+		if err != nil || b >= 360 {
+			return 0, false, fmt.Errorf("invalid true bearing %q", s)
+		}
+		return b, true, nil
+	}
+	return 0, false, nil
+}
+
+// GLS is a GBAS landing system record, subsection T.
+type GLS struct {
+	Primary
+	Ident                     string `arinc:"14-17" json:"ident"`
+	Category                  string `arinc:"18" json:"category"`
+	ContinuationNumber        string `arinc:"22" json:"continuation_number"`
+	Channel                   string `arinc:"23-27" json:"channel"`
+	Runway                    string `arinc:"28-32" json:"runway"`
+	Bearing                   string `arinc:"52-55" json:"bearing"`
+	Latitude                  string `arinc:"56-64" json:"latitude"`
+	Longitude                 string `arinc:"65-74" json:"longitude"`
+	Station                   string `arinc:"75-78" json:"station"`
+	ServiceVolumeRadius       string `arinc:"81-82" json:"service_volume_radius,omitempty"`
+	TDMASlots                 string `arinc:"83-84" json:"tdma_slots,omitempty"`
+	ApproachSlope             string `arinc:"85-87" json:"approach_slope,omitempty"`
+	MagneticVariation         string `arinc:"88-92" json:"magnetic_variation,omitempty"`
+	StationElevation          string `arinc:"95-99" json:"station_elevation,omitempty"`
+	Datum                     string `arinc:"100-102" json:"datum,omitempty"`
+	StationType               string `arinc:"103-104" json:"station_type,omitempty"`
+	StationEllipsoidElevation string `arinc:"107-111" json:"station_ellipsoid_elevation,omitempty"`
+}
+
+// Position returns the coordinates of the GLS ground station.
+func (g *GLS) Position() (lat, lon float64, err error) {
+	return position(g.Latitude, g.Longitude)
+}
+
+// MagneticBearing returns the bearing of the GLS approach.
+func (g *GLS) MagneticBearing() (Bearing, error) {
+	return ParseBearing(g.Bearing)
+}
+
+// TerminalWaypoint is a waypoint record of an airport, subsection C.
+type TerminalWaypoint struct {
+	Primary
+	Ident              string `arinc:"14-18" json:"ident"`
+	WaypointRegion     string `arinc:"20-21" json:"waypoint_region"`
+	ContinuationNumber string `arinc:"22" json:"continuation_number"`
+	Type               string `arinc:"27-29" json:"type"`
+	Usage              string `arinc:"30-31" json:"usage,omitempty"`
+	Latitude           string `arinc:"33-41" json:"latitude"`
+	Longitude          string `arinc:"42-51" json:"longitude"`
+	MagneticVariation  string `arinc:"75-79" json:"magnetic_variation,omitempty"`
+	Datum              string `arinc:"85-87" json:"datum,omitempty"`
+	NameFormat         string `arinc:"96-98" json:"name_format,omitempty"`
+	Name               string `arinc:"99-123" json:"name,omitempty"`
+}
+
+// Position returns the coordinates of the waypoint.
+func (w *TerminalWaypoint) Position() (lat, lon float64, err error) {
+	return position(w.Latitude, w.Longitude)
+}
+
+// Procedure is a leg of a SID, STAR or approach procedure, subsections D,
+// E and F.
+type Procedure struct {
+	Primary
+	Ident                       string `arinc:"14-19" json:"ident"`
+	RouteType                   string `arinc:"20" json:"route_type"`
+	Transition                  string `arinc:"21-25" json:"transition,omitempty"`
+	Sequence                    string `arinc:"27-29" json:"sequence"`
+	Fix                         string `arinc:"30-34" json:"fix,omitempty"`
+	FixRegion                   string `arinc:"35-36" json:"fix_region,omitempty"`
+	FixSection                  string `arinc:"37" json:"fix_section,omitempty"`
+	FixSubsection               string `arinc:"38" json:"fix_subsection,omitempty"`
+	ContinuationNumber          string `arinc:"39" json:"continuation_number"`
+	DescriptionCode             string `arinc:"40-43" json:"description_code,omitempty"`
+	TurnDirection               string `arinc:"44" json:"turn_direction,omitempty"`
+	RNP                         string `arinc:"45-47" json:"rnp,omitempty"`
+	PathTerminator              string `arinc:"48-49" json:"path_terminator"`
+	TurnDirectionValid          string `arinc:"50" json:"turn_direction_valid,omitempty"`
+	RecommendedNavaid           string `arinc:"51-54" json:"recommended_navaid,omitempty"`
+	RecommendedNavaidRegion     string `arinc:"55-56" json:"recommended_navaid_region,omitempty"`
+	ArcRadius                   string `arinc:"57-62" json:"arc_radius,omitempty"`
+	Theta                       string `arinc:"63-66" json:"theta,omitempty"`
+	Rho                         string `arinc:"67-70" json:"rho,omitempty"`
+	Course                      string `arinc:"71-74" json:"course,omitempty"`
+	Distance                    string `arinc:"75-78" json:"distance,omitempty"`
+	RecommendedNavaidSection    string `arinc:"79" json:"recommended_navaid_section,omitempty"`
+	RecommendedNavaidSubsection string `arinc:"80" json:"recommended_navaid_subsection,omitempty"`
+	AltitudeDescription         string `arinc:"83" json:"altitude_description,omitempty"`
+	ATCIndicator                string `arinc:"84" json:"atc_indicator,omitempty"`
+	Altitude                    string `arinc:"85-89" json:"altitude,omitempty"`
+	Altitude2                   string `arinc:"90-94" json:"altitude2,omitempty"`
+	TransitionAltitude          string `arinc:"95-99" json:"transition_altitude,omitempty"`
+	SpeedLimit                  string `arinc:"100-102" json:"speed_limit,omitempty"`
+	VerticalAngle               string `arinc:"103-106" json:"vertical_angle,omitempty"`
+	CenterFix                   string `arinc:"107-111" json:"center_fix,omitempty"`
+	MultipleCode                string `arinc:"112" json:"multiple_code,omitempty"`
+	CenterFixRegion             string `arinc:"113-114" json:"center_fix_region,omitempty"`
+	CenterFixSection            string `arinc:"115" json:"center_fix_section,omitempty"`
+	CenterFixSubsection         string `arinc:"116" json:"center_fix_subsection,omitempty"`
+	GNSSFMSIndicator            string `arinc:"117" json:"gnss_fms_indicator,omitempty"`
+	SpeedLimitDescription       string `arinc:"118" json:"speed_limit_description,omitempty"`
+	RouteQualifier1             string `arinc:"119" json:"route_qualifier1,omitempty"`
+	RouteQualifier2             string `arinc:"120" json:"route_qualifier2,omitempty"`
+}
+
+// Procedure kinds, which are the subsections of their records.
+const (
+	SID      = "D"
+	STAR     = "E"
+	Approach = "F"
+)
+
+// PathPoint is the final approach segment data of an RNAV approach,
+// subsection P.
+type PathPoint struct {
+	Primary
+	Approach                  string `arinc:"14-19" json:"approach"`
+	Runway                    string `arinc:"20-24" json:"runway"`
+	OperationType             string `arinc:"25-26" json:"operation_type"`
+	ContinuationNumber        string `arinc:"27" json:"continuation_number"`
+	RouteIndicator            string `arinc:"28" json:"route_indicator,omitempty"`
+	SBASProvider              string `arinc:"29-30" json:"sbas_provider"`
+	ReferencePathDataSelector string `arinc:"31-32" json:"reference_path_data_selector"`
+	ReferencePath             string `arinc:"33-36" json:"reference_path"`
+	ApproachPerformance       string `arinc:"37" json:"approach_performance"`
+	Latitude                  string `arinc:"38-48" json:"latitude"`
+	Longitude                 string `arinc:"49-60" json:"longitude"`
+	EllipsoidHeight           string `arinc:"61-66" json:"ellipsoid_height"`
+	GlidePathAngle            string `arinc:"67-70" json:"glide_path_angle"`
+	FPAPLatitude              string `arinc:"71-81" json:"fpap_latitude"`
+	FPAPLongitude             string `arinc:"82-93" json:"fpap_longitude"`
+	CourseWidth               string `arinc:"94-98" json:"course_width"`
+	LengthOffset              string `arinc:"99-102" json:"length_offset,omitempty"`
+	TCH                       string `arinc:"103-108" json:"tch"`
+	TCHUnits                  string `arinc:"109" json:"tch_units"`
+	HAL                       string `arinc:"110-112" json:"hal"`
+	VAL                       string `arinc:"113-115" json:"val"`
+	CRC                       string `arinc:"116-123" json:"crc"`
+}
+
+// Position returns the coordinates of the landing threshold point.
+func (p *PathPoint) Position() (lat, lon float64, err error) {
+	return position(p.Latitude, p.Longitude)
+}
+
+// MSA is a minimum sector altitude record, subsection S.
+type MSA struct {
+	Primary
+	Center             string    `arinc:"14-18" json:"center"`
+	CenterRegion       string    `arinc:"19-20" json:"center_region"`
+	CenterSection      string    `arinc:"21" json:"center_section"`
+	CenterSubsection   string    `arinc:"22" json:"center_subsection,omitempty"`
+	MultipleCode       string    `arinc:"23" json:"multiple_code,omitempty"`
+	ContinuationNumber string    `arinc:"39" json:"continuation_number"`
+	Sectors            [7]Sector `arinc:"43-119" json:"sectors"`
+	MagneticTrue       string    `arinc:"120" json:"magnetic_true"`
+}
+
+// Sector is a sector of an MSA. Unused sectors are blank.
+type Sector struct {
+	// From and To are the bearings to the center that bound the sector.
+	From     string `arinc:"1-3" json:"from,omitempty"`
+	To       string `arinc:"4-6" json:"to,omitempty"`
+	Altitude string `arinc:"7-9" json:"altitude,omitempty"`
+	Radius   string `arinc:"10-11" json:"radius,omitempty"`
+}
+
+// Continuation is a continuation record. Its fields depend on the primary
+// record and its application type, so they are left undecoded in Data.
+type Continuation struct {
+	Common
+	// Ident is the text between the subsection and the continuation record
+	// number, which identifies the primary record.
+	Ident       string `json:"ident"`
+	Number      string `json:"number"`
+	Application string `json:"application"`
+	// Data is the text after the application type up to the record number.
+	Data string `json:"data"`
+}
+
+func (c *Continuation) decode(line string) {
+	col := continuationColumns[line[12]]
+	c.Ident = strings.TrimRight(line[13:col-1], " ")
+	c.Number = line[col-1 : col]
+	c.Application = line[col : col+1]
+	c.Data = strings.TrimRight(line[col+1:123], " ")
+}
+
+func (c *Continuation) encode(b []byte) error {
+	if len(c.Subsection) != 1 || continuationColumns[c.Subsection[0]] == 0 {
+		return fmt.Errorf("no continuation records in subsection %q", c.Subsection)
+	}
+	col := continuationColumns[c.Subsection[0]]
+	if err := put(b, 14, col-1, "Ident", c.Ident); err != nil {
+		return err
+	}
+	if err := put(b, col, col, "Number", c.Number); err != nil {
+		return err
+	}
+	if err := put(b, col+1, col+1, "Application", c.Application); err != nil {
+		return err
+	}
+	return put(b, col+2, 123, "Data", c.Data)
+}
+
+// Unknown is a record of a section or subsection without a type in this
+// package, such as a navaid. It is kept as its line.
+type Unknown struct {
+	Line string `json:"line"`
+}
+
+func (*Unknown) record() {}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+)
+
+const (
+	// APIKeyScheme is the Authorization scheme of requests signed with an
+	// API key: "HMAC-SHA256 <key ID>:<hex signature>".
+	APIKeyScheme = "HMAC-SHA256"
+	// TimestampHeader carries the Unix time a signed request was made at.
+	TimestampHeader = "X-Signature-Timestamp"
+
+	// maxSignedBody is the largest request body that can be signed.
+	maxSignedBody = 1 << 20
+	// defaultMaxSkew is how far a signed request's timestamp may be from
+	// the current time.
+	defaultMaxSkew = 5 * time.Minute
+)
+
+type apiKeyGetter interface {
+	Get(context.Context, string) (*db.APIKey, error)
+	Touch(_ context.Context, id string, at time.Time) error
+}
+
+type nonceStore interface {
+	Use(_ context.Context, nonce string, now, expires time.Time) error
+}
+
+// NewAPIKey returns a new key granting role and its secret. The secret is
+// derived from the ID of the key and pepper, a server-side secret that is
+// not stored with the keys, so reading the database is not enough to sign
+// requests. Only the hash of the secret is kept in the key; the secret must
+// be given to the caller.
+func NewAPIKey(pepper []byte, name string, role Role, now time.Time) (*db.APIKey, string, error) {
+	if len(pepper) == 0 {
+		return nil, "", errors.New("no API key pepper configured")
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", fmt.Errorf("could not generate key ID: %v", err)
+	}
+	key := &db.APIKey{
+		ID:      hex.EncodeToString(id),
+		Name:    name,
+		Role:    string(role),
+		Created: now,
+	}
+	secret := deriveSecret(pepper, key.ID)
+	key.Hash = hashSecret(secret)
+	return key, secret, nil
+}
+
+// deriveSecret returns the secret of the API key id, which requests are
+// signed with.
+func deriveSecret(pepper []byte, id string) string {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte("api-key:" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hashSecret returns the hex encoded SHA-256 hash of an API key secret. It
+// ties a stored key to the pepper it was issued with, so that keys issued
+// with another pepper are rejected rather than failing every signature.
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// stringToSign returns what is signed for a request: its method, path,
+// timestamp and the hex encoded SHA-256 hash of its body, one per line.
+func stringToSign(method, path, timestamp string, body []byte) string {
+	h := sha256.Sum256(body)
+	return strings.Join([]string{method, path, timestamp, hex.EncodeToString(h[:])}, "\n")
+}
+
+func sign(secret string, msg string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// SignRequest signs r with the API key id and its secret, as of time t. The
+// body of r is read and replaced so that it can still be sent.
+func SignRequest(r *http.Request, id, secret string, t time.Time) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return fmt.Errorf("could not read body: %v", err)
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	ts := strconv.FormatInt(t.Unix(), 10)
+	sig := sign(secret, stringToSign(r.Method, r.URL.Path, ts, body))
+	r.Header.Set(TimestampHeader, ts)
+	r.Header.Set("Authorization", APIKeyScheme+" "+id+":"+hex.EncodeToString(sig))
+	return nil
+}
+
+// IsAPIKeyRequest reports whether r is signed with an API key.
+func IsAPIKeyRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), APIKeyScheme+" ")
+}
+
+// APIKeyVerifier verifies requests signed with API keys. A signature is only
+// accepted once, and only within MaxSkew of its timestamp, so captured
+// requests cannot be replayed. Used signatures are recorded in Nonces, which
+// must be shared by every replica of the app.
+type APIKeyVerifier struct {
+	Keys   apiKeyGetter
+	Nonces nonceStore
+	// Pepper is the server-side secret the keys were issued with.
+	Pepper []byte
+	// MaxSkew defaults to five minutes.
+	MaxSkew time.Duration
+
+	now func() time.Time
+}
+
+// Verify checks the signature of r and returns the key it was signed with.
+// The body of r is read and replaced so that handlers can still read it.
+func (v *APIKeyVerifier) Verify(r *http.Request) (_ *db.APIKey, rej *Rejection) {
+	ctx, span := tracing.Start(r.Context(), "auth.APIKeyVerifier.Verify")
+	defer func() {
+		var err error
+		if rej != nil {
+			err = rej
+		}
+		tracing.End(span, err)
+	}()
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	maxSkew := v.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = defaultMaxSkew
+	}
+
+	cred := strings.TrimPrefix(r.Header.Get("Authorization"), APIKeyScheme+" ")
+	parts := strings.SplitN(cred, ":", 2)
+	if len(parts) != 2 {
+		return nil, unauthorized("Authorization header must be %q.", APIKeyScheme+" <key ID>:<signature>")
+	}
+	id, sigHex := parts[0], parts[1]
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return nil, unauthorized("Signature must be hex encoded.")
+	}
+	ts := r.Header.Get(TimestampHeader)
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, unauthorized("%s header must be a Unix time.", TimestampHeader)
+	}
+	if skew := now.Sub(time.Unix(secs, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, unauthorized("Request timestamp is outside the allowed window of %v.", maxSkew)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+		if err != nil {
+			return nil, unauthorized("Could not read request body.")
+		}
+		if len(body) > maxSignedBody {
+			return nil, unauthorized("Signed request bodies are limited to %d bytes.", maxSignedBody)
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	key, err := v.Keys.Get(ctx, id)
+	if err != nil {
+		logging.Errorf(ctx, "Could not get API key %q: %v", id, err)
+		return nil, unauthorized("Invalid credentials.")
+	}
+	if key == nil || key.Revoked() {
+		return nil, unauthorized("Unknown or revoked API key %q.", id)
+	}
+	secret := deriveSecret(v.Pepper, id)
+	if len(v.Pepper) == 0 || !hmac.Equal([]byte(hashSecret(secret)), []byte(key.Hash)) {
+		logging.Errorf(ctx, "API key %q was not issued with the configured pepper.", id)
+		return nil, unauthorized("Invalid credentials.")
+	}
+	if !hmac.Equal(sig, sign(secret, stringToSign(r.Method, r.URL.Path, ts, body))) {
+		return nil, unauthorized("Invalid signature.")
+	}
+	// A signature cannot be replayed once its timestamp is outside the
+	// window, so it only has to be remembered until then. It is recorded
+	// re-encoded, since hex of any case decodes to the same signature.
+	if err := v.Nonces.Use(ctx, hex.EncodeToString(sig), now, time.Unix(secs, 0).Add(maxSkew+time.Second)); errors.Is(err, db.ErrNonceUsed) {
+		return nil, unauthorized("Request has already been used.")
+	} else if err != nil {
+		logging.Errorf(ctx, "Could not record signature of API key %q: %v", id, err)
+		return nil, unauthorized("Invalid credentials.")
+	}
+
+	if err := v.Keys.Touch(ctx, id, now); err != nil {
+		logging.Errorf(ctx, "Could not record use of API key %q: %v", id, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+type fakeAPIKeys struct {
+	Keys    map[string]*db.APIKey
+	Touched map[string]time.Time
+}
+
+func (f *fakeAPIKeys) Get(_ context.Context, id string) (*db.APIKey, error) {
+	return f.Keys[id], nil
+}
+
+func (f *fakeAPIKeys) Touch(_ context.Context, id string, at time.Time) error {
+	if f.Touched == nil {
+		f.Touched = make(map[string]time.Time)
+	}
+	f.Touched[id] = at
+	return nil
+}
+
+// fakeNonces is a db.NonceStore that never forgets a nonce.
+type fakeNonces map[string]bool
+
+func (f fakeNonces) Use(_ context.Context, nonce string, _, _ time.Time) error {
+	if f[nonce] {
+		return db.ErrNonceUsed
+	}
+	f[nonce] = true
+	return nil
+}
+
+var testPepper = []byte("0123456789abcdef0123456789abcdef")
+
+func TestAPIKeyVerify(t *testing.T) {
+	now := time.Date(2020, 7, 16, 12, 0, 0, 0, time.UTC)
+	key, secret, err := NewAPIKey(testPepper, "cron", RoleScheduler, now)
+	if err != nil {
+		t.Fatalf("NewAPIKey() = _, _, %v want _, _, <nil>", err)
+	}
+	if strings.Contains(key.Hash, secret) {
+		t.Fatal("NewAPIKey() stored the secret in the key")
+	}
+	revoked, revokedSecret, err := NewAPIKey(testPepper, "old", RoleScheduler, now)
+	if err != nil {
+		t.Fatalf("NewAPIKey() = _, _, %v want _, _, <nil>", err)
+	}
+	revoked.RevokedAt = now
+	otherPepper, otherPepperSecret, err := NewAPIKey([]byte("another pepper"), "other", RoleScheduler, now)
+	if err != nil {
+		t.Fatalf("NewAPIKey() = _, _, %v want _, _, <nil>", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		id       string
+		secret   string
+		signedAt time.Time
+		tamper   func(r *http.Request)
+		wantErr  bool
+	}{
+		{
+			name:     "Good",
+			id:       key.ID,
+			secret:   secret,
+			signedAt: now,
+		},
+		{
+			name:     "SlightlySkewed",
+			id:       key.ID,
+			secret:   secret,
+			signedAt: now.Add(-4 * time.Minute),
+		},
+		{
+			name:     "WrongSecret",
+			id:       key.ID,
+			secret:   revokedSecret,
+			signedAt: now,
+			wantErr:  true,
+		},
+		{
+			name:     "StoredHash",
+			id:       key.ID,
+			secret:   key.Hash,
+			signedAt: now,
+			wantErr:  true,
+		},
+		{
+			name:     "OtherPepper",
+			id:       otherPepper.ID,
+			secret:   otherPepperSecret,
+			signedAt: now,
+			wantErr:  true,
+		},
+		{
+			name:     "UnknownKey",
+			id:       "unknown",
+			secret:   secret,
+			signedAt: now,
+			wantErr:  true,
+		},
+		{
+			name:     "RevokedKey",
+			id:       revoked.ID,
+			secret:   revokedSecret,
+			signedAt: now,
+			wantErr:  true,
+		},
+		{
+			name:     "Stale",
+			id:       key.ID,
+			secret:   secret,
+			signedAt: now.Add(-10 * time.Minute),
+			wantErr:  true,
+		},
+		{
+			name:     "TamperedBody",
+			id:       key.ID,
+			secret:   secret,
+			signedAt: now,
+			tamper: func(r *http.Request) {
+				r.Body = ioutil.NopCloser(strings.NewReader(`{"cycle": "other"}`))
+			},
+			wantErr: true,
+		},
+		{
+			name:     "TamperedPath",
+			id:       key.ID,
+			secret:   secret,
+			signedAt: now,
+			tamper:   func(r *http.Request) { r.URL.Path = "/admin/apikeys" },
+			wantErr:  true,
+		},
+		{
+			name:     "TamperedMethod",
+			id:       key.ID,
+			secret:   secret,
+			signedAt: now,
+			tamper:   func(r *http.Request) { r.Method = http.MethodDelete },
+			wantErr:  true,
+		},
+		{
+			name:     "TamperedTimestamp",
+			id:       key.ID,
+			secret:   secret,
+			signedAt: now,
+			tamper:   func(r *http.Request) { r.Header.Set(TimestampHeader, "1594900801") },
+			wantErr:  true,
+		},
+		{
+			name:     "MalformedHeader",
+			id:       key.ID,
+			secret:   secret,
+			signedAt: now,
+			tamper:   func(r *http.Request) { r.Header.Set("Authorization", APIKeyScheme+" nocolon") },
+			wantErr:  true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := &fakeAPIKeys{Keys: map[string]*db.APIKey{key.ID: key, revoked.ID: revoked, otherPepper.ID: otherPepper}}
+			v := &APIKeyVerifier{Keys: keys, Nonces: fakeNonces{}, Pepper: testPepper, now: func() time.Time { return now }}
+
+			req := httptest.NewRequest(http.MethodPost, "/process", strings.NewReader(`{"cycle": "06/18/2020"}`))
+			if err := SignRequest(req, tt.id, tt.secret, tt.signedAt); err != nil {
+				t.Fatalf("SignRequest() = %v want <nil>", err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(req)
+			}
+			got, rej := v.Verify(req)
+			if tt.wantErr {
+				if rej == nil {
+					t.Fatal("Verify() = _, <nil> want _, <non-nil>")
+				}
+				if rej.Status != http.StatusUnauthorized {
+					t.Errorf("Verify() status = %d want %d", rej.Status, http.StatusUnauthorized)
+				}
+				if _, ok := keys.Touched[tt.id]; ok {
+					t.Error("rejected request recorded key use")
+				}
+				return
+			}
+			if rej != nil {
+				t.Fatalf("Verify() = _, %v want _, <nil>", rej)
+			}
+			if got.ID != key.ID {
+				t.Errorf("Verify() key = %q want %q", got.ID, key.ID)
+			}
+			if used := keys.Touched[key.ID]; !used.Equal(now) {
+				t.Errorf("key last used at %v want %v", used, now)
+			}
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil || string(body) != `{"cycle": "06/18/2020"}` {
+				t.Errorf("body after Verify() = %q, %v want original body", body, err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyReplay(t *testing.T) {
+	now := time.Now()
+	key, secret, err := NewAPIKey(testPepper, "cron", RoleScheduler, now)
+	if err != nil {
+		t.Fatalf("NewAPIKey() = _, _, %v want _, _, <nil>", err)
+	}
+	// Each replica of the app has its own verifier, sharing the nonces.
+	keys := &fakeAPIKeys{Keys: map[string]*db.APIKey{key.ID: key}}
+	nonces := fakeNonces{}
+	a := &APIKeyVerifier{Keys: keys, Nonces: nonces, Pepper: testPepper}
+	b := &APIKeyVerifier{Keys: keys, Nonces: nonces, Pepper: testPepper}
+
+	req := httptest.NewRequest(http.MethodPost, "/process", nil)
+	if err := SignRequest(req, key.ID, secret, now); err != nil {
+		t.Fatalf("SignRequest() = %v want <nil>", err)
+	}
+	if _, rej := a.Verify(req); rej != nil {
+		t.Fatalf("first Verify() = _, %v want _, <nil>", rej)
+	}
+	for _, tc := range []struct {
+		name string
+		auth string
+	}{
+		{name: "same", auth: req.Header.Get("Authorization")},
+		{name: "upper-cased signature", auth: APIKeyScheme + " " + key.ID + ":" + strings.ToUpper(strings.TrimPrefix(req.Header.Get("Authorization"), APIKeyScheme+" "+key.ID+":"))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			replay := httptest.NewRequest(http.MethodPost, "/process", nil)
+			replay.Header = req.Header.Clone()
+			replay.Header.Set("Authorization", tc.auth)
+			if _, rej := b.Verify(replay); rej == nil || rej.Status != http.StatusUnauthorized {
+				t.Errorf("replayed Verify() = _, %v want _, status %d", rej, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	now := time.Now()
+	scheduler, schedulerSecret, err := NewAPIKey(testPepper, "cron", RoleScheduler, now)
+	if err != nil {
+		t.Fatalf("NewAPIKey() = _, _, %v want _, _, <nil>", err)
+	}
+	reader, readerSecret, err := NewAPIKey(testPepper, "dashboard", RoleReader, now)
+	if err != nil {
+		t.Fatalf("NewAPIKey() = _, _, %v want _, _, <nil>", err)
+	}
+	m := &Middleware{
+		Verifier: &fakeTokenVerifier{},
+		APIKeys: &APIKeyVerifier{
+			Keys: &fakeAPIKeys{Keys: map[string]*db.APIKey{
+				scheduler.ID: scheduler,
+				reader.ID:    reader,
+			}},
+			Nonces: fakeNonces{},
+			Pepper: testPepper,
+		},
+	}
+
+	for _, tt := range []struct {
+		name       string
+		id, secret string
+		wantStatus int
+	}{
+		{name: "Scheduler", id: scheduler.ID, secret: schedulerSecret, wantStatus: http.StatusOK},
+		{name: "Reader", id: reader.ID, secret: readerSecret, wantStatus: http.StatusForbidden},
+		{name: "BadSignature", id: scheduler.ID, secret: readerSecret, wantStatus: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *Identity
+			h := m.Require(RoleScheduler, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = IdentityFrom(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/process", nil)
+			if err := SignRequest(req, tt.id, tt.secret, now); err != nil {
+				t.Fatalf("SignRequest() = %v want <nil>", err)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && (got == nil || got.Issuer != APIKeyIssuer) {
+				t.Errorf("identity = %v want API key identity", got)
+			}
+		})
+	}
+}
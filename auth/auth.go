@@ -3,14 +3,20 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
 )
 
+// Verifier verifies Google ID tokens by calling Google's tokeninfo endpoint.
+// JWKSVerifier verifies them offline instead.
 type Verifier struct {
 	url string
 }
@@ -22,10 +28,14 @@ func NewVerifier() *Verifier {
 }
 
 type jwt struct {
-	Audience      string `json:"aud"`
-	Email         string `json:"email"`
-	EmailVerified string `json:"email_verified"`
-	Expires       string `json:"exp"`
+	Issuer          string `json:"iss"`
+	Subject         string `json:"sub"`
+	Audience        string `json:"aud"`
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	EmailVerified   string `json:"email_verified"`
+	HostedDomain    string `json:"hd"`
+	Expires         string `json:"exp"`
 }
 
 // ParseAuthHeader returns the bearer token of the provided authorization
@@ -37,33 +47,71 @@ func ParseAuthHeader(header string) string {
 	return strings.TrimPrefix(header, "Bearer ")
 }
 
+// VerifyGoogle verifies token and returns the verified email address of its
+// subject.
 func (v *Verifier) VerifyGoogle(ctx context.Context, token string) (string, error) {
-	res, err := http.Get(v.url + "?id_token=" + token)
+	c, err := v.Verify(ctx, token)
 	if err != nil {
-		return "", fmt.Errorf("problem getting token info: %v", err)
+		return "", err
+	}
+	if !c.EmailVerified {
+		return "", fmt.Errorf("invalid email: %q", c.Email)
 	}
+	return c.Email, nil
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+// Verify asks Google to verify token and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (_ *Claims, err error) {
+	ctx, span := tracing.Start(ctx, "auth.Verifier.Verify")
+	defer func() { tracing.End(span, err) }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url+"?id_token="+url.QueryEscape(token), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create token info request: %v", err)
+	}
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("could not read body: %v", err)
+		return nil, fmt.Errorf("problem getting token info: %v", err)
 	}
 	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("problem getting token info, got status %s", res.Status)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read body: %v", err)
+	}
 
 	var j jwt
 	if err := json.Unmarshal(body, &j); err != nil {
-		return "", fmt.Errorf("could not unmarshal response: %v", err)
+		return nil, fmt.Errorf("could not unmarshal response: %v", err)
 	}
 
-	if j.EmailVerified != "true" {
-		return "", fmt.Errorf("invalid email: %q", j.Email)
-	}
 	expireSeconds, err := strconv.ParseInt(j.Expires, 10, 64)
 	if err != nil {
-		return "", fmt.Errorf("invalid expiry time format: %v", err)
+		return nil, fmt.Errorf("invalid expiry time format: %v", err)
 	}
 	expireTime := time.Unix(expireSeconds, 0)
 	if time.Now().After(expireTime) {
-		return "", fmt.Errorf("credentials expired: %v", err)
+		return nil, errors.New("credentials expired")
 	}
-	return j.Email, nil
+	return &Claims{
+		Issuer:          j.Issuer,
+		Subject:         j.Subject,
+		Audience:        audience{j.Audience},
+		AuthorizedParty: j.AuthorizedParty,
+		Email:           j.Email,
+		EmailVerified:   j.EmailVerified == "true",
+		HostedDomain:    j.HostedDomain,
+		Expires:         expireSeconds,
+		raw: map[string]interface{}{
+			"iss":            j.Issuer,
+			"sub":            j.Subject,
+			"aud":            j.Audience,
+			"azp":            j.AuthorizedParty,
+			"email":          j.Email,
+			"email_verified": j.EmailVerified == "true",
+			"hd":             j.HostedDomain,
+		},
+	}, nil
 }
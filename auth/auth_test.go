@@ -127,3 +127,15 @@ func TestVerifyGoogle(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyGoogleErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "invalid_token"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	v := &Verifier{url: srv.URL}
+	if _, err := v.VerifyGoogle(context.Background(), "some-token"); err == nil {
+		t.Error("VerifyGoogle() = _, <nil> want _, <non-nil>")
+	}
+}
@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+)
+
+const (
+	googleCertsURL = "https://www.googleapis.com/oauth2/v3/certs"
+	// minRefreshInterval limits how often an unknown key ID can force the key
+	// set to be fetched again, so forged tokens cannot hammer the endpoint.
+	minRefreshInterval = time.Minute
+)
+
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// JWKSVerifier verifies Google ID tokens offline against Google's published
+// signing keys. Keys are cached for as long as the Cache-Control header of
+// the key set allows, and tokens that verify are cached until they expire.
+type JWKSVerifier struct {
+	jwksURL string
+	issuers []string
+	client  *http.Client
+	now     func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	keysExpire  time.Time
+	lastRefresh time.Time
+	tokens      map[string]*Claims
+}
+
+// NewJWKSVerifier returns a verifier for ID tokens signed by Google.
+func NewJWKSVerifier() *JWKSVerifier {
+	return &JWKSVerifier{
+		jwksURL: googleCertsURL,
+		issuers: googleIssuers,
+	}
+}
+
+// Claims are the claims of a verified ID token.
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Email           string   `json:"email"`
+	EmailVerified   bool     `json:"email_verified"`
+	HostedDomain    string   `json:"hd"`
+	Expires         int64    `json:"exp"`
+	NotBefore       int64    `json:"nbf"`
+	IssuedAt        int64    `json:"iat"`
+
+	// raw holds every claim of the token, including ones without a field.
+	raw map[string]interface{}
+}
+
+// Claim returns the value of the named claim formatted as a string, and
+// whether the token has it. Lists and objects are not supported. Claims that
+// were not decoded from a token only have the claims with fields.
+func (c *Claims) Claim(name string) (string, bool) {
+	if c.raw == nil {
+		return c.fieldClaim(name)
+	}
+	switch v := c.raw[name].(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+func (c *Claims) fieldClaim(name string) (string, bool) {
+	var v string
+	switch name {
+	case "iss":
+		v = c.Issuer
+	case "sub":
+		v = c.Subject
+	case "azp":
+		v = c.AuthorizedParty
+	case "email":
+		v = c.Email
+	case "hd":
+		v = c.HostedDomain
+	case "email_verified":
+		return strconv.FormatBool(c.EmailVerified), true
+	}
+	return v, v != ""
+}
+
+// audience is the "aud" claim, which may be a single string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return fmt.Errorf("invalid audience: %v", err)
+	}
+	*a = multiple
+	return nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// VerifyGoogle verifies the signature and claims of token and returns the
+// verified email address of its subject.
+func (v *JWKSVerifier) VerifyGoogle(ctx context.Context, token string) (string, error) {
+	c, err := v.Verify(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	if !c.EmailVerified {
+		return "", fmt.Errorf("invalid email: %q", c.Email)
+	}
+	return c.Email, nil
+}
+
+// Verify checks that token is an RS256 JWT signed by a key in the key set,
+// issued by a trusted issuer and currently valid, and returns its claims.
+func (v *JWKSVerifier) Verify(ctx context.Context, token string) (_ *Claims, err error) {
+	ctx, span := tracing.Start(ctx, "auth.JWKSVerifier.Verify")
+	defer func() { tracing.End(span, err) }()
+	now := v.timeNow()
+	v.mu.Lock()
+	c, ok := v.tokens[token]
+	v.mu.Unlock()
+	if ok && now.Before(time.Unix(c.Expires, 0)) {
+		return c, nil
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var h jwtHeader
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	if h.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", h.Algorithm)
+	}
+	key, err := v.key(ctx, h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+
+	c = &Claims{}
+	if err := decodeSegment(parts[1], c); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	if err := decodeSegment(parts[1], &c.raw); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	if !contains(v.issuers, c.Issuer) {
+		return nil, fmt.Errorf("untrusted issuer %q", c.Issuer)
+	}
+	if c.Expires == 0 || !now.Before(time.Unix(c.Expires, 0)) {
+		return nil, errors.New("credentials expired")
+	}
+	if c.NotBefore != 0 && now.Before(time.Unix(c.NotBefore, 0)) {
+		return nil, errors.New("credentials not valid yet")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.tokens == nil {
+		v.tokens = make(map[string]*Claims)
+	}
+	for t, cached := range v.tokens {
+		if !now.Before(time.Unix(cached.Expires, 0)) {
+			delete(v.tokens, t)
+		}
+	}
+	v.tokens[token] = c
+	return c, nil
+}
+
+// key returns the public key with the specified ID, fetching the key set if
+// the cached one has expired or does not contain the key.
+func (v *JWKSVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.timeNow()
+	if key, ok := v.keys[kid]; ok && now.Before(v.keysExpire) {
+		return key, nil
+	}
+	if _, ok := v.keys[kid]; !ok && now.Before(v.keysExpire) && now.Sub(v.lastRefresh) < minRefreshInterval {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if err := v.refreshKeys(ctx, now); err != nil {
+		return nil, err
+	}
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+// refreshKeys fetches the key set. It must be called with v.mu held.
+func (v *JWKSVerifier) refreshKeys(ctx context.Context, now time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "auth.JWKSVerifier.refreshKeys")
+	defer func() { tracing.End(span, err) }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return fmt.Errorf("could not create key set request: %v", err)
+	}
+	client := v.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not fetch key set: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not fetch key set, got status %s", res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("could not read key set: %v", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return fmt.Errorf("could not unmarshal key set: %v", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+		key, err := k.rsaKey()
+		if err != nil {
+			return fmt.Errorf("invalid key %q: %v", k.KeyID, err)
+		}
+		keys[k.KeyID] = key
+	}
+	v.keys = keys
+	v.lastRefresh = now
+	v.keysExpire = now.Add(maxAge(res.Header))
+	return nil
+}
+
+func (k *jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %v", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %v", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// maxAge returns how long a response may be cached according to its
+// Cache-Control and Age headers. Responses without a max-age, or marked
+// no-cache or no-store, are not cached.
+func maxAge(h http.Header) time.Duration {
+	var age time.Duration
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			age = time.Duration(seconds) * time.Second
+		}
+	}
+	if elapsed, err := strconv.Atoi(h.Get("Age")); err == nil && elapsed > 0 {
+		age -= time.Duration(elapsed) * time.Second
+	}
+	if age < 0 {
+		return 0
+	}
+	return age
+}
+
+func (v *JWKSVerifier) timeNow() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
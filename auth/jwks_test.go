@@ -0,0 +1,343 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testKeyID = "test-key"
+
+var (
+	testKey  = mustGenerateKey()
+	otherKey = mustGenerateKey()
+)
+
+func mustGenerateKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// signToken returns a JWT with the specified header and claims, signed with
+// key using RS256.
+func signToken(t *testing.T, key *rsa.PrivateKey, header, claims interface{}) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("could not marshal header: %v", err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("could not marshal claims: %v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func publicJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"alg": "RS256",
+		"use": "sig",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+type fakeJWKSServer struct {
+	*httptest.Server
+	fetches      int32
+	cacheControl string
+}
+
+func newFakeJWKSServer(cacheControl string) *fakeJWKSServer {
+	f := &fakeJWKSServer{cacheControl: cacheControl}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.fetches, 1)
+		w.Header().Set("Cache-Control", f.cacheControl)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []interface{}{publicJWK(testKeyID, testKey)},
+		})
+	}))
+	return f
+}
+
+func goodClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"sub":            "1234",
+		"aud":            "https://example.com/process",
+		"email":          "some-email@example.com",
+		"email_verified": true,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+}
+
+func withClaim(claims map[string]interface{}, name string, value interface{}) map[string]interface{} {
+	c := make(map[string]interface{})
+	for k, v := range claims {
+		c[k] = v
+	}
+	c[name] = value
+	return c
+}
+
+func TestJWKSVerifyGoogle(t *testing.T) {
+	now := time.Now()
+	goodHeader := map[string]string{"alg": "RS256", "kid": testKeyID, "typ": "JWT"}
+
+	for _, tt := range []struct {
+		name    string
+		key     *rsa.PrivateKey
+		header  interface{}
+		claims  interface{}
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "Good",
+			key:    testKey,
+			header: goodHeader,
+			claims: goodClaims(now),
+			want:   "some-email@example.com",
+		},
+		{
+			name:   "ShortIssuer",
+			key:    testKey,
+			header: goodHeader,
+			claims: withClaim(goodClaims(now), "iss", "accounts.google.com"),
+			want:   "some-email@example.com",
+		},
+		{
+			name:   "AudienceList",
+			key:    testKey,
+			header: goodHeader,
+			claims: withClaim(goodClaims(now), "aud", []string{"a", "b"}),
+			want:   "some-email@example.com",
+		},
+		{
+			name:    "WrongKey",
+			key:     otherKey,
+			header:  goodHeader,
+			claims:  goodClaims(now),
+			wantErr: true,
+		},
+		{
+			name:    "UnknownKeyID",
+			key:     testKey,
+			header:  map[string]string{"alg": "RS256", "kid": "other-key"},
+			claims:  goodClaims(now),
+			wantErr: true,
+		},
+		{
+			name:    "UnsupportedAlgorithm",
+			key:     testKey,
+			header:  map[string]string{"alg": "none", "kid": testKeyID},
+			claims:  goodClaims(now),
+			wantErr: true,
+		},
+		{
+			name:    "UntrustedIssuer",
+			key:     testKey,
+			header:  goodHeader,
+			claims:  withClaim(goodClaims(now), "iss", "https://evil.example.com"),
+			wantErr: true,
+		},
+		{
+			name:    "Expired",
+			key:     testKey,
+			header:  goodHeader,
+			claims:  withClaim(goodClaims(now), "exp", now.Add(-time.Minute).Unix()),
+			wantErr: true,
+		},
+		{
+			name:    "NotYetValid",
+			key:     testKey,
+			header:  goodHeader,
+			claims:  withClaim(goodClaims(now), "nbf", now.Add(time.Minute).Unix()),
+			wantErr: true,
+		},
+		{
+			name:    "UnverifiedEmail",
+			key:     testKey,
+			header:  goodHeader,
+			claims:  withClaim(goodClaims(now), "email_verified", false),
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeJWKSServer("public, max-age=3600")
+			defer srv.Close()
+
+			v := &JWKSVerifier{jwksURL: srv.URL, issuers: googleIssuers}
+			token := signToken(t, tt.key, tt.header, tt.claims)
+			got, err := v.VerifyGoogle(context.Background(), token)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("VerifyGoogle(_, %q) = _, <nil> want _, <non-nil>", token)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("VerifyGoogle(_, %q) = _, %v want _, <nil>", token, err)
+			}
+			if got != tt.want {
+				t.Errorf("VerifyGoogle(_, %q) = %q, _ want %q, _", token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWKSVerifyMalformed(t *testing.T) {
+	srv := newFakeJWKSServer("max-age=3600")
+	defer srv.Close()
+
+	v := &JWKSVerifier{jwksURL: srv.URL, issuers: googleIssuers}
+	for _, token := range []string{"", "abc", "a.b", "a.b.c", "a.b.c.d"} {
+		if _, err := v.Verify(context.Background(), token); err == nil {
+			t.Errorf("Verify(_, %q) = _, <nil> want _, <non-nil>", token)
+		}
+	}
+}
+
+func TestJWKSKeyCaching(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		cacheControl string
+		advance      time.Duration
+		wantFetches  int32
+	}{
+		{
+			name:         "Cached",
+			cacheControl: "public, max-age=3600, must-revalidate",
+			wantFetches:  1,
+		},
+		{
+			name:         "CacheExpired",
+			cacheControl: "public, max-age=60",
+			advance:      2 * time.Minute,
+			wantFetches:  2,
+		},
+		{
+			name:         "NoCache",
+			cacheControl: "no-cache",
+			wantFetches:  2,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeJWKSServer(tt.cacheControl)
+			defer srv.Close()
+
+			now := time.Now()
+			v := &JWKSVerifier{
+				jwksURL: srv.URL,
+				issuers: googleIssuers,
+				now:     func() time.Time { return now },
+			}
+			header := map[string]string{"alg": "RS256", "kid": testKeyID}
+			first := signToken(t, testKey, header, withClaim(goodClaims(now), "sub", "first"))
+			second := signToken(t, testKey, header, withClaim(goodClaims(now), "sub", "second"))
+			if _, err := v.Verify(context.Background(), first); err != nil {
+				t.Fatalf("Verify(_, first) = _, %v want _, <nil>", err)
+			}
+			now = now.Add(tt.advance)
+			if _, err := v.Verify(context.Background(), second); err != nil {
+				t.Fatalf("Verify(_, second) = _, %v want _, <nil>", err)
+			}
+			if got := atomic.LoadInt32(&srv.fetches); got != tt.wantFetches {
+				t.Errorf("key set fetched %d times want %d", got, tt.wantFetches)
+			}
+		})
+	}
+}
+
+func TestJWKSTokenCaching(t *testing.T) {
+	srv := newFakeJWKSServer("no-store")
+
+	now := time.Now()
+	v := &JWKSVerifier{
+		jwksURL: srv.URL,
+		issuers: googleIssuers,
+		now:     func() time.Time { return now },
+	}
+	token := signToken(t, testKey, map[string]string{"alg": "RS256", "kid": testKeyID}, goodClaims(now))
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Fatalf("Verify() = _, %v want _, <nil>", err)
+	}
+
+	// A cached token verifies without the key set.
+	srv.Close()
+	now = now.Add(30 * time.Minute)
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Errorf("Verify() of cached token = _, %v want _, <nil>", err)
+	}
+
+	// Once the token expires it is verified again, and rejected.
+	now = now.Add(time.Hour)
+	if _, err := v.Verify(context.Background(), token); err == nil {
+		t.Error("Verify() of expired cached token = _, <nil> want _, <non-nil>")
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	for _, tt := range []struct {
+		cacheControl string
+		age          string
+		want         time.Duration
+	}{
+		{cacheControl: "public, max-age=21600, must-revalidate", want: 6 * time.Hour},
+		{cacheControl: "max-age=600", age: "100", want: 500 * time.Second},
+		{cacheControl: "max-age=60", age: "100", want: 0},
+		{cacheControl: "no-cache, max-age=600", want: 0},
+		{cacheControl: "max-age=abc", want: 0},
+		{cacheControl: "", want: 0},
+	} {
+		h := http.Header{}
+		h.Set("Cache-Control", tt.cacheControl)
+		if tt.age != "" {
+			h.Set("Age", tt.age)
+		}
+		if got := maxAge(h); got != tt.want {
+			t.Errorf("maxAge(Cache-Control: %q, Age: %q) = %v want %v", tt.cacheControl, tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestClaimFromFields(t *testing.T) {
+	c := &Claims{Issuer: GoogleIssuerURL, Email: "admin@example.com", EmailVerified: true}
+	for _, tt := range []struct {
+		name   string
+		want   string
+		wantOK bool
+	}{
+		{name: "iss", want: GoogleIssuerURL, wantOK: true},
+		{name: "email", want: "admin@example.com", wantOK: true},
+		{name: "email_verified", want: "true", wantOK: true},
+		{name: "hd"},
+		{name: "repository"},
+	} {
+		if got, ok := c.Claim(tt.name); got != tt.want || ok != tt.wantOK {
+			t.Errorf("Claim(%q) = %q, %t want %q, %t", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+// Role is a named set of privileges granted to verified callers.
+type Role string
+
+const (
+	// RoleScheduler may trigger processing runs.
+	RoleScheduler Role = "scheduler"
+	// RoleAdmin may do anything, including everything the other roles may.
+	RoleAdmin Role = "admin"
+	// RoleReader may read operational data such as run history.
+	RoleReader Role = "reader"
+)
+
+// RoleBinding grants a role to the callers whose tokens match it.
+type RoleBinding struct {
+	Role Role `json:"role"`
+	// Issuer, if set, is the "iss" claim matching tokens must have.
+	Issuer string `json:"issuer"`
+	// Claims maps claim names to their allowed values, as in Policy. If an
+	// "email" claim is listed, the email must also be verified.
+	Claims map[string][]string `json:"claims"`
+}
+
+func (b *RoleBinding) matches(c *Claims) bool {
+	if b.Issuer != "" && b.Issuer != c.Issuer {
+		return false
+	}
+	if len(b.Claims) == 0 && b.Issuer == "" {
+		// A binding that matches everyone is almost certainly a mistake.
+		return false
+	}
+	for name, allowed := range b.Claims {
+		if name == "email" && !c.EmailVerified {
+			return false
+		}
+		v, ok := c.Claim(name)
+		if !ok || !contains(allowed, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Identity is a verified caller and the roles it was granted.
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+	Roles   []Role
+}
+
+// HasRole reports whether the identity was granted role, either directly or
+// through RoleAdmin.
+func (i *Identity) HasRole(role Role) bool {
+	for _, r := range i.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Identity) String() string {
+	name := i.Email
+	if name == "" {
+		name = i.Subject
+	}
+	roles := make([]string, len(i.Roles))
+	for j, r := range i.Roles {
+		roles[j] = string(r)
+	}
+	return fmt.Sprintf("%s (%s) [%s]", name, i.Issuer, strings.Join(roles, ", "))
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the caller identity.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFrom returns the caller identity stored in ctx by the middleware,
+// or nil if there is none.
+func IdentityFrom(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// APIKeyIssuer is the issuer of identities authenticated with API keys.
+const APIKeyIssuer = "api-key"
+
+// Middleware authenticates requests and authorizes them by role.
+type Middleware struct {
+	Verifier TokenVerifier
+	Bindings []RoleBinding
+	// APIKeys, if set, verifies requests signed with API keys instead of
+	// bearer tokens. Such callers have the role of their key.
+	APIKeys *APIKeyVerifier
+	// Insecure skips authentication and treats every caller as an
+	// anonymous admin. It is only meant for running locally.
+	Insecure bool
+}
+
+// Identify returns the identity for verified claims, with every role whose
+// binding matches them.
+func (m *Middleware) Identify(c *Claims) *Identity {
+	id := &Identity{Issuer: c.Issuer, Subject: c.Subject, Email: c.Email}
+	seen := make(map[Role]bool)
+	for i := range m.Bindings {
+		b := &m.Bindings[i]
+		if !seen[b.Role] && b.matches(c) {
+			seen[b.Role] = true
+			id.Roles = append(id.Roles, b.Role)
+		}
+	}
+	return id
+}
+
+// Require returns a handler that only calls h for callers that have role.
+// The caller identity is available to h through IdentityFrom.
+func (m *Middleware) Require(role Role, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.Insecure {
+			id := &Identity{Subject: "anonymous", Roles: []Role{RoleAdmin}}
+			h.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+			return
+		}
+		id, rej := m.authenticate(r)
+		if rej != nil {
+			http.Error(w, rej.Reason, rej.Status)
+			return
+		}
+		if !id.HasRole(role) {
+			logging.Warningf(r.Context(), "Rejected %s %s for %s: missing role %q.", r.Method, r.URL.Path, id, role)
+			http.Error(w, fmt.Sprintf("Caller does not have the %q role.", role), http.StatusForbidden)
+			return
+		}
+		logging.Infof(r.Context(), "Allowed %s %s for %s with role %q.", r.Method, r.URL.Path, id, role)
+		h.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+func (m *Middleware) authenticate(r *http.Request) (*Identity, *Rejection) {
+	if m.APIKeys != nil && IsAPIKeyRequest(r) {
+		key, rej := m.APIKeys.Verify(r)
+		if rej != nil {
+			return nil, logRejection(r, nil, rej)
+		}
+		return &Identity{
+			Issuer:  APIKeyIssuer,
+			Subject: key.Name + "/" + key.ID,
+			Roles:   []Role{Role(key.Role)},
+		}, nil
+	}
+	c, rej := Authenticate(r, m.Verifier, nil)
+	if rej != nil {
+		return nil, rej
+	}
+	return m.Identify(c), nil
+}
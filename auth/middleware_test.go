@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testBindings = []RoleBinding{
+	{
+		Role:   RoleScheduler,
+		Issuer: GoogleIssuerURL,
+		Claims: map[string][]string{"email": {"scheduler@example.com"}},
+	},
+	{
+		Role:   RoleScheduler,
+		Issuer: GitHubActionsIssuerURL,
+		Claims: map[string][]string{"repository": {"org/repo"}},
+	},
+	{
+		Role:   RoleAdmin,
+		Claims: map[string][]string{"email": {"admin@example.com"}},
+	},
+	{
+		Role:   RoleReader,
+		Claims: map[string][]string{"hd": {"example.com"}},
+	},
+}
+
+func googleClaims(email string, verified bool, hd string) *Claims {
+	return &Claims{
+		Issuer:        GoogleIssuerURL,
+		Subject:       "1234",
+		Email:         email,
+		EmailVerified: verified,
+		HostedDomain:  hd,
+		raw: map[string]interface{}{
+			"iss":            GoogleIssuerURL,
+			"sub":            "1234",
+			"email":          email,
+			"email_verified": verified,
+			"hd":             hd,
+		},
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	m := &Middleware{Bindings: testBindings}
+	for _, tt := range []struct {
+		name   string
+		claims *Claims
+		want   []Role
+	}{
+		{
+			name:   "Scheduler",
+			claims: googleClaims("scheduler@example.com", true, ""),
+			want:   []Role{RoleScheduler},
+		},
+		{
+			name:   "UnverifiedEmail",
+			claims: googleClaims("scheduler@example.com", false, ""),
+		},
+		{
+			name:   "AdminAndReader",
+			claims: googleClaims("admin@example.com", true, "example.com"),
+			want:   []Role{RoleAdmin, RoleReader},
+		},
+		{
+			name: "GitHubActions",
+			claims: &Claims{
+				Issuer: GitHubActionsIssuerURL,
+				raw:    map[string]interface{}{"iss": GitHubActionsIssuerURL, "repository": "org/repo"},
+			},
+			want: []Role{RoleScheduler},
+		},
+		{
+			name: "WrongIssuer",
+			claims: &Claims{
+				Issuer: "https://evil.example.com",
+				raw:    map[string]interface{}{"iss": "https://evil.example.com", "repository": "org/repo"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.Identify(tt.claims)
+			if diff := cmp.Diff(tt.want, got.Roles); diff != "" {
+				t.Errorf("Identify() roles diff (-want +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	admin := &Identity{Roles: []Role{RoleAdmin}}
+	reader := &Identity{Roles: []Role{RoleReader}}
+	if !admin.HasRole(RoleScheduler) {
+		t.Error("admin.HasRole(RoleScheduler) = false want true")
+	}
+	if reader.HasRole(RoleScheduler) {
+		t.Error("reader.HasRole(RoleScheduler) = true want false")
+	}
+	if !reader.HasRole(RoleReader) {
+		t.Error("reader.HasRole(RoleReader) = false want true")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		authHeader string
+		verifier   *fakeTokenVerifier
+		insecure   bool
+		wantStatus int
+		wantEmail  string
+	}{
+		{
+			name:       "Good",
+			authHeader: "Bearer token",
+			verifier:   &fakeTokenVerifier{Claims: googleClaims("scheduler@example.com", true, "")},
+			wantStatus: http.StatusOK,
+			wantEmail:  "scheduler@example.com",
+		},
+		{
+			name:       "Admin",
+			authHeader: "Bearer token",
+			verifier:   &fakeTokenVerifier{Claims: googleClaims("admin@example.com", true, "")},
+			wantStatus: http.StatusOK,
+			wantEmail:  "admin@example.com",
+		},
+		{
+			name:       "NoAuthorization",
+			verifier:   &fakeTokenVerifier{},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "MissingRole",
+			authHeader: "Bearer token",
+			verifier:   &fakeTokenVerifier{Claims: googleClaims("some-email@evil.com", true, "example.com")},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "VerificationError",
+			authHeader: "Bearer token",
+			verifier:   &fakeTokenVerifier{Err: errors.New("problem verifying token")},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "IssuerPolicyRejection",
+			authHeader: "Bearer token",
+			verifier:   &fakeTokenVerifier{Err: forbidden("Claim %q is required.", "repository")},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Insecure",
+			insecure:   true,
+			wantStatus: http.StatusOK,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *Identity
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = IdentityFrom(r.Context())
+			})
+			m := &Middleware{Verifier: tt.verifier, Bindings: testBindings, Insecure: tt.insecure}
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/process", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			m.Require(RoleScheduler, h).ServeHTTP(rr, req)
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", status, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if got != nil {
+					t.Errorf("wrapped handler called with identity %v, want not called", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("wrapped handler got no identity")
+			}
+			if got.Email != tt.wantEmail {
+				t.Errorf("identity email = %q want %q", got.Email, tt.wantEmail)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+)
+
+const (
+	// GoogleIssuerURL is the issuer of Google ID tokens, such as the ones
+	// Cloud Scheduler sends.
+	GoogleIssuerURL = "https://accounts.google.com"
+	// GitHubActionsIssuerURL is the issuer of GitHub Actions OIDC tokens.
+	GitHubActionsIssuerURL = "https://token.actions.githubusercontent.com"
+)
+
+// Issuer is a trusted OIDC token issuer.
+type Issuer struct {
+	// URL is the "iss" claim of the issuer's tokens.
+	URL string `json:"url"`
+	// JWKSURL is where the issuer publishes its signing keys. If it is
+	// empty, it is discovered from the issuer's OpenID configuration.
+	JWKSURL string `json:"jwks_url"`
+	// Policy lists the claims the issuer's tokens must have. The audience
+	// is required.
+	Policy Policy `json:"policy"`
+
+	// aliases are other "iss" claims the issuer uses.
+	aliases []string
+}
+
+// GoogleIssuer returns the issuer of Google ID tokens with the specified
+// policy.
+func GoogleIssuer(p Policy) *Issuer {
+	return &Issuer{
+		URL:     GoogleIssuerURL,
+		JWKSURL: googleCertsURL,
+		Policy:  p,
+		aliases: []string{"accounts.google.com"},
+	}
+}
+
+// OIDCVerifier verifies tokens from any of several trusted issuers, checking
+// each token against the policy of the issuer that signed it.
+type OIDCVerifier struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	issuers   map[string]*Issuer
+	verifiers map[*Issuer]*JWKSVerifier
+}
+
+// NewOIDCVerifier returns a verifier that trusts the specified issuers.
+func NewOIDCVerifier(issuers ...*Issuer) (*OIDCVerifier, error) {
+	v := &OIDCVerifier{
+		issuers:   make(map[string]*Issuer),
+		verifiers: make(map[*Issuer]*JWKSVerifier),
+	}
+	for _, iss := range issuers {
+		if iss.URL == "" {
+			return nil, errors.New("issuer must have a URL")
+		}
+		if iss.Policy.Audience == "" {
+			return nil, fmt.Errorf("issuer %q must have an audience", iss.URL)
+		}
+		for _, u := range append([]string{iss.URL}, iss.aliases...) {
+			if _, ok := v.issuers[u]; ok {
+				return nil, fmt.Errorf("issuer %q is listed more than once", u)
+			}
+			v.issuers[u] = iss
+		}
+	}
+	return v, nil
+}
+
+// Verify verifies token with the keys of the issuer named by its "iss" claim
+// and checks it against that issuer's policy. Policy failures are returned as
+// a *Rejection.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (_ *Claims, err error) {
+	ctx, span := tracing.Start(ctx, "auth.OIDCVerifier.Verify")
+	defer func() { tracing.End(span, err) }()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	// The issuer is read before the signature is checked only to pick the
+	// keys to check it with; the issuer's verifier checks it again.
+	var unverified struct {
+		Issuer string `json:"iss"`
+	}
+	if err := decodeSegment(parts[1], &unverified); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	iss, ok := v.issuers[unverified.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("untrusted issuer %q", unverified.Issuer)
+	}
+	jv, err := v.verifier(ctx, iss)
+	if err != nil {
+		return nil, err
+	}
+	c, err := jv.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if rej := iss.Policy.Check(c); rej != nil {
+		return nil, rej
+	}
+	return c, nil
+}
+
+// verifier returns the key verifier for iss, discovering its key set URL the
+// first time it is needed.
+func (v *OIDCVerifier) verifier(ctx context.Context, iss *Issuer) (*JWKSVerifier, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if jv, ok := v.verifiers[iss]; ok {
+		return jv, nil
+	}
+	jwksURL := iss.JWKSURL
+	if jwksURL == "" {
+		var err error
+		if jwksURL, err = v.discover(ctx, iss.URL); err != nil {
+			return nil, err
+		}
+	}
+	jv := &JWKSVerifier{
+		jwksURL: jwksURL,
+		issuers: append([]string{iss.URL}, iss.aliases...),
+		client:  v.client,
+	}
+	v.verifiers[iss] = jv
+	return jv, nil
+}
+
+// discover returns the key set URL from the OpenID configuration of the
+// issuer at issuerURL.
+func (v *OIDCVerifier) discover(ctx context.Context, issuerURL string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "auth.OIDCVerifier.discover")
+	defer func() { tracing.End(span, err) }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuerURL, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return "", fmt.Errorf("could not create discovery request: %v", err)
+	}
+	client := v.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not discover issuer %q: %v", issuerURL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not discover issuer %q, got status %s", issuerURL, res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("could not read discovery document: %v", err)
+	}
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("could not unmarshal discovery document: %v", err)
+	}
+	if doc.Issuer != issuerURL {
+		return "", fmt.Errorf("discovery document is for issuer %q, want %q", doc.Issuer, issuerURL)
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("discovery document for %q has no jwks_uri", issuerURL)
+	}
+	return doc.JWKSURI, nil
+}
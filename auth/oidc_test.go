@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newFakeIssuer starts an OIDC issuer that publishes testKey through its
+// discovery document. If issuer is empty the server's own URL is advertised.
+func newFakeIssuer(issuer string) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		iss := issuer
+		if iss == "" {
+			iss = srv.URL
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   iss,
+			"jwks_uri": srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []interface{}{publicJWK(testKeyID, testKey)},
+		})
+	})
+	return srv
+}
+
+func TestOIDCVerify(t *testing.T) {
+	ci := newFakeIssuer("")
+	defer ci.Close()
+	idp := newFakeIssuer("")
+	defer idp.Close()
+	wrongIssuer := newFakeIssuer("https://evil.example.com")
+	defer wrongIssuer.Close()
+
+	v, err := NewOIDCVerifier(
+		&Issuer{
+			URL: ci.URL,
+			Policy: Policy{
+				Audience: "https://example.com/process",
+				Claims:   map[string][]string{"repository": {"org/repo"}, "ref": {"refs/heads/master"}},
+			},
+		},
+		&Issuer{
+			URL:     idp.URL,
+			JWKSURL: idp.URL + "/jwks",
+			Policy: Policy{
+				Audience: "webapp",
+				Emails:   []string{"backfill@example.com"},
+			},
+		},
+		&Issuer{
+			URL:    wrongIssuer.URL,
+			Policy: Policy{Audience: "webapp"},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewOIDCVerifier() = _, %v want _, <nil>", err)
+	}
+
+	now := time.Now()
+	header := map[string]string{"alg": "RS256", "kid": testKeyID}
+	ciClaims := map[string]interface{}{
+		"iss":        ci.URL,
+		"sub":        "repo:org/repo:ref:refs/heads/master",
+		"aud":        "https://example.com/process",
+		"repository": "org/repo",
+		"ref":        "refs/heads/master",
+		"exp":        now.Add(time.Hour).Unix(),
+	}
+	idpClaims := map[string]interface{}{
+		"iss":            idp.URL,
+		"aud":            "webapp",
+		"email":          "backfill@example.com",
+		"email_verified": true,
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+
+	for _, tt := range []struct {
+		name       string
+		claims     map[string]interface{}
+		wantErr    bool
+		wantStatus int
+	}{
+		{
+			name:   "GoodCI",
+			claims: ciClaims,
+		},
+		{
+			name:   "GoodIdentityProvider",
+			claims: idpClaims,
+		},
+		{
+			name:       "WrongRepository",
+			claims:     withClaim(ciClaims, "repository", "org/fork"),
+			wantErr:    true,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "MissingClaim",
+			claims:     withClaim(ciClaims, "ref", nil),
+			wantErr:    true,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "WrongAudience",
+			claims:     withClaim(ciClaims, "aud", "webapp"),
+			wantErr:    true,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "OtherIssuersPolicy",
+			claims:     withClaim(idpClaims, "email", "someone@example.com"),
+			wantErr:    true,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:    "UntrustedIssuer",
+			claims:  withClaim(ciClaims, "iss", "https://evil.example.com"),
+			wantErr: true,
+		},
+		{
+			name:    "DiscoveryIssuerMismatch",
+			claims:  withClaim(idpClaims, "iss", wrongIssuer.URL),
+			wantErr: true,
+		},
+		{
+			name:    "Expired",
+			claims:  withClaim(ciClaims, "exp", now.Add(-time.Hour).Unix()),
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signToken(t, testKey, header, tt.claims)
+			got, err := v.Verify(context.Background(), token)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Verify() = _, %v want _, <nil>", err)
+				}
+				if got.Issuer != tt.claims["iss"] {
+					t.Errorf("Verify() issuer = %q want %q", got.Issuer, tt.claims["iss"])
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Verify() = _, <nil> want _, <non-nil>")
+			}
+			var rej *Rejection
+			if tt.wantStatus == 0 {
+				if errors.As(err, &rej) {
+					t.Errorf("Verify() = _, %v want a verification error, not a rejection", err)
+				}
+				return
+			}
+			if !errors.As(err, &rej) || rej.Status != tt.wantStatus {
+				t.Errorf("Verify() = _, %v want rejection with status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewOIDCVerifierInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		issuers []*Issuer
+	}{
+		{
+			name:    "NoURL",
+			issuers: []*Issuer{{Policy: Policy{Audience: "aud"}}},
+		},
+		{
+			name:    "NoAudience",
+			issuers: []*Issuer{{URL: "https://example.com"}},
+		},
+		{
+			name: "Duplicate",
+			issuers: []*Issuer{
+				{URL: "https://example.com", Policy: Policy{Audience: "aud"}},
+				{URL: "https://example.com", Policy: Policy{Audience: "other"}},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewOIDCVerifier(tt.issuers...); err == nil {
+				t.Error("NewOIDCVerifier() = _, <nil> want _, <non-nil>")
+			}
+		})
+	}
+}
+
+func TestAuthenticateIssuerRejection(t *testing.T) {
+	idp := newFakeIssuer("")
+	defer idp.Close()
+	v, err := NewOIDCVerifier(&Issuer{
+		URL:    idp.URL,
+		Policy: Policy{Audience: "webapp", Claims: map[string][]string{"role": {"scheduler"}}},
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCVerifier() = _, %v want _, <nil>", err)
+	}
+	token := signToken(t, testKey, map[string]string{"alg": "RS256", "kid": testKeyID}, map[string]interface{}{
+		"iss":  idp.URL,
+		"aud":  "webapp",
+		"role": "reader",
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/process", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if _, rej := Authenticate(req, v, nil); rej == nil || rej.Status != http.StatusForbidden {
+		t.Errorf("Authenticate() = _, %v want status %d", rej, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+// TokenVerifier verifies a bearer token and returns its claims.
+type TokenVerifier interface {
+	Verify(context.Context, string) (*Claims, error)
+}
+
+// Policy lists the claims a verified token must have to be accepted. Empty
+// lists allow any value of that claim, but Audience is always required so
+// that tokens minted for other services are never accepted.
+type Policy struct {
+	// Audience is the expected "aud" claim, usually the URL of the app.
+	Audience string `json:"audience"`
+	// Emails are the allowed "email" claims. The email must be verified.
+	Emails []string `json:"emails"`
+	// AuthorizedParties are the allowed "azp" claims.
+	AuthorizedParties []string `json:"authorized_parties"`
+	// HostedDomains are the allowed G Suite "hd" claims.
+	HostedDomains []string `json:"hosted_domains"`
+	// Claims maps the names of other claims to their allowed values, for
+	// example {"repository": ["org/repo"]} for GitHub Actions tokens. Every
+	// listed claim must have one of its allowed values.
+	Claims map[string][]string `json:"claims"`
+}
+
+// Rejection explains why a request was not authorized. Reason is safe to
+// return to the caller.
+type Rejection struct {
+	// Status is http.StatusUnauthorized if the caller could not be
+	// authenticated, or http.StatusForbidden if the caller is not allowed.
+	Status int
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return r.Reason
+}
+
+func unauthorized(format string, a ...interface{}) *Rejection {
+	return &Rejection{Status: http.StatusUnauthorized, Reason: fmt.Sprintf(format, a...)}
+}
+
+func forbidden(format string, a ...interface{}) *Rejection {
+	return &Rejection{Status: http.StatusForbidden, Reason: fmt.Sprintf(format, a...)}
+}
+
+// Check returns a rejection if the claims do not satisfy the policy. A token
+// for the wrong audience is treated as unauthenticated, since it was never
+// meant to be presented to this app.
+func (p *Policy) Check(c *Claims) *Rejection {
+	if p.Audience == "" {
+		return forbidden("No audience is configured.")
+	}
+	if !contains(c.Audience, p.Audience) {
+		return unauthorized("Token audience %q does not match %q.", c.Audience, p.Audience)
+	}
+	if len(p.Emails) > 0 {
+		if !c.EmailVerified {
+			return forbidden("Email %q is not verified.", c.Email)
+		}
+		if !contains(p.Emails, c.Email) {
+			return forbidden("Email %q is not allowed.", c.Email)
+		}
+	}
+	if len(p.AuthorizedParties) > 0 && !contains(p.AuthorizedParties, c.AuthorizedParty) {
+		return forbidden("Authorized party %q is not allowed.", c.AuthorizedParty)
+	}
+	if len(p.HostedDomains) > 0 && !contains(p.HostedDomains, c.HostedDomain) {
+		return forbidden("Hosted domain %q is not allowed.", c.HostedDomain)
+	}
+	names := make([]string, 0, len(p.Claims))
+	for name := range p.Claims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v, ok := c.Claim(name)
+		if !ok {
+			return forbidden("Claim %q is required.", name)
+		}
+		if !contains(p.Claims[name], v) {
+			return forbidden("Claim %q value %q is not allowed.", name, v)
+		}
+	}
+	return nil
+}
+
+// Authenticate verifies the bearer token of r and checks its claims against
+// p, if it is not nil. If the verifier rejects the token with a *Rejection,
+// for example because it failed the policy of its issuer, that rejection is
+// returned. Every decision is logged.
+func Authenticate(r *http.Request, v TokenVerifier, p *Policy) (*Claims, *Rejection) {
+	a := r.Header.Get("Authorization")
+	if a == "" {
+		return nil, logRejection(r, nil, unauthorized("Must provide credentials."))
+	}
+	token := ParseAuthHeader(a)
+	if token == "" {
+		return nil, logRejection(r, nil, unauthorized("Authorization header must be a bearer token."))
+	}
+	c, err := v.Verify(r.Context(), token)
+	var rej *Rejection
+	if errors.As(err, &rej) {
+		return nil, logRejection(r, nil, rej)
+	}
+	if err != nil {
+		logging.Warningf(r.Context(), "Could not verify token for %s %s: %v", r.Method, r.URL.Path, err)
+		return nil, logRejection(r, nil, unauthorized("Invalid credentials."))
+	}
+	if p != nil {
+		if rej := p.Check(c); rej != nil {
+			return nil, logRejection(r, c, rej)
+		}
+	}
+	logging.Infof(r.Context(), "Authorized %s %s for subject %q, email %q.", r.Method, r.URL.Path, c.Subject, c.Email)
+	return c, nil
+}
+
+func logRejection(r *http.Request, c *Claims, rej *Rejection) *Rejection {
+	if c == nil {
+		logging.Warningf(r.Context(), "Rejected %s %s with status %d: %s", r.Method, r.URL.Path, rej.Status, rej.Reason)
+	} else {
+		logging.Warningf(r.Context(), "Rejected %s %s for subject %q, email %q with status %d: %s", r.Method, r.URL.Path, c.Subject, c.Email, rej.Status, rej.Reason)
+	}
+	return rej
+}
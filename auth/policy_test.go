@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{
+		Audience:          "https://example.com/process",
+		Emails:            []string{"scheduler@example.com"},
+		AuthorizedParties: []string{"1234"},
+		HostedDomains:     []string{"example.com"},
+	}
+	good := Claims{
+		Audience:        audience{"https://example.com/process"},
+		AuthorizedParty: "1234",
+		Email:           "scheduler@example.com",
+		EmailVerified:   true,
+		HostedDomain:    "example.com",
+	}
+
+	for _, tt := range []struct {
+		name       string
+		policy     *Policy
+		claims     func(c *Claims)
+		wantStatus int
+	}{
+		{
+			name:   "Good",
+			policy: policy,
+		},
+		{
+			name:   "OnlyAudience",
+			policy: &Policy{Audience: "https://example.com/process"},
+			claims: func(c *Claims) { c.Email = "anyone@example.com" },
+		},
+		{
+			name:   "OneOfManyAudiences",
+			policy: policy,
+			claims: func(c *Claims) { c.Audience = audience{"other", "https://example.com/process"} },
+		},
+		{
+			name:       "NoAudienceConfigured",
+			policy:     &Policy{},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "WrongAudience",
+			policy:     policy,
+			claims:     func(c *Claims) { c.Audience = audience{"https://example.com/other"} },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "WrongEmail",
+			policy:     policy,
+			claims:     func(c *Claims) { c.Email = "someone@evil.com" },
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "UnverifiedEmail",
+			policy:     policy,
+			claims:     func(c *Claims) { c.EmailVerified = false },
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "WrongAuthorizedParty",
+			policy:     policy,
+			claims:     func(c *Claims) { c.AuthorizedParty = "5678" },
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "WrongHostedDomain",
+			policy:     policy,
+			claims:     func(c *Claims) { c.HostedDomain = "" },
+			wantStatus: http.StatusForbidden,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := good
+			if tt.claims != nil {
+				tt.claims(&c)
+			}
+			rej := tt.policy.Check(&c)
+			if tt.wantStatus == 0 {
+				if rej != nil {
+					t.Errorf("Check() = %v want <nil>", rej)
+				}
+				return
+			}
+			if rej == nil {
+				t.Fatalf("Check() = <nil> want status %d", tt.wantStatus)
+			}
+			if rej.Status != tt.wantStatus {
+				t.Errorf("Check() status = %d want %d", rej.Status, tt.wantStatus)
+			}
+			if rej.Reason == "" {
+				t.Error("Check() returned an empty reason")
+			}
+		})
+	}
+}
+
+type fakeTokenVerifier struct {
+	Claims *Claims
+	Err    error
+}
+
+func (f *fakeTokenVerifier) Verify(context.Context, string) (*Claims, error) {
+	return f.Claims, f.Err
+}
+
+func TestAuthenticate(t *testing.T) {
+	policy := &Policy{Audience: "aud", Emails: []string{"scheduler@example.com"}}
+	good := &Claims{Audience: audience{"aud"}, Email: "scheduler@example.com", EmailVerified: true}
+
+	for _, tt := range []struct {
+		name       string
+		header     string
+		verifier   *fakeTokenVerifier
+		wantStatus int
+	}{
+		{
+			name:     "Good",
+			header:   "Bearer token",
+			verifier: &fakeTokenVerifier{Claims: good},
+		},
+		{
+			name:       "NoHeader",
+			verifier:   &fakeTokenVerifier{Claims: good},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "NotBearer",
+			header:     "token",
+			verifier:   &fakeTokenVerifier{Claims: good},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "VerificationError",
+			header:     "Bearer token",
+			verifier:   &fakeTokenVerifier{Err: errors.New("bad signature")},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "PolicyRejects",
+			header: "Bearer token",
+			verifier: &fakeTokenVerifier{
+				Claims: &Claims{Audience: audience{"aud"}, Email: "someone@evil.com", EmailVerified: true},
+			},
+			wantStatus: http.StatusForbidden,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/process", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			got, rej := Authenticate(req, tt.verifier, policy)
+			if tt.wantStatus == 0 {
+				if rej != nil {
+					t.Fatalf("Authenticate() = _, %v want _, <nil>", rej)
+				}
+				if got != tt.verifier.Claims {
+					t.Errorf("Authenticate() = %+v, _ want %+v, _", got, tt.verifier.Claims)
+				}
+				return
+			}
+			if rej == nil || rej.Status != tt.wantStatus {
+				t.Errorf("Authenticate() = _, %v want status %d", rej, tt.wantStatus)
+			}
+		})
+	}
+}
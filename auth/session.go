@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+const (
+	// SessionCookie is the name of the cookie holding a browser session.
+	SessionCookie = "session"
+	// CSRFField is the form field, or header, that must carry the CSRF token
+	// of the session on requests that change state.
+	CSRFField = "csrf_token"
+
+	defaultSessionTTL = 12 * time.Hour
+)
+
+// Sessions issues and checks signed session cookies for users who signed in
+// with a browser. Sessions are not stored anywhere, so the roles of a session
+// are those the user had when signing in, until it expires.
+type Sessions struct {
+	// Key signs session cookies. Anyone who knows it can forge a session.
+	Key []byte
+	// Path limits the cookie to part of the site. It defaults to "/".
+	Path string
+	// TTL is how long a session lasts. It defaults to 12 hours.
+	TTL time.Duration
+	// Insecure skips authentication and treats every caller as an
+	// anonymous admin, like Middleware.Insecure.
+	Insecure bool
+
+	now func() time.Time
+}
+
+type session struct {
+	Issuer  string `json:"iss"`
+	Subject string `json:"sub"`
+	Email   string `json:"email"`
+	Roles   []Role `json:"roles"`
+	Expires int64  `json:"exp"`
+	CSRF    string `json:"csrf"`
+}
+
+type csrfKey struct{}
+
+// CSRFToken returns the CSRF token of the session that made the request
+// carrying ctx, for embedding in forms.
+func CSRFToken(ctx context.Context) string {
+	t, _ := ctx.Value(csrfKey{}).(string)
+	return t
+}
+
+func (s *Sessions) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// Start signs id in to a new session by setting its cookie on w.
+func (s *Sessions) Start(w http.ResponseWriter, id *Identity) error {
+	if len(s.Key) == 0 {
+		return fmt.Errorf("no session key")
+	}
+	csrf := make([]byte, 16)
+	if _, err := rand.Read(csrf); err != nil {
+		return fmt.Errorf("could not generate CSRF token: %v", err)
+	}
+	ttl := s.TTL
+	if ttl == 0 {
+		ttl = defaultSessionTTL
+	}
+	expires := s.timeNow().Add(ttl)
+	payload, err := json.Marshal(&session{
+		Issuer:  id.Issuer,
+		Subject: id.Subject,
+		Email:   id.Email,
+		Roles:   id.Roles,
+		Expires: expires.Unix(),
+		CSRF:    base64.RawURLEncoding.EncodeToString(csrf),
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode session: %v", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, s.cookie(value+"."+s.sign(value), expires))
+	return nil
+}
+
+// End signs the caller out by clearing the session cookie.
+func (s *Sessions) End(w http.ResponseWriter) {
+	c := s.cookie("", time.Unix(0, 0))
+	c.MaxAge = -1
+	http.SetCookie(w, c)
+}
+
+func (s *Sessions) cookie(value string, expires time.Time) *http.Cookie {
+	path := s.Path
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    value,
+		Path:     path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (s *Sessions) sign(value string) string {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// get returns the valid session of r, or nil if there is none.
+func (s *Sessions) get(r *http.Request) *session {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil || len(s.Key) == 0 {
+		return nil
+	}
+	parts := strings.SplitN(c.Value, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(s.sign(parts[0]))) {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil
+	}
+	var sess session
+	if err := json.Unmarshal(payload, &sess); err != nil {
+		return nil
+	}
+	if s.timeNow().After(time.Unix(sess.Expires, 0)) {
+		return nil
+	}
+	return &sess
+}
+
+// Require returns a handler that only calls h for signed in callers that have
+// role. Callers without a session are redirected to loginURL if they are
+// reading a page, and rejected otherwise. Requests other than GET and HEAD
+// must carry the CSRF token of the session. The caller identity and token are
+// available to h through IdentityFrom and CSRFToken.
+func (s *Sessions) Require(role Role, loginURL string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Insecure {
+			id := &Identity{Subject: "anonymous", Roles: []Role{RoleAdmin}}
+			ctx := context.WithValue(WithIdentity(r.Context(), id), csrfKey{}, "insecure")
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		sess := s.get(r)
+		if sess == nil {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				http.Redirect(w, r, loginURL, http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "Sign in required.", http.StatusUnauthorized)
+			return
+		}
+		id := &Identity{Issuer: sess.Issuer, Subject: sess.Subject, Email: sess.Email, Roles: sess.Roles}
+		if !id.HasRole(role) {
+			logging.Warningf(r.Context(), "Rejected %s %s for %s: missing role %q.", r.Method, r.URL.Path, id, role)
+			http.Error(w, fmt.Sprintf("Caller does not have the %q role.", role), http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			token := r.Header.Get("X-CSRF-Token")
+			if token == "" {
+				token = r.PostFormValue(CSRFField)
+			}
+			if !hmac.Equal([]byte(token), []byte(sess.CSRF)) {
+				logging.Warningf(r.Context(), "Rejected %s %s for %s: invalid CSRF token.", r.Method, r.URL.Path, id)
+				http.Error(w, "Invalid CSRF token.", http.StatusForbidden)
+				return
+			}
+		}
+		ctx := context.WithValue(WithIdentity(r.Context(), id), csrfKey{}, sess.CSRF)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signIn(t *testing.T, s *Sessions, id *Identity) *http.Cookie {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	if err := s.Start(rr, id); err != nil {
+		t.Fatalf("Start() = %v want <nil>", err)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Start() set %d cookies want 1", len(cookies))
+	}
+	return cookies[0]
+}
+
+func TestSessionsRequire(t *testing.T) {
+	now := time.Date(2020, 7, 16, 12, 0, 0, 0, time.UTC)
+	s := &Sessions{Key: []byte("test key"), now: func() time.Time { return now }}
+	admin := &Identity{Issuer: GoogleIssuerURL, Subject: "1", Email: "admin@example.com", Roles: []Role{RoleAdmin}}
+	reader := &Identity{Issuer: GoogleIssuerURL, Subject: "2", Email: "reader@example.com", Roles: []Role{RoleReader}}
+
+	adminCookie := signIn(t, s, admin)
+	readerCookie := signIn(t, s, reader)
+	forged := *adminCookie
+	forged.Value = strings.Replace(forged.Value, ".", "x.", 1)
+	otherKey := &Sessions{Key: []byte("other key"), now: s.now}
+	otherCookie := signIn(t, otherKey, admin)
+
+	var csrf string
+	var gotID *Identity
+	h := s.Require(RoleAdmin, "/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		csrf = CSRFToken(r.Context())
+		gotID = IdentityFrom(r.Context())
+	}))
+
+	// Read the CSRF token of the admin session.
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	req.AddCookie(adminCookie)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if csrf == "" || gotID == nil || gotID.Email != admin.Email {
+		t.Fatalf("admin session identity = %v, CSRF token %q want admin with token", gotID, csrf)
+	}
+	adminCSRF := csrf
+
+	for _, tt := range []struct {
+		name         string
+		method       string
+		cookie       *http.Cookie
+		csrf         string
+		after        time.Duration
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "Get", method: http.MethodGet, cookie: adminCookie, wantStatus: http.StatusOK},
+		{name: "NoSessionGet", method: http.MethodGet, wantStatus: http.StatusSeeOther, wantLocation: "/login"},
+		{name: "NoSessionPost", method: http.MethodPost, csrf: adminCSRF, wantStatus: http.StatusUnauthorized},
+		{name: "Forged", method: http.MethodGet, cookie: &forged, wantStatus: http.StatusSeeOther, wantLocation: "/login"},
+		{name: "OtherKey", method: http.MethodGet, cookie: otherCookie, wantStatus: http.StatusSeeOther, wantLocation: "/login"},
+		{name: "Expired", method: http.MethodGet, cookie: adminCookie, after: 13 * time.Hour, wantStatus: http.StatusSeeOther, wantLocation: "/login"},
+		{name: "MissingRole", method: http.MethodGet, cookie: readerCookie, wantStatus: http.StatusForbidden},
+		{name: "PostWithCSRF", method: http.MethodPost, cookie: adminCookie, csrf: adminCSRF, wantStatus: http.StatusOK},
+		{name: "PostWithoutCSRF", method: http.MethodPost, cookie: adminCookie, wantStatus: http.StatusForbidden},
+		{name: "PostWithWrongCSRF", method: http.MethodPost, cookie: adminCookie, csrf: "guess", wantStatus: http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = func() time.Time { return now.Add(tt.after) }
+			defer func() { s.now = func() time.Time { return now } }()
+
+			form := url.Values{}
+			if tt.csrf != "" {
+				form.Set(CSRFField, tt.csrf)
+			}
+			req := httptest.NewRequest(tt.method, "/admin", strings.NewReader(form.Encode()))
+			req.Header.Set("content-type", "application/x-www-form-urlencoded")
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tt.wantStatus)
+			}
+			if got := rr.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("handler redirected to %q want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestSessionsEnd(t *testing.T) {
+	s := &Sessions{Key: []byte("test key"), Path: "/admin"}
+	rr := httptest.NewRecorder()
+	s.End(rr)
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 || cookies[0].Path != "/admin" {
+		t.Errorf("End() set cookies %v want one expired cookie for /admin", cookies)
+	}
+}
@@ -0,0 +1,76 @@
+// Package blobtest provides an in-memory stand-in for the storage clients in
+// package blob, for tests.
+package blobtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Storage keeps objects in memory and records which of them were read and
+// made public. The zero value is empty and ready to use.
+type Storage struct {
+	// Objects are the contents of the objects by name. Like GCS objects,
+	// new ones only appear once their writer is closed.
+	Objects map[string]string
+	// Reads are the names of the objects opened with NewReader, in order.
+	Reads []string
+	// Public are the names of the objects made public, in order.
+	Public []string
+}
+
+// NewObject returns a writer for the object fileName.
+func (s *Storage) NewObject(_ context.Context, fileName string) io.WriteCloser {
+	return &objectWriter{s: s, name: fileName}
+}
+
+// NewReader returns a reader for the object fileName.
+func (s *Storage) NewReader(_ context.Context, fileName string) (io.ReadCloser, error) {
+	s.Reads = append(s.Reads, fileName)
+	o, ok := s.Objects[fileName]
+	if !ok {
+		return nil, fmt.Errorf("object %q does not exist", fileName)
+	}
+	return ioutil.NopCloser(strings.NewReader(o)), nil
+}
+
+// NewRangeReader returns a reader for length bytes of the object fileName,
+// starting at offset.
+func (s *Storage) NewRangeReader(_ context.Context, fileName string, offset, length int64) (io.ReadCloser, error) {
+	o, ok := s.Objects[fileName]
+	if !ok {
+		return nil, fmt.Errorf("object %q does not exist", fileName)
+	}
+	if offset < 0 || length < 0 || offset+length > int64(len(o)) {
+		return nil, fmt.Errorf("range %d+%d is outside object %q of %d bytes", offset, length, fileName, len(o))
+	}
+	return ioutil.NopCloser(strings.NewReader(o[offset : offset+length])), nil
+}
+
+// AllowPublicAccess records that the object fileName was made public. Like
+// its GCS counterpart, it fails if the object does not exist.
+func (s *Storage) AllowPublicAccess(_ context.Context, fileName string) error {
+	if _, ok := s.Objects[fileName]; !ok {
+		return fmt.Errorf("object %q does not exist", fileName)
+	}
+	s.Public = append(s.Public, fileName)
+	return nil
+}
+
+type objectWriter struct {
+	bytes.Buffer
+	s    *Storage
+	name string
+}
+
+func (w *objectWriter) Close() error {
+	if w.s.Objects == nil {
+		w.s.Objects = make(map[string]string)
+	}
+	w.s.Objects[w.name] = w.String()
+	return nil
+}
@@ -0,0 +1,104 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+// Dir stores objects as files under a local directory, with the same names
+// they would have in a bucket.
+type Dir string
+
+// NewObject creates a new object with the specified file name and returns a
+// writer for it. Like a GCS object, the file only appears once the writer is
+// closed without error.
+func (d Dir) NewObject(ctx context.Context, fileName string) io.WriteCloser {
+	path := d.path(fileName)
+	logging.Debugf(ctx, "Writing %s.", path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return &fileWriter{err: fmt.Errorf("could not create directory: %v", err)}
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return &fileWriter{err: fmt.Errorf("could not create file: %v", err)}
+	}
+	return &fileWriter{f: f, path: path}
+}
+
+// NewReader returns a reader for the object with the specified file name.
+func (d Dir) NewReader(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	logging.Debugf(ctx, "Reading %s.", d.path(fileName))
+	return os.Open(d.path(fileName))
+}
+
+// NewRangeReader returns a reader for length bytes of the object with the
+// specified file name, starting at offset.
+func (d Dir) NewRangeReader(ctx context.Context, fileName string, offset, length int64) (io.ReadCloser, error) {
+	logging.Debugf(ctx, "Reading %d bytes at %d of %s.", length, offset, d.path(fileName))
+	f, err := os.Open(d.path(fileName))
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReader{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+// rangeReader reads part of a file and closes the file when it is closed.
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+// AllowPublicAccess does nothing, since local files are not served, but like
+// its GCS counterpart it fails if the object does not exist.
+func (d Dir) AllowPublicAccess(_ context.Context, fileName string) error {
+	_, err := os.Stat(d.path(fileName))
+	return err
+}
+
+func (d Dir) path(fileName string) string {
+	return filepath.Join(string(d), filepath.FromSlash(fileName))
+}
+
+// fileWriter writes to a temporary file and moves it into place on Close.
+type fileWriter struct {
+	f    *os.File
+	path string
+	// err is returned by every call if the file could not be created.
+	err error
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.f.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *fileWriter) Close() error {
+	if w.f == nil {
+		return w.err
+	}
+	// Temporary files are only readable by their owner.
+	if err := w.f.Chmod(0644); err != nil && w.err == nil {
+		w.err = err
+	}
+	closeErr := w.f.Close()
+	if w.err == nil {
+		w.err = closeErr
+	}
+	if w.err != nil {
+		os.Remove(w.f.Name())
+		return w.err
+	}
+	return os.Rename(w.f.Name(), w.path)
+}
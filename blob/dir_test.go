@@ -0,0 +1,68 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "blobtest")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	ctx := context.Background()
+	d := Dir(root)
+
+	w := d.NewObject(ctx, "processed/FAACIFP18")
+	if _, err := io.WriteString(w, "data"); err != nil {
+		t.Fatalf("Write() = _, %v want _, <nil>", err)
+	}
+	if _, err := d.NewReader(ctx, "processed/FAACIFP18"); err == nil {
+		t.Error("NewReader() before Close() = _, <nil> want _, <non-nil>")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v want <nil>", err)
+	}
+	if err := d.AllowPublicAccess(ctx, "processed/FAACIFP18"); err != nil {
+		t.Errorf("AllowPublicAccess() = %v want <nil>", err)
+	}
+	r, err := d.NewReader(ctx, "processed/FAACIFP18")
+	if err != nil {
+		t.Fatalf("NewReader() = _, %v want _, <nil>", err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("could not read object: %v", err)
+	}
+	if got, want := string(b), "data"; got != want {
+		t.Errorf("object contents = %q want %q", got, want)
+	}
+
+	rr, err := d.NewRangeReader(ctx, "processed/FAACIFP18", 1, 2)
+	if err != nil {
+		t.Fatalf("NewRangeReader() = _, %v want _, <nil>", err)
+	}
+	defer rr.Close()
+	if b, err = ioutil.ReadAll(rr); err != nil {
+		t.Fatalf("could not read object range: %v", err)
+	}
+	if got, want := string(b), "at"; got != want {
+		t.Errorf("object range contents = %q want %q", got, want)
+	}
+
+	if err := d.AllowPublicAccess(ctx, "missing"); err == nil {
+		t.Error("AllowPublicAccess() of missing object = <nil> want <non-nil>")
+	}
+	files, err := ioutil.ReadDir(root + "/processed")
+	if err != nil {
+		t.Fatalf("could not list directory: %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("directory has %d files, want 1 without temporary files", len(files))
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"io"
 
 	"cloud.google.com/go/storage"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// GCSClient is a client for writing new objects to a GCS bucket.
+// GCSClient is a client for reading and writing objects in a GCS bucket.
 type GCSClient struct {
 	Client     *storage.Client
 	BucketName string
@@ -16,11 +20,71 @@ type GCSClient struct {
 // NewObject creates a new object with the specified file name in the bucket for this
 // GCS client and returns a writer for the object.
 func (g *GCSClient) NewObject(ctx context.Context, fileName string) io.WriteCloser {
-	return g.Client.Bucket(g.BucketName).Object(fileName).NewWriter(ctx)
+	logging.Debugf(ctx, "Writing gs://%s/%s.", g.BucketName, fileName)
+	ctx, span := tracing.Start(ctx, "blob.GCSClient.NewObject", g.labels(fileName)...)
+	return &tracedWriter{g.Client.Bucket(g.BucketName).Object(fileName).NewWriter(ctx), span}
+}
+
+// NewReader returns a reader for the object with the specified file name in the
+// bucket for this GCS client.
+func (g *GCSClient) NewReader(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	logging.Debugf(ctx, "Reading gs://%s/%s.", g.BucketName, fileName)
+	ctx, span := tracing.Start(ctx, "blob.GCSClient.NewReader", g.labels(fileName)...)
+	r, err := g.Client.Bucket(g.BucketName).Object(fileName).NewReader(ctx)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	return &tracedReader{r, span}, nil
+}
+
+// NewRangeReader returns a reader for length bytes of the object with the
+// specified file name, starting at offset.
+func (g *GCSClient) NewRangeReader(ctx context.Context, fileName string, offset, length int64) (io.ReadCloser, error) {
+	logging.Debugf(ctx, "Reading %d bytes at %d of gs://%s/%s.", length, offset, g.BucketName, fileName)
+	ctx, span := tracing.Start(ctx, "blob.GCSClient.NewRangeReader", g.labels(fileName)...)
+	r, err := g.Client.Bucket(g.BucketName).Object(fileName).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	return &tracedReader{r, span}, nil
 }
 
 // AllowPublicAccess sets the ACL on the specified file to be public. The object must
 // already exist.
 func (g *GCSClient) AllowPublicAccess(ctx context.Context, fileName string) error {
-	return g.Client.Bucket(g.BucketName).Object(fileName).ACL().Set(ctx, storage.AllUsers, storage.RoleReader)
+	logging.Debugf(ctx, "Making gs://%s/%s public.", g.BucketName, fileName)
+	ctx, span := tracing.Start(ctx, "blob.GCSClient.AllowPublicAccess", g.labels(fileName)...)
+	err := g.Client.Bucket(g.BucketName).Object(fileName).ACL().Set(ctx, storage.AllUsers, storage.RoleReader)
+	tracing.End(span, err)
+	return err
+}
+
+func (g *GCSClient) labels(fileName string) []label.KeyValue {
+	return []label.KeyValue{label.String("gcs.bucket", g.BucketName), label.String("gcs.object", fileName)}
+}
+
+// tracedWriter ends the span of an upload when the upload is finished.
+type tracedWriter struct {
+	io.WriteCloser
+	span trace.Span
+}
+
+func (w *tracedWriter) Close() error {
+	err := w.WriteCloser.Close()
+	tracing.End(w.span, err)
+	return err
+}
+
+// tracedReader ends the span of a download when the reader is closed.
+type tracedReader struct {
+	io.ReadCloser
+	span trace.Span
+}
+
+func (r *tracedReader) Close() error {
+	err := r.ReadCloser.Close()
+	tracing.End(r.span, err)
+	return err
 }
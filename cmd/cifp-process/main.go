@@ -0,0 +1,66 @@
+// Command cifp-process enhances a CIFP zip archive downloaded from the FAA
+// with the same pipeline as the app, writing the processed data, its indexes,
+// GeoJSON and subsets to a local directory instead of Cloud Storage. It is
+// used to reproduce the app's output without access to its database or
+// bucket.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/blob"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/config"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/processing"
+)
+
+var (
+	in                        = flag.String("in", "", "Path to the CIFP zip archive downloaded from the FAA.")
+	out                       = flag.String("out", "", "Directory to write the processed data to. It is created if it does not exist.")
+	cycle                     = flag.String("cycle", "", "Cycle of the archive, such as 06/18/2020, which names its indexes, GeoJSON and subsets.")
+	name                      = flag.String("name", "FAACIFP18_processed", "File name of the processed data in --out.")
+	configPath                = flag.String("config", "", "Path to the app's JSON configuration file, whose enhance settings and subsets are used. The defaults are used if it is empty.")
+	removeDuplicateLocalizers = flag.Bool("remove_duplicate_localizers", true, "Remove localizers that duplicate another at the same airport. Overrides --config if given.")
+)
+
+func main() {
+	ctx := context.Background()
+	flag.Parse()
+
+	if *in == "" || *out == "" || *cycle == "" {
+		log.Fatal("Must provide --in, --out and --cycle.")
+	}
+	cfg := config.Default()
+	if *configPath != "" {
+		var err error
+		if cfg, err = config.Load(*configPath, os.Getenv); err != nil {
+			log.Fatalf("Could not load configuration: %v", err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "remove_duplicate_localizers" {
+			cfg.Enhance.RemoveDuplicateLocalizers = *removeDuplicateLocalizers
+		}
+	})
+
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatalf("Could not open archive: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatalf("Could not read archive: %v", err)
+	}
+
+	p := processing.NewPipeline(cfg, blob.Dir(*out))
+	a, err := p.Process(ctx, *cycle, f, info.Size(), *name)
+	if err != nil {
+		log.Fatalf("Could not process %s: %v", *in, err)
+	}
+	fmt.Printf("%s  %s\n", a.Checksum, filepath.Join(*out, *name))
+}
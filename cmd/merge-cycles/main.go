@@ -0,0 +1,49 @@
+// Command merge-cycles is a one-time migration that moves cycles stored under
+// auto-generated Firestore document IDs to their deterministic IDs, merging any
+// duplicate documents for the same cycle and reporting the ones it removed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+var (
+	projectID = flag.String("project_id", os.Getenv("PROJECT_ID"), "Project ID that contains the Firestore database.")
+	dryRun    = flag.Bool("dry_run", false, "Report the documents that would be removed without changing anything.")
+)
+
+func main() {
+	ctx := context.Background()
+	flag.Parse()
+
+	if *projectID == "" {
+		log.Fatal("Must provide a project ID.")
+	}
+	fsClient, err := firestore.NewClient(ctx, *projectID)
+	if err != nil {
+		log.Fatalf("Could not create firestore client: %v", err)
+	}
+	defer fsClient.Close()
+
+	cycles := &db.Cycles{Client: fsClient}
+	merged, err := cycles.MergeDuplicates(ctx, *dryRun)
+	for _, m := range merged {
+		fmt.Printf("%s -> %s: removed %s\n", m.Name, m.ID, strings.Join(m.Removed, ", "))
+	}
+	if err != nil {
+		log.Fatalf("Could not merge duplicates: %v", err)
+	}
+	if len(merged) == 0 {
+		fmt.Println("No documents to migrate.")
+	} else if *dryRun {
+		fmt.Println("Dry run, nothing was changed.")
+	}
+}
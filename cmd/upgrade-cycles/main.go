@@ -0,0 +1,43 @@
+// Command upgrade-cycles upgrades every cycle stored in Firestore to the
+// current schema version, such as by recording when cycles processed before
+// updates were tracked were last updated. Cycles are also upgraded lazily when
+// they are read, but the app lists cycles by date, so documents written
+// without one are only upgraded by this command, which walks every document.
+// Cycles that cannot be upgraded are logged, and make it exit with an error
+// once the others are upgraded. SQLite databases are upgraded when the app
+// opens them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"cloud.google.com/go/firestore"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+var projectID = flag.String("project_id", os.Getenv("PROJECT_ID"), "Project ID that contains the Firestore database.")
+
+func main() {
+	ctx := context.Background()
+	flag.Parse()
+
+	if *projectID == "" {
+		log.Fatal("Must provide a project ID.")
+	}
+	fsClient, err := firestore.NewClient(ctx, *projectID)
+	if err != nil {
+		log.Fatalf("Could not create firestore client: %v", err)
+	}
+	defer fsClient.Close()
+
+	cycles := &db.Cycles{Client: fsClient}
+	n, err := cycles.UpgradeAll(ctx)
+	fmt.Printf("Upgraded %d cycles to schema version %d.\n", n, db.SchemaVersion)
+	if err != nil {
+		log.Fatalf("Could not upgrade cycles: %v", err)
+	}
+}
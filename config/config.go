@@ -0,0 +1,590 @@
+// Package config reads the app's settings from a JSON file, with overrides
+// from environment variables, and checks them before the app starts.
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/auth"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/subset"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/webhook"
+)
+
+// Config is the configuration of the app. Settings with an env tag can be
+// overridden by the environment variable it names. Structured settings, such
+// as lists of role bindings, are overridden with the path of a JSON file.
+type Config struct {
+	Server   Server   `json:"server"`
+	Routes   Routes   `json:"routes"`
+	FAA      FAA      `json:"faa"`
+	Storage  Storage  `json:"storage"`
+	Database Database `json:"database"`
+	Auth     Auth     `json:"auth"`
+	Enhance  Enhance  `json:"enhance"`
+	// Subsets are published alongside the processed data of each cycle.
+	Subsets []subset.Spec `json:"subsets"`
+	// RunTimeout limits processing runs started by the scheduler and the
+	// admin console.
+	RunTimeout Duration               `json:"run_timeout" env:"RUN_TIMEOUT"`
+	Scheduler  Scheduler              `json:"scheduler"`
+	Webhooks   []webhook.Subscription `json:"webhooks" env:"WEBHOOKS"`
+	Health     Health                 `json:"health"`
+	Logging    Logging                `json:"logging"`
+	Tracing    Tracing                `json:"tracing"`
+}
+
+// Server configures the HTTP server.
+type Server struct {
+	Port string `json:"port" env:"PORT"`
+	// ProjectID is the Google Cloud project the app runs in. Log entries
+	// are grouped by the Cloud Trace trace of their request if it is set.
+	ProjectID string `json:"project_id" env:"PROJECT_ID"`
+	// SiteURL is the public URL of the app, used for links in the feeds.
+	// It is derived from each request if empty.
+	SiteURL string `json:"site_url" env:"SITE_URL"`
+	// DrainPeriod is how long requests and runs in progress get to finish
+	// when the app is shut down.
+	DrainPeriod Duration `json:"drain_period" env:"DRAIN_PERIOD"`
+}
+
+// Route configures where a handler is served and how long its requests may
+// take. Requests are not limited if Timeout is zero.
+type Route struct {
+	Path    string   `json:"path"`
+	Timeout Duration `json:"timeout"`
+}
+
+// Routes configures the HTTP routes.
+type Routes struct {
+	Index       Route `json:"index"`
+	AtomFeed    Route `json:"atom_feed"`
+	RSSFeed     Route `json:"rss_feed"`
+	Process     Route `json:"process"`
+	Admin       Route `json:"admin"`
+	APIKeys     Route `json:"api_keys"`
+	DeadLetters Route `json:"dead_letters"`
+	LogLevel    Route `json:"log_level"`
+	Metrics     Route `json:"metrics"`
+	Healthz     Route `json:"healthz"`
+	Readyz      Route `json:"readyz"`
+	Download    Route `json:"download"`
+	API         Route `json:"api"`
+	Airports    Route `json:"airports"`
+}
+
+// FAA configures where CIFP data is fetched from.
+type FAA struct {
+	// EditionsURL lists the CIFP edition to process.
+	EditionsURL string `json:"editions_url" env:"FAA_EDITIONS_URL"`
+}
+
+// Storage configures where original and processed data is stored.
+type Storage struct {
+	// Backend is the kind of storage. Only "gcs" is supported.
+	Backend string `json:"backend"`
+	Bucket  string `json:"bucket" env:"GCS_BUCKET"`
+	// HealthObject is written and read back by readiness checks.
+	HealthObject string `json:"health_object"`
+}
+
+// Database configures where cycles, API keys and the like are stored.
+type Database struct {
+	// URL is either firestore://project or sqlite:///path. It defaults to
+	// Firestore in the server's project.
+	URL string `json:"url" env:"DATABASE_URL"`
+}
+
+// Auth configures who may call the app.
+type Auth struct {
+	// Disabled treats every caller as an admin, for testing purposes.
+	Disabled bool `json:"disabled" env:"NOAUTH"`
+	// ServiceAccountEmail is granted the scheduler role.
+	ServiceAccountEmail string `json:"service_account_email" env:"SERVICE_ACCOUNT"`
+	// Audience is the audience Google ID tokens must be minted for,
+	// usually the URL of the process route.
+	Audience string `json:"audience" env:"AUDIENCE"`
+	// AuthorizedParties are the allowed azp claims of Google ID tokens.
+	// Any are allowed if empty.
+	AuthorizedParties []string `json:"authorized_parties" env:"AUTHORIZED_PARTIES"`
+	// HostedDomains are the allowed hd claims of Google ID tokens. Any are
+	// allowed if empty.
+	HostedDomains []string `json:"hosted_domains" env:"HOSTED_DOMAINS"`
+	// RoleBindings grant roles besides the service account's.
+	RoleBindings []auth.RoleBinding `json:"role_bindings" env:"ROLE_BINDINGS"`
+	// TrustedIssuers are OIDC issuers besides Google, such as GitHub
+	// Actions, whose tokens may call the app.
+	TrustedIssuers []*auth.Issuer `json:"trusted_issuers" env:"TRUSTED_ISSUERS"`
+	// OAuth configures signing in to the admin console, which is disabled
+	// if there is no client ID and auth is enabled.
+	OAuth OAuth `json:"oauth"`
+	// SessionKey signs admin console sessions. A random key is used if it
+	// is empty, so sessions do not survive restarts.
+	SessionKey string `json:"session_key" env:"SESSION_KEY"`
+	// APIKeyPepper is the secret the secrets of API keys are derived from.
+	// It must not be stored in the database, and API keys are disabled if
+	// it is empty. Changing it invalidates every key.
+	APIKeyPepper string `json:"api_key_pepper" env:"API_KEY_PEPPER"`
+}
+
+// OAuth configures the OAuth client admins sign in with.
+type OAuth struct {
+	ClientID     string `json:"client_id" env:"OAUTH_CLIENT_ID"`
+	ClientSecret string `json:"client_secret" env:"OAUTH_CLIENT_SECRET"`
+	// RedirectURL is the URL of the admin callback route.
+	RedirectURL string `json:"redirect_url" env:"OAUTH_REDIRECT_URL"`
+}
+
+// Enhance configures how CIFP data is enhanced.
+type Enhance struct {
+	RemoveDuplicateLocalizers bool `json:"remove_duplicate_localizers" env:"REMOVE_DUPLICATE_LOCALIZERS"`
+}
+
+// Scheduler configures the built-in scheduler.
+type Scheduler struct {
+	// Schedule is a cron expression, such as "0 6 * * *", on which to
+	// process data. The scheduler is disabled if it is empty.
+	Schedule string `json:"schedule" env:"SCHEDULE"`
+	// LeaseTTL is how long a replica holds the scheduler lease without
+	// renewing it.
+	LeaseTTL Duration `json:"lease_ttl"`
+}
+
+// Health configures the readiness checks.
+type Health struct {
+	// CheckTimeout limits each check.
+	CheckTimeout Duration `json:"check_timeout"`
+	// CacheFor is how long a readiness report is reused for.
+	CacheFor Duration `json:"cache_for"`
+}
+
+// Logging configures the structured logger.
+type Logging struct {
+	Level logging.Level `json:"level" env:"LOG_LEVEL"`
+}
+
+// Tracing configures where trace spans are exported to.
+type Tracing struct {
+	// Exporter is "otlp", "stdout", or empty to not export spans.
+	Exporter string `json:"exporter" env:"TRACE_EXPORTER"`
+	// Endpoint is the host:port of the OpenTelemetry collector.
+	Endpoint string `json:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	// Insecure sends spans to the collector without TLS.
+	Insecure bool `json:"insecure" env:"OTLP_INSECURE"`
+}
+
+// Duration is a time.Duration written as a string such as "30s".
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, want a number and a unit such as 30s", b)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Default returns the settings used where the configuration file and the
+// environment do not set any.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:        "8080",
+			DrainPeriod: Duration(30 * time.Second),
+		},
+		Routes: Routes{
+			Index:       Route{Path: "/", Timeout: Duration(5 * time.Second)},
+			AtomFeed:    Route{Path: "/feed.atom", Timeout: Duration(5 * time.Second)},
+			RSSFeed:     Route{Path: "/feed.rss", Timeout: Duration(5 * time.Second)},
+			Process:     Route{Path: "/process", Timeout: Duration(120 * time.Second)},
+			Admin:       Route{Path: "/admin", Timeout: Duration(10 * time.Second)},
+			APIKeys:     Route{Path: "/admin/apikeys", Timeout: Duration(10 * time.Second)},
+			DeadLetters: Route{Path: "/admin/deadletters", Timeout: Duration(30 * time.Second)},
+			LogLevel:    Route{Path: "/admin/loglevel", Timeout: Duration(10 * time.Second)},
+			Metrics:     Route{Path: "/metrics"},
+			Healthz:     Route{Path: "/healthz"},
+			Readyz:      Route{Path: "/readyz"},
+			Download:    Route{Path: "/download", Timeout: Duration(30 * time.Second)},
+			API:         Route{Path: "/api/v1", Timeout: Duration(60 * time.Second)},
+			Airports:    Route{Path: "/airports", Timeout: Duration(60 * time.Second)},
+		},
+		FAA: FAA{
+			EditionsURL: "https://soa.smext.faa.gov/apra/cifp/chart?edition=next",
+		},
+		Storage: Storage{
+			Backend:      "gcs",
+			Bucket:       "faa-cifp-data",
+			HealthObject: "health/readyz",
+		},
+		Enhance: Enhance{
+			RemoveDuplicateLocalizers: true,
+		},
+		RunTimeout: Duration(120 * time.Second),
+		Scheduler: Scheduler{
+			LeaseTTL: Duration(time.Minute),
+		},
+		Health: Health{
+			CheckTimeout: Duration(5 * time.Second),
+			// Cloud Storage allows about one write per second to an
+			// object, and every instance writes the same one.
+			CacheFor: Duration(10 * time.Second),
+		},
+		Logging: Logging{Level: logging.Info},
+	}
+}
+
+// Load reads the configuration file at path over the defaults, applies the
+// overrides from getenv, usually os.Getenv, and validates the result. The
+// file is optional if path is empty. Settings the app does not know, and
+// invalid settings, are errors.
+func Load(path string, getenv func(string) string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read configuration: %v", err)
+		}
+		if err := decode(b, c); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", path, err)
+		}
+	}
+	var errs Errors
+	applyEnv(reflect.ValueOf(c).Elem(), "", getenv, &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if c.Database.URL == "" && c.Server.ProjectID != "" {
+		c.Database.URL = "firestore://" + c.Server.ProjectID
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// decode strictly decodes the JSON in b into c, reporting where in b any
+// problem is.
+func decode(b []byte, c *Config) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(c)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		if dec.More() {
+			return errors.New("unexpected data after the configuration object")
+		}
+		return nil
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("%s: %v", position(b, syntaxErr.Offset), err)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("%s: %s must be %s, not %s", position(b, typeErr.Offset), typeErr.Field, typeErr.Type, typeErr.Value)
+	default:
+		return err
+	}
+}
+
+// position returns the line and column of offset in b.
+func position(b []byte, offset int64) string {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	before := b[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("line %d, column %d", line, col)
+}
+
+// Errors lists every problem with a configuration, each prefixed with the
+// setting it is about.
+type Errors []string
+
+func (e Errors) Error() string {
+	return "invalid configuration:\n\t" + strings.Join(e, "\n\t")
+}
+
+func (e *Errors) add(setting, format string, args ...interface{}) {
+	*e = append(*e, setting+": "+fmt.Sprintf(format, args...))
+}
+
+// applyEnv overrides the fields of the struct v that have an env tag with the
+// environment variables they name, recursing into nested structs. path is
+// the setting v is at.
+func applyEnv(v reflect.Value, path string, getenv func(string) string, errs *Errors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		setting := strings.Split(f.Tag.Get("json"), ",")[0]
+		if path != "" {
+			setting = path + "." + setting
+		}
+		env := f.Tag.Get("env")
+		if env == "" {
+			if f.Type.Kind() == reflect.Struct {
+				applyEnv(v.Field(i), setting, getenv, errs)
+			}
+			continue
+		}
+		s := getenv(env)
+		if s == "" {
+			continue
+		}
+		if err := set(v.Field(i), s); err != nil {
+			errs.add(setting, "invalid %s: %v", env, err)
+		}
+	}
+}
+
+// set sets v to the value of an environment variable.
+func set(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(splitList(s)))
+	default:
+		b, err := ioutil.ReadFile(s)
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v.Addr().Interface()); err != nil {
+			return fmt.Errorf("could not parse %s: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, ignoring empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// Validate checks every setting and returns Errors listing the invalid ones.
+func (c *Config) Validate() error {
+	var errs Errors
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs.add("server.port", "%q is not a port number", c.Server.Port)
+	}
+	if c.Server.SiteURL != "" {
+		checkURL(&errs, "server.site_url", c.Server.SiteURL)
+	}
+	checkNotNegative(&errs, "server.drain_period", c.Server.DrainPeriod)
+
+	c.Routes.check(&errs)
+
+	checkURL(&errs, "faa.editions_url", c.FAA.EditionsURL)
+
+	if c.Storage.Backend != "gcs" {
+		errs.add("storage.backend", "unsupported backend %q, want \"gcs\"", c.Storage.Backend)
+	}
+	if c.Storage.Bucket == "" {
+		errs.add("storage.bucket", "must be set")
+	}
+	if c.Storage.HealthObject == "" {
+		errs.add("storage.health_object", "must be set")
+	}
+
+	checkDatabaseURL(&errs, c.Database.URL)
+
+	c.Auth.check(&errs)
+
+	names := make(map[string]bool)
+	for i := range c.Subsets {
+		s := &c.Subsets[i]
+		setting := fmt.Sprintf("subsets[%d]", i)
+		if err := s.Validate(); err != nil {
+			errs.add(setting, "%v", err)
+		} else if names[s.Name] {
+			errs.add(setting, "duplicate name %q", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	checkPositive(&errs, "run_timeout", c.RunTimeout)
+	if c.Scheduler.Schedule != "" {
+		if _, err := cron.ParseStandard(c.Scheduler.Schedule); err != nil {
+			errs.add("scheduler.schedule", "%v", err)
+		}
+	}
+	checkPositive(&errs, "scheduler.lease_ttl", c.Scheduler.LeaseTTL)
+	if _, err := webhook.NewDispatcher(nil, c.Webhooks...); err != nil {
+		errs.add("webhooks", "%v", err)
+	}
+
+	checkPositive(&errs, "health.check_timeout", c.Health.CheckTimeout)
+	checkNotNegative(&errs, "health.cache_for", c.Health.CacheFor)
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if c.Tracing.Endpoint == "" {
+			errs.add("tracing.endpoint", "must be set to export spans with %q", c.Tracing.Exporter)
+		}
+	default:
+		errs.add("tracing.exporter", "unknown exporter %q, want %q, %q or none", c.Tracing.Exporter, tracing.ExporterOTLP, tracing.ExporterStdout)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// all returns the routes by setting name.
+func (r *Routes) all() map[string]Route {
+	return map[string]Route{
+		"index":        r.Index,
+		"atom_feed":    r.AtomFeed,
+		"rss_feed":     r.RSSFeed,
+		"process":      r.Process,
+		"admin":        r.Admin,
+		"api_keys":     r.APIKeys,
+		"dead_letters": r.DeadLetters,
+		"log_level":    r.LogLevel,
+		"metrics":      r.Metrics,
+		"healthz":      r.Healthz,
+		"readyz":       r.Readyz,
+		"download":     r.Download,
+		"api":          r.API,
+		"airports":     r.Airports,
+	}
+}
+
+func (r *Routes) check(errs *Errors) {
+	routes := r.all()
+	names := make([]string, 0, len(routes))
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	seen := make(map[string]string)
+	for _, name := range names {
+		route := routes[name]
+		setting := "routes." + name
+		switch {
+		case !strings.HasPrefix(route.Path, "/"):
+			errs.add(setting+".path", "%q must start with /", route.Path)
+		case route.Path != "/" && strings.HasSuffix(route.Path, "/"):
+			errs.add(setting+".path", "%q must not end with /", route.Path)
+		case seen[route.Path] != "":
+			errs.add(setting+".path", "%q is also the path of routes.%s", route.Path, seen[route.Path])
+		default:
+			seen[route.Path] = name
+		}
+		checkNotNegative(errs, setting+".timeout", route.Timeout)
+	}
+}
+
+// minPepperLength is the shortest API key pepper accepted, so that it cannot
+// be guessed from the hashes of the keys.
+const minPepperLength = 32
+
+func (a *Auth) check(errs *Errors) {
+	if a.ServiceAccountEmail == "" {
+		errs.add("auth.service_account_email", "must be set")
+	}
+	if a.Audience == "" && !a.Disabled {
+		errs.add("auth.audience", "must be set unless auth.disabled is true")
+	}
+	for i, b := range a.RoleBindings {
+		switch b.Role {
+		case auth.RoleAdmin, auth.RoleScheduler, auth.RoleReader:
+		default:
+			errs.add(fmt.Sprintf("auth.role_bindings[%d].role", i), "unknown role %q, want %q, %q or %q", b.Role, auth.RoleAdmin, auth.RoleScheduler, auth.RoleReader)
+		}
+		if len(b.Claims) == 0 {
+			errs.add(fmt.Sprintf("auth.role_bindings[%d].claims", i), "must list at least one claim")
+		}
+	}
+	if _, err := auth.NewOIDCVerifier(a.TrustedIssuers...); err != nil {
+		errs.add("auth.trusted_issuers", "%v", err)
+	}
+	if a.APIKeyPepper != "" && len(a.APIKeyPepper) < minPepperLength {
+		errs.add("auth.api_key_pepper", "must be at least %d characters", minPepperLength)
+	}
+	if a.OAuth.ClientID != "" {
+		if a.OAuth.RedirectURL == "" {
+			errs.add("auth.oauth.redirect_url", "must be set when auth.oauth.client_id is")
+		} else {
+			checkURL(errs, "auth.oauth.redirect_url", a.OAuth.RedirectURL)
+		}
+	}
+}
+
+func checkURL(errs *Errors, setting, s string) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		errs.add(setting, "%q must be an absolute HTTP(S) URL", s)
+	}
+}
+
+func checkDatabaseURL(errs *Errors, s string) {
+	if s == "" {
+		errs.add("database.url", "must be set, or server.project_id to use its Firestore database")
+		return
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		errs.add("database.url", "%v", err)
+		return
+	}
+	switch {
+	case u.Scheme == "firestore" && u.Host == "":
+		errs.add("database.url", "%q must name a project, as in firestore://project", s)
+	case u.Scheme == "sqlite" && u.Path == "":
+		errs.add("database.url", "%q must name a file, as in sqlite:///path/to/file.db", s)
+	case u.Scheme != "firestore" && u.Scheme != "sqlite":
+		errs.add("database.url", "unsupported database %q, want firestore or sqlite", u.Scheme)
+	}
+}
+
+func checkPositive(errs *Errors, setting string, d Duration) {
+	if d <= 0 {
+		errs.add(setting, "must be positive, not %v", time.Duration(d))
+	}
+}
+
+func checkNotNegative(errs *Errors, setting string, d Duration) {
+	if d < 0 {
+		errs.add(setting, "must not be negative, not %v", time.Duration(d))
+	}
+}
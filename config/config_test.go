@@ -0,0 +1,328 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/auth"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/subset"
+)
+
+// valid returns the defaults with the settings that have none filled in.
+func valid() *Config {
+	c := Default()
+	c.Auth.ServiceAccountEmail = "scheduler@example.iam.gserviceaccount.com"
+	c.Auth.Audience = "https://example.com/process"
+	c.Database.URL = "firestore://project"
+	return c
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func writeFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("could not write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeFile(t, dir, "config.json", `{
+		"server": {"project_id": "project", "port": "9000"},
+		"routes": {"process": {"path": "/run", "timeout": "5m"}},
+		"auth": {
+			"service_account_email": "scheduler@example.iam.gserviceaccount.com",
+			"audience": "https://example.com/run",
+			"role_bindings": [{"role": "admin", "issuer": "https://accounts.google.com", "claims": {"email": ["admin@example.com"]}}]
+		},
+		"enhance": {"remove_duplicate_localizers": false},
+		"logging": {"level": "debug"}
+	}`)
+	issuers := writeFile(t, dir, "issuers.json", `[{
+		"url": "https://token.actions.githubusercontent.com",
+		"policy": {
+			"audience": "https://example.com/run",
+			"claims": {"repository": ["org/repo"]}
+		}
+	}]`)
+
+	got, err := Load(path, env(map[string]string{
+		"PORT":               "8000",
+		"AUTHORIZED_PARTIES": " a, b ,,c ",
+		"TRUSTED_ISSUERS":    issuers,
+		"DRAIN_PERIOD":       "1m",
+		"GCS_BUCKET":         "bucket",
+	}))
+	if err != nil {
+		t.Fatalf("Load() = _, %v want _, <nil>", err)
+	}
+
+	want := Default()
+	want.Server.ProjectID = "project"
+	want.Server.Port = "8000"
+	want.Server.DrainPeriod = Duration(time.Minute)
+	want.Routes.Process = Route{Path: "/run", Timeout: Duration(5 * time.Minute)}
+	want.Storage.Bucket = "bucket"
+	want.Database.URL = "firestore://project"
+	want.Auth.ServiceAccountEmail = "scheduler@example.iam.gserviceaccount.com"
+	want.Auth.Audience = "https://example.com/run"
+	want.Auth.AuthorizedParties = []string{"a", "b", "c"}
+	want.Auth.RoleBindings = []auth.RoleBinding{{
+		Role:   auth.RoleAdmin,
+		Issuer: "https://accounts.google.com",
+		Claims: map[string][]string{"email": {"admin@example.com"}},
+	}}
+	want.Auth.TrustedIssuers = []*auth.Issuer{{
+		URL: "https://token.actions.githubusercontent.com",
+		Policy: auth.Policy{
+			Audience: "https://example.com/run",
+			Claims:   map[string][]string{"repository": {"org/repo"}},
+		},
+	}}
+	want.Enhance.RemoveDuplicateLocalizers = false
+	want.Logging.Level = logging.Debug
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(auth.Issuer{})); diff != "" {
+		t.Errorf("Load() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	required := map[string]string{
+		"SERVICE_ACCOUNT": "scheduler@example.iam.gserviceaccount.com",
+		"AUDIENCE":        "https://example.com/process",
+		"PROJECT_ID":      "project",
+	}
+	for _, tt := range []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "UnknownSetting",
+			file:    `{"storage": {"buket": "typo"}}`,
+			wantErr: `unknown field "buket"`,
+		},
+		{
+			name:    "Syntax",
+			file:    "{\n  \"server\": {\"port\": \"80\",}\n}",
+			wantErr: "line 2, column 28",
+		},
+		{
+			name:    "WrongType",
+			file:    "{\n  \"auth\": {\"disabled\": \"yes\"}\n}",
+			wantErr: "line 2, column 29: auth.disabled must be bool, not string",
+		},
+		{
+			name:    "InvalidDuration",
+			file:    `{"run_timeout": "2 minutes"}`,
+			wantErr: `invalid duration "2 minutes"`,
+		},
+		{
+			name:    "TrailingData",
+			file:    `{} {}`,
+			wantErr: "unexpected data after the configuration object",
+		},
+		{
+			name:    "InvalidEnvBool",
+			file:    `{}`,
+			env:     map[string]string{"NOAUTH": "maybe"},
+			wantErr: `auth.disabled: invalid NOAUTH: "maybe" is not true or false`,
+		},
+		{
+			name:    "InvalidEnvLevel",
+			file:    `{}`,
+			env:     map[string]string{"LOG_LEVEL": "loud"},
+			wantErr: "logging.level: invalid LOG_LEVEL",
+		},
+		{
+			name:    "MissingEnvFile",
+			file:    `{}`,
+			env:     map[string]string{"WEBHOOKS": filepath.Join(dir, "missing.json")},
+			wantErr: "webhooks: invalid WEBHOOKS",
+		},
+		{
+			name:    "Invalid",
+			file:    `{"routes": {"metrics": {"path": "metrics"}}}`,
+			wantErr: `routes.metrics.path: "metrics" must start with /`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, dir, tt.name+".json", tt.file)
+			vars := map[string]string{}
+			for k, v := range required {
+				vars[k] = v
+			}
+			for k, v := range tt.env {
+				vars[k] = v
+			}
+			if _, err := Load(path, env(vars)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() = _, %v want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		modify   func(c *Config)
+		wantErrs Errors
+	}{
+		{
+			name:   "Valid",
+			modify: func(c *Config) {},
+		},
+		{
+			name: "NoAuth",
+			modify: func(c *Config) {
+				c.Auth.Disabled = true
+				c.Auth.Audience = ""
+				c.Database.URL = "sqlite:///tmp/cycles.db"
+			},
+		},
+		{
+			name: "Server",
+			modify: func(c *Config) {
+				c.Server.Port = "http"
+				c.Server.SiteURL = "example.com"
+				c.Server.DrainPeriod = Duration(-time.Second)
+			},
+			wantErrs: Errors{
+				`server.port: "http" is not a port number`,
+				`server.site_url: "example.com" must be an absolute HTTP(S) URL`,
+				"server.drain_period: must not be negative, not -1s",
+			},
+		},
+		{
+			name: "Routes",
+			modify: func(c *Config) {
+				c.Routes.Admin.Path = "/admin/"
+				c.Routes.Healthz.Path = "/metrics"
+				c.Routes.Process.Timeout = Duration(-time.Second)
+			},
+			wantErrs: Errors{
+				`routes.admin.path: "/admin/" must not end with /`,
+				`routes.metrics.path: "/metrics" is also the path of routes.healthz`,
+				"routes.process.timeout: must not be negative, not -1s",
+			},
+		},
+		{
+			name: "Backends",
+			modify: func(c *Config) {
+				c.FAA.EditionsURL = "ftp://faa.gov/cifp"
+				c.Storage.Backend = "s3"
+				c.Storage.Bucket = ""
+				c.Database.URL = "postgres://localhost/cycles"
+			},
+			wantErrs: Errors{
+				`faa.editions_url: "ftp://faa.gov/cifp" must be an absolute HTTP(S) URL`,
+				`storage.backend: unsupported backend "s3", want "gcs"`,
+				"storage.bucket: must be set",
+				`database.url: unsupported database "postgres", want firestore or sqlite`,
+			},
+		},
+		{
+			name: "Subsets",
+			modify: func(c *Config) {
+				c.Subsets = []subset.Spec{
+					{Name: "west", Regions: []string{"K1", "K2"}},
+					{Name: "west", Airports: []string{"KHWD"}},
+					{Name: "Bay Area", Airports: []string{"KHWD"}},
+					{Name: "empty"},
+				}
+			},
+			wantErrs: Errors{
+				`subsets[1]: duplicate name "west"`,
+				`subsets[2]: name "Bay Area" must be lower case letters, digits, - and _`,
+				`subsets[3]: subset "empty" must list regions, airports or a box`,
+			},
+		},
+		{
+			name: "NoDatabase",
+			modify: func(c *Config) {
+				c.Database.URL = ""
+			},
+			wantErrs: Errors{"database.url: must be set, or server.project_id to use its Firestore database"},
+		},
+		{
+			name: "Auth",
+			modify: func(c *Config) {
+				c.Auth.ServiceAccountEmail = ""
+				c.Auth.Audience = ""
+				c.Auth.RoleBindings = []auth.RoleBinding{{Role: "owner", Issuer: auth.GoogleIssuerURL}}
+				c.Auth.TrustedIssuers = []*auth.Issuer{{URL: "https://token.actions.githubusercontent.com"}}
+				c.Auth.OAuth.ClientID = "client"
+				c.Auth.APIKeyPepper = "short"
+			},
+			wantErrs: Errors{
+				"auth.service_account_email: must be set",
+				"auth.audience: must be set unless auth.disabled is true",
+				`auth.role_bindings[0].role: unknown role "owner", want "admin", "scheduler" or "reader"`,
+				"auth.role_bindings[0].claims: must list at least one claim",
+				"auth.oauth.redirect_url: must be set when auth.oauth.client_id is",
+				"auth.api_key_pepper: must be at least 32 characters",
+				`auth.trusted_issuers: issuer "https://token.actions.githubusercontent.com" must have an audience`,
+			},
+		},
+		{
+			name: "Runs",
+			modify: func(c *Config) {
+				c.RunTimeout = 0
+				c.Scheduler.Schedule = "every day"
+				c.Health.CheckTimeout = 0
+			},
+			wantErrs: Errors{
+				"run_timeout: must be positive, not 0s",
+				"scheduler.schedule: expected exactly 5 fields, found 2: [every day]",
+				"health.check_timeout: must be positive, not 0s",
+			},
+		},
+		{
+			name: "Tracing",
+			modify: func(c *Config) {
+				c.Tracing.Exporter = "otlp"
+			},
+			wantErrs: Errors{`tracing.endpoint: must be set to export spans with "otlp"`},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			err := c.Validate()
+			var gotErrs Errors
+			if err != nil {
+				var ok bool
+				if gotErrs, ok = err.(Errors); !ok {
+					t.Fatalf("Validate() = %v want Errors", err)
+				}
+			}
+			if diff := cmp.Diff(tt.wantErrs, gotErrs, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("Validate() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const apiKeyCollection = "api_keys"
+
+// APIKey is a key that lets a caller without Google credentials, such as an
+// on-premises cron job, sign requests. The secret itself is never stored.
+type APIKey struct {
+	ID   string `firestore:"id"`
+	Name string `firestore:"name"`
+	// Role is the auth role granted to requests signed with the key.
+	Role string `firestore:"role"`
+	// Hash is the hex encoded SHA-256 hash of the secret. Requests cannot
+	// be signed with it.
+	Hash      string    `firestore:"hash"`
+	Created   time.Time `firestore:"created"`
+	LastUsed  time.Time `firestore:"last_used"`
+	RevokedAt time.Time `firestore:"revoked_at"`
+}
+
+// Revoked reports whether the key has been revoked.
+func (k *APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// APIKeyStore persists API keys.
+type APIKeyStore interface {
+	Add(context.Context, *APIKey) error
+	Get(context.Context, string) (*APIKey, error)
+	List(context.Context) ([]*APIKey, error)
+	Revoke(_ context.Context, id string, at time.Time) error
+	Touch(_ context.Context, id string, at time.Time) error
+}
+
+var _ APIKeyStore = (*APIKeys)(nil)
+
+// APIKeys stores API keys in Firestore, keyed by their ID.
+type APIKeys struct {
+	Client *firestore.Client
+}
+
+func (a *APIKeys) Add(ctx context.Context, key *APIKey) error {
+	if _, err := a.Client.Collection(apiKeyCollection).Doc(key.ID).Create(ctx, key); err != nil {
+		return fmt.Errorf("could not add API key: %v", err)
+	}
+	return nil
+}
+
+// Get returns the key with the specified ID, or nil if there is none.
+func (a *APIKeys) Get(ctx context.Context, id string) (*APIKey, error) {
+	doc, err := a.Client.Collection(apiKeyCollection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get API key: %v", err)
+	}
+	var key APIKey
+	if err := doc.DataTo(&key); err != nil {
+		return nil, fmt.Errorf("could not convert doc to API key: %v", err)
+	}
+	return &key, nil
+}
+
+// List returns every key, including revoked ones, oldest first.
+func (a *APIKeys) List(ctx context.Context) ([]*APIKey, error) {
+	var keys []*APIKey
+	iter := a.Client.Collection(apiKeyCollection).OrderBy("created", firestore.Asc).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not list API keys: %v", err)
+		}
+		var key APIKey
+		if err := doc.DataTo(&key); err != nil {
+			return nil, fmt.Errorf("could not convert doc to API key: %v", err)
+		}
+		keys = append(keys, &key)
+	}
+	return keys, nil
+}
+
+// Revoke marks the key with the specified ID as revoked.
+func (a *APIKeys) Revoke(ctx context.Context, id string, at time.Time) error {
+	return a.update(ctx, id, "revoked_at", at)
+}
+
+// Touch records that the key with the specified ID was used.
+func (a *APIKeys) Touch(ctx context.Context, id string, at time.Time) error {
+	return a.update(ctx, id, "last_used", at)
+}
+
+func (a *APIKeys) update(ctx context.Context, id, field string, at time.Time) error {
+	_, err := a.Client.Collection(apiKeyCollection).Doc(id).Update(ctx, []firestore.Update{{Path: field, Value: at}})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("could not update API key %q: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("could not update API key %q: %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testClient := newFirestoreTestClient(context.Background())
+	defer cleanUpCollection(t, ctx, testClient, apiKeyCollection)
+
+	keys := &APIKeys{Client: testClient}
+
+	created := time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC)
+	first := &APIKey{ID: "first", Name: "cron", Role: "scheduler", Hash: "abcd", Created: created}
+	second := &APIKey{ID: "second", Name: "backfill", Role: "admin", Hash: "ef01", Created: created.Add(time.Hour)}
+	for _, k := range []*APIKey{second, first} {
+		if err := keys.Add(ctx, k); err != nil {
+			t.Fatalf("could not add API key: %v", err)
+		}
+	}
+
+	used := created.Add(2 * time.Hour)
+	if err := keys.Touch(ctx, "first", used); err != nil {
+		t.Fatalf("Touch() = %v want <nil>", err)
+	}
+	revoked := created.Add(3 * time.Hour)
+	if err := keys.Revoke(ctx, "second", revoked); err != nil {
+		t.Fatalf("Revoke() = %v want <nil>", err)
+	}
+	if err := keys.Revoke(ctx, "missing", revoked); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke() of missing key = %v want %v", err, ErrNotFound)
+	}
+
+	got, err := keys.List(ctx)
+	if err != nil {
+		t.Fatalf("List() = _, %v want _, <nil>", err)
+	}
+	want := []*APIKey{
+		{ID: "first", Name: "cron", Role: "scheduler", Hash: "abcd", Created: created, LastUsed: used},
+		{ID: "second", Name: "backfill", Role: "admin", Hash: "ef01", Created: created.Add(time.Hour), RevokedAt: revoked},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("List() diff (-want +got): %s", diff)
+	}
+
+	if key, err := keys.Get(ctx, "missing"); key != nil || err != nil {
+		t.Errorf("Get() of missing key = %v, %v want <nil>, <nil>", key, err)
+	}
+}
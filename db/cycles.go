@@ -2,12 +2,19 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"net/url"
 	"time"
 
 	"cloud.google.com/go/firestore"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/metrics"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+	"go.opentelemetry.io/otel/semconv"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const cycleCollection = "cycles"
@@ -17,61 +24,203 @@ type Cycle struct {
 	Original  string    `firestore:"original"`
 	Processed string    `firestore:"processed"`
 	Date      time.Time `firestore:"date"`
+	// SchemaVersion is the version of the schema the cycle was written with.
+	// Stores set it to the current SchemaVersion when adding a cycle.
+	SchemaVersion int `firestore:"schema_version"`
+	// Hidden cycles are kept but not offered for download. Cycles written
+	// before it was added are not hidden.
+	Hidden bool `firestore:"hidden"`
+	// Checksum is the hex encoded SHA-256 hash of the processed data. It is
+	// empty for cycles processed before checksums were recorded.
+	Checksum string `firestore:"checksum"`
+	// Updated is when the processed data was last written. Cycles processed
+	// before it was recorded are upgraded to their Date.
+	Updated time.Time `firestore:"updated"`
+}
+
+// ErrCycleExists is returned when adding a cycle whose name is already stored.
+var ErrCycleExists = errors.New("cycle already exists")
+
+// ErrNotFound is returned when updating something that is not stored.
+var ErrNotFound = errors.New("not found")
+
+// DocID returns the Firestore document ID for the cycle with the specified
+// name. Cycle names are dates such as "06/18/2020", and document IDs may not
+// contain slashes, so the name is query escaped.
+func DocID(name string) string {
+	return url.QueryEscape(name)
 }
 
 type Cycles struct {
 	Client *firestore.Client
 }
 
-func (c *Cycles) Add(ctx context.Context, cycle *Cycle) error {
-	if _, _, err := c.Client.Collection(cycleCollection).Add(ctx, cycle); err != nil {
+// Add stores a new cycle under its deterministic document ID. If a cycle with
+// the same name already exists it is left untouched and ErrCycleExists is
+// returned.
+func (c *Cycles) Add(ctx context.Context, cycle *Cycle) (err error) {
+	defer metrics.ObserveDBCall("firestore", "add")()
+	ctx, span := tracing.Start(ctx, "db.Cycles.Add", semconv.DBSystemKey.String("firestore"))
+	defer func() { tracing.End(span, err) }()
+	stored := *cycle
+	stored.SchemaVersion = SchemaVersion
+	_, err = c.Client.Collection(cycleCollection).Doc(DocID(cycle.Name)).Create(ctx, &stored)
+	if status.Code(err) == codes.AlreadyExists {
+		return fmt.Errorf("could not add cycle %q: %w", cycle.Name, ErrCycleExists)
+	}
+	if err != nil {
 		return fmt.Errorf("could not add cycle: %v", err)
 	}
 	return nil
 }
 
-func (c *Cycles) Get(ctx context.Context, name string) (*Cycle, error) {
-	iter := c.Client.Collection(cycleCollection).Where("name", "==", name).Documents(ctx)
-	var cycle Cycle
-	var i int
-	for i = 0; ; i++ {
+// Get returns the cycle with the specified name, or nil if there is none.
+func (c *Cycles) Get(ctx context.Context, name string) (_ *Cycle, err error) {
+	defer metrics.ObserveDBCall("firestore", "get")()
+	ctx, span := tracing.Start(ctx, "db.Cycles.Get", semconv.DBSystemKey.String("firestore"))
+	defer func() { tracing.End(span, err) }()
+	doc, err := c.Client.Collection(cycleCollection).Doc(DocID(name)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get cycle: %v", err)
+	}
+	return c.read(ctx, doc)
+}
+
+func (c *Cycles) List(ctx context.Context) (_ []*Cycle, err error) {
+	defer metrics.ObserveDBCall("firestore", "list")()
+	ctx, span := tracing.Start(ctx, "db.Cycles.List", semconv.DBSystemKey.String("firestore"))
+	defer func() { tracing.End(span, err) }()
+	var cycles []*Cycle
+	iter := c.Client.Collection(cycleCollection).OrderBy("date", firestore.Desc).Limit(10).Documents(ctx)
+	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
-		if i > 0 {
-			log.Printf("More than one item in database with name %q.", name)
-			break
-		}
 		if err != nil {
 			return nil, fmt.Errorf("could not list cycles: %v", err)
 		}
-		if err := doc.DataTo(&cycle); err != nil {
-			return nil, fmt.Errorf("could not convert doc to cycle: %v", err)
+		cycle, err := c.read(ctx, doc)
+		if err != nil {
+			return nil, err
 		}
+		cycles = append(cycles, cycle)
 	}
-	if i == 0 {
-		return nil, nil
+	return cycles, nil
+}
+
+// SetHidden hides or shows the cycle with the specified name, returning
+// ErrNotFound if there is none.
+func (c *Cycles) SetHidden(ctx context.Context, name string, hidden bool) (err error) {
+	defer metrics.ObserveDBCall("firestore", "setHidden")()
+	ctx, span := tracing.Start(ctx, "db.Cycles.SetHidden", semconv.DBSystemKey.String("firestore"))
+	defer func() { tracing.End(span, err) }()
+	_, err = c.Client.Collection(cycleCollection).Doc(DocID(name)).Update(ctx, []firestore.Update{
+		{Path: "hidden", Value: hidden},
+	})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("could not update cycle %q: %w", name, ErrNotFound)
 	}
-	return &cycle, nil
+	if err != nil {
+		return fmt.Errorf("could not update cycle %q: %v", name, err)
+	}
+	return nil
 }
 
-func (c *Cycles) List(ctx context.Context) ([]*Cycle, error) {
-	var cycles []*Cycle
-	iter := c.Client.Collection(cycleCollection).OrderBy("date", firestore.Desc).Limit(10).Documents(ctx)
+// SetChecksum records the checksum of new processed data for the cycle with
+// the specified name and when it was written, returning ErrNotFound if there
+// is no such cycle.
+func (c *Cycles) SetChecksum(ctx context.Context, name, checksum string, updated time.Time) (err error) {
+	defer metrics.ObserveDBCall("firestore", "setChecksum")()
+	ctx, span := tracing.Start(ctx, "db.Cycles.SetChecksum", semconv.DBSystemKey.String("firestore"))
+	defer func() { tracing.End(span, err) }()
+	_, err = c.Client.Collection(cycleCollection).Doc(DocID(name)).Update(ctx, []firestore.Update{
+		{Path: "checksum", Value: checksum},
+		{Path: "updated", Value: updated},
+	})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("could not update cycle %q: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("could not update cycle %q: %v", name, err)
+	}
+	return nil
+}
+
+// UpgradeAll upgrades every stored cycle to the current SchemaVersion and
+// returns the number of cycles that were rewritten. It walks every document,
+// including those without a date that List never reads. Cycles that cannot
+// be fully upgraded keep the version they reached, and are reported in an
+// error wrapping ErrMigrationFailed once the others are upgraded.
+func (c *Cycles) UpgradeAll(ctx context.Context) (int, error) {
+	var n, failed int
+	iter := c.Client.Collection(cycleCollection).Documents(ctx)
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("could not list cycles: %v", err)
+			return n, fmt.Errorf("could not list cycles: %v", err)
 		}
-		var c Cycle
-		if err := doc.DataTo(&c); err != nil {
-			return nil, fmt.Errorf("could not convert doc to cycle: %v", err)
+		var cycle Cycle
+		if err := doc.DataTo(&cycle); err != nil {
+			return n, fmt.Errorf("could not convert doc %q to cycle: %v", doc.Ref.ID, err)
+		}
+		upgraded, err := Upgrade(ctx, &cycle)
+		if err != nil {
+			logging.Errorf(ctx, "Could not upgrade cycle %q: %v", cycle.Name, err)
+			failed++
 		}
-		cycles = append(cycles, &c)
+		if !upgraded {
+			continue
+		}
+		if err := writeUpgrade(ctx, doc, &cycle); err != nil {
+			return n, err
+		}
+		n++
 	}
-	return cycles, nil
+	if failed > 0 {
+		return n, fmt.Errorf("could not upgrade %d cycles: %w", failed, ErrMigrationFailed)
+	}
+	return n, nil
+}
+
+// read converts doc to a cycle, upgrading it to the current SchemaVersion. The
+// upgraded cycle is written back on a best-effort basis so that later reads
+// are cheaper; the caller gets the upgraded cycle even if that write fails.
+func (c *Cycles) read(ctx context.Context, doc *firestore.DocumentSnapshot) (*Cycle, error) {
+	var cycle Cycle
+	if err := doc.DataTo(&cycle); err != nil {
+		return nil, fmt.Errorf("could not convert doc to cycle: %v", err)
+	}
+	upgraded, err := Upgrade(ctx, &cycle)
+	if err != nil {
+		logging.Errorf(ctx, "Could not upgrade cycle %q: %v", cycle.Name, err)
+	}
+	if upgraded {
+		if err := writeUpgrade(ctx, doc, &cycle); err != nil {
+			logging.Errorf(ctx, "Could not save upgraded cycle %q: %v", cycle.Name, err)
+		}
+	}
+	return &cycle, nil
+}
+
+// writeUpgrade replaces doc with the upgraded cycle, failing if the document
+// was modified since it was read.
+func writeUpgrade(ctx context.Context, doc *firestore.DocumentSnapshot, cycle *Cycle) error {
+	if _, err := doc.Ref.Update(ctx, []firestore.Update{
+		{Path: "name", Value: cycle.Name},
+		{Path: "original", Value: cycle.Original},
+		{Path: "processed", Value: cycle.Processed},
+		{Path: "date", Value: cycle.Date},
+		{Path: "schema_version", Value: cycle.SchemaVersion},
+		{Path: "updated", Value: cycle.Updated},
+	}, firestore.LastUpdateTime(doc.UpdateTime)); err != nil {
+		return fmt.Errorf("could not upgrade cycle %q: %v", cycle.Name, err)
+	}
+	return nil
 }
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -20,7 +21,12 @@ import (
 
 func cleanUp(t *testing.T, ctx context.Context, client *firestore.Client) {
 	t.Helper()
-	iter := client.Collection(cycleCollection).Documents(ctx)
+	cleanUpCollection(t, ctx, client, cycleCollection)
+}
+
+func cleanUpCollection(t *testing.T, ctx context.Context, client *firestore.Client, collection string) {
+	t.Helper()
+	iter := client.Collection(collection).Documents(ctx)
 	batch := client.Batch()
 	for {
 		doc, err := iter.Next()
@@ -54,10 +60,11 @@ func TestAddListCycle(t *testing.T) {
 				Date:      time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
 			},
 			want: []*Cycle{{
-				Name:      "200326",
-				Original:  "original",
-				Processed: "processed",
-				Date:      time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+				Name:          "200326",
+				Original:      "original",
+				Processed:     "processed",
+				Date:          time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+				SchemaVersion: SchemaVersion,
 			}},
 		},
 	} {
@@ -96,16 +103,18 @@ func TestListCycleDesc(t *testing.T) {
 	}
 
 	oldCycle := &Cycle{
-		Name:      "06/18/2020",
-		Original:  "original",
-		Processed: "processed",
-		Date:      time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+		Name:          "06/18/2020",
+		Original:      "original",
+		Processed:     "processed",
+		Date:          time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+		SchemaVersion: SchemaVersion,
 	}
 	newCycle := &Cycle{
-		Name:      "07/16/2020",
-		Original:  "original",
-		Processed: "processed",
-		Date:      time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+		Name:          "07/16/2020",
+		Original:      "original",
+		Processed:     "processed",
+		Date:          time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+		SchemaVersion: SchemaVersion,
 	}
 
 	cycles := []*Cycle{
@@ -139,37 +148,208 @@ func TestListCycleDescMaxEntries(t *testing.T) {
 		Client: testClient,
 	}
 
-	oldCycle := &Cycle{
-		Name:      "06/18/2020",
-		Original:  "original",
-		Processed: "processed",
-		Date:      time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+	var all []*Cycle
+	for i := 0; i < 15; i++ {
+		all = append(all, &Cycle{
+			Name:          fmt.Sprintf("cycle-%02d", i),
+			Original:      "original",
+			Processed:     "processed",
+			Date:          time.Date(2020, 1, 1+28*i, 0, 0, 0, 0, time.UTC),
+			SchemaVersion: SchemaVersion,
+		})
 	}
-	newCycle := &Cycle{
+	for _, c := range all {
+		if err := cyclesDb.Add(ctx, c); err != nil {
+			t.Errorf("could not add entity: %v", err)
+		}
+	}
+
+	got, err := cyclesDb.List(ctx)
+	if err != nil {
+		t.Errorf("could not list cycles: %v", err)
+	}
+	var want []*Cycle
+	for i := len(all) - 1; i >= len(all)-10; i-- {
+		want = append(want, all[i])
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("cycles diff (-got +want): %s", diff)
+	}
+}
+
+func TestAddDuplicate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testClient := newFirestoreTestClient(context.Background())
+	defer cleanUp(t, ctx, testClient)
+
+	cyclesDb := &Cycles{
+		Client: testClient,
+	}
+
+	first := &Cycle{
+		Name:          "07/16/2020",
+		Processed:     "first",
+		Date:          time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+		SchemaVersion: SchemaVersion,
+	}
+	if err := cyclesDb.Add(ctx, first); err != nil {
+		t.Fatalf("could not add entity: %v", err)
+	}
+	second := &Cycle{
 		Name:      "07/16/2020",
-		Original:  "original",
-		Processed: "processed",
+		Processed: "second",
 		Date:      time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
 	}
+	if err := cyclesDb.Add(ctx, second); !errors.Is(err, ErrCycleExists) {
+		t.Errorf("Add() = %v for duplicate cycle want %v", err, ErrCycleExists)
+	}
 
-	for i := 0; i < 15; i++ {
-		if err := cyclesDb.Add(ctx, oldCycle); err != nil {
-			t.Errorf("could not add entity: %v", err)
+	got, err := cyclesDb.Get(ctx, "07/16/2020")
+	if err != nil {
+		t.Fatalf("could not get cycle: %v", err)
+	}
+	if diff := cmp.Diff(first, got); diff != "" {
+		t.Errorf("cycle was overwritten (-want +got): %s", diff)
+	}
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testClient := newFirestoreTestClient(context.Background())
+	defer cleanUp(t, ctx, testClient)
+
+	cyclesDb := &Cycles{
+		Client: testClient,
+	}
+
+	// Documents written before IDs were deterministic.
+	legacy := []*Cycle{
+		{Name: "06/18/2020", Original: "original-1", Date: time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC)},
+		{
+			Name:          "06/18/2020",
+			Original:      "original-2",
+			Processed:     "processed-2",
+			SchemaVersion: SchemaVersion,
+			Hidden:        true,
+			Checksum:      "abcd",
+			Updated:       time.Date(2020, 6, 20, 12, 0, 0, 0, time.UTC),
+		},
+		{Name: "07/16/2020", Original: "original", Processed: "processed", Date: time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC)},
+	}
+	var legacyIDs []string
+	for _, c := range legacy {
+		ref, _, err := testClient.Collection(cycleCollection).Add(ctx, c)
+		if err != nil {
+			t.Fatalf("could not add legacy entity: %v", err)
 		}
+		legacyIDs = append(legacyIDs, ref.ID)
 	}
-	if err := cyclesDb.Add(ctx, newCycle); err != nil {
-		t.Errorf("could not add entity: %v", err)
+	if err := cyclesDb.Add(ctx, &Cycle{Name: "08/13/2020", Date: time.Date(2020, 8, 13, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatalf("could not add entity: %v", err)
 	}
 
-	got, err := cyclesDb.List(ctx)
+	dryRun, err := cyclesDb.MergeDuplicates(ctx, true)
 	if err != nil {
-		t.Errorf("could not list cycles: %v", err)
+		t.Fatalf("MergeDuplicates(_, true) = _, %v want _, <nil>", err)
+	}
+	got, err := cyclesDb.MergeDuplicates(ctx, false)
+	if err != nil {
+		t.Fatalf("MergeDuplicates(_, false) = _, %v want _, <nil>", err)
+	}
+	want := []*MergedCycle{
+		{Name: "06/18/2020", ID: DocID("06/18/2020"), Removed: legacyIDs[:2]},
+		{Name: "07/16/2020", ID: DocID("07/16/2020"), Removed: legacyIDs[2:]},
 	}
-	want := []*Cycle{
-		newCycle, oldCycle, oldCycle, oldCycle, oldCycle, oldCycle, oldCycle, oldCycle, oldCycle, oldCycle,
+	if diff := cmp.Diff(want, dryRun); diff != "" {
+		t.Errorf("dry run report diff (-want +got): %s", diff)
 	}
 	if diff := cmp.Diff(want, got); diff != "" {
-		t.Errorf("cycles diff (-got +want): %s", diff)
+		t.Errorf("report diff (-want +got): %s", diff)
+	}
+
+	gotCycle, err := cyclesDb.Get(ctx, "06/18/2020")
+	if err != nil {
+		t.Fatalf("could not get cycle: %v", err)
+	}
+	wantCycle := &Cycle{
+		Name:          "06/18/2020",
+		Original:      "original-1",
+		Processed:     "processed-2",
+		Date:          time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+		SchemaVersion: SchemaVersion,
+		Hidden:        true,
+		Checksum:      "abcd",
+		Updated:       time.Date(2020, 6, 20, 12, 0, 0, 0, time.UTC),
+	}
+	if diff := cmp.Diff(wantCycle, gotCycle); diff != "" {
+		t.Errorf("merged cycle diff (-want +got): %s", diff)
+	}
+
+	again, err := cyclesDb.MergeDuplicates(ctx, false)
+	if err != nil {
+		t.Fatalf("MergeDuplicates(_, false) = _, %v want _, <nil>", err)
+	}
+	if len(again) != 0 {
+		t.Errorf("second MergeDuplicates() = %+v, _ want no merges", again)
+	}
+}
+
+func TestUpgradeOnRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testClient := newFirestoreTestClient(context.Background())
+	defer cleanUp(t, ctx, testClient)
+
+	cyclesDb := &Cycles{
+		Client: testClient,
+	}
+
+	for _, name := range []string{"06/18/2020", "07/16/2020"} {
+		if _, err := testClient.Collection(cycleCollection).Doc(DocID(name)).Set(ctx, map[string]interface{}{
+			"name":      name,
+			"original":  "original",
+			"processed": "processed",
+		}); err != nil {
+			t.Fatalf("could not add unversioned entity: %v", err)
+		}
+	}
+
+	got, err := cyclesDb.Get(ctx, "06/18/2020")
+	if err != nil {
+		t.Fatalf("could not get cycle: %v", err)
+	}
+	want := &Cycle{
+		Name:          "06/18/2020",
+		Original:      "original",
+		Processed:     "processed",
+		Date:          time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+		Updated:       time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+		SchemaVersion: SchemaVersion,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Get() diff (-want +got): %s", diff)
+	}
+
+	// Get wrote the upgrade back, so only the other cycle is left to upgrade.
+	n, err := cyclesDb.UpgradeAll(ctx)
+	if err != nil {
+		t.Fatalf("UpgradeAll() = _, %v want _, <nil>", err)
+	}
+	if n != 1 {
+		t.Errorf("UpgradeAll() = %d, _ want 1, _", n)
+	}
+	doc, err := testClient.Collection(cycleCollection).Doc(DocID("07/16/2020")).Get(ctx)
+	if err != nil {
+		t.Fatalf("could not get document: %v", err)
+	}
+	var stored Cycle
+	if err := doc.DataTo(&stored); err != nil {
+		t.Fatalf("could not convert doc to cycle: %v", err)
+	}
+	if stored.SchemaVersion != SchemaVersion {
+		t.Errorf("stored schema version = %d want %d", stored.SchemaVersion, SchemaVersion)
 	}
 }
 
@@ -208,10 +388,11 @@ func TestAddGet(t *testing.T) {
 		t.Errorf("could not list cycles: %v", err)
 	}
 	want := &Cycle{
-		Name:      "200425",
-		Original:  "original",
-		Processed: "processed",
-		Date:      time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+		Name:          "200425",
+		Original:      "original",
+		Processed:     "processed",
+		Date:          time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+		SchemaVersion: SchemaVersion,
 	}
 	if diff := cmp.Diff(want, got); diff != "" {
 		t.Errorf("Get() = %+v, _ want %+v, _", got, want)
@@ -298,3 +479,60 @@ func TestMain(m *testing.M) {
 
 	os.Exit(result)
 }
+
+func TestSetHidden(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testClient := newFirestoreTestClient(context.Background())
+	defer cleanUp(t, ctx, testClient)
+
+	cyclesDb := &Cycles{
+		Client: testClient,
+	}
+	if err := cyclesDb.Add(ctx, &Cycle{Name: "07/16/2020"}); err != nil {
+		t.Fatalf("could not add entity: %v", err)
+	}
+	for _, hidden := range []bool{true, false} {
+		if err := cyclesDb.SetHidden(ctx, "07/16/2020", hidden); err != nil {
+			t.Fatalf("SetHidden(_, _, %t) = %v want <nil>", hidden, err)
+		}
+		got, err := cyclesDb.Get(ctx, "07/16/2020")
+		if err != nil {
+			t.Fatalf("could not get cycle: %v", err)
+		}
+		if got.Hidden != hidden {
+			t.Errorf("Get() hidden = %t want %t", got.Hidden, hidden)
+		}
+	}
+	if err := cyclesDb.SetHidden(ctx, "doesnotexist", true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetHidden() of missing cycle = %v want %v", err, ErrNotFound)
+	}
+}
+
+func TestSetChecksum(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testClient := newFirestoreTestClient(context.Background())
+	defer cleanUp(t, ctx, testClient)
+
+	cyclesDb := &Cycles{
+		Client: testClient,
+	}
+	if err := cyclesDb.Add(ctx, &Cycle{Name: "07/16/2020", Checksum: "old"}); err != nil {
+		t.Fatalf("could not add entity: %v", err)
+	}
+	updated := time.Date(2020, 7, 20, 12, 0, 0, 0, time.UTC)
+	if err := cyclesDb.SetChecksum(ctx, "07/16/2020", "new", updated); err != nil {
+		t.Fatalf("SetChecksum() = %v want <nil>", err)
+	}
+	got, err := cyclesDb.Get(ctx, "07/16/2020")
+	if err != nil {
+		t.Fatalf("could not get cycle: %v", err)
+	}
+	if got.Checksum != "new" || !got.Updated.Equal(updated) {
+		t.Errorf("Get() checksum, updated = %q, %v want %q, %v", got.Checksum, got.Updated, "new", updated)
+	}
+	if err := cyclesDb.SetChecksum(ctx, "doesnotexist", "new", updated); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetChecksum() of missing cycle = %v want %v", err, ErrNotFound)
+	}
+}
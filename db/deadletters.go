@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const deadLetterCollection = "dead_letters"
+
+// maxDeadLetters is the most dead letters List returns.
+const maxDeadLetters = 100
+
+// DeadLetter is a webhook event that could not be delivered to a subscriber,
+// kept so that it can be replayed.
+type DeadLetter struct {
+	ID        string `firestore:"id"`
+	EventID   string `firestore:"event_id"`
+	EventType string `firestore:"event_type"`
+	// URL is the subscription the event was meant for.
+	URL string `firestore:"url"`
+	// Payload is the JSON encoded event.
+	Payload   string    `firestore:"payload"`
+	Attempts  int       `firestore:"attempts"`
+	LastError string    `firestore:"last_error"`
+	Created   time.Time `firestore:"created"`
+	// ReplayedAt is when the event was successfully replayed, if it was.
+	ReplayedAt time.Time `firestore:"replayed_at"`
+}
+
+// DeadLetterStore persists webhook events that could not be delivered.
+type DeadLetterStore interface {
+	Add(context.Context, *DeadLetter) error
+	Get(context.Context, string) (*DeadLetter, error)
+	List(context.Context) ([]*DeadLetter, error)
+	MarkReplayed(_ context.Context, id string, at time.Time) error
+}
+
+var _ DeadLetterStore = (*DeadLetters)(nil)
+
+// DeadLetters stores dead letters in Firestore, keyed by their ID.
+type DeadLetters struct {
+	Client *firestore.Client
+}
+
+func (d *DeadLetters) Add(ctx context.Context, dl *DeadLetter) error {
+	if _, err := d.Client.Collection(deadLetterCollection).Doc(dl.ID).Create(ctx, dl); err != nil {
+		return fmt.Errorf("could not add dead letter: %v", err)
+	}
+	return nil
+}
+
+// Get returns the dead letter with the specified ID, or nil if there is none.
+func (d *DeadLetters) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	doc, err := d.Client.Collection(deadLetterCollection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get dead letter: %v", err)
+	}
+	var dl DeadLetter
+	if err := doc.DataTo(&dl); err != nil {
+		return nil, fmt.Errorf("could not convert doc to dead letter: %v", err)
+	}
+	return &dl, nil
+}
+
+// List returns the 100 most recent dead letters, newest first, including
+// replayed ones.
+func (d *DeadLetters) List(ctx context.Context) ([]*DeadLetter, error) {
+	var dls []*DeadLetter
+	iter := d.Client.Collection(deadLetterCollection).OrderBy("created", firestore.Desc).Limit(maxDeadLetters).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not list dead letters: %v", err)
+		}
+		var dl DeadLetter
+		if err := doc.DataTo(&dl); err != nil {
+			return nil, fmt.Errorf("could not convert doc to dead letter: %v", err)
+		}
+		dls = append(dls, &dl)
+	}
+	return dls, nil
+}
+
+// MarkReplayed records that the dead letter with the specified ID was
+// delivered by a replay.
+func (d *DeadLetters) MarkReplayed(ctx context.Context, id string, at time.Time) error {
+	_, err := d.Client.Collection(deadLetterCollection).Doc(id).Update(ctx, []firestore.Update{{Path: "replayed_at", Value: at}})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("could not update dead letter %q: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("could not update dead letter %q: %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// MergedCycle describes the documents that were merged into the deterministic
+// document for a single cycle.
+type MergedCycle struct {
+	Name string
+	// ID is the deterministic document ID the cycle is now stored under.
+	ID string
+	// Removed are the IDs of the documents that were deleted after their
+	// fields were merged into ID.
+	Removed []string
+}
+
+// MergeDuplicates moves every cycle stored under an auto-generated document ID
+// to its deterministic ID, merging documents that share a name. Fields already
+// set on the document at the deterministic ID, or else on the oldest document,
+// take precedence; empty fields are filled in from the other duplicates. The
+// merged cycle is hidden if any duplicate was, and has the highest schema
+// version and the latest update of the duplicates. If
+// dryRun is true nothing is written, but the returned report still lists the
+// documents that would be removed.
+func (c *Cycles) MergeDuplicates(ctx context.Context, dryRun bool) ([]*MergedCycle, error) {
+	byName := make(map[string][]*firestore.DocumentSnapshot)
+	var names []string
+	iter := c.Client.Collection(cycleCollection).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not list cycles: %v", err)
+		}
+		var cycle Cycle
+		if err := doc.DataTo(&cycle); err != nil {
+			return nil, fmt.Errorf("could not convert doc %q to cycle: %v", doc.Ref.ID, err)
+		}
+		if _, ok := byName[cycle.Name]; !ok {
+			names = append(names, cycle.Name)
+		}
+		byName[cycle.Name] = append(byName[cycle.Name], doc)
+	}
+	sort.Strings(names)
+
+	var merged []*MergedCycle
+	for _, name := range names {
+		docs := byName[name]
+		id := DocID(name)
+		if len(docs) == 1 && docs[0].Ref.ID == id {
+			continue
+		}
+		m, err := c.mergeDocs(ctx, name, docs, dryRun)
+		if err != nil {
+			return merged, err
+		}
+		merged = append(merged, m)
+	}
+	return merged, nil
+}
+
+func (c *Cycles) mergeDocs(ctx context.Context, name string, docs []*firestore.DocumentSnapshot, dryRun bool) (*MergedCycle, error) {
+	id := DocID(name)
+	sort.SliceStable(docs, func(i, j int) bool {
+		if (docs[i].Ref.ID == id) != (docs[j].Ref.ID == id) {
+			return docs[i].Ref.ID == id
+		}
+		return docs[i].CreateTime.Before(docs[j].CreateTime)
+	})
+
+	var cycle Cycle
+	m := &MergedCycle{Name: name, ID: id}
+	for _, doc := range docs {
+		var dup Cycle
+		if err := doc.DataTo(&dup); err != nil {
+			return nil, fmt.Errorf("could not convert doc %q to cycle: %v", doc.Ref.ID, err)
+		}
+		merge(&cycle, &dup)
+		if doc.Ref.ID != id {
+			m.Removed = append(m.Removed, doc.Ref.ID)
+		}
+	}
+	if dryRun {
+		return m, nil
+	}
+
+	// The batch is atomic, so a failure part way through never leaves a cycle
+	// deleted without its merged replacement.
+	batch := c.Client.Batch()
+	batch.Set(c.Client.Collection(cycleCollection).Doc(id), &cycle)
+	for _, removed := range m.Removed {
+		batch.Delete(c.Client.Collection(cycleCollection).Doc(removed))
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("could not merge duplicates of %q: %v", name, err)
+	}
+	return m, nil
+}
+
+// merge sets each empty field of dst to the corresponding field of src, hides
+// dst if src is hidden, and takes the schema version of src if it is higher
+// and its checksum and update time if it was updated later.
+func merge(dst, src *Cycle) {
+	if dst.Name == "" {
+		dst.Name = src.Name
+	}
+	if dst.Original == "" {
+		dst.Original = src.Original
+	}
+	if dst.Processed == "" {
+		dst.Processed = src.Processed
+	}
+	if dst.Date.IsZero() {
+		dst.Date = src.Date
+	}
+	dst.Hidden = dst.Hidden || src.Hidden
+	if src.SchemaVersion > dst.SchemaVersion {
+		dst.SchemaVersion = src.SchemaVersion
+	}
+	if src.Updated.After(dst.Updated) {
+		dst.Updated = src.Updated
+		if src.Checksum != "" {
+			dst.Checksum = src.Checksum
+		}
+	}
+	if dst.Checksum == "" {
+		dst.Checksum = src.Checksum
+	}
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const leaseCollection = "leases"
+
+// ErrLeaseHeld is returned when a lease is held by another holder.
+var ErrLeaseHeld = errors.New("lease held by another holder")
+
+// Lease gives one holder, such as a replica of the app, the exclusive right
+// to do some work until it expires.
+type Lease struct {
+	Name string `firestore:"name"`
+	// Holder is empty if the lease was released.
+	Holder  string    `firestore:"holder"`
+	Expires time.Time `firestore:"expires"`
+	// LastRun is when the work was last done, by any holder.
+	LastRun time.Time `firestore:"last_run"`
+}
+
+// LeaseStore persists leases.
+type LeaseStore interface {
+	// Acquire takes or renews the named lease for holder until ttl after
+	// now, unless another holder has it and it has not expired, in which
+	// case it returns ErrLeaseHeld.
+	Acquire(_ context.Context, name, holder string, now time.Time, ttl time.Duration) (*Lease, error)
+	// SetLastRun records when the work was last done, returning
+	// ErrLeaseHeld if holder no longer holds the lease.
+	SetLastRun(_ context.Context, name, holder string, at time.Time) error
+	// Release gives up the lease so another holder can take it without
+	// waiting for it to expire. It does nothing if holder does not hold it.
+	Release(_ context.Context, name, holder string) error
+}
+
+var _ LeaseStore = (*Leases)(nil)
+
+// Leases stores leases in Firestore, keyed by their name.
+type Leases struct {
+	Client *firestore.Client
+}
+
+func (l *Leases) Acquire(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (*Lease, error) {
+	ref := l.Client.Collection(leaseCollection).Doc(name)
+	var lease *Lease
+	err := l.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var err error
+		if lease, err = getLease(tx, ref); err != nil {
+			return err
+		}
+		if lease == nil {
+			lease = &Lease{Name: name}
+		}
+		if lease.Holder != "" && lease.Holder != holder && now.Before(lease.Expires) {
+			return ErrLeaseHeld
+		}
+		lease.Holder = holder
+		lease.Expires = now.Add(ttl)
+		return tx.Set(ref, lease)
+	})
+	if errors.Is(err, ErrLeaseHeld) {
+		return nil, fmt.Errorf("could not acquire lease %q: %w", name, ErrLeaseHeld)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire lease %q: %v", name, err)
+	}
+	return lease, nil
+}
+
+func (l *Leases) SetLastRun(ctx context.Context, name, holder string, at time.Time) error {
+	ref := l.Client.Collection(leaseCollection).Doc(name)
+	err := l.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		lease, err := getLease(tx, ref)
+		if err != nil {
+			return err
+		}
+		if lease == nil || lease.Holder != holder {
+			return ErrLeaseHeld
+		}
+		return tx.Update(ref, []firestore.Update{{Path: "last_run", Value: at}})
+	})
+	if errors.Is(err, ErrLeaseHeld) {
+		return fmt.Errorf("could not update lease %q: %w", name, ErrLeaseHeld)
+	}
+	if err != nil {
+		return fmt.Errorf("could not update lease %q: %v", name, err)
+	}
+	return nil
+}
+
+func (l *Leases) Release(ctx context.Context, name, holder string) error {
+	ref := l.Client.Collection(leaseCollection).Doc(name)
+	err := l.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		lease, err := getLease(tx, ref)
+		if err != nil {
+			return err
+		}
+		if lease == nil || lease.Holder != holder {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{{Path: "holder", Value: ""}})
+	})
+	if err != nil {
+		return fmt.Errorf("could not release lease %q: %v", name, err)
+	}
+	return nil
+}
+
+// getLease reads the lease at ref in tx, returning nil if there is none.
+func getLease(tx *firestore.Transaction, ref *firestore.DocumentRef) (*Lease, error) {
+	doc, err := tx.Get(ref)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lease Lease
+	if err := doc.DataTo(&lease); err != nil {
+		return nil, fmt.Errorf("could not convert doc to lease: %v", err)
+	}
+	return &lease, nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const nonceCollection = "nonces"
+
+// ErrNonceUsed is returned when a nonce has already been used.
+var ErrNonceUsed = errors.New("nonce already used")
+
+// Nonce is a value, such as the signature of a request, that may only be
+// used once until it expires.
+type Nonce struct {
+	Expires time.Time `firestore:"expires"`
+}
+
+// NonceStore records used nonces, so that every replica of the app rejects a
+// nonce used by any of them.
+type NonceStore interface {
+	// Use records nonce until expires, unless it was already used and has
+	// not expired as of now, in which case it returns ErrNonceUsed.
+	Use(_ context.Context, nonce string, now, expires time.Time) error
+}
+
+var _ NonceStore = (*Nonces)(nil)
+
+// Nonces stores nonces in Firestore, keyed by their value. Expired nonces
+// are overwritten when they are used again; a TTL policy on the expires
+// field of the collection deletes the rest.
+type Nonces struct {
+	Client *firestore.Client
+}
+
+func (n *Nonces) Use(ctx context.Context, nonce string, now, expires time.Time) error {
+	ref := n.Client.Collection(nonceCollection).Doc(nonce)
+	err := n.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var used Nonce
+			if err := doc.DataTo(&used); err != nil {
+				return fmt.Errorf("could not convert doc to nonce: %v", err)
+			}
+			if now.Before(used.Expires) {
+				return ErrNonceUsed
+			}
+		}
+		return tx.Set(ref, &Nonce{Expires: expires})
+	})
+	if errors.Is(err, ErrNonceUsed) {
+		return fmt.Errorf("could not use nonce: %w", ErrNonceUsed)
+	}
+	if err != nil {
+		return fmt.Errorf("could not use nonce: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+// SchemaVersion is the version of the Cycle schema written by this version of
+// the app. Documents with a lower version are upgraded by Upgrade.
+const SchemaVersion = 2
+
+// Migration upgrades a cycle from the previous schema version to Version.
+// Apply returns an error, leaving the cycle unchanged, if the cycle cannot
+// be upgraded.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(context.Context, *Cycle) error
+}
+
+// ErrMigrationFailed is wrapped by the errors of upgrades that stopped at a
+// migration that could not be applied.
+var ErrMigrationFailed = errors.New("migration failed")
+
+// Migrations are applied in order to bring a cycle up to SchemaVersion.
+// Documents written before the schema was versioned have version 0. When
+// adding a field to Cycle, bump SchemaVersion and append a migration that
+// populates the field for existing documents. Fields whose zero value is
+// correct for existing documents, such as Hidden, which is false for every
+// cycle written before cycles could be hidden, need no migration. Fields that
+// cannot be derived from the document, such as Checksum, keep their zero
+// value, which the field must document.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "derive missing date from name",
+		Apply: func(_ context.Context, c *Cycle) error {
+			if !c.Date.IsZero() {
+				return nil
+			}
+			d, err := time.Parse("01/02/2006", c.Name)
+			if err != nil {
+				return fmt.Errorf("could not derive date from name: %v", err)
+			}
+			c.Date = d
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		Description: "set missing updated time from date",
+		Apply: func(_ context.Context, c *Cycle) error {
+			if !c.Updated.IsZero() {
+				return nil
+			}
+			if c.Date.IsZero() {
+				return errors.New("cycle has no date to set updated time from")
+			}
+			// Cycles processed before updates were recorded were
+			// published no later than they became effective.
+			c.Updated = c.Date
+			return nil
+		},
+	},
+}
+
+// Upgrade applies each migration newer than the schema version of c, logging
+// every step, and reports whether c was changed. It stops at the first
+// migration that fails, leaving c at the version before it, and returns an
+// error wrapping ErrMigrationFailed. Cycles written by a newer version of the
+// app are left as they are.
+func Upgrade(ctx context.Context, c *Cycle) (bool, error) {
+	var upgraded bool
+	for _, m := range Migrations {
+		if c.SchemaVersion >= m.Version {
+			continue
+		}
+		if err := m.Apply(ctx, c); err != nil {
+			return upgraded, fmt.Errorf("%w: schema version %d: %v", ErrMigrationFailed, m.Version, err)
+		}
+		logging.Infof(ctx, "Upgraded cycle %q from schema version %d to %d: %s.", c.Name, c.SchemaVersion, m.Version, m.Description)
+		c.SchemaVersion = m.Version
+		upgraded = true
+	}
+	return upgraded, nil
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigrationsSequential(t *testing.T) {
+	for i, m := range Migrations {
+		if m.Version != i+1 {
+			t.Errorf("Migrations[%d].Version = %d want %d", i, m.Version, i+1)
+		}
+	}
+	if got := len(Migrations); got != SchemaVersion {
+		t.Errorf("len(Migrations) = %d want SchemaVersion %d", got, SchemaVersion)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		cycle        *Cycle
+		want         *Cycle
+		wantUpgraded bool
+		wantErr      bool
+	}{
+		{
+			name:  "Unversioned",
+			cycle: &Cycle{Name: "06/18/2020", Processed: "processed"},
+			want: &Cycle{
+				Name:          "06/18/2020",
+				Processed:     "processed",
+				Date:          time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				Updated:       time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				SchemaVersion: SchemaVersion,
+			},
+			wantUpgraded: true,
+		},
+		{
+			name:  "UnversionedWithDate",
+			cycle: &Cycle{Name: "06/18/2020", Date: time.Date(2020, 6, 17, 0, 0, 0, 0, time.UTC)},
+			want: &Cycle{
+				Name:          "06/18/2020",
+				Date:          time.Date(2020, 6, 17, 0, 0, 0, 0, time.UTC),
+				Updated:       time.Date(2020, 6, 17, 0, 0, 0, 0, time.UTC),
+				SchemaVersion: SchemaVersion,
+			},
+			wantUpgraded: true,
+		},
+		{
+			name: "WithoutUpdated",
+			cycle: &Cycle{
+				Name:          "06/18/2020",
+				Date:          time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				SchemaVersion: 1,
+			},
+			want: &Cycle{
+				Name:          "06/18/2020",
+				Date:          time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				Updated:       time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				SchemaVersion: SchemaVersion,
+			},
+			wantUpgraded: true,
+		},
+		{
+			name: "WithUpdated",
+			cycle: &Cycle{
+				Name:          "06/18/2020",
+				Date:          time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				Updated:       time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC),
+				SchemaVersion: 1,
+			},
+			want: &Cycle{
+				Name:          "06/18/2020",
+				Date:          time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				Updated:       time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC),
+				SchemaVersion: SchemaVersion,
+			},
+			wantUpgraded: true,
+		},
+		{
+			name:    "UnparsableName",
+			cycle:   &Cycle{Name: "legacy", Processed: "processed"},
+			want:    &Cycle{Name: "legacy", Processed: "processed"},
+			wantErr: true,
+		},
+		{
+			name:    "WithoutDate",
+			cycle:   &Cycle{Name: "legacy", SchemaVersion: 1},
+			want:    &Cycle{Name: "legacy", SchemaVersion: 1},
+			wantErr: true,
+		},
+		{
+			name:         "Current",
+			cycle:        &Cycle{Name: "06/18/2020", SchemaVersion: SchemaVersion},
+			want:         &Cycle{Name: "06/18/2020", SchemaVersion: SchemaVersion},
+			wantUpgraded: false,
+		},
+		{
+			name:         "Newer",
+			cycle:        &Cycle{Name: "06/18/2020", SchemaVersion: SchemaVersion + 1},
+			want:         &Cycle{Name: "06/18/2020", SchemaVersion: SchemaVersion + 1},
+			wantUpgraded: false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Upgrade(context.Background(), tt.cycle)
+			if got != tt.wantUpgraded {
+				t.Errorf("Upgrade() = %t, _ want %t, _", got, tt.wantUpgraded)
+			}
+			if gotErr := err != nil; gotErr != tt.wantErr || (gotErr && !errors.Is(err, ErrMigrationFailed)) {
+				t.Errorf("Upgrade() = _, %v want error %t", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, tt.cycle); diff != "" {
+				t.Errorf("upgraded cycle diff (-want +got): %s", diff)
+			}
+		})
+	}
+}
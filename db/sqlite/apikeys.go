@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+var _ db.APIKeyStore = (*APIKeys)(nil)
+
+const apiKeyColumns = `id, name, role, hash, created, last_used, revoked_at`
+
+// APIKeys stores API keys in a SQLite database opened by Open.
+type APIKeys struct {
+	DB *sql.DB
+}
+
+func (a *APIKeys) Add(ctx context.Context, key *db.APIKey) error {
+	if _, err := a.DB.ExecContext(ctx,
+		`INSERT INTO api_keys (`+apiKeyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key.ID, key.Name, key.Role, key.Hash, key.Created.UTC(), nullTime(key.LastUsed), nullTime(key.RevokedAt)); err != nil {
+		return fmt.Errorf("could not add API key: %v", err)
+	}
+	return nil
+}
+
+// Get returns the key with the specified ID, or nil if there is none.
+func (a *APIKeys) Get(ctx context.Context, id string) (*db.APIKey, error) {
+	key, err := scanAPIKey(a.DB.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get API key: %v", err)
+	}
+	return key, nil
+}
+
+// List returns every key, including revoked ones, oldest first.
+func (a *APIKeys) List(ctx context.Context) ([]*db.APIKey, error) {
+	rows, err := a.DB.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created`)
+	if err != nil {
+		return nil, fmt.Errorf("could not list API keys: %v", err)
+	}
+	defer rows.Close()
+	var keys []*db.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read API key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list API keys: %v", err)
+	}
+	return keys, nil
+}
+
+// Revoke marks the key with the specified ID as revoked.
+func (a *APIKeys) Revoke(ctx context.Context, id string, at time.Time) error {
+	return a.update(ctx, `UPDATE api_keys SET revoked_at = ? WHERE id = ?`, id, at)
+}
+
+// Touch records that the key with the specified ID was used.
+func (a *APIKeys) Touch(ctx context.Context, id string, at time.Time) error {
+	return a.update(ctx, `UPDATE api_keys SET last_used = ? WHERE id = ?`, id, at)
+}
+
+func (a *APIKeys) update(ctx context.Context, stmt, id string, at time.Time) error {
+	res, err := a.DB.ExecContext(ctx, stmt, at.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("could not update API key %q: %v", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("could not update API key %q: %w", id, db.ErrNotFound)
+	}
+	return nil
+}
+
+func scanAPIKey(s scanner) (*db.APIKey, error) {
+	var key db.APIKey
+	var lastUsed, revokedAt sql.NullTime
+	if err := s.Scan(&key.ID, &key.Name, &key.Role, &key.Hash, &key.Created, &lastUsed, &revokedAt); err != nil {
+		return nil, err
+	}
+	key.Created = key.Created.UTC()
+	if lastUsed.Valid {
+		key.LastUsed = lastUsed.Time.UTC()
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = revokedAt.Time.UTC()
+	}
+	return &key, nil
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
@@ -0,0 +1,62 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+	keys := &APIKeys{DB: cyclesDb.DB}
+
+	created := time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC)
+	first := &db.APIKey{ID: "first", Name: "cron", Role: "scheduler", Hash: "abcd", Created: created}
+	second := &db.APIKey{ID: "second", Name: "backfill", Role: "admin", Hash: "ef01", Created: created.Add(time.Hour)}
+	for _, k := range []*db.APIKey{second, first} {
+		if err := keys.Add(ctx, k); err != nil {
+			t.Fatalf("could not add API key: %v", err)
+		}
+	}
+
+	used := created.Add(2 * time.Hour)
+	if err := keys.Touch(ctx, "first", used); err != nil {
+		t.Fatalf("Touch() = %v want <nil>", err)
+	}
+	revoked := created.Add(3 * time.Hour)
+	if err := keys.Revoke(ctx, "second", revoked); err != nil {
+		t.Fatalf("Revoke() = %v want <nil>", err)
+	}
+	if err := keys.Revoke(ctx, "missing", revoked); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("Revoke() of missing key = %v want %v", err, db.ErrNotFound)
+	}
+
+	got, err := keys.List(ctx)
+	if err != nil {
+		t.Fatalf("List() = _, %v want _, <nil>", err)
+	}
+	want := []*db.APIKey{
+		{ID: "first", Name: "cron", Role: "scheduler", Hash: "abcd", Created: created, LastUsed: used},
+		{ID: "second", Name: "backfill", Role: "admin", Hash: "ef01", Created: created.Add(time.Hour), RevokedAt: revoked},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("List() diff (-want +got): %s", diff)
+	}
+
+	key, err := keys.Get(ctx, "second")
+	if err != nil {
+		t.Fatalf("Get() = _, %v want _, <nil>", err)
+	}
+	if !key.Revoked() {
+		t.Error("Get() returned key that is not revoked, want revoked")
+	}
+	if key, err := keys.Get(ctx, "missing"); key != nil || err != nil {
+		t.Errorf("Get() of missing key = %v, %v want <nil>, <nil>", key, err)
+	}
+}
@@ -0,0 +1,89 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+var _ db.DeadLetterStore = (*DeadLetters)(nil)
+
+const deadLetterColumns = `id, event_id, event_type, url, payload, attempts, last_error, created, replayed_at`
+
+// DeadLetters stores undelivered webhook events in a SQLite database opened
+// by Open.
+type DeadLetters struct {
+	DB *sql.DB
+}
+
+func (d *DeadLetters) Add(ctx context.Context, dl *db.DeadLetter) error {
+	if _, err := d.DB.ExecContext(ctx,
+		`INSERT INTO dead_letters (`+deadLetterColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		dl.ID, dl.EventID, dl.EventType, dl.URL, dl.Payload, dl.Attempts, dl.LastError, dl.Created.UTC(), nullTime(dl.ReplayedAt)); err != nil {
+		return fmt.Errorf("could not add dead letter: %v", err)
+	}
+	return nil
+}
+
+// Get returns the dead letter with the specified ID, or nil if there is none.
+func (d *DeadLetters) Get(ctx context.Context, id string) (*db.DeadLetter, error) {
+	dl, err := scanDeadLetter(d.DB.QueryRowContext(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get dead letter: %v", err)
+	}
+	return dl, nil
+}
+
+// List returns the 100 most recent dead letters, newest first, including
+// replayed ones.
+func (d *DeadLetters) List(ctx context.Context) ([]*db.DeadLetter, error) {
+	rows, err := d.DB.QueryContext(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters ORDER BY created DESC LIMIT 100`)
+	if err != nil {
+		return nil, fmt.Errorf("could not list dead letters: %v", err)
+	}
+	defer rows.Close()
+	var dls []*db.DeadLetter
+	for rows.Next() {
+		dl, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read dead letter: %v", err)
+		}
+		dls = append(dls, dl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list dead letters: %v", err)
+	}
+	return dls, nil
+}
+
+// MarkReplayed records that the dead letter with the specified ID was
+// delivered by a replay.
+func (d *DeadLetters) MarkReplayed(ctx context.Context, id string, at time.Time) error {
+	res, err := d.DB.ExecContext(ctx, `UPDATE dead_letters SET replayed_at = ? WHERE id = ?`, at.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("could not update dead letter %q: %v", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("could not update dead letter %q: %w", id, db.ErrNotFound)
+	}
+	return nil
+}
+
+func scanDeadLetter(s scanner) (*db.DeadLetter, error) {
+	var dl db.DeadLetter
+	var replayedAt sql.NullTime
+	if err := s.Scan(&dl.ID, &dl.EventID, &dl.EventType, &dl.URL, &dl.Payload, &dl.Attempts, &dl.LastError, &dl.Created, &replayedAt); err != nil {
+		return nil, err
+	}
+	dl.Created = dl.Created.UTC()
+	if replayedAt.Valid {
+		dl.ReplayedAt = replayedAt.Time.UTC()
+	}
+	return &dl, nil
+}
@@ -0,0 +1,50 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+func TestDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+	dls := &DeadLetters{DB: cyclesDb.DB}
+
+	created := time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC)
+	older := &db.DeadLetter{ID: "older", EventID: "e1", EventType: "cycle.published", URL: "https://example.com/hook", Payload: `{}`, Attempts: 5, LastError: "timeout", Created: created}
+	newer := &db.DeadLetter{ID: "newer", EventID: "e2", EventType: "processing.failed", URL: "https://example.com/hook", Payload: `{}`, Attempts: 5, LastError: "status 500", Created: created.Add(time.Hour)}
+	for _, dl := range []*db.DeadLetter{older, newer} {
+		if err := dls.Add(ctx, dl); err != nil {
+			t.Fatalf("could not add dead letter: %v", err)
+		}
+	}
+
+	replayed := created.Add(2 * time.Hour)
+	if err := dls.MarkReplayed(ctx, "older", replayed); err != nil {
+		t.Fatalf("MarkReplayed() = %v want <nil>", err)
+	}
+	if err := dls.MarkReplayed(ctx, "missing", replayed); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("MarkReplayed() of missing dead letter = %v want %v", err, db.ErrNotFound)
+	}
+
+	got, err := dls.List(ctx)
+	if err != nil {
+		t.Fatalf("List() = _, %v want _, <nil>", err)
+	}
+	wantOlder := *older
+	wantOlder.ReplayedAt = replayed
+	if diff := cmp.Diff([]*db.DeadLetter{newer, &wantOlder}, got); diff != "" {
+		t.Errorf("List() diff (-want +got): %s", diff)
+	}
+
+	gotOne, err := dls.Get(ctx, "missing")
+	if err != nil || gotOne != nil {
+		t.Errorf("Get() of missing dead letter = %v, %v want <nil>, <nil>", gotOne, err)
+	}
+}
@@ -0,0 +1,77 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+var _ db.LeaseStore = (*Leases)(nil)
+
+// Leases stores leases in a SQLite database opened by Open.
+type Leases struct {
+	DB *sql.DB
+}
+
+func (l *Leases) Acquire(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (*db.Lease, error) {
+	tx, err := l.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire lease %q: %v", name, err)
+	}
+	defer tx.Rollback()
+	lease, err := scanLease(tx.QueryRowContext(ctx, `SELECT name, holder, expires, last_run FROM leases WHERE name = ?`, name))
+	if err == sql.ErrNoRows {
+		lease, err = &db.Lease{Name: name}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire lease %q: %v", name, err)
+	}
+	if lease.Holder != "" && lease.Holder != holder && now.Before(lease.Expires) {
+		return nil, fmt.Errorf("could not acquire lease %q: %w", name, db.ErrLeaseHeld)
+	}
+	lease.Holder = holder
+	lease.Expires = now.Add(ttl).UTC()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT OR REPLACE INTO leases (name, holder, expires, last_run) VALUES (?, ?, ?, ?)`,
+		lease.Name, lease.Holder, lease.Expires, nullTime(lease.LastRun)); err != nil {
+		return nil, fmt.Errorf("could not acquire lease %q: %v", name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not acquire lease %q: %v", name, err)
+	}
+	return lease, nil
+}
+
+func (l *Leases) SetLastRun(ctx context.Context, name, holder string, at time.Time) error {
+	res, err := l.DB.ExecContext(ctx, `UPDATE leases SET last_run = ? WHERE name = ? AND holder = ?`, at.UTC(), name, holder)
+	if err != nil {
+		return fmt.Errorf("could not update lease %q: %v", name, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("could not update lease %q: %w", name, db.ErrLeaseHeld)
+	}
+	return nil
+}
+
+func (l *Leases) Release(ctx context.Context, name, holder string) error {
+	if _, err := l.DB.ExecContext(ctx, `UPDATE leases SET holder = '' WHERE name = ? AND holder = ?`, name, holder); err != nil {
+		return fmt.Errorf("could not release lease %q: %v", name, err)
+	}
+	return nil
+}
+
+func scanLease(s scanner) (*db.Lease, error) {
+	var lease db.Lease
+	var lastRun sql.NullTime
+	if err := s.Scan(&lease.Name, &lease.Holder, &lease.Expires, &lastRun); err != nil {
+		return nil, err
+	}
+	lease.Expires = lease.Expires.UTC()
+	if lastRun.Valid {
+		lease.LastRun = lastRun.Time.UTC()
+	}
+	return &lease, nil
+}
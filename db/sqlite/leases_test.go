@@ -0,0 +1,53 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+func TestLeases(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+
+	leases := &Leases{DB: cyclesDb.DB}
+	now := time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC)
+
+	got, err := leases.Acquire(ctx, "scheduler", "a", now, time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire() = _, %v want _, <nil>", err)
+	}
+	if diff := cmp.Diff(&db.Lease{Name: "scheduler", Holder: "a", Expires: now.Add(time.Minute)}, got); diff != "" {
+		t.Errorf("unexpected lease diff (-want +got):\n%s", diff)
+	}
+	if _, err := leases.Acquire(ctx, "scheduler", "b", now.Add(30*time.Second), time.Minute); !errors.Is(err, db.ErrLeaseHeld) {
+		t.Errorf("Acquire() of held lease = _, %v want _, %v", err, db.ErrLeaseHeld)
+	}
+	if err := leases.SetLastRun(ctx, "scheduler", "a", now); err != nil {
+		t.Fatalf("SetLastRun() = %v want <nil>", err)
+	}
+	if err := leases.SetLastRun(ctx, "scheduler", "b", now); !errors.Is(err, db.ErrLeaseHeld) {
+		t.Errorf("SetLastRun() by other holder = %v want %v", err, db.ErrLeaseHeld)
+	}
+
+	expired := now.Add(2 * time.Minute)
+	got, err = leases.Acquire(ctx, "scheduler", "b", expired, time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire() of expired lease = _, %v want _, <nil>", err)
+	}
+	if diff := cmp.Diff(&db.Lease{Name: "scheduler", Holder: "b", Expires: expired.Add(time.Minute), LastRun: now}, got); diff != "" {
+		t.Errorf("unexpected lease diff (-want +got):\n%s", diff)
+	}
+
+	if err := leases.Release(ctx, "scheduler", "b"); err != nil {
+		t.Fatalf("Release() = %v want <nil>", err)
+	}
+	if _, err := leases.Acquire(ctx, "scheduler", "a", expired, time.Minute); err != nil {
+		t.Errorf("Acquire() of released lease = _, %v want _, <nil>", err)
+	}
+}
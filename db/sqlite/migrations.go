@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+// migrations are the schema changes applied to a database in order. The
+// schema version of a database is the number of migrations applied to it, so
+// existing entries must never be edited or reordered; append new ones instead.
+var migrations = []string{
+	`CREATE TABLE cycles (
+		name      TEXT PRIMARY KEY,
+		original  TEXT NOT NULL,
+		processed TEXT NOT NULL,
+		date      TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX cycles_date ON cycles (date)`,
+	`ALTER TABLE cycles ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE api_keys (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		role       TEXT NOT NULL,
+		hash       TEXT NOT NULL,
+		created    TIMESTAMP NOT NULL,
+		last_used  TIMESTAMP,
+		revoked_at TIMESTAMP
+	)`,
+	`ALTER TABLE cycles ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE TABLE dead_letters (
+		id          TEXT PRIMARY KEY,
+		event_id    TEXT NOT NULL,
+		event_type  TEXT NOT NULL,
+		url         TEXT NOT NULL,
+		payload     TEXT NOT NULL,
+		attempts    INTEGER NOT NULL,
+		last_error  TEXT NOT NULL,
+		created     TIMESTAMP NOT NULL,
+		replayed_at TIMESTAMP
+	)`,
+	`CREATE INDEX dead_letters_created ON dead_letters (created)`,
+	`ALTER TABLE cycles ADD COLUMN checksum TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE cycles ADD COLUMN updated TIMESTAMP`,
+	`CREATE TABLE leases (
+		name     TEXT PRIMARY KEY,
+		holder   TEXT NOT NULL,
+		expires  TIMESTAMP NOT NULL,
+		last_run TIMESTAMP
+	)`,
+	`CREATE TABLE nonces (
+		nonce   TEXT PRIMARY KEY,
+		expires TIMESTAMP NOT NULL
+	)`,
+}
+
+// migrate brings the database schema up to date, applying each pending
+// migration in its own transaction.
+func migrate(ctx context.Context, sqlDB *sql.DB) error {
+	if _, err := sqlDB.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("could not create schema version table: %v", err)
+	}
+	var version int
+	if err := sqlDB.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return fmt.Errorf("could not read schema version: %v", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		if err := applyMigration(ctx, sqlDB, i+1, migrations[i]); err != nil {
+			return err
+		}
+		logging.Infof(ctx, "Applied database migration %d.", i+1)
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, sqlDB *sql.DB, version int, stmt string) error {
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin migration %d: %v", version, err)
+	}
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not apply migration %d: %v", version, err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_version (version) VALUES (?)`, version); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not record migration %d: %v", version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit migration %d: %v", version, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+var _ db.NonceStore = (*Nonces)(nil)
+
+// Nonces stores nonces in a SQLite database opened by Open. Expired nonces
+// are deleted whenever one is used.
+type Nonces struct {
+	DB *sql.DB
+}
+
+func (n *Nonces) Use(ctx context.Context, nonce string, now, expires time.Time) error {
+	tx, err := n.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not use nonce: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM nonces WHERE expires <= ?`, now.UTC()); err != nil {
+		return fmt.Errorf("could not use nonce: %v", err)
+	}
+	var used time.Time
+	err = tx.QueryRowContext(ctx, `SELECT expires FROM nonces WHERE nonce = ?`, nonce).Scan(&used)
+	if err == nil {
+		return fmt.Errorf("could not use nonce: %w", db.ErrNonceUsed)
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("could not use nonce: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO nonces (nonce, expires) VALUES (?, ?)`, nonce, expires.UTC()); err != nil {
+		return fmt.Errorf("could not use nonce: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not use nonce: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+func TestNonces(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+
+	nonces := &Nonces{DB: cyclesDb.DB}
+	now := time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC)
+
+	if err := nonces.Use(ctx, "abc", now, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Use() = %v want <nil>", err)
+	}
+	if err := nonces.Use(ctx, "abc", now.Add(30*time.Second), now.Add(time.Minute)); !errors.Is(err, db.ErrNonceUsed) {
+		t.Errorf("Use() of used nonce = %v want %v", err, db.ErrNonceUsed)
+	}
+	if err := nonces.Use(ctx, "def", now, now.Add(time.Minute)); err != nil {
+		t.Errorf("Use() of other nonce = %v want <nil>", err)
+	}
+	if err := nonces.Use(ctx, "abc", now.Add(2*time.Minute), now.Add(3*time.Minute)); err != nil {
+		t.Errorf("Use() of expired nonce = %v want <nil>", err)
+	}
+}
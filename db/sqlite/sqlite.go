@@ -0,0 +1,229 @@
+// Package sqlite is an embedded SQLite implementation of the cycle store, for
+// running the app without access to Firestore.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/metrics"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+var _ db.Store = (*Cycles)(nil)
+
+const cycleColumns = `name, original, processed, date, schema_version, hidden, checksum, updated`
+
+// Cycles stores cycles in a SQLite database.
+type Cycles struct {
+	DB *sql.DB
+}
+
+// Open opens the SQLite database at the specified path, creating it if it
+// does not exist, and applies any pending schema migrations. Because the
+// database is embedded, stored cycles are also upgraded to the current
+// db.SchemaVersion up front rather than lazily.
+func Open(ctx context.Context, path string) (*Cycles, error) {
+	sqlDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %v", err)
+	}
+	// SQLite only supports a single writer, so serialize access through one
+	// connection rather than surfacing "database is locked" errors.
+	sqlDB.SetMaxOpenConns(1)
+	if err := migrate(ctx, sqlDB); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	c := &Cycles{DB: sqlDB}
+	// Cycles that cannot be upgraded are still served at the version they
+	// reached, and are upgraded again the next time the database is opened.
+	if _, err := c.UpgradeAll(ctx); errors.Is(err, db.ErrMigrationFailed) {
+		logging.Errorf(ctx, "Could not upgrade every cycle: %v", err)
+	} else if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the underlying database.
+func (c *Cycles) Close() error {
+	return c.DB.Close()
+}
+
+// Add stores a new cycle, returning db.ErrCycleExists if a cycle with the same
+// name is already stored.
+func (c *Cycles) Add(ctx context.Context, cycle *db.Cycle) (err error) {
+	defer metrics.ObserveDBCall("sqlite", "add")()
+	ctx, span := tracing.Start(ctx, "sqlite.Cycles.Add", semconv.DBSystemSqlite)
+	defer func() { tracing.End(span, err) }()
+	if _, err := c.DB.ExecContext(ctx,
+		`INSERT INTO cycles (`+cycleColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		cycle.Name, cycle.Original, cycle.Processed, cycle.Date.UTC(), db.SchemaVersion, cycle.Hidden, cycle.Checksum, nullTime(cycle.Updated)); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return fmt.Errorf("could not add cycle %q: %w", cycle.Name, db.ErrCycleExists)
+		}
+		return fmt.Errorf("could not add cycle: %v", err)
+	}
+	return nil
+}
+
+func (c *Cycles) Get(ctx context.Context, name string) (_ *db.Cycle, err error) {
+	defer metrics.ObserveDBCall("sqlite", "get")()
+	ctx, span := tracing.Start(ctx, "sqlite.Cycles.Get", semconv.DBSystemSqlite)
+	defer func() { tracing.End(span, err) }()
+	row := c.DB.QueryRowContext(ctx,
+		`SELECT `+cycleColumns+` FROM cycles WHERE name = ?`, name)
+	cycle, err := scanCycle(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get cycle: %v", err)
+	}
+	c.upgrade(ctx, cycle)
+	return cycle, nil
+}
+
+func (c *Cycles) List(ctx context.Context) (_ []*db.Cycle, err error) {
+	defer metrics.ObserveDBCall("sqlite", "list")()
+	ctx, span := tracing.Start(ctx, "sqlite.Cycles.List", semconv.DBSystemSqlite)
+	defer func() { tracing.End(span, err) }()
+	cycles, err := c.query(ctx, `SELECT `+cycleColumns+` FROM cycles ORDER BY date DESC LIMIT 10`)
+	if err != nil {
+		return nil, fmt.Errorf("could not list cycles: %v", err)
+	}
+	for _, cycle := range cycles {
+		c.upgrade(ctx, cycle)
+	}
+	return cycles, nil
+}
+
+// SetHidden hides or shows the cycle with the specified name, returning
+// db.ErrNotFound if there is none.
+func (c *Cycles) SetHidden(ctx context.Context, name string, hidden bool) (err error) {
+	defer metrics.ObserveDBCall("sqlite", "setHidden")()
+	ctx, span := tracing.Start(ctx, "sqlite.Cycles.SetHidden", semconv.DBSystemSqlite)
+	defer func() { tracing.End(span, err) }()
+	res, err := c.DB.ExecContext(ctx, `UPDATE cycles SET hidden = ? WHERE name = ?`, hidden, name)
+	if err != nil {
+		return fmt.Errorf("could not update cycle %q: %v", name, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("could not update cycle %q: %w", name, db.ErrNotFound)
+	}
+	return nil
+}
+
+// SetChecksum records the checksum of new processed data for the cycle with
+// the specified name and when it was written, returning db.ErrNotFound if
+// there is no such cycle.
+func (c *Cycles) SetChecksum(ctx context.Context, name, checksum string, updated time.Time) (err error) {
+	defer metrics.ObserveDBCall("sqlite", "setChecksum")()
+	ctx, span := tracing.Start(ctx, "sqlite.Cycles.SetChecksum", semconv.DBSystemSqlite)
+	defer func() { tracing.End(span, err) }()
+	res, err := c.DB.ExecContext(ctx, `UPDATE cycles SET checksum = ?, updated = ? WHERE name = ?`, checksum, updated.UTC(), name)
+	if err != nil {
+		return fmt.Errorf("could not update cycle %q: %v", name, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("could not update cycle %q: %w", name, db.ErrNotFound)
+	}
+	return nil
+}
+
+// UpgradeAll upgrades every stored cycle to the current db.SchemaVersion and
+// returns the number of cycles that were rewritten. Cycles that cannot be
+// fully upgraded keep the version they reached, and are reported in an error
+// wrapping db.ErrMigrationFailed once the others are upgraded.
+func (c *Cycles) UpgradeAll(ctx context.Context) (int, error) {
+	cycles, err := c.query(ctx, `SELECT `+cycleColumns+` FROM cycles WHERE schema_version < ?`, db.SchemaVersion)
+	if err != nil {
+		return 0, fmt.Errorf("could not list cycles to upgrade: %v", err)
+	}
+	var n, failed int
+	for _, cycle := range cycles {
+		upgraded, err := db.Upgrade(ctx, cycle)
+		if err != nil {
+			logging.Errorf(ctx, "Could not upgrade cycle %q: %v", cycle.Name, err)
+			failed++
+		}
+		if !upgraded {
+			continue
+		}
+		if err := c.save(ctx, cycle); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if failed > 0 {
+		return n, fmt.Errorf("could not upgrade %d cycles: %w", failed, db.ErrMigrationFailed)
+	}
+	return n, nil
+}
+
+// upgrade upgrades cycle to the current db.SchemaVersion, writing it back on a
+// best-effort basis.
+func (c *Cycles) upgrade(ctx context.Context, cycle *db.Cycle) {
+	upgraded, err := db.Upgrade(ctx, cycle)
+	if err != nil {
+		logging.Errorf(ctx, "Could not upgrade cycle %q: %v", cycle.Name, err)
+	}
+	if !upgraded {
+		return
+	}
+	if err := c.save(ctx, cycle); err != nil {
+		logging.Errorf(ctx, "Could not save upgraded cycle %q: %v", cycle.Name, err)
+	}
+}
+
+func (c *Cycles) save(ctx context.Context, cycle *db.Cycle) error {
+	if _, err := c.DB.ExecContext(ctx,
+		`UPDATE cycles SET original = ?, processed = ?, date = ?, schema_version = ?, updated = ? WHERE name = ?`,
+		cycle.Original, cycle.Processed, cycle.Date.UTC(), cycle.SchemaVersion, nullTime(cycle.Updated), cycle.Name); err != nil {
+		return fmt.Errorf("could not upgrade cycle %q: %v", cycle.Name, err)
+	}
+	return nil
+}
+
+func (c *Cycles) query(ctx context.Context, query string, args ...interface{}) ([]*db.Cycle, error) {
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cycles []*db.Cycle
+	for rows.Next() {
+		cycle, err := scanCycle(rows)
+		if err != nil {
+			return nil, err
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCycle(s scanner) (*db.Cycle, error) {
+	var cycle db.Cycle
+	var updated sql.NullTime
+	if err := s.Scan(&cycle.Name, &cycle.Original, &cycle.Processed, &cycle.Date, &cycle.SchemaVersion, &cycle.Hidden, &cycle.Checksum, &updated); err != nil {
+		return nil, err
+	}
+	cycle.Date = cycle.Date.UTC()
+	if updated.Valid {
+		cycle.Updated = updated.Time.UTC()
+	}
+	return &cycle, nil
+}
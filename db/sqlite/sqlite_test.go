@@ -0,0 +1,299 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+func newTestCycles(t *testing.T) (*Cycles, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "sqlitetest")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	cycles, err := Open(context.Background(), filepath.Join(dir, "cycles.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("could not open database: %v", err)
+	}
+	return cycles, func() {
+		cycles.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAddListCycle(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+
+	cycle := &db.Cycle{
+		Name:          "200326",
+		Original:      "original",
+		Processed:     "processed",
+		Date:          time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+		SchemaVersion: db.SchemaVersion,
+		Checksum:      "abcd",
+		Updated:       time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := cyclesDb.Add(ctx, cycle); err != nil {
+		t.Errorf("could not add entity: %v", err)
+	}
+	got, err := cyclesDb.List(ctx)
+	if err != nil {
+		t.Errorf("could not list cycles: %v", err)
+	}
+	if diff := cmp.Diff([]*db.Cycle{cycle}, got); diff != "" {
+		t.Errorf("cycles diff (-got +want): %s", diff)
+	}
+}
+
+func TestAddDuplicate(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+
+	cycle := &db.Cycle{
+		Name: "07/16/2020",
+		Date: time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+	}
+	if err := cyclesDb.Add(ctx, cycle); err != nil {
+		t.Fatalf("could not add entity: %v", err)
+	}
+	if err := cyclesDb.Add(ctx, cycle); !errors.Is(err, db.ErrCycleExists) {
+		t.Errorf("Add() = %v for duplicate cycle want %v", err, db.ErrCycleExists)
+	}
+}
+
+func TestListCycleDescMaxEntries(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+
+	var all []*db.Cycle
+	for i := 0; i < 15; i++ {
+		all = append(all, &db.Cycle{
+			Name:          fmt.Sprintf("cycle-%02d", i),
+			Original:      "original",
+			Processed:     "processed",
+			Date:          time.Date(2020, 1, 1+28*i, 0, 0, 0, 0, time.UTC),
+			SchemaVersion: db.SchemaVersion,
+		})
+	}
+	for _, c := range all {
+		if err := cyclesDb.Add(ctx, c); err != nil {
+			t.Fatalf("could not add entity: %v", err)
+		}
+	}
+
+	got, err := cyclesDb.List(ctx)
+	if err != nil {
+		t.Errorf("could not list cycles: %v", err)
+	}
+	var want []*db.Cycle
+	for i := len(all) - 1; i >= len(all)-10; i-- {
+		want = append(want, all[i])
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("cycles diff (-got +want): %s", diff)
+	}
+}
+
+func TestAddGet(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+
+	for _, name := range []string{"200326", "200425"} {
+		if err := cyclesDb.Add(ctx, &db.Cycle{
+			Name:      name,
+			Original:  "original",
+			Processed: "processed",
+			Date:      time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+		}); err != nil {
+			t.Fatalf("could not add entity: %v", err)
+		}
+	}
+	got, err := cyclesDb.Get(ctx, "200425")
+	if err != nil {
+		t.Errorf("could not get cycle: %v", err)
+	}
+	want := &db.Cycle{
+		Name:          "200425",
+		Original:      "original",
+		Processed:     "processed",
+		Date:          time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+		SchemaVersion: db.SchemaVersion,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Get() = %+v, _ want %+v, _", got, want)
+	}
+
+	got, err = cyclesDb.Get(ctx, "doesnotexist")
+	if err != nil {
+		t.Errorf("could not get cycle: %v", err)
+	}
+	if got != nil {
+		t.Errorf("Get() = %+v, _ want <nil>, _", got)
+	}
+}
+
+func TestSetHidden(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+
+	if err := cyclesDb.Add(ctx, &db.Cycle{Name: "07/16/2020"}); err != nil {
+		t.Fatalf("could not add entity: %v", err)
+	}
+	for _, hidden := range []bool{true, false} {
+		if err := cyclesDb.SetHidden(ctx, "07/16/2020", hidden); err != nil {
+			t.Fatalf("SetHidden(_, _, %t) = %v want <nil>", hidden, err)
+		}
+		got, err := cyclesDb.Get(ctx, "07/16/2020")
+		if err != nil {
+			t.Fatalf("could not get cycle: %v", err)
+		}
+		if got.Hidden != hidden {
+			t.Errorf("Get() hidden = %t want %t", got.Hidden, hidden)
+		}
+	}
+	if err := cyclesDb.SetHidden(ctx, "doesnotexist", true); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("SetHidden() of missing cycle = %v want %v", err, db.ErrNotFound)
+	}
+}
+
+func TestSetChecksum(t *testing.T) {
+	ctx := context.Background()
+	cyclesDb, cleanUp := newTestCycles(t)
+	defer cleanUp()
+
+	if err := cyclesDb.Add(ctx, &db.Cycle{Name: "07/16/2020", Checksum: "old"}); err != nil {
+		t.Fatalf("could not add entity: %v", err)
+	}
+	updated := time.Date(2020, 7, 20, 12, 0, 0, 0, time.UTC)
+	if err := cyclesDb.SetChecksum(ctx, "07/16/2020", "new", updated); err != nil {
+		t.Fatalf("SetChecksum() = %v want <nil>", err)
+	}
+	got, err := cyclesDb.Get(ctx, "07/16/2020")
+	if err != nil {
+		t.Fatalf("could not get cycle: %v", err)
+	}
+	if got.Checksum != "new" || !got.Updated.Equal(updated) {
+		t.Errorf("Get() checksum, updated = %q, %v want %q, %v", got.Checksum, got.Updated, "new", updated)
+	}
+	if err := cyclesDb.SetChecksum(ctx, "doesnotexist", "new", updated); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("SetChecksum() of missing cycle = %v want %v", err, db.ErrNotFound)
+	}
+}
+
+func TestReopenKeepsData(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "sqlitetest")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cycles.db")
+
+	first, err := Open(ctx, path)
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	if err := first.Add(ctx, &db.Cycle{Name: "07/16/2020"}); err != nil {
+		t.Fatalf("could not add entity: %v", err)
+	}
+	first.Close()
+
+	second, err := Open(ctx, path)
+	if err != nil {
+		t.Fatalf("could not reopen database: %v", err)
+	}
+	defer second.Close()
+	got, err := second.Get(ctx, "07/16/2020")
+	if err != nil {
+		t.Fatalf("could not get cycle: %v", err)
+	}
+	if got == nil {
+		t.Errorf("Get() = <nil>, _ after reopening want cycle")
+	}
+	var version int
+	if err := second.DB.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		t.Fatalf("could not read schema version: %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("schema version = %d want %d", version, len(migrations))
+	}
+}
+
+func TestUpgradeUnversioned(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "sqlitetest")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cycles.db")
+
+	first, err := Open(ctx, path)
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	for _, name := range []string{"06/18/2020", "07/16/2020", "legacy"} {
+		if _, err := first.DB.ExecContext(ctx,
+			`INSERT INTO cycles (name, original, processed, date, schema_version) VALUES (?, 'original', 'processed', ?, 0)`,
+			name, time.Time{}); err != nil {
+			t.Fatalf("could not add unversioned entity: %v", err)
+		}
+	}
+
+	got, err := first.Get(ctx, "06/18/2020")
+	if err != nil {
+		t.Fatalf("could not get cycle: %v", err)
+	}
+	want := &db.Cycle{
+		Name:          "06/18/2020",
+		Original:      "original",
+		Processed:     "processed",
+		Date:          time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+		Updated:       time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+		SchemaVersion: db.SchemaVersion,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Get() diff (-want +got): %s", diff)
+	}
+	first.Close()
+
+	// Reopening upgrades the cycle that has not been read yet.
+	second, err := Open(ctx, path)
+	if err != nil {
+		t.Fatalf("could not reopen database: %v", err)
+	}
+	defer second.Close()
+	var stale int
+	if err := second.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM cycles WHERE schema_version < ?`, db.SchemaVersion).Scan(&stale); err != nil {
+		t.Fatalf("could not count stale cycles: %v", err)
+	}
+	// The cycle whose date cannot be derived stays at its version, so that
+	// it is not served as upgraded with a zero date.
+	if stale != 1 {
+		t.Errorf("%d cycles not upgraded on open, want 1", stale)
+	}
+	legacy, err := second.Get(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("could not get cycle: %v", err)
+	}
+	if legacy.SchemaVersion != 0 {
+		t.Errorf("Get() schema version = %d want 0", legacy.SchemaVersion)
+	}
+}
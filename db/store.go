@@ -0,0 +1,20 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Store persists the cycles that have been processed. Implementations must
+// return a nil cycle and a nil error from Get when no cycle has the name, and
+// must return at most the ten most recent cycles from List, newest first,
+// including hidden ones.
+type Store interface {
+	Add(context.Context, *Cycle) error
+	Get(context.Context, string) (*Cycle, error)
+	List(context.Context) ([]*Cycle, error)
+	SetHidden(_ context.Context, name string, hidden bool) error
+	SetChecksum(_ context.Context, name, checksum string, updated time.Time) error
+}
+
+var _ Store = (*Cycles)(nil)
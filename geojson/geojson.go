@@ -0,0 +1,282 @@
+// Package geojson exports the airports, localizers, terminal waypoints and
+// procedure legs of CIFP data as GeoJSON features, so that they can be
+// overlaid on maps.
+package geojson
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/arinc424"
+)
+
+// ErrNoFeatures is returned when there are no features to write, such as
+// for an airport that is not in the data.
+var ErrNoFeatures = errors.New("no features")
+
+// Feature is a GeoJSON feature.
+type Feature struct {
+	Type string `json:"type"`
+	// Geometry is nil for procedure legs whose fix is not in the data.
+	Geometry   *Geometry              `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Geometry is a GeoJSON point.
+type Geometry struct {
+	Type string `json:"type"`
+	// Coordinates are the longitude and latitude of the point, in that
+	// order.
+	Coordinates []float64 `json:"coordinates"`
+}
+
+func point(lat, lon float64) *Geometry {
+	return &Geometry{Type: "Point", Coordinates: []float64{lon, lat}}
+}
+
+var procedureKinds = map[string]string{
+	arinc424.SID:      "sid",
+	arinc424.STAR:     "star",
+	arinc424.Approach: "approach",
+}
+
+// Write reads CIFP data from r and writes its features to w as a GeoJSON
+// feature collection. If airport is not empty, only the features of that
+// airport are written. It returns the number of features written, or
+// ErrNoFeatures if there are none, in which case nothing is written.
+//
+// Procedure legs are located at their fix, which is looked up among the
+// reference point, terminal waypoints, runways and localizers of their
+// airport, and the enroute waypoints and navaids read before it. CIFP data
+// lists the enroute sections first, so only legs without a fix, or to fixes
+// that are not in the data, have no geometry.
+func Write(w io.Writer, r io.Reader, airport string) (int, error) {
+	e := &encoder{w: bufio.NewWriter(w), fixes: make(map[string]*Geometry)}
+	rd := arinc424.NewReader(r)
+	var current string
+	var recs []arinc424.Record
+	for {
+		rec, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("could not read records: %v", err)
+		}
+		if u, ok := rec.(*arinc424.Unknown); ok {
+			e.addEnrouteFix(u.Line)
+			continue
+		}
+		icao := arinc424.AirportOf(rec)
+		if icao == "" || (airport != "" && icao != airport) {
+			continue
+		}
+		// The records of an airport are listed together, but its legs come
+		// before its runways and localizers, so they are written once all of
+		// them are read.
+		if icao != current {
+			if err := e.writeAirport(recs); err != nil {
+				return 0, err
+			}
+			current, recs = icao, nil
+		}
+		recs = append(recs, rec)
+	}
+	if err := e.writeAirport(recs); err != nil {
+		return 0, err
+	}
+	if e.n == 0 {
+		return 0, ErrNoFeatures
+	}
+	if _, err := e.w.WriteString("\n]}\n"); err != nil {
+		return 0, err
+	}
+	return e.n, e.w.Flush()
+}
+
+type encoder struct {
+	w *bufio.Writer
+	n int
+	// fixes are the positions of fixes by fixKey.
+	fixes map[string]*Geometry
+}
+
+func fixKey(section, subsection, ident, region string) string {
+	return section + strings.TrimSpace(subsection) + "/" + ident + "/" + region
+}
+
+// addEnrouteFix adds the fix of line if it is an enroute waypoint, section
+// EA, or a VHF navaid or NDB, section D.
+func (e *encoder) addEnrouteFix(line string) {
+	if len(line) != arinc424.LineLength || line[0] == 'H' || !(line[4] == 'D' || (line[4] == 'E' && line[5] == 'A')) {
+		return
+	}
+	lat, err1 := arinc424.ParseLatitude(line[32:41])
+	lon, err2 := arinc424.ParseLongitude(line[41:51])
+	// DMEs without a VOR have no position in these columns.
+	if err1 != nil || err2 != nil {
+		return
+	}
+	ident := strings.TrimSpace(line[13:18])
+	e.fixes[fixKey(line[4:5], line[5:6], ident, line[19:21])] = point(lat, lon)
+}
+
+type positioned interface {
+	Position() (lat, lon float64, err error)
+}
+
+func geometry(p positioned) *Geometry {
+	lat, lon, err := p.Position()
+	if err != nil {
+		return nil
+	}
+	return point(lat, lon)
+}
+
+// writeAirport writes the features of the records of an airport.
+func (e *encoder) writeAirport(recs []arinc424.Record) error {
+	// Terminal fixes are only known within their airport.
+	terminal := make(map[string]*Geometry)
+	for _, rec := range recs {
+		switch r := rec.(type) {
+		case *arinc424.Airport:
+			terminal[fixKey("P", "A", r.Airport, r.Region)] = geometry(r)
+		case *arinc424.TerminalWaypoint:
+			terminal[fixKey("P", "C", r.Ident, r.WaypointRegion)] = geometry(r)
+		case *arinc424.Runway:
+			terminal[fixKey("P", "G", r.Ident, r.Region)] = geometry(r)
+		case *arinc424.Localizer:
+			terminal[fixKey("P", "I", r.Ident, r.Region)] = geometry(r)
+		}
+	}
+	for _, rec := range recs {
+		var f *Feature
+		switch r := rec.(type) {
+		case *arinc424.Airport:
+			f = airportFeature(r)
+		case *arinc424.Localizer:
+			f = localizerFeature(r)
+		case *arinc424.TerminalWaypoint:
+			f = &Feature{Geometry: geometry(r), Properties: map[string]interface{}{
+				"kind":    "terminal_waypoint",
+				"airport": r.Airport,
+				"ident":   r.Ident,
+				"region":  r.WaypointRegion,
+				"type":    r.Type,
+				"name":    r.Name,
+			}}
+		case *arinc424.Procedure:
+			f = legFeature(r)
+			key := fixKey(r.FixSection, r.FixSubsection, r.Fix, r.FixRegion)
+			if g, ok := terminal[key]; ok {
+				f.Geometry = g
+			} else {
+				f.Geometry = e.fixes[key]
+			}
+		}
+		if f == nil {
+			continue
+		}
+		if err := e.write(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) write(f *Feature) error {
+	f.Type = "Feature"
+	b, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("could not encode feature: %v", err)
+	}
+	sep := ",\n"
+	if e.n == 0 {
+		sep = `{"type":"FeatureCollection","features":[` + "\n"
+	}
+	if _, err := e.w.WriteString(sep); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(b); err != nil {
+		return err
+	}
+	e.n++
+	return nil
+}
+
+func airportFeature(a *arinc424.Airport) *Feature {
+	props := map[string]interface{}{
+		"kind":    "airport",
+		"airport": a.Airport,
+		"iata":    a.IATA,
+		"name":    a.Name,
+	}
+	if elev, err := strconv.Atoi(a.Elevation); err == nil {
+		props["elevation_ft"] = elev
+	}
+	if v, err := arinc424.ParseMagneticVariation(a.MagneticVariation); err == nil {
+		props["magnetic_variation"] = v
+	}
+	return &Feature{Geometry: geometry(a), Properties: props}
+}
+
+func localizerFeature(l *arinc424.Localizer) *Feature {
+	props := map[string]interface{}{
+		"kind":     "localizer",
+		"airport":  l.Airport,
+		"ident":    l.Ident,
+		"category": l.Category,
+		"runway":   l.Runway,
+	}
+	if f, err := l.FrequencyMHz(); err == nil {
+		props["frequency_mhz"] = f
+	}
+	if b, err := l.MagneticBearing(); err == nil {
+		if b.True {
+			props["true_bearing"] = b.Degrees
+		} else {
+			props["magnetic_bearing"] = b.Degrees
+		}
+	}
+	// The bearing computed by the enhancer replaces the published one.
+	if b, ok, err := l.TrueBearing(); err == nil && ok {
+		props["true_bearing"] = b
+	}
+	return &Feature{Geometry: geometry(l), Properties: props}
+}
+
+func legFeature(p *arinc424.Procedure) *Feature {
+	props := map[string]interface{}{
+		"kind":            "procedure_leg",
+		"airport":         p.Airport,
+		"procedure":       p.Ident,
+		"procedure_kind":  procedureKinds[p.Subsection],
+		"route_type":      p.RouteType,
+		"transition":      p.Transition,
+		"sequence":        p.Sequence,
+		"fix":             p.Fix,
+		"path_terminator": p.PathTerminator,
+	}
+	for name, v := range map[string]string{
+		"turn_direction":       p.TurnDirection,
+		"altitude_description": p.AltitudeDescription,
+		"altitude":             p.Altitude,
+		"altitude2":            p.Altitude2,
+		"speed_limit":          p.SpeedLimit,
+		"recommended_navaid":   p.RecommendedNavaid,
+	} {
+		if v != "" {
+			props[name] = v
+		}
+	}
+	if b, err := arinc424.ParseBearing(p.Course); err == nil {
+		props["course"] = b.Degrees
+		props["course_true"] = b.True
+	}
+	return &Feature{Properties: props}
+}
@@ -0,0 +1,197 @@
+package geojson
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/blob/blobtest"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+)
+
+// testData returns the processed data of KHWD after the SJC VOR and the
+// SUNOL enroute waypoint.
+func testData(t *testing.T) string {
+	t.Helper()
+	b, err := ioutil.ReadFile("../pipeline/want_processed.txt")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	return strings.Join(lines[:5], "") +
+		"SUSAD        SJC   K2011430VTHW N37224581W121565806N37224581W121565806                                                     123452003\n" +
+		"SUSAEAENRT   SUNOL K20    W     N37361838W121522474                                               SUNOL                    234562003\n" +
+		strings.Join(lines[5:], "")
+}
+
+type featureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+}
+
+func decode(t *testing.T, data []byte) *featureCollection {
+	t.Helper()
+	fc := &featureCollection{}
+	if err := json.Unmarshal(data, fc); err != nil {
+		t.Fatalf("could not decode features: %v\n%s", err, data)
+	}
+	return fc
+}
+
+// find returns the first feature with the properties in props.
+func find(fc *featureCollection, props map[string]interface{}) *Feature {
+	for _, f := range fc.Features {
+		match := true
+		for k, v := range props {
+			if f.Properties[k] != v {
+				match = false
+			}
+		}
+		if match {
+			return f
+		}
+	}
+	return nil
+}
+
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	n, err := Write(&b, strings.NewReader(testData(t)), "KHWD")
+	if err != nil {
+		t.Fatalf("Write() = _, %v want _, <nil>", err)
+	}
+	fc := decode(t, b.Bytes())
+	if fc.Type != "FeatureCollection" || len(fc.Features) != n {
+		t.Errorf("Write() wrote a %q of %d features want a FeatureCollection of %d", fc.Type, len(fc.Features), n)
+	}
+	kinds := make(map[string]int)
+	for _, f := range fc.Features {
+		kinds[f.Properties["kind"].(string)]++
+	}
+	wantKinds := map[string]int{"airport": 1, "localizer": 1, "terminal_waypoint": 16, "procedure_leg": 70}
+	if diff := cmp.Diff(wantKinds, kinds); diff != "" {
+		t.Errorf("Write() wrote unexpected features (-want +got):\n%s", diff)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		props map[string]interface{}
+		want  *Feature
+	}{
+		{
+			name:  "airport",
+			props: map[string]interface{}{"kind": "airport"},
+			want: &Feature{
+				Type:     "Feature",
+				Geometry: &Geometry{Type: "Point", Coordinates: []float64{-122.121736, 37.658928}},
+				Properties: map[string]interface{}{
+					"kind": "airport", "airport": "KHWD", "iata": "HWD", "name": "HAYWARD EXECUTIVE",
+					"elevation_ft": 52.0, "magnetic_variation": 15.0,
+				},
+			},
+		},
+		{
+			name:  "localizer",
+			props: map[string]interface{}{"kind": "localizer"},
+			want: &Feature{
+				Type:     "Feature",
+				Geometry: &Geometry{Type: "Point", Coordinates: []float64{-122.129653, 37.662833}},
+				Properties: map[string]interface{}{
+					"kind": "localizer", "airport": "KHWD", "ident": "IHWD", "category": "0", "runway": "RW28L",
+					"frequency_mhz": 111.5, "magnetic_bearing": 287.9, "true_bearing": 303.05,
+				},
+			},
+		},
+		{
+			name:  "leg to terminal waypoint",
+			props: map[string]interface{}{"procedure": "L28L", "fix": "FERNE"},
+			want: &Feature{
+				Type:     "Feature",
+				Geometry: &Geometry{Type: "Point", Coordinates: []float64{-121.999297, 37.595764}},
+				Properties: map[string]interface{}{
+					"kind": "procedure_leg", "airport": "KHWD", "procedure": "L28L", "procedure_kind": "approach",
+					"route_type": "L", "transition": "", "sequence": "020", "fix": "FERNE", "path_terminator": "CF",
+					"altitude_description": "+", "altitude": "02500", "recommended_navaid": "IHWD",
+					"course": 288.0, "course_true": false,
+				},
+			},
+		},
+		{
+			name:  "leg to VOR",
+			props: map[string]interface{}{"procedure": "L28L", "fix": "SJC"},
+			want: &Feature{
+				Type:     "Feature",
+				Geometry: &Geometry{Type: "Point", Coordinates: []float64{-121.949461, 37.379392}},
+			},
+		},
+		{
+			name:  "leg to enroute waypoint",
+			props: map[string]interface{}{"procedure": "R28L", "fix": "SUNOL"},
+			want: &Feature{
+				Type:     "Feature",
+				Geometry: &Geometry{Type: "Point", Coordinates: []float64{-121.873539, 37.605106}},
+			},
+		},
+		{
+			name:  "leg to missing fix",
+			props: map[string]interface{}{"fix": "OAK"},
+			want:  &Feature{Type: "Feature"},
+		},
+	} {
+		got := find(fc, tc.props)
+		if got == nil {
+			t.Errorf("Write() wrote no %s feature", tc.name)
+			continue
+		}
+		opts := []cmp.Option{cmpopts.EquateApprox(0, 1e-6)}
+		if tc.want.Properties == nil {
+			opts = append(opts, cmpopts.IgnoreFields(Feature{}, "Properties"))
+		}
+		if diff := cmp.Diff(tc.want, got, opts...); diff != "" {
+			t.Errorf("Write() wrote unexpected %s feature (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	var b bytes.Buffer
+	n, err := Write(&b, strings.NewReader(testData(t)), "")
+	if err != nil {
+		t.Fatalf("Write() = _, %v want _, <nil>", err)
+	}
+	if n != 88 {
+		t.Errorf("Write() = %d, _ want 88, _", n)
+	}
+}
+
+func TestWriteNoFeatures(t *testing.T) {
+	var b bytes.Buffer
+	if _, err := Write(&b, strings.NewReader(testData(t)), "KJFK"); err != ErrNoFeatures {
+		t.Errorf("Write() = _, %v want _, %v", err, ErrNoFeatures)
+	}
+	if b.Len() != 0 {
+		t.Errorf("Write() wrote %q want nothing", b.String())
+	}
+}
+
+func TestPublish(t *testing.T) {
+	data := testData(t)
+	s := &blobtest.Storage{}
+	p := &Publisher{Storage: s}
+	a := &pipeline.Artifact{Cycle: "06/18/2020", Data: strings.NewReader(data), Size: int64(len(data))}
+	if err := p.Publish(context.Background(), a); err != nil {
+		t.Fatalf("Publish() = %v want <nil>", err)
+	}
+	fc := decode(t, []byte(s.Objects["geojson/06-18-2020.geojson"]))
+	if got, want := len(fc.Features), 88; got != want {
+		t.Errorf("Publish() stored %d features want %d", got, want)
+	}
+	if diff := cmp.Diff([]string{"geojson/06-18-2020.geojson"}, s.Public); diff != "" {
+		t.Errorf("Publish() made unexpected objects public (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,57 @@
+package geojson
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+)
+
+type storageClient interface {
+	NewObject(_ context.Context, fileName string) io.WriteCloser
+	AllowPublicAccess(_ context.Context, fileName string) error
+}
+
+// ObjectName returns the name in storage of the features of cycle.
+func ObjectName(cycle string) string {
+	return "geojson/" + strings.Replace(cycle, "/", "-", -1) + ".geojson"
+}
+
+// AirportObjectName returns the name in storage of the features of the
+// airport icao in cycle, generated from the processed data with checksum. The
+// checksum changes when the cycle is reprocessed, so features of the data it
+// replaced are not served.
+func AirportObjectName(cycle, checksum, icao string) string {
+	return path.Join("geojson", strings.Replace(cycle, "/", "-", -1), "airports", checksum, icao+".geojson")
+}
+
+// Publisher is a pipeline.Publisher that stores the features of the
+// processed data of each cycle, publicly.
+type Publisher struct {
+	Storage storageClient
+}
+
+// Publish stores the features of a.
+func (p *Publisher) Publish(ctx context.Context, a *pipeline.Artifact) error {
+	name := ObjectName(a.Cycle)
+	// The features of a whole cycle are too large to keep in memory, so they
+	// are written as they are read.
+	w := p.Storage.NewObject(ctx, name)
+	n, err := Write(w, a.Reader(), "")
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("could not write features: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("could not write features: %v", err)
+	}
+	if err := p.Storage.AllowPublicAccess(ctx, name); err != nil {
+		return fmt.Errorf("could not set public access on features: %v", err)
+	}
+	logging.Infof(ctx, "Published %d features of %s.", n, a.Cycle)
+	return nil
+}
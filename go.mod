@@ -3,11 +3,20 @@ module github.com/wallaceicy06/webapp-enhance-faa-cifp
 go 1.13
 
 require (
-	cloud.google.com/go v0.72.0
+	cloud.google.com/go v0.72.0 // indirect
 	cloud.google.com/go/firestore v1.3.0
 	cloud.google.com/go/storage v1.12.0
-	github.com/google/go-cmp v0.5.3
+	github.com/google/go-cmp v0.5.4
+	github.com/mattn/go-sqlite3 v1.14.5
 	github.com/phayes/freeport v0.0.0-20180830031419-95f893ade6f2
+	github.com/prometheus/client_golang v1.8.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/wallaceicy06/enhance-faa-cifp v1.1.5
+	go.opentelemetry.io/otel v0.15.0
+	go.opentelemetry.io/otel/exporters/otlp v0.15.0
+	go.opentelemetry.io/otel/exporters/stdout v0.15.0
+	go.opentelemetry.io/otel/sdk v0.15.0
+	golang.org/x/oauth2 v0.0.0-20200902213428-5d25da1a8d43
 	google.golang.org/api v0.35.0
+	google.golang.org/grpc v1.33.2
 )
@@ -0,0 +1,303 @@
+// Package admin serves the browser admin console, where admins sign in with
+// OAuth 2.0 to trigger processing, watch runs and manage cycles.
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/auth"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/templates"
+	"golang.org/x/oauth2"
+)
+
+const (
+	stateCookie       = "oauth_state"
+	defaultRunTimeout = 120 * time.Second
+)
+
+type cyclesStore interface {
+	Get(context.Context, string) (*db.Cycle, error)
+	List(context.Context) ([]*db.Cycle, error)
+	SetHidden(_ context.Context, name string, hidden bool) error
+}
+
+type processor interface {
+	Process(context.Context) error
+	Reprocess(context.Context, *db.Cycle) error
+}
+
+type identifier interface {
+	Identify(*auth.Claims) *auth.Identity
+}
+
+// Handler serves the admin console under Prefix. Callers sign in with the
+// OAuth 2.0 authorization code flow and must be granted auth.RoleAdmin by
+// Identities.
+type Handler struct {
+	Prefix string
+	// OAuth is the client used to sign in. Its RedirectURL must point at
+	// <Prefix>/callback and its scopes must include "openid" and "email".
+	// Signing in is disabled if it is nil, which is only useful together
+	// with insecure Sessions.
+	OAuth *oauth2.Config
+	// Verifier verifies the ID tokens returned by the OAuth server, which
+	// are minted for the OAuth client ID.
+	Verifier   auth.TokenVerifier
+	Identities identifier
+	Sessions   *auth.Sessions
+	Cycles     cyclesStore
+	Processor  processor
+	Runs       *runs.Tracker
+	// RunTimeout limits runs started from the console. It defaults to 120
+	// seconds.
+	RunTimeout time.Duration
+
+	running sync.WaitGroup
+}
+
+type dashboardValues struct {
+	Prefix       string
+	Identity     *auth.Identity
+	CSRFToken    string
+	Running      bool
+	Runs         []runs.Run
+	Errors       []runs.Run
+	Cycles       []*db.Cycle
+	DisplayError string
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, h.Prefix)
+	if (path == "/login" || path == "/callback") && h.OAuth == nil {
+		http.Error(w, "Sign in is not configured.", http.StatusNotFound)
+		return
+	}
+	switch path {
+	case "/login":
+		h.login(w, r)
+	case "/callback":
+		h.callback(w, r)
+	default:
+		h.Sessions.Require(auth.RoleAdmin, h.Prefix+"/login", http.HandlerFunc(h.console)).ServeHTTP(w, r)
+	}
+}
+
+// console serves the pages that require an admin session.
+func (h *Handler) console(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, h.Prefix)
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		switch path {
+		case "", "/":
+			h.dashboard(w, r)
+		case "/runs":
+			h.listRuns(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	switch path {
+	case "/logout":
+		h.Sessions.End(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	case "/process":
+		h.start(r, "process latest", h.Processor.Process)
+		h.redirectHome(w, r)
+	case "/cycles/reprocess":
+		h.reprocess(w, r)
+	case "/cycles/hide":
+		h.hide(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) redirectHome(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, h.Prefix+"/", http.StatusSeeOther)
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logging.Errorf(r.Context(), "Could not generate OAuth state: %v", err)
+		http.Error(w, "Could not sign in.", http.StatusInternalServerError)
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     h.Prefix,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, h.OAuth.AuthCodeURL(state), http.StatusFound)
+}
+
+func (h *Handler) callback(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: h.Prefix, MaxAge: -1, HttpOnly: true, Secure: true})
+	q := r.URL.Query()
+	c, err := r.Cookie(stateCookie)
+	if err != nil || q.Get("state") == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(q.Get("state"))) != 1 {
+		http.Error(w, "Invalid sign in state, please try again.", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		logging.Warningf(r.Context(), "OAuth server returned error %q: %s", e, q.Get("error_description"))
+		http.Error(w, "Sign in was not completed.", http.StatusUnauthorized)
+		return
+	}
+	tok, err := h.OAuth.Exchange(r.Context(), q.Get("code"))
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not exchange OAuth code: %v", err)
+		http.Error(w, "Could not sign in.", http.StatusUnauthorized)
+		return
+	}
+	idToken, _ := tok.Extra("id_token").(string)
+	if idToken == "" {
+		logging.Errorf(r.Context(), "OAuth server did not return an ID token.")
+		http.Error(w, "Could not sign in.", http.StatusUnauthorized)
+		return
+	}
+	claims, err := h.Verifier.Verify(r.Context(), idToken)
+	if err != nil {
+		logging.Warningf(r.Context(), "Could not verify ID token: %v", err)
+		http.Error(w, "Could not sign in.", http.StatusUnauthorized)
+		return
+	}
+	id := h.Identities.Identify(claims)
+	if !id.HasRole(auth.RoleAdmin) {
+		logging.Warningf(r.Context(), "Rejected admin sign in for %s: missing role %q.", id, auth.RoleAdmin)
+		http.Error(w, fmt.Sprintf("%s is not an admin.", claims.Email), http.StatusForbidden)
+		return
+	}
+	if err := h.Sessions.Start(w, id); err != nil {
+		logging.Errorf(r.Context(), "Could not start session: %v", err)
+		http.Error(w, "Could not sign in.", http.StatusInternalServerError)
+		return
+	}
+	logging.Infof(r.Context(), "Admin %s signed in.", id)
+	h.redirectHome(w, r)
+}
+
+func (h *Handler) dashboard(w http.ResponseWriter, r *http.Request) {
+	dv := &dashboardValues{
+		Prefix:    h.Prefix,
+		Identity:  auth.IdentityFrom(r.Context()),
+		CSRFToken: auth.CSRFToken(r.Context()),
+		Runs:      h.Runs.Recent(),
+	}
+	for _, run := range dv.Runs {
+		if !run.Done() {
+			dv.Running = true
+		}
+		if run.Err != "" {
+			dv.Errors = append(dv.Errors, run)
+		}
+	}
+	cycles, err := h.Cycles.List(r.Context())
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not list cycles: %v", err)
+		dv.DisplayError = "Could not list cycles."
+	}
+	dv.Cycles = cycles
+
+	w.Header().Set("content-type", "text/html")
+	if err := templates.Admin.Execute(w, dv); err != nil {
+		logging.Errorf(r.Context(), "Could not execute template: %v", err)
+	}
+}
+
+func (h *Handler) listRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	recent := h.Runs.Recent()
+	if recent == nil {
+		recent = []runs.Run{}
+	}
+	if err := json.NewEncoder(w).Encode(recent); err != nil {
+		logging.Errorf(r.Context(), "Could not write response: %v", err)
+	}
+}
+
+func (h *Handler) reprocess(w http.ResponseWriter, r *http.Request) {
+	name := r.PostFormValue("cycle")
+	c, err := h.Cycles.Get(r.Context(), name)
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not get cycle %q: %v", name, err)
+		http.Error(w, "Could not get cycle.", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.start(r, "reprocess "+name, func(ctx context.Context) error {
+		return h.Processor.Reprocess(ctx, c)
+	})
+	h.redirectHome(w, r)
+}
+
+func (h *Handler) hide(w http.ResponseWriter, r *http.Request) {
+	name := r.PostFormValue("cycle")
+	hidden, err := strconv.ParseBool(r.PostFormValue("hidden"))
+	if err != nil {
+		http.Error(w, "hidden must be true or false.", http.StatusBadRequest)
+		return
+	}
+	err = h.Cycles.SetHidden(r.Context(), name, hidden)
+	if errors.Is(err, db.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not update cycle %q: %v", name, err)
+		http.Error(w, "Could not update cycle.", http.StatusInternalServerError)
+		return
+	}
+	logging.Infof(r.Context(), "Cycle %q hidden set to %t by %s.", name, hidden, auth.IdentityFrom(r.Context()))
+	h.redirectHome(w, r)
+}
+
+// start runs f in the background on behalf of the caller of r, so that the
+// run outlives the request and its progress can be watched.
+func (h *Handler) start(r *http.Request, what string, f func(context.Context) error) {
+	id := auth.IdentityFrom(r.Context())
+	ctx, run := h.Runs.Start(auth.WithIdentity(logging.Detach(r.Context()), id), fmt.Sprintf("%s (%s)", id, what))
+	timeout := h.RunTimeout
+	if timeout == 0 {
+		timeout = defaultRunTimeout
+	}
+	logging.Infof(ctx, "Starting run %s to %s for %s.", run.ID(), what, id)
+	h.running.Add(1)
+	go func() {
+		defer h.running.Done()
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err := f(ctx)
+		if err != nil {
+			logging.Errorf(ctx, "Run %s failed: %v", run.ID(), err)
+		}
+		run.Finish(err)
+	}()
+}
@@ -0,0 +1,377 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/auth"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+	"golang.org/x/oauth2"
+)
+
+// newFakeOAuthServer returns an OAuth server whose token endpoint exchanges
+// the code "good-code" for the ID token idToken.
+func newFakeOAuthServer(idToken string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		if r.PostFormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+		res := map[string]string{"access_token": "access", "token_type": "Bearer"}
+		if idToken != "" {
+			res["id_token"] = idToken
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	return httptest.NewServer(mux)
+}
+
+type fakeVerifier struct{}
+
+// Verify accepts tokens of the form "email:verified".
+func (fakeVerifier) Verify(_ context.Context, token string) (*auth.Claims, error) {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid token")
+	}
+	return &auth.Claims{Issuer: auth.GoogleIssuerURL, Subject: parts[0], Email: parts[0], EmailVerified: parts[1] == "verified"}, nil
+}
+
+type fakeCycles struct {
+	cycles []*db.Cycle
+	err    error
+}
+
+func (f *fakeCycles) Get(_ context.Context, name string) (*db.Cycle, error) {
+	for _, c := range f.cycles {
+		if c.Name == name {
+			return c, f.err
+		}
+	}
+	return nil, f.err
+}
+
+func (f *fakeCycles) List(context.Context) ([]*db.Cycle, error) {
+	return f.cycles, f.err
+}
+
+func (f *fakeCycles) SetHidden(_ context.Context, name string, hidden bool) error {
+	if f.err != nil {
+		return f.err
+	}
+	for _, c := range f.cycles {
+		if c.Name == name {
+			c.Hidden = hidden
+			return nil
+		}
+	}
+	return fmt.Errorf("could not update cycle %q: %w", name, db.ErrNotFound)
+}
+
+type fakeProcessor struct {
+	err         error
+	processedBy *auth.Identity
+	reprocessed *db.Cycle
+}
+
+func (f *fakeProcessor) Process(ctx context.Context) error {
+	f.processedBy = auth.IdentityFrom(ctx)
+	runs.Stage(ctx, "enhancing")
+	return f.err
+}
+
+func (f *fakeProcessor) Reprocess(_ context.Context, c *db.Cycle) error {
+	f.reprocessed = c
+	return f.err
+}
+
+func newTestHandler(oauthURL string) *Handler {
+	return &Handler{
+		Prefix: "/admin",
+		OAuth: &oauth2.Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Endpoint:     oauth2.Endpoint{AuthURL: oauthURL + "/auth", TokenURL: oauthURL + "/token"},
+			RedirectURL:  "https://example.com/admin/callback",
+			Scopes:       []string{"openid", "email"},
+		},
+		Verifier: fakeVerifier{},
+		Identities: &auth.Middleware{Bindings: []auth.RoleBinding{{
+			Role:   auth.RoleAdmin,
+			Issuer: auth.GoogleIssuerURL,
+			Claims: map[string][]string{"email": {"admin@example.com"}},
+		}}},
+		Sessions:  &auth.Sessions{Key: []byte("test key"), Path: "/admin"},
+		Cycles:    &fakeCycles{cycles: []*db.Cycle{{Name: "06/18/2020", Processed: "processed/FAACIFP18_processed_06-18-2020"}}},
+		Processor: &fakeProcessor{},
+		Runs:      &runs.Tracker{},
+	}
+}
+
+func cookieNamed(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// signIn goes through the OAuth flow, exchanging code at the fake OAuth
+// server, and returns the response to the callback.
+func signIn(t *testing.T, h *Handler, code string, mangleState bool) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/login", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("login returned wrong status code: got %d want %d", rr.Code, http.StatusFound)
+	}
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("login redirected to invalid URL: %v", err)
+	}
+	if loc.Path != "/auth" || loc.Query().Get("client_id") != "client" {
+		t.Errorf("login redirected to %v want the OAuth server", loc)
+	}
+	state := cookieNamed(rr.Result().Cookies(), stateCookie)
+	if state == nil || state.Value != loc.Query().Get("state") {
+		t.Fatalf("login state cookie = %v want state %q", state, loc.Query().Get("state"))
+	}
+
+	q := url.Values{"code": {code}, "state": {state.Value}}
+	if mangleState {
+		q.Set("state", "forged")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/admin/callback?"+q.Encode(), nil)
+	req.AddCookie(state)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSignIn(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		idToken     string
+		code        string
+		mangleState bool
+		wantStatus  int
+	}{
+		{name: "Admin", idToken: "admin@example.com:verified", code: "good-code", wantStatus: http.StatusSeeOther},
+		{name: "NotAdmin", idToken: "pilot@example.com:verified", code: "good-code", wantStatus: http.StatusForbidden},
+		{name: "UnverifiedEmail", idToken: "admin@example.com:unverified", code: "good-code", wantStatus: http.StatusForbidden},
+		{name: "InvalidIDToken", idToken: "garbage", code: "good-code", wantStatus: http.StatusUnauthorized},
+		{name: "NoIDToken", code: "good-code", wantStatus: http.StatusUnauthorized},
+		{name: "BadCode", idToken: "admin@example.com:verified", code: "bad-code", wantStatus: http.StatusUnauthorized},
+		{name: "ForgedState", idToken: "admin@example.com:verified", code: "good-code", mangleState: true, wantStatus: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeOAuthServer(tt.idToken)
+			defer srv.Close()
+			h := newTestHandler(srv.URL)
+
+			rr := signIn(t, h, tt.code, tt.mangleState)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("callback returned wrong status code: got %d want %d", rr.Code, tt.wantStatus)
+			}
+			session := cookieNamed(rr.Result().Cookies(), auth.SessionCookie)
+			if tt.wantStatus != http.StatusSeeOther {
+				if session != nil {
+					t.Errorf("callback set session cookie for rejected sign in")
+				}
+				return
+			}
+			if got := rr.Header().Get("Location"); got != "/admin/" {
+				t.Errorf("callback redirected to %q want %q", got, "/admin/")
+			}
+			if session == nil {
+				t.Fatal("callback did not set a session cookie")
+			}
+			req := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+			req.AddCookie(session)
+			rr = httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "admin@example.com") {
+				t.Errorf("dashboard after sign in = %d %q want the dashboard", rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestSignInRequired(t *testing.T) {
+	h := newTestHandler("https://oauth.example.com")
+	for _, tt := range []struct {
+		method     string
+		wantStatus int
+	}{
+		{method: http.MethodGet, wantStatus: http.StatusSeeOther},
+		{method: http.MethodPost, wantStatus: http.StatusUnauthorized},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tt.method, "/admin/process", nil))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s returned wrong status code: got %d want %d", tt.method, rr.Code, tt.wantStatus)
+		}
+	}
+}
+
+var csrfPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// session signs in as an admin and returns the session cookie and its CSRF
+// token, read from the dashboard.
+func session(t *testing.T, h *Handler) (*http.Cookie, string) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	if err := h.Sessions.Start(rr, &auth.Identity{Email: "admin@example.com", Roles: []auth.Role{auth.RoleAdmin}}); err != nil {
+		t.Fatalf("could not start session: %v", err)
+	}
+	cookie := cookieNamed(rr.Result().Cookies(), auth.SessionCookie)
+	req := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	m := csrfPattern.FindStringSubmatch(rr.Body.String())
+	if m == nil {
+		t.Fatalf("dashboard has no CSRF token: %s", rr.Body.String())
+	}
+	return cookie, m[1]
+}
+
+func post(h *Handler, path string, cookie *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestProcess(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		err        error
+		wantStage  string
+		wantErrors bool
+	}{
+		{name: "Good", wantStage: "done"},
+		{name: "Failed", err: errors.New("FAA unavailable"), wantStage: "failed", wantErrors: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler("https://oauth.example.com")
+			p := &fakeProcessor{err: tt.err}
+			h.Processor = p
+			cookie, csrf := session(t, h)
+
+			if rr := post(h, "/admin/process", cookie, url.Values{}); rr.Code != http.StatusForbidden {
+				t.Errorf("process without CSRF token returned %d want %d", rr.Code, http.StatusForbidden)
+			}
+			rr := post(h, "/admin/process", cookie, url.Values{auth.CSRFField: {csrf}})
+			if rr.Code != http.StatusSeeOther {
+				t.Fatalf("process returned wrong status code: got %d want %d", rr.Code, http.StatusSeeOther)
+			}
+			h.running.Wait()
+
+			if p.processedBy == nil || p.processedBy.Email != "admin@example.com" {
+				t.Errorf("processed by %v want admin@example.com", p.processedBy)
+			}
+			recent := h.Runs.Recent()
+			if len(recent) != 1 || recent[0].Stage != tt.wantStage {
+				t.Fatalf("runs = %+v want one run in stage %q", recent, tt.wantStage)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+			req.AddCookie(cookie)
+			rr = httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			body := rr.Body.String()
+			if !strings.Contains(body, recent[0].ID) {
+				t.Errorf("dashboard does not list run %s", recent[0].ID)
+			}
+			if gotErrors := tt.err != nil && strings.Contains(body, tt.err.Error()); gotErrors != tt.wantErrors {
+				t.Errorf("dashboard lists run error = %t want %t", gotErrors, tt.wantErrors)
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "/admin/runs", nil)
+			req.AddCookie(cookie)
+			rr = httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			var got []runs.Run
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("could not decode runs: %v", err)
+			}
+			if diff := cmp.Diff(recent, got); diff != "" {
+				t.Errorf("runs diff (-want +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestReprocess(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		cycle      string
+		wantStatus int
+	}{
+		{name: "Good", cycle: "06/18/2020", wantStatus: http.StatusSeeOther},
+		{name: "Missing", cycle: "07/16/2020", wantStatus: http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler("https://oauth.example.com")
+			p := &fakeProcessor{}
+			h.Processor = p
+			cookie, csrf := session(t, h)
+
+			rr := post(h, "/admin/cycles/reprocess", cookie, url.Values{auth.CSRFField: {csrf}, "cycle": {tt.cycle}})
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("reprocess returned wrong status code: got %d want %d", rr.Code, tt.wantStatus)
+			}
+			h.running.Wait()
+			if reprocessed := p.reprocessed != nil && p.reprocessed.Name == tt.cycle; reprocessed != (tt.wantStatus == http.StatusSeeOther) {
+				t.Errorf("reprocessed %v, want %q reprocessed %t", p.reprocessed, tt.cycle, tt.wantStatus == http.StatusSeeOther)
+			}
+		})
+	}
+}
+
+func TestHide(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		cycle      string
+		hidden     string
+		wantStatus int
+		wantHidden bool
+	}{
+		{name: "Hide", cycle: "06/18/2020", hidden: "true", wantStatus: http.StatusSeeOther, wantHidden: true},
+		{name: "Show", cycle: "06/18/2020", hidden: "false", wantStatus: http.StatusSeeOther},
+		{name: "Missing", cycle: "07/16/2020", hidden: "true", wantStatus: http.StatusNotFound},
+		{name: "InvalidHidden", cycle: "06/18/2020", hidden: "maybe", wantStatus: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler("https://oauth.example.com")
+			cycles := &fakeCycles{cycles: []*db.Cycle{{Name: "06/18/2020"}}}
+			h.Cycles = cycles
+			cookie, csrf := session(t, h)
+
+			rr := post(h, "/admin/cycles/hide", cookie, url.Values{auth.CSRFField: {csrf}, "cycle": {tt.cycle}, "hidden": {tt.hidden}})
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("hide returned wrong status code: got %d want %d", rr.Code, tt.wantStatus)
+			}
+			if got := cycles.cycles[0].Hidden; got != tt.wantHidden {
+				t.Errorf("cycle hidden = %t want %t", got, tt.wantHidden)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Package airport serves a page to search the airports of the latest cycle
+// and compare their localizers before and after enhancement.
+package airport
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/airports"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/templates"
+)
+
+// maxResults is the most airports listed for a search.
+const maxResults = 20
+
+type cyclesLister interface {
+	List(context.Context) ([]*db.Cycle, error)
+}
+
+type airportStore interface {
+	Index(context.Context, *db.Cycle) (*airports.Index, error)
+	Airport(_ context.Context, c *db.Cycle, icao string) (*airports.Airport, error)
+	Original(_ context.Context, c *db.Cycle, icao string) (*airports.Airport, error)
+}
+
+// Handler serves the airport search page. GET ?q=<query> lists the airports
+// of the latest public cycle whose identifier starts with the query or
+// whose name contains it. If one airport matches, or one has the query as its
+// identifier, its localizers are compared with those of the original data.
+type Handler struct {
+	Cycles   cyclesLister
+	Airports airportStore
+}
+
+type values struct {
+	Path         string
+	Query        string
+	Cycle        *db.Cycle
+	Results      []*airports.Entry
+	Airport      *airports.Entry
+	Localizers   []*airports.LocalizerComparison
+	DisplayError string
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	v := &values{Path: r.URL.Path, Query: strings.TrimSpace(r.FormValue("q"))}
+	if v.Query != "" {
+		h.search(r.Context(), v)
+	}
+	w.Header().Set("content-type", "text/html")
+	if err := templates.Airport.Execute(w, v); err != nil {
+		logging.Errorf(r.Context(), "Could not execute template: %v", err)
+	}
+}
+
+// search fills in the results of v.Query, or v.DisplayError.
+func (h *Handler) search(ctx context.Context, v *values) {
+	cycles, err := h.Cycles.List(ctx)
+	if err != nil {
+		logging.Errorf(ctx, "Could not list cycles: %v", err)
+		v.DisplayError = "Could not get the latest cycle. Please try again later."
+		return
+	}
+	for _, c := range cycles {
+		if !c.Hidden {
+			v.Cycle = c
+			break
+		}
+	}
+	if v.Cycle == nil {
+		v.DisplayError = "No cycles have been processed yet."
+		return
+	}
+	ix, err := h.Airports.Index(ctx, v.Cycle)
+	if err != nil {
+		logging.Errorf(ctx, "Could not get index of %s: %v", v.Cycle.Name, err)
+		v.DisplayError = "Could not search airports. Please try again later."
+		return
+	}
+	v.Results = ix.Search(v.Query, maxResults)
+	if len(v.Results) == 1 || (len(v.Results) > 0 && v.Results[0].ICAO == strings.ToUpper(v.Query)) {
+		v.Airport = v.Results[0]
+	}
+	if v.Airport == nil {
+		return
+	}
+
+	processed, err := h.Airports.Airport(ctx, v.Cycle, v.Airport.ICAO)
+	if err != nil {
+		logging.Errorf(ctx, "Could not get %s in %s: %v", v.Airport.ICAO, v.Cycle.Name, err)
+		v.DisplayError = "Could not get the processed data of the airport. Please try again later."
+		return
+	}
+	original, err := h.Airports.Original(ctx, v.Cycle, v.Airport.ICAO)
+	if err != nil {
+		logging.Errorf(ctx, "Could not get original %s in %s: %v", v.Airport.ICAO, v.Cycle.Name, err)
+		v.DisplayError = "Could not get the original data of the airport. Please try again later."
+		return
+	}
+	v.Localizers = airports.CompareLocalizers(original, processed)
+}
@@ -0,0 +1,142 @@
+package airport
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/airports"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/blob/blobtest"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+type fakeCyclesLister struct {
+	Cycles []*db.Cycle
+	Err    error
+}
+
+func (fl *fakeCyclesLister) List(context.Context) ([]*db.Cycle, error) {
+	return fl.Cycles, fl.Err
+}
+
+func newHandler(t *testing.T, lister *fakeCyclesLister) *Handler {
+	t.Helper()
+	storage := &blobtest.Storage{Objects: make(map[string]string)}
+	for name, file := range map[string]string{
+		"FAACIFP18-06-18-2020":           "pipeline/original.zip",
+		"FAACIFP18-06-18-2020_processed": "pipeline/want_processed.txt",
+	} {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("could not read test data: %v", err)
+		}
+		storage.Objects[name] = string(b)
+	}
+	return &Handler{
+		Cycles:   lister,
+		Airports: &airports.Store{Storage: storage},
+	}
+}
+
+func TestAirportHandler(t *testing.T) {
+	cycles := []*db.Cycle{
+		{Name: "07/16/2020", Original: "FAACIFP18-07-16-2020", Processed: "FAACIFP18-07-16-2020_processed", Hidden: true},
+		{Name: "06/18/2020", Original: "FAACIFP18-06-18-2020", Processed: "FAACIFP18-06-18-2020_processed"},
+	}
+	for _, tt := range []struct {
+		name        string
+		query       string
+		lister      *fakeCyclesLister
+		wantBody    []string
+		notWantBody []string
+	}{
+		{
+			name:        "NoQuery",
+			lister:      &fakeCyclesLister{Cycles: cycles},
+			notWantBody: []string{"<h2>Airports</h2>", "IHWD"},
+		},
+		{
+			name:   "Airport",
+			query:  "khwd",
+			lister: &fakeCyclesLister{Cycles: cycles},
+			wantBody: []string{
+				"<h2>KHWD HAYWARD EXECUTIVE</h2>",
+				"Localizers in cycle 06/18/2020.",
+				"<td>IHWD</td>",
+				"<td>RW28L</td>",
+				"<td>287.9&deg;M (302.90&deg;T)</td>",
+				"<td>303.05&deg;T</td>",
+				"<td>&#43;0.15&deg;</td>",
+				"<td>No</td>",
+				`<a href="/airports?q=KHWD">KHWD</a>`,
+			},
+		},
+		{
+			name:     "Name",
+			query:    "hayward",
+			lister:   &fakeCyclesLister{Cycles: cycles},
+			wantBody: []string{"<h2>KHWD HAYWARD EXECUTIVE</h2>", "<td>IHWD</td>"},
+		},
+		{
+			name:        "NoMatch",
+			query:       "KJFK",
+			lister:      &fakeCyclesLister{Cycles: cycles},
+			wantBody:    []string{"No airports in cycle 06/18/2020 match KJFK."},
+			notWantBody: []string{"IHWD"},
+		},
+		{
+			name:     "NoCycles",
+			query:    "KHWD",
+			lister:   &fakeCyclesLister{Cycles: cycles[:1]},
+			wantBody: []string{"No cycles have been processed yet."},
+		},
+		{
+			name:     "ListError",
+			query:    "KHWD",
+			lister:   &fakeCyclesLister{Err: errors.New("list error")},
+			wantBody: []string{"Could not get the latest cycle."},
+		},
+		{
+			name:   "MissingOriginal",
+			query:  "KHWD",
+			lister: &fakeCyclesLister{Cycles: []*db.Cycle{{Name: "06/18/2020", Original: "missing", Processed: "FAACIFP18-06-18-2020_processed"}}},
+			wantBody: []string{
+				"Could not get the original data of the airport.",
+			},
+			notWantBody: []string{"<td>IHWD</td>"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/airports?q="+tt.query, nil)
+			rr := httptest.NewRecorder()
+			newHandler(t, tt.lister).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Errorf("unexpected status: got (%v) want (%v)", status, http.StatusOK)
+			}
+			body := rr.Body.String()
+			for _, want := range tt.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("body does not contain %q:\n%s", want, body)
+				}
+			}
+			for _, notWant := range tt.notWantBody {
+				if strings.Contains(body, notWant) {
+					t.Errorf("body contains %q:\n%s", notWant, body)
+				}
+			}
+		})
+	}
+}
+
+func TestAirportHandlerMethodNotAllowed(t *testing.T) {
+	rr := httptest.NewRecorder()
+	(&Handler{}).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/airports", nil))
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: got (%v) want (%v)", status, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,221 @@
+// Package api serves the records of the airports in each cycle as JSON, so
+// that tools can query CIFP data without downloading the whole file.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/airports"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/arinc424"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/subset"
+)
+
+type cycleGetter interface {
+	Get(context.Context, string) (*db.Cycle, error)
+}
+
+type airportGetter interface {
+	Airport(_ context.Context, c *db.Cycle, icao string) (*airports.Airport, error)
+}
+
+// Handler serves, under Prefix:
+//
+//	GET <Prefix>/cycles/<cycle>/airports/<ICAO>                  an airport
+//	GET <Prefix>/cycles/<cycle>/airports/<ICAO>/procedures/<id> the legs of a procedure
+//
+// where the cycle is named with dashes, as in 06-18-2020. Every record is
+// returned with its decoded fields and its lines.
+type Handler struct {
+	Cycles   cycleGetter
+	Airports airportGetter
+	Prefix   string
+}
+
+// record is a record as returned by the API.
+type record struct {
+	Fields   arinc424.Record `json:"fields"`
+	Position *position       `json:"position,omitempty"`
+	// Lines are the line of the record followed by those of its
+	// continuation records.
+	Lines []string `json:"lines"`
+}
+
+type position struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+type airportResponse struct {
+	Cycle      string       `json:"cycle"`
+	Airport    *record      `json:"airport"`
+	Runways    []*record    `json:"runways"`
+	Localizers []*record    `json:"localizers"`
+	GLS        []*record    `json:"gls"`
+	Waypoints  []*record    `json:"waypoints"`
+	Procedures []*procedure `json:"procedures"`
+	PathPoints []*record    `json:"path_points"`
+	MSAs       []*record    `json:"msas"`
+}
+
+// procedure is a SID, STAR or approach. Legs are only listed when a single
+// procedure is requested.
+type procedure struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Transitions []string  `json:"transitions"`
+	Legs        []*record `json:"legs,omitempty"`
+}
+
+type procedureResponse struct {
+	Cycle      string       `json:"cycle"`
+	Airport    string       `json:"airport"`
+	Procedures []*procedure `json:"procedures"`
+	// PathPoints are the final approach segments of approaches.
+	PathPoints []*record `json:"path_points,omitempty"`
+}
+
+var kinds = map[string]string{
+	arinc424.SID:      "sid",
+	arinc424.STAR:     "star",
+	arinc424.Approach: "approach",
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, h.Prefix), "/"), "/")
+	if (len(parts) != 4 && len(parts) != 6) || parts[0] != "cycles" || parts[2] != "airports" || (len(parts) == 6 && parts[4] != "procedures") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	icao := strings.ToUpper(parts[3])
+	if !subset.ValidAirport(icao) {
+		http.Error(w, fmt.Sprintf("Invalid airport %q, want an ICAO identifier such as KHWD.", parts[3]), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	c, err := h.Cycles.Get(ctx, strings.Replace(parts[1], "-", "/", -1))
+	if err != nil {
+		logging.Errorf(ctx, "Could not get cycle: %v", err)
+		http.Error(w, "Could not get cycle.", http.StatusInternalServerError)
+		return
+	}
+	if c == nil || c.Hidden {
+		http.Error(w, fmt.Sprintf("No cycle %s.", parts[1]), http.StatusNotFound)
+		return
+	}
+	a, err := h.Airports.Airport(ctx, c, icao)
+	if err == airports.ErrNoAirport {
+		http.Error(w, fmt.Sprintf("Airport %s is not in cycle %s.", icao, c.Name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.Errorf(ctx, "Could not get %s in %s: %v", icao, c.Name, err)
+		http.Error(w, "Could not get airport.", http.StatusInternalServerError)
+		return
+	}
+
+	var res interface{}
+	if len(parts) == 6 {
+		pr := procedureResponse{Cycle: c.Name, Airport: icao, Procedures: procedures(a.Legs(parts[5]), true)}
+		if len(pr.Procedures) == 0 {
+			http.Error(w, fmt.Sprintf("No procedure %s at %s.", parts[5], icao), http.StatusNotFound)
+			return
+		}
+		for _, p := range a.PathPoints {
+			if p.Approach == parts[5] {
+				pr.PathPoints = append(pr.PathPoints, newRecord(p))
+			}
+		}
+		res = pr
+	} else {
+		res = newAirportResponse(c, a)
+	}
+	w.Header().Set("content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logging.Errorf(ctx, "Could not write response: %v", err)
+	}
+}
+
+func newAirportResponse(c *db.Cycle, a *airports.Airport) *airportResponse {
+	res := &airportResponse{
+		Cycle:      c.Name,
+		Airport:    newRecord(a.Airport),
+		Runways:    []*record{},
+		Localizers: []*record{},
+		GLS:        []*record{},
+		Waypoints:  []*record{},
+		Procedures: procedures(a.Procedures, false),
+		PathPoints: []*record{},
+		MSAs:       []*record{},
+	}
+	for _, r := range a.Runways {
+		res.Runways = append(res.Runways, newRecord(r))
+	}
+	for _, l := range a.Localizers {
+		res.Localizers = append(res.Localizers, newRecord(l))
+	}
+	for _, g := range a.GLS {
+		res.GLS = append(res.GLS, newRecord(g))
+	}
+	for _, wp := range a.Waypoints {
+		res.Waypoints = append(res.Waypoints, newRecord(wp))
+	}
+	for _, p := range a.PathPoints {
+		res.PathPoints = append(res.PathPoints, newRecord(p))
+	}
+	for _, m := range a.MSAs {
+		res.MSAs = append(res.MSAs, newRecord(m))
+	}
+	return res
+}
+
+// procedures groups legs into procedures, in the order they first appear.
+func procedures(legs []*arinc424.Procedure, withLegs bool) []*procedure {
+	res := []*procedure{}
+	byKey := make(map[string]*procedure)
+	transitions := make(map[string]bool)
+	for _, l := range legs {
+		key := l.Subsection + l.Ident
+		p, ok := byKey[key]
+		if !ok {
+			p = &procedure{ID: l.Ident, Kind: kinds[l.Subsection], Transitions: []string{}}
+			byKey[key] = p
+			res = append(res, p)
+		}
+		if l.Transition != "" && !transitions[key+"/"+l.Transition] {
+			transitions[key+"/"+l.Transition] = true
+			p.Transitions = append(p.Transitions, l.Transition)
+		}
+		if withLegs {
+			p.Legs = append(p.Legs, newRecord(l))
+		}
+	}
+	return res
+}
+
+type positioned interface {
+	Position() (lat, lon float64, err error)
+}
+
+func newRecord(r arinc424.Record) *record {
+	res := &record{Fields: r}
+	if p, ok := r.(positioned); ok {
+		if lat, lon, err := p.Position(); err == nil {
+			res.Position = &position{Lat: lat, Lon: lon}
+		}
+	}
+	// Parsed records always format.
+	res.Lines, _ = arinc424.Lines(r)
+	return res
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/airports"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/blob/blobtest"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+type fakeCycles map[string]*db.Cycle
+
+func (f fakeCycles) Get(_ context.Context, name string) (*db.Cycle, error) {
+	return f[name], nil
+}
+
+func newHandler(t *testing.T) *Handler {
+	t.Helper()
+	processed, err := ioutil.ReadFile("../../pipeline/want_processed.txt")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	return &Handler{
+		Prefix: "/api/v1",
+		Cycles: fakeCycles{
+			"06/18/2020": {Name: "06/18/2020", Processed: "FAACIFP18-06-18-2020_processed"},
+			"05/21/2020": {Name: "05/21/2020", Processed: "FAACIFP18-05-21-2020_processed", Hidden: true},
+		},
+		Airports: &airports.Store{Storage: &blobtest.Storage{Objects: map[string]string{"FAACIFP18-06-18-2020_processed": string(processed)}}},
+	}
+}
+
+func TestServeHTTPErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{name: "missing airport", method: http.MethodGet, path: "/api/v1/cycles/06-18-2020/airports/KJFK", wantStatus: http.StatusNotFound},
+		{name: "missing procedure", method: http.MethodGet, path: "/api/v1/cycles/06-18-2020/airports/KHWD/procedures/I28R", wantStatus: http.StatusNotFound},
+		{name: "invalid airport", method: http.MethodGet, path: "/api/v1/cycles/06-18-2020/airports/K-HWD", wantStatus: http.StatusBadRequest},
+		{name: "missing cycle", method: http.MethodGet, path: "/api/v1/cycles/01-02-2020/airports/KHWD", wantStatus: http.StatusNotFound},
+		{name: "hidden cycle", method: http.MethodGet, path: "/api/v1/cycles/05-21-2020/airports/KHWD", wantStatus: http.StatusNotFound},
+		{name: "unknown path", method: http.MethodGet, path: "/api/v1/cycles/06-18-2020/airports/KHWD/runways", wantStatus: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodPost, path: "/api/v1/cycles/06-18-2020/airports/KHWD", wantStatus: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			newHandler(t).ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServeHTTPAirport(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newHandler(t).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/cycles/06-18-2020/airports/khwd", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+	}
+	var got struct {
+		Cycle   string `json:"cycle"`
+		Airport struct {
+			Fields struct {
+				Airport string `json:"airport"`
+				Name    string `json:"name"`
+			} `json:"fields"`
+			Position position `json:"position"`
+			Lines    []string `json:"lines"`
+		} `json:"airport"`
+		Runways    []json.RawMessage `json:"runways"`
+		Localizers []struct {
+			Fields struct {
+				Ident   string `json:"ident"`
+				Bearing string `json:"bearing"`
+			} `json:"fields"`
+			Lines []string `json:"lines"`
+		} `json:"localizers"`
+		Waypoints  []json.RawMessage `json:"waypoints"`
+		Procedures []*procedure      `json:"procedures"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if got.Cycle != "06/18/2020" || got.Airport.Fields.Airport != "KHWD" || got.Airport.Fields.Name != "HAYWARD EXECUTIVE" {
+		t.Errorf("handler returned airport %+v of %q want KHWD of 06/18/2020", got.Airport.Fields, got.Cycle)
+	}
+	if lat, lon := got.Airport.Position.Lat, got.Airport.Position.Lon; lat < 37.658 || lat > 37.659 || lon < -122.122 || lon > -122.121 {
+		t.Errorf("handler returned position %v, %v want about 37.6589, -122.1217", lat, lon)
+	}
+	if len(got.Airport.Lines) != 1 || !strings.HasPrefix(got.Airport.Lines[0], "SUSAP KHWDK2AHWD") {
+		t.Errorf("handler returned airport lines %q want the reference point record", got.Airport.Lines)
+	}
+	if len(got.Runways) != 4 || len(got.Waypoints) != 16 {
+		t.Errorf("handler returned %d runways and %d waypoints want 4 and 16", len(got.Runways), len(got.Waypoints))
+	}
+	if len(got.Localizers) != 1 || got.Localizers[0].Fields.Ident != "IHWD" || got.Localizers[0].Fields.Bearing != "2879" || len(got.Localizers[0].Lines) != 2 {
+		t.Errorf("handler returned localizers %+v want IHWD with a bearing of 2879 and a continuation record", got.Localizers)
+	}
+	wantProcedures := []*procedure{
+		{ID: "PXN6", Kind: "star", Transitions: []string{"AVE", "GMN", "ALL"}},
+		{ID: "SHARR1", Kind: "star", Transitions: []string{"MRLET", "RPARK", "RUSME", "ALL"}},
+		{ID: "L28L", Kind: "approach", Transitions: []string{"SJC"}},
+		{ID: "R28L", Kind: "approach", Transitions: []string{"SJC", "SUNOL", "VINCO"}},
+		{ID: "VDM-A", Kind: "approach", Transitions: []string{"SJC"}},
+	}
+	if diff := cmp.Diff(wantProcedures, got.Procedures); diff != "" {
+		t.Errorf("handler returned unexpected procedures (-want +got):\n%s", diff)
+	}
+}
+
+func TestServeHTTPProcedure(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newHandler(t).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/cycles/06-18-2020/airports/KHWD/procedures/R28L", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+	}
+	var got struct {
+		Airport    string `json:"airport"`
+		Procedures []struct {
+			ID   string `json:"id"`
+			Kind string `json:"kind"`
+			Legs []struct {
+				Fields struct {
+					Transition     string `json:"transition"`
+					Sequence       string `json:"sequence"`
+					Fix            string `json:"fix"`
+					PathTerminator string `json:"path_terminator"`
+				} `json:"fields"`
+				Lines []string `json:"lines"`
+			} `json:"legs"`
+		} `json:"procedures"`
+		PathPoints []json.RawMessage `json:"path_points"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(got.Procedures) != 1 || got.Procedures[0].ID != "R28L" || got.Procedures[0].Kind != "approach" {
+		t.Fatalf("handler returned procedures %+v want approach R28L", got.Procedures)
+	}
+	legs := got.Procedures[0].Legs
+	if len(legs) != 19 {
+		t.Fatalf("handler returned %d legs want 19", len(legs))
+	}
+	if f := legs[0].Fields; f.Transition != "SJC" || f.Sequence != "010" || f.Fix != "SJC" || f.PathTerminator != "IF" {
+		t.Errorf("handler returned first leg %+v want IF to SJC", f)
+	}
+	// The leg to SUDGE has an SBAS continuation record.
+	var continued int
+	for _, l := range legs {
+		if len(l.Lines) > 1 {
+			continued++
+		}
+	}
+	if continued != 1 {
+		t.Errorf("handler returned %d legs with continuation records want 1", continued)
+	}
+	if len(got.PathPoints) != 1 {
+		t.Errorf("handler returned %d path points want 1", len(got.PathPoints))
+	}
+}
@@ -0,0 +1,174 @@
+// Package apikeys serves the admin endpoints that manage API keys.
+package apikeys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/auth"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+type apiKeyStore interface {
+	Add(context.Context, *db.APIKey) error
+	List(context.Context) ([]*db.APIKey, error)
+	Revoke(_ context.Context, id string, at time.Time) error
+}
+
+// Handler creates, lists and revokes API keys under Prefix:
+//
+//	POST   <Prefix>      creates a key from {"name", "role"} and returns its secret
+//	GET    <Prefix>      lists every key
+//	DELETE <Prefix>/<id> revokes a key
+//
+// It does not authenticate callers, so it must be wrapped in auth.Middleware
+// requiring the admin role.
+type Handler struct {
+	Keys   apiKeyStore
+	Prefix string
+	// Pepper is the server-side secret the secrets of keys are derived
+	// from. Keys cannot be created without it.
+	Pepper []byte
+
+	now func() time.Time
+}
+
+type createRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type createResponse struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// keyResponse is an API key as returned to admins, without its hash.
+type keyResponse struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Role     string     `json:"role"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+	Revoked  *time.Time `json:"revoked,omitempty"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.Prefix), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		h.create(w, r)
+	case id == "" && r.Method == http.MethodGet:
+		h.list(w, r)
+	case id != "" && r.Method == http.MethodDelete:
+		h.revoke(w, r, id)
+	case id == "":
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+	default:
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) timeNow() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Request body must be JSON with a name and role.", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Key must have a name.", http.StatusBadRequest)
+		return
+	}
+	switch role := auth.Role(req.Role); role {
+	case auth.RoleScheduler, auth.RoleReader, auth.RoleAdmin:
+	default:
+		http.Error(w, "Unknown role "+req.Role+".", http.StatusBadRequest)
+		return
+	}
+	key, secret, err := auth.NewAPIKey(h.Pepper, req.Name, auth.Role(req.Role), h.timeNow())
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not create API key: %v", err)
+		http.Error(w, "Could not create API key.", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Keys.Add(r.Context(), key); err != nil {
+		logging.Errorf(r.Context(), "Could not add API key: %v", err)
+		http.Error(w, "Could not create API key.", http.StatusInternalServerError)
+		return
+	}
+	logging.Infof(r.Context(), "Created API key %q (%s) with role %s by %s.", key.ID, key.Name, key.Role, actor(r))
+	writeJSON(r.Context(), w, http.StatusCreated, &createResponse{ID: key.ID, Secret: secret})
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.Keys.List(r.Context())
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not list API keys: %v", err)
+		http.Error(w, "Could not list API keys.", http.StatusInternalServerError)
+		return
+	}
+	res := []*keyResponse{}
+	for _, k := range keys {
+		res = append(res, &keyResponse{
+			ID:       k.ID,
+			Name:     k.Name,
+			Role:     k.Role,
+			Created:  k.Created,
+			LastUsed: optionalTime(k.LastUsed),
+			Revoked:  optionalTime(k.RevokedAt),
+		})
+	}
+	writeJSON(r.Context(), w, http.StatusOK, res)
+}
+
+func (h *Handler) revoke(w http.ResponseWriter, r *http.Request, id string) {
+	err := h.Keys.Revoke(r.Context(), id, h.timeNow())
+	if errors.Is(err, db.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not revoke API key %q: %v", id, err)
+		http.Error(w, "Could not revoke API key.", http.StatusInternalServerError)
+		return
+	}
+	logging.Infof(r.Context(), "Revoked API key %q by %s.", id, actor(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// actor describes who made r, for logging.
+func actor(r *http.Request) string {
+	if id := auth.IdentityFrom(r.Context()); id != nil {
+		return id.String()
+	}
+	return "unknown caller"
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Errorf(ctx, "Could not write response: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package apikeys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+type fakeKeys struct {
+	keys []*db.APIKey
+	err  error
+}
+
+func (f *fakeKeys) Add(_ context.Context, k *db.APIKey) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.keys = append(f.keys, k)
+	return nil
+}
+
+func (f *fakeKeys) List(context.Context) ([]*db.APIKey, error) {
+	return f.keys, f.err
+}
+
+func (f *fakeKeys) Revoke(_ context.Context, id string, at time.Time) error {
+	if f.err != nil {
+		return f.err
+	}
+	for _, k := range f.keys {
+		if k.ID == id {
+			k.RevokedAt = at
+			return nil
+		}
+	}
+	return fmt.Errorf("could not update API key %q: %w", id, db.ErrNotFound)
+}
+
+var now = time.Date(2020, 7, 16, 12, 0, 0, 0, time.UTC)
+
+func TestCreate(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		body       string
+		storeErr   error
+		noPepper   bool
+		wantStatus int
+	}{
+		{
+			name:       "Good",
+			body:       `{"name": "cron", "role": "scheduler"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "NotJSON",
+			body:       `name=cron`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "NoName",
+			body:       `{"role": "scheduler"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "UnknownRole",
+			body:       `{"name": "cron", "role": "superuser"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "NoPepper",
+			body:       `{"name": "cron", "role": "scheduler"}`,
+			noPepper:   true,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "StoreError",
+			body:       `{"name": "cron", "role": "scheduler"}`,
+			storeErr:   errors.New("database unavailable"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := &fakeKeys{err: tt.storeErr}
+			h := &Handler{Keys: keys, Prefix: "/admin/apikeys", Pepper: []byte("pepper"), now: func() time.Time { return now }}
+			if tt.noPepper {
+				h.Pepper = nil
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/apikeys", strings.NewReader(tt.body)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusCreated {
+				if len(keys.keys) != 0 {
+					t.Errorf("stored %d keys want 0", len(keys.keys))
+				}
+				return
+			}
+			var got createResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if len(keys.keys) != 1 {
+				t.Fatalf("stored %d keys want 1", len(keys.keys))
+			}
+			stored := keys.keys[0]
+			if got.ID != stored.ID || got.Secret == "" {
+				t.Errorf("response = %+v want ID %q and a secret", got, stored.ID)
+			}
+			if strings.Contains(rr.Body.String(), stored.Hash) {
+				t.Error("response contains the key hash")
+			}
+			if stored.Name != "cron" || stored.Role != "scheduler" || !stored.Created.Equal(now) {
+				t.Errorf("stored key = %+v want name cron, role scheduler, created %v", stored, now)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	keys := &fakeKeys{keys: []*db.APIKey{
+		{ID: "a", Name: "cron", Role: "scheduler", Hash: "secrethash", Created: now, LastUsed: now.Add(time.Hour)},
+		{ID: "b", Name: "old", Role: "reader", Hash: "secrethash", Created: now, RevokedAt: now.Add(time.Minute)},
+	}}
+	h := &Handler{Keys: keys, Prefix: "/admin/apikeys"}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/apikeys", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+	}
+	if strings.Contains(rr.Body.String(), "secrethash") {
+		t.Error("response contains key hashes")
+	}
+	var got []*keyResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	used, revoked := now.Add(time.Hour), now.Add(time.Minute)
+	want := []*keyResponse{
+		{ID: "a", Name: "cron", Role: "scheduler", Created: now, LastUsed: &used},
+		{ID: "b", Name: "old", Role: "reader", Created: now, Revoked: &revoked},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("keys diff (-want +got): %s", diff)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{
+			name:       "Good",
+			method:     http.MethodDelete,
+			path:       "/admin/apikeys/a",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Missing",
+			method:     http.MethodDelete,
+			path:       "/admin/apikeys/missing",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "WrongMethod",
+			method:     http.MethodPost,
+			path:       "/admin/apikeys/a",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &db.APIKey{ID: "a", Name: "cron", Role: "scheduler", Created: now}
+			h := &Handler{Keys: &fakeKeys{keys: []*db.APIKey{key}}, Prefix: "/admin/apikeys", now: func() time.Time { return now }}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tt.wantStatus)
+			}
+			if wantRevoked := tt.wantStatus == http.StatusNoContent; key.Revoked() != wantRevoked {
+				t.Errorf("key revoked = %t want %t", key.Revoked(), wantRevoked)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Package deadletters serves the admin endpoints that list and replay webhook
+// events that could not be delivered.
+package deadletters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+type deadLetterLister interface {
+	List(context.Context) ([]*db.DeadLetter, error)
+}
+
+type replayer interface {
+	Replay(_ context.Context, id string) error
+}
+
+// Handler lists and replays dead letters under Prefix:
+//
+//	GET  <Prefix>             lists recent dead letters
+//	POST <Prefix>/<id>/replay delivers a dead letter again
+//
+// It does not authenticate callers, so it must be wrapped in auth.Middleware
+// requiring the admin role.
+type Handler struct {
+	DeadLetters deadLetterLister
+	Replayer    replayer
+	Prefix      string
+}
+
+// deadLetterResponse is a dead letter as returned to admins. The event is
+// included as JSON rather than as an escaped string.
+type deadLetterResponse struct {
+	ID         string          `json:"id"`
+	EventID    string          `json:"event_id"`
+	EventType  string          `json:"event_type"`
+	URL        string          `json:"url"`
+	Event      json.RawMessage `json:"event"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"last_error"`
+	Created    time.Time       `json:"created"`
+	ReplayedAt *time.Time      `json:"replayed_at,omitempty"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, h.Prefix), "/")
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		h.list(w, r)
+	case strings.HasSuffix(path, "/replay") && r.Method == http.MethodPost:
+		h.replay(w, r, strings.TrimSuffix(path, "/replay"))
+	case path == "":
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+	case strings.HasSuffix(path, "/replay"):
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	dls, err := h.DeadLetters.List(r.Context())
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not list dead letters: %v", err)
+		http.Error(w, "Could not list dead letters.", http.StatusInternalServerError)
+		return
+	}
+	res := []*deadLetterResponse{}
+	for _, dl := range dls {
+		d := &deadLetterResponse{
+			ID:        dl.ID,
+			EventID:   dl.EventID,
+			EventType: dl.EventType,
+			URL:       dl.URL,
+			Event:     json.RawMessage(dl.Payload),
+			Attempts:  dl.Attempts,
+			LastError: dl.LastError,
+			Created:   dl.Created,
+		}
+		if !dl.ReplayedAt.IsZero() {
+			replayed := dl.ReplayedAt
+			d.ReplayedAt = &replayed
+		}
+		res = append(res, d)
+	}
+	w.Header().Set("content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logging.Errorf(r.Context(), "Could not write response: %v", err)
+	}
+}
+
+func (h *Handler) replay(w http.ResponseWriter, r *http.Request, id string) {
+	err := h.Replayer.Replay(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not replay dead letter %q: %v", id, err)
+		http.Error(w, "Could not deliver event.", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package deadletters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+type fakeDeadLetters struct {
+	letters []*db.DeadLetter
+	err     error
+}
+
+func (f *fakeDeadLetters) List(context.Context) ([]*db.DeadLetter, error) {
+	return f.letters, f.err
+}
+
+type fakeReplayer struct {
+	replayed []string
+	err      error
+}
+
+func (f *fakeReplayer) Replay(_ context.Context, id string) error {
+	if id == "missing" {
+		return fmt.Errorf("could not replay dead letter %q: %w", id, db.ErrNotFound)
+	}
+	if f.err != nil {
+		return f.err
+	}
+	f.replayed = append(f.replayed, id)
+	return nil
+}
+
+func TestList(t *testing.T) {
+	created := time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC)
+	replayed := created.Add(time.Hour)
+	h := &Handler{
+		Prefix: "/admin/deadletters",
+		DeadLetters: &fakeDeadLetters{letters: []*db.DeadLetter{
+			{ID: "a", EventID: "e1", EventType: "cycle.published", URL: "https://example.com/hook", Payload: `{"id":"e1"}`, Attempts: 5, LastError: "timeout", Created: created, ReplayedAt: replayed},
+		}},
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/deadletters", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+	}
+	var got []*deadLetterResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	want := []*deadLetterResponse{
+		{ID: "a", EventID: "e1", EventType: "cycle.published", URL: "https://example.com/hook", Event: json.RawMessage(`{"id":"e1"}`), Attempts: 5, LastError: "timeout", Created: created, ReplayedAt: &replayed},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("dead letters diff (-want +got): %s", diff)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		method     string
+		path       string
+		replayErr  error
+		wantStatus int
+	}{
+		{name: "Good", method: http.MethodPost, path: "/admin/deadletters/a/replay", wantStatus: http.StatusNoContent},
+		{name: "Missing", method: http.MethodPost, path: "/admin/deadletters/missing/replay", wantStatus: http.StatusNotFound},
+		{name: "DeliveryFailed", method: http.MethodPost, path: "/admin/deadletters/a/replay", replayErr: errors.New("got status 500"), wantStatus: http.StatusBadGateway},
+		{name: "WrongMethod", method: http.MethodGet, path: "/admin/deadletters/a/replay", wantStatus: http.StatusMethodNotAllowed},
+		{name: "UnknownPath", method: http.MethodPost, path: "/admin/deadletters/a", wantStatus: http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			replayer := &fakeReplayer{err: tt.replayErr}
+			h := &Handler{Prefix: "/admin/deadletters", DeadLetters: &fakeDeadLetters{}, Replayer: replayer}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tt.wantStatus)
+			}
+			if wantReplayed := tt.wantStatus == http.StatusNoContent; (len(replayer.replayed) == 1) != wantReplayed {
+				t.Errorf("replayed %v, want replayed %t", replayer.replayed, wantReplayed)
+			}
+		})
+	}
+}
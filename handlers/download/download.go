@@ -0,0 +1,235 @@
+// Package download serves files generated on demand from the processed data
+// of a cycle, such as the records of a single airport.
+package download
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/geojson"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/subset"
+)
+
+type cycleGetter interface {
+	Get(context.Context, string) (*db.Cycle, error)
+}
+
+type storageClient interface {
+	NewObject(_ context.Context, fileName string) io.WriteCloser
+	NewReader(_ context.Context, fileName string) (io.ReadCloser, error)
+}
+
+// Handler serves, under Prefix:
+//
+//	GET <Prefix>/<cycle>/airports/<ICAO>  the subset of a cycle with a single airport
+//	GET <Prefix>/<cycle>/geojson          the features of a cycle as GeoJSON
+//	GET <Prefix>/<cycle>/geojson/<ICAO>   the features of a single airport
+//
+// where the cycle is named with dashes, as in 06-18-2020. Files are generated
+// from the processed data the first time they are requested and cached in
+// Storage.
+type Handler struct {
+	Cycles  cycleGetter
+	Storage storageClient
+	Prefix  string
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, h.Prefix), "/"), "/")
+	var icao string
+	switch {
+	case len(parts) == 3 && (parts[1] == "airports" || parts[1] == "geojson"):
+		icao = strings.ToUpper(parts[2])
+	case len(parts) == 2 && parts[1] == "geojson":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(parts) == 3 && !subset.ValidAirport(icao) {
+		http.Error(w, fmt.Sprintf("Invalid airport %q, want an ICAO identifier such as KHWD.", parts[2]), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	c, err := h.Cycles.Get(ctx, strings.Replace(parts[0], "-", "/", -1))
+	if err != nil {
+		logging.Errorf(ctx, "Could not get cycle: %v", err)
+		http.Error(w, "Could not get cycle.", http.StatusInternalServerError)
+		return
+	}
+	if c == nil || c.Hidden {
+		http.NotFound(w, r)
+		return
+	}
+
+	if parts[1] == "geojson" && icao == "" {
+		h.serveCycleGeoJSON(w, r, c)
+		return
+	}
+	var data []byte
+	var contentType, fileName string
+	if parts[1] == "geojson" {
+		data, err = h.airportGeoJSON(ctx, c, icao)
+		contentType, fileName = "application/geo+json", "FAACIFP18_"+icao+".geojson"
+	} else {
+		data, err = h.airport(ctx, c, icao)
+		contentType, fileName = "text/plain; charset=utf-8", "FAACIFP18_"+icao
+	}
+	if err == subset.ErrNoRecords || err == geojson.ErrNoFeatures {
+		http.Error(w, fmt.Sprintf("Airport %s is not in cycle %s.", icao, c.Name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.Errorf(ctx, "Could not get %s of %s for %s: %v", parts[1], c.Name, icao, err)
+		http.Error(w, "Could not get airport data.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(data); err != nil {
+		logging.Warningf(ctx, "Could not write response: %v", err)
+	}
+}
+
+// serveCycleGeoJSON serves the features of c, which are too large to keep in
+// memory, from storage.
+func (h *Handler) serveCycleGeoJSON(w http.ResponseWriter, r *http.Request, c *db.Cycle) {
+	ctx := r.Context()
+	name := geojson.ObjectName(c.Name)
+	rc, err := h.Storage.NewReader(ctx, name)
+	if err != nil {
+		logging.Debugf(ctx, "Generating %s, since it could not be read: %v", name, err)
+		if err := h.writeCycleGeoJSON(ctx, c, name); err != nil {
+			logging.Errorf(ctx, "Could not generate features of %s: %v", c.Name, err)
+			http.Error(w, "Could not get cycle data.", http.StatusInternalServerError)
+			return
+		}
+		if rc, err = h.Storage.NewReader(ctx, name); err != nil {
+			logging.Errorf(ctx, "Could not read features of %s: %v", c.Name, err)
+			http.Error(w, "Could not get cycle data.", http.StatusInternalServerError)
+			return
+		}
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "FAACIFP18_"+strings.Replace(c.Name, "/", "-", -1)+".geojson"))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		logging.Warningf(ctx, "Could not write response: %v", err)
+	}
+}
+
+func (h *Handler) writeCycleGeoJSON(ctx context.Context, c *db.Cycle, name string) error {
+	rc, err := h.Storage.NewReader(ctx, c.Processed)
+	if err != nil {
+		return fmt.Errorf("could not open processed data: %v", err)
+	}
+	defer rc.Close()
+	wc := h.Storage.NewObject(ctx, name)
+	if _, err := geojson.Write(wc, rc, ""); err != nil {
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+// airportGeoJSON returns the features of icao in c, from the cache if they
+// were generated before.
+func (h *Handler) airportGeoJSON(ctx context.Context, c *db.Cycle, icao string) ([]byte, error) {
+	name := geojson.AirportObjectName(c.Name, c.Checksum, icao)
+	data, err := h.read(ctx, name)
+	if err == nil {
+		return data, nil
+	}
+	logging.Debugf(ctx, "Generating %s, since it could not be read: %v", name, err)
+
+	// The whole processed data is read, since legs may end at enroute fixes.
+	rc, err := h.Storage.NewReader(ctx, c.Processed)
+	if err != nil {
+		return nil, fmt.Errorf("could not open processed data: %v", err)
+	}
+	defer rc.Close()
+	var b bytes.Buffer
+	if _, err := geojson.Write(&b, rc, icao); err != nil {
+		return nil, err
+	}
+	h.cache(ctx, name, b.Bytes())
+	return b.Bytes(), nil
+}
+
+// airport returns the subset of c with only icao, from the cache if it was
+// generated before.
+func (h *Handler) airport(ctx context.Context, c *db.Cycle, icao string) ([]byte, error) {
+	name := subset.AirportObjectName(c.Name, c.Checksum, icao)
+	data, err := h.read(ctx, name)
+	if err == nil {
+		return data, nil
+	}
+	logging.Debugf(ctx, "Generating %s, since it could not be read: %v", name, err)
+
+	// Extract reads the data several times, so copy it to a file rather than
+	// downloading it more than once.
+	f, err := ioutil.TempFile("", "processed")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	rc, err := h.Storage.NewReader(ctx, c.Processed)
+	if err != nil {
+		return nil, fmt.Errorf("could not open processed data: %v", err)
+	}
+	_, err = io.Copy(f, rc)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not download processed data: %v", err)
+	}
+
+	var b bytes.Buffer
+	if _, err := subset.Extract(f, &b, &subset.Spec{Name: strings.ToLower(icao), Airports: []string{icao}}); err != nil {
+		return nil, err
+	}
+	h.cache(ctx, name, b.Bytes())
+	return b.Bytes(), nil
+}
+
+// cache stores data as name. A failure to cache only makes the next request
+// slower.
+func (h *Handler) cache(ctx context.Context, name string, data []byte) {
+	wc := h.Storage.NewObject(ctx, name)
+	if _, err := wc.Write(data); err != nil {
+		logging.Warningf(ctx, "Could not cache %s: %v", name, err)
+	}
+	if err := wc.Close(); err != nil {
+		logging.Warningf(ctx, "Could not cache %s: %v", name, err)
+	}
+}
+
+func (h *Handler) read(ctx context.Context, name string) ([]byte, error) {
+	rc, err := h.Storage.NewReader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,208 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/blob/blobtest"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+type fakeCycles map[string]*db.Cycle
+
+func (f fakeCycles) Get(_ context.Context, name string) (*db.Cycle, error) {
+	return f[name], nil
+}
+
+func newHandler(t *testing.T) (*Handler, *blobtest.Storage) {
+	t.Helper()
+	processed, err := ioutil.ReadFile("../../pipeline/want_processed.txt")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	s := &blobtest.Storage{Objects: map[string]string{"FAACIFP18-06-18-2020_processed": string(processed)}}
+	return &Handler{
+		Prefix:  "/download",
+		Storage: s,
+		Cycles: fakeCycles{
+			"06/18/2020": {Name: "06/18/2020", Processed: "FAACIFP18-06-18-2020_processed", Checksum: "abc123"},
+			"05/21/2020": {Name: "05/21/2020", Processed: "FAACIFP18-05-21-2020_processed", Hidden: true},
+		},
+	}, s
+}
+
+func TestServeHTTP(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{name: "airport", method: http.MethodGet, path: "/download/06-18-2020/airports/KHWD", wantStatus: http.StatusOK},
+		{name: "lower case", method: http.MethodGet, path: "/download/06-18-2020/airports/khwd", wantStatus: http.StatusOK},
+		{name: "missing airport", method: http.MethodGet, path: "/download/06-18-2020/airports/KJFK", wantStatus: http.StatusNotFound},
+		{name: "invalid airport", method: http.MethodGet, path: "/download/06-18-2020/airports/K-HWD", wantStatus: http.StatusBadRequest},
+		{name: "missing cycle", method: http.MethodGet, path: "/download/01-02-2020/airports/KHWD", wantStatus: http.StatusNotFound},
+		{name: "hidden cycle", method: http.MethodGet, path: "/download/05-21-2020/airports/KHWD", wantStatus: http.StatusNotFound},
+		{name: "unknown path", method: http.MethodGet, path: "/download/06-18-2020", wantStatus: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodPost, path: "/download/06-18-2020/airports/KHWD", wantStatus: http.StatusMethodNotAllowed},
+		{name: "cycle geojson", method: http.MethodGet, path: "/download/06-18-2020/geojson", wantStatus: http.StatusOK},
+		{name: "airport geojson", method: http.MethodGet, path: "/download/06-18-2020/geojson/khwd", wantStatus: http.StatusOK},
+		{name: "missing airport geojson", method: http.MethodGet, path: "/download/06-18-2020/geojson/KJFK", wantStatus: http.StatusNotFound},
+		{name: "invalid airport geojson", method: http.MethodGet, path: "/download/06-18-2020/geojson/K-HWD", wantStatus: http.StatusBadRequest},
+		{name: "hidden cycle geojson", method: http.MethodGet, path: "/download/05-21-2020/geojson", wantStatus: http.StatusNotFound},
+		{name: "geojson wrong method", method: http.MethodPost, path: "/download/06-18-2020/geojson", wantStatus: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, _ := newHandler(t)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServeHTTPCaches(t *testing.T) {
+	h, s := newHandler(t)
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/download/06-18-2020/airports/KHWD", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+		}
+		if got, want := rr.Header().Get("Content-Disposition"), `attachment; filename="FAACIFP18_KHWD"`; got != want {
+			t.Errorf("handler returned Content-Disposition %q want %q", got, want)
+		}
+		bodies = append(bodies, rr.Body.String())
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("handler returned a different subset from the cache")
+	}
+	if got, want := strings.Count(bodies[0], "\n"), 103; got != want {
+		t.Errorf("handler returned %d lines want %d", got, want)
+	}
+	if _, ok := s.Objects["subsets/06-18-2020/airports/abc123/KHWD"]; !ok {
+		t.Errorf("handler did not cache the subset")
+	}
+	var processedReads int
+	for _, r := range s.Reads {
+		if r == "FAACIFP18-06-18-2020_processed" {
+			processedReads++
+		}
+	}
+	if processedReads != 1 {
+		t.Errorf("handler read the processed data %d times want 1", processedReads)
+	}
+}
+
+func TestServeHTTPReprocessed(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		path       string
+		wantObject string
+	}{
+		{name: "airport", path: "/download/06-18-2020/airports/KHWD", wantObject: "subsets/06-18-2020/airports/def456/KHWD"},
+		{name: "airport geojson", path: "/download/06-18-2020/geojson/KHWD", wantObject: "geojson/06-18-2020/airports/def456/KHWD.geojson"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, s := newHandler(t)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+			}
+
+			// Reprocessing the cycle replaces its data and checksum.
+			h.Cycles.(fakeCycles)["06/18/2020"].Checksum = "def456"
+			s.Objects["FAACIFP18-06-18-2020_processed"] = strings.Replace(s.Objects["FAACIFP18-06-18-2020_processed"], "HAYWARD EXECUTIVE", "HAYWARD REPROCESS", 1)
+			rr = httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+			}
+			if !strings.Contains(rr.Body.String(), "HAYWARD REPROCESS") {
+				t.Errorf("handler returned data cached before the cycle was reprocessed")
+			}
+			if _, ok := s.Objects[tc.wantObject]; !ok {
+				t.Errorf("handler did not cache %s", tc.wantObject)
+			}
+		})
+	}
+}
+
+func TestServeHTTPGeoJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		path         string
+		wantObject   string
+		wantFileName string
+		wantFeatures int
+	}{
+		{
+			name:         "cycle",
+			path:         "/download/06-18-2020/geojson",
+			wantObject:   "geojson/06-18-2020.geojson",
+			wantFileName: "FAACIFP18_06-18-2020.geojson",
+			wantFeatures: 88,
+		},
+		{
+			name:         "airport",
+			path:         "/download/06-18-2020/geojson/KHWD",
+			wantObject:   "geojson/06-18-2020/airports/abc123/KHWD.geojson",
+			wantFileName: "FAACIFP18_KHWD.geojson",
+			wantFeatures: 88,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, s := newHandler(t)
+			var bodies []string
+			for i := 0; i < 2; i++ {
+				rr := httptest.NewRecorder()
+				h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+				if rr.Code != http.StatusOK {
+					t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+				}
+				if got, want := rr.Header().Get("Content-Type"), "application/geo+json"; got != want {
+					t.Errorf("handler returned Content-Type %q want %q", got, want)
+				}
+				if got, want := rr.Header().Get("Content-Disposition"), fmt.Sprintf("attachment; filename=%q", tc.wantFileName); got != want {
+					t.Errorf("handler returned Content-Disposition %q want %q", got, want)
+				}
+				bodies = append(bodies, rr.Body.String())
+			}
+			if bodies[0] != bodies[1] {
+				t.Errorf("handler returned different features from the cache")
+			}
+			var fc struct {
+				Features []json.RawMessage `json:"features"`
+			}
+			if err := json.Unmarshal([]byte(bodies[0]), &fc); err != nil {
+				t.Fatalf("could not decode features: %v", err)
+			}
+			if got := len(fc.Features); got != tc.wantFeatures {
+				t.Errorf("handler returned %d features want %d", got, tc.wantFeatures)
+			}
+			if _, ok := s.Objects[tc.wantObject]; !ok {
+				t.Errorf("handler did not cache %s", tc.wantObject)
+			}
+			var processedReads int
+			for _, r := range s.Reads {
+				if r == "FAACIFP18-06-18-2020_processed" {
+					processedReads++
+				}
+			}
+			if processedReads != 1 {
+				t.Errorf("handler read the processed data %d times want 1", processedReads)
+			}
+		})
+	}
+}
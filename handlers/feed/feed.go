@@ -0,0 +1,239 @@
+// Package feed serves the published cycles as Atom and RSS feeds, so that
+// feed readers can notify users of new data.
+package feed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+// Format is the format of a feed.
+type Format int
+
+const (
+	// Atom is an Atom 1.0 feed.
+	Atom Format = iota
+	// RSS is an RSS 2.0 feed.
+	RSS
+)
+
+const (
+	feedTitle = "Enhanced FAA CIFP Data"
+	feedAbout = "Enhanced FAA Coded Instrument Flight Procedures data, processed for flight simulators like X-Plane."
+	// maxAge is how long clients may cache a feed. New cycles are
+	// published every 28 days, so there is no need to poll often.
+	maxAge = time.Hour
+)
+
+type cyclesLister interface {
+	List(context.Context) ([]*db.Cycle, error)
+}
+
+// Handler serves the most recent public cycles as a feed.
+type Handler struct {
+	Format     Format
+	BucketName string
+	Cycles     cyclesLister
+	// SiteURL is the public URL of the app, such as
+	// "https://enhance-faa-cifp.seanharger.com". It is derived from each
+	// request if empty.
+	SiteURL string
+}
+
+// entry is a cycle as it appears in a feed.
+type entry struct {
+	name      string
+	effective time.Time
+	updated   time.Time
+	link      string
+	checksum  string
+}
+
+func (e *entry) summary() string {
+	s := fmt.Sprintf("FAA CIFP cycle %s, effective %s.", e.name, e.effective.Format("January 2, 2006"))
+	if e.checksum != "" {
+		s += " SHA-256 checksum: " + e.checksum + "."
+	}
+	return s
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cycles, err := h.Cycles.List(r.Context())
+	if err != nil {
+		logging.Errorf(r.Context(), "Could not list cycles: %v", err)
+		http.Error(w, "Could not list cycles.", http.StatusInternalServerError)
+		return
+	}
+	var entries []*entry
+	var updated time.Time
+	for _, c := range cycles {
+		if c.Hidden {
+			continue
+		}
+		e := &entry{
+			name:      c.Name,
+			effective: c.Date,
+			updated:   c.Updated,
+			link:      fmt.Sprintf("https://storage.googleapis.com/%s/%s", h.BucketName, c.Processed),
+			checksum:  c.Checksum,
+		}
+		if e.updated.After(updated) {
+			updated = e.updated
+		}
+		entries = append(entries, e)
+	}
+
+	site := h.siteURL(r)
+	var v interface{}
+	contentType := "application/atom+xml; charset=utf-8"
+	if h.Format == RSS {
+		v = newRSS(site, updated, entries)
+		contentType = "application/rss+xml; charset=utf-8"
+	} else {
+		v = newAtom(site, updated, entries)
+	}
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	if err := xml.NewEncoder(&b).Encode(v); err != nil {
+		logging.Errorf(r.Context(), "Could not encode feed: %v", err)
+		http.Error(w, "Could not encode feed.", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(b.Bytes())
+	w.Header().Set("content-type", contentType)
+	w.Header().Set("cache-control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("etag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	// ServeContent answers conditional requests from the ETag and
+	// Last-Modified headers.
+	http.ServeContent(w, r, "", updated, bytes.NewReader(b.Bytes()))
+}
+
+func (h *Handler) siteURL(r *http.Request) string {
+	if h.SiteURL != "" {
+		return h.SiteURL
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   atomAuthor  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+}
+
+func newAtom(site string, updated time.Time, entries []*entry) *atomFeed {
+	f := &atomFeed{
+		Title:    feedTitle,
+		Subtitle: feedAbout,
+		ID:       site + "/feed.atom",
+		Updated:  updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: site + "/feed.atom"},
+			{Rel: "alternate", Type: "text/html", Href: site + "/"},
+		},
+		Author: atomAuthor{Name: feedTitle},
+	}
+	for _, e := range entries {
+		f.Entries = append(f.Entries, atomEntry{
+			Title: "Cycle " + e.name,
+			// The ID must not change when a cycle is reprocessed, so
+			// it is based on the name rather than the data.
+			ID:      site + "/feed.atom#" + url.QueryEscape(e.name),
+			Updated: e.updated.UTC().Format(time.RFC3339),
+			Links:   []atomLink{{Rel: "enclosure", Type: "text/plain", Href: e.link}},
+			Summary: e.summary(),
+		})
+	}
+	return f
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	TTL           int       `xml:"ttl"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func newRSS(site string, updated time.Time, entries []*entry) *rssFeed {
+	f := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       feedTitle,
+			Link:        site + "/",
+			Description: feedAbout,
+			TTL:         int(maxAge.Minutes()),
+		},
+	}
+	if !updated.IsZero() {
+		f.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, e := range entries {
+		f.Channel.Items = append(f.Channel.Items, rssItem{
+			Title:       "Cycle " + e.name,
+			Link:        e.link,
+			Description: e.summary(),
+			GUID:        rssGUID{Value: site + "/feed.rss#" + url.QueryEscape(e.name)},
+			PubDate:     e.updated.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return f
+}
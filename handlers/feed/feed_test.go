@@ -0,0 +1,203 @@
+package feed
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+type fakeCyclesLister struct {
+	Cycles []*db.Cycle
+	Err    error
+}
+
+func (fl *fakeCyclesLister) List(context.Context) ([]*db.Cycle, error) {
+	return fl.Cycles, fl.Err
+}
+
+var testCycles = []*db.Cycle{
+	{
+		Name:      "07/16/2020",
+		Processed: "processed/FAACIFP18_processed_07-16-2020",
+		Date:      time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC),
+		Checksum:  "abcd",
+		Updated:   time.Date(2020, 7, 10, 12, 0, 0, 0, time.UTC),
+	},
+	{
+		Name:      "06/18/2020",
+		Processed: "processed/FAACIFP18_processed_06-18-2020",
+		Date:      time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+		Updated:   time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+		Hidden:    true,
+	},
+	{
+		Name:      "05/21/2020",
+		Processed: "processed/FAACIFP18_processed_05-21-2020",
+		Date:      time.Date(2020, 5, 21, 0, 0, 0, 0, time.UTC),
+		Updated:   time.Date(2020, 5, 21, 0, 0, 0, 0, time.UTC),
+	},
+}
+
+func TestAtom(t *testing.T) {
+	h := &Handler{
+		Format:     Atom,
+		BucketName: "bucket",
+		Cycles:     &fakeCyclesLister{Cycles: testCycles},
+		SiteURL:    "https://example.com",
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/feed.atom", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() status = %d want %d", rr.Code, http.StatusOK)
+	}
+	if got, want := rr.Header().Get("content-type"), "application/atom+xml; charset=utf-8"; got != want {
+		t.Errorf("content-type = %q want %q", got, want)
+	}
+	if got, want := rr.Header().Get("last-modified"), "Fri, 10 Jul 2020 12:00:00 GMT"; got != want {
+		t.Errorf("last-modified = %q want %q", got, want)
+	}
+	var got atomFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("xml.Unmarshal() = %v want <nil>", err)
+	}
+	want := atomFeed{
+		XMLName:  xml.Name{Space: "http://www.w3.org/2005/Atom", Local: "feed"},
+		Title:    feedTitle,
+		Subtitle: feedAbout,
+		ID:       "https://example.com/feed.atom",
+		Updated:  "2020-07-10T12:00:00Z",
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: "https://example.com/feed.atom"},
+			{Rel: "alternate", Type: "text/html", Href: "https://example.com/"},
+		},
+		Author: atomAuthor{Name: feedTitle},
+		Entries: []atomEntry{
+			{
+				Title:   "Cycle 07/16/2020",
+				ID:      "https://example.com/feed.atom#07%2F16%2F2020",
+				Updated: "2020-07-10T12:00:00Z",
+				Links:   []atomLink{{Rel: "enclosure", Type: "text/plain", Href: "https://storage.googleapis.com/bucket/processed/FAACIFP18_processed_07-16-2020"}},
+				Summary: "FAA CIFP cycle 07/16/2020, effective July 16, 2020. SHA-256 checksum: abcd.",
+			},
+			{
+				Title:   "Cycle 05/21/2020",
+				ID:      "https://example.com/feed.atom#05%2F21%2F2020",
+				Updated: "2020-05-21T00:00:00Z",
+				Links:   []atomLink{{Rel: "enclosure", Type: "text/plain", Href: "https://storage.googleapis.com/bucket/processed/FAACIFP18_processed_05-21-2020"}},
+				Summary: "FAA CIFP cycle 05/21/2020, effective May 21, 2020.",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected feed diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRSS(t *testing.T) {
+	h := &Handler{
+		Format:     RSS,
+		BucketName: "bucket",
+		Cycles:     &fakeCyclesLister{Cycles: testCycles},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/feed.rss", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() status = %d want %d", rr.Code, http.StatusOK)
+	}
+	if got, want := rr.Header().Get("content-type"), "application/rss+xml; charset=utf-8"; got != want {
+		t.Errorf("content-type = %q want %q", got, want)
+	}
+	var got rssFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("xml.Unmarshal() = %v want <nil>", err)
+	}
+	want := rssFeed{
+		XMLName: xml.Name{Local: "rss"},
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         feedTitle,
+			Link:          "https://example.com/",
+			Description:   feedAbout,
+			LastBuildDate: "Fri, 10 Jul 2020 12:00:00 +0000",
+			TTL:           60,
+			Items: []rssItem{
+				{
+					Title:       "Cycle 07/16/2020",
+					Link:        "https://storage.googleapis.com/bucket/processed/FAACIFP18_processed_07-16-2020",
+					Description: "FAA CIFP cycle 07/16/2020, effective July 16, 2020. SHA-256 checksum: abcd.",
+					GUID:        rssGUID{Value: "https://example.com/feed.rss#07%2F16%2F2020"},
+					PubDate:     "Fri, 10 Jul 2020 12:00:00 +0000",
+				},
+				{
+					Title:       "Cycle 05/21/2020",
+					Link:        "https://storage.googleapis.com/bucket/processed/FAACIFP18_processed_05-21-2020",
+					Description: "FAA CIFP cycle 05/21/2020, effective May 21, 2020.",
+					GUID:        rssGUID{Value: "https://example.com/feed.rss#05%2F21%2F2020"},
+					PubDate:     "Thu, 21 May 2020 00:00:00 +0000",
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected feed diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	h := &Handler{
+		BucketName: "bucket",
+		Cycles:     &fakeCyclesLister{Cycles: testCycles},
+		SiteURL:    "https://example.com",
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/feed.atom", nil))
+	etag := rr.Header().Get("etag")
+	if etag == "" {
+		t.Fatal("ServeHTTP() sent no etag")
+	}
+	if got, want := rr.Header().Get("cache-control"), "public, max-age=3600"; got != want {
+		t.Errorf("cache-control = %q want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{name: "MatchingETag", header: "If-None-Match", value: etag, wantStatus: http.StatusNotModified},
+		{name: "StaleETag", header: "If-None-Match", value: `"stale"`, wantStatus: http.StatusOK},
+		{name: "NotModifiedSince", header: "If-Modified-Since", value: "Fri, 10 Jul 2020 12:00:00 GMT", wantStatus: http.StatusNotModified},
+		{name: "ModifiedSince", header: "If-Modified-Since", value: "Thu, 09 Jul 2020 12:00:00 GMT", wantStatus: http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/feed.atom", nil)
+			req.Header.Set(tt.header, tt.value)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d want %d", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestListError(t *testing.T) {
+	h := &Handler{Cycles: &fakeCyclesLister{Err: errors.New("list error")}}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/feed.atom", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("ServeHTTP() status = %d want %d", rr.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,218 @@
+// Package health serves liveness and readiness probes for load balancers and
+// uptime checks.
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+const (
+	statusOK          = "ok"
+	statusError       = "error"
+	statusUnavailable = "unavailable"
+
+	defaultTimeout = 5 * time.Second
+)
+
+// Check checks that a dependency of the app is usable.
+type Check struct {
+	// Name identifies the dependency in the readiness report.
+	Name string
+	// Check returns an error if the dependency is not usable. It should
+	// give up when ctx is done.
+	Check func(context.Context) error
+}
+
+// Live reports that the process is up. It checks no dependencies, so that a
+// failing dependency does not get the app restarted.
+func Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/plain; charset=utf-8")
+		w.Header().Set("cache-control", "no-store")
+		io.WriteString(w, "ok\n")
+	})
+}
+
+// Ready runs its checks concurrently and reports the status of each
+// dependency as JSON. It responds with 503 Service Unavailable if any check
+// fails.
+type Ready struct {
+	Checks []Check
+	// Timeout limits how long each check may take. It defaults to five
+	// seconds.
+	Timeout time.Duration
+	// CacheFor is how long a report is reused for, which keeps frequent
+	// probes from overloading the dependencies. Reports are not reused if
+	// it is zero.
+	CacheFor time.Duration
+
+	now    func() time.Time
+	mu     sync.Mutex
+	last   *report
+	lastAt time.Time
+}
+
+// report is the JSON body of a readiness response.
+type report struct {
+	Status string                  `json:"status"`
+	Checks map[string]*checkResult `json:"checks"`
+}
+
+type checkResult struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+func (h *Ready) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rep := h.report(r.Context())
+	status := http.StatusOK
+	if rep.Status != statusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("cache-control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(rep); err != nil {
+		logging.Errorf(r.Context(), "Could not write response: %v", err)
+	}
+}
+
+// report returns the cached report if it is recent enough, and runs the
+// checks otherwise. Concurrent probes wait for a single run of the checks,
+// which is detached from the probe that started it, so that a probe giving
+// up does not fail the checks and the report cached for the others.
+func (h *Ready) report(ctx context.Context) *report {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.last != nil && h.timeNow().Sub(h.lastAt) < h.CacheFor {
+		return h.last
+	}
+	rep := h.run(logging.Detach(ctx))
+	h.last, h.lastAt = rep, h.timeNow()
+	return rep
+}
+
+func (h *Ready) run(ctx context.Context) *report {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	results := make([]*checkResult, len(h.Checks))
+	var wg sync.WaitGroup
+	for i := range h.Checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := h.Checks[i]
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := c.Check(ctx)
+			results[i] = &checkResult{Status: statusOK, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				logging.Warningf(ctx, "Readiness check %s failed: %v", c.Name, err)
+				results[i].Status, results[i].Error = statusError, err.Error()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	rep := &report{Status: statusOK, Checks: make(map[string]*checkResult)}
+	for i, c := range h.Checks {
+		rep.Checks[c.Name] = results[i]
+		if results[i].Status != statusOK {
+			rep.Status = statusUnavailable
+		}
+	}
+	return rep
+}
+
+func (h *Ready) timeNow() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+type cyclesGetter interface {
+	Get(context.Context, string) (*db.Cycle, error)
+}
+
+// Database checks that cycles can be read from the database.
+func Database(cycles cyclesGetter) Check {
+	return Check{Name: "database", Check: func(ctx context.Context) error {
+		// Any name will do; a cycle that does not exist is not an error.
+		_, err := cycles.Get(ctx, "readiness-check")
+		return err
+	}}
+}
+
+type storageClient interface {
+	NewObject(_ context.Context, fileName string) io.WriteCloser
+	NewReader(_ context.Context, fileName string) (io.ReadCloser, error)
+}
+
+// Storage checks that the object with the specified name can be written and
+// read back, which needs the same permissions as publishing cycles.
+func Storage(s storageClient, fileName string) Check {
+	return Check{Name: "storage", Check: func(ctx context.Context) error {
+		want := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+		w := s.NewObject(ctx, fileName)
+		if _, err := w.Write(want); err != nil {
+			w.Close()
+			return fmt.Errorf("could not write %s: %v", fileName, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("could not write %s: %v", fileName, err)
+		}
+		r, err := s.NewReader(ctx, fileName)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", fileName, err)
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", fileName, err)
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("read %q from %s, want %q", got, fileName, want)
+		}
+		return nil
+	}}
+}
+
+// Endpoint checks that a GET request to url succeeds. The check is named
+// name, and requests are sent with client, or http.DefaultClient if nil.
+func Endpoint(name, url string, client *http.Client) Check {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return Check{Name: name, Check: func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("could not create request: %v", err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("got status %s", res.Status)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,201 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+func ok(context.Context) error { return nil }
+
+func failing(context.Context) error { return errors.New("unreachable") }
+
+func slow(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestLive(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Live().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestReady(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		checks     []Check
+		wantStatus int
+		wantReport *report
+	}{
+		{
+			name:       "AllOK",
+			checks:     []Check{{"database", ok}, {"storage", ok}},
+			wantStatus: http.StatusOK,
+			wantReport: &report{Status: "ok", Checks: map[string]*checkResult{
+				"database": {Status: "ok"},
+				"storage":  {Status: "ok"},
+			}},
+		},
+		{
+			name:       "OneFailing",
+			checks:     []Check{{"database", ok}, {"faa", failing}},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReport: &report{Status: "unavailable", Checks: map[string]*checkResult{
+				"database": {Status: "ok"},
+				"faa":      {Status: "error", Error: "unreachable"},
+			}},
+		},
+		{
+			name:       "TimedOut",
+			checks:     []Check{{"storage", slow}},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReport: &report{Status: "unavailable", Checks: map[string]*checkResult{
+				"storage": {Status: "error", Error: "context deadline exceeded"},
+			}},
+		},
+		{
+			name:       "NoChecks",
+			wantStatus: http.StatusOK,
+			wantReport: &report{Status: "ok", Checks: map[string]*checkResult{}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Ready{Checks: tt.checks, Timeout: 10 * time.Millisecond}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d want %d", rr.Code, tt.wantStatus)
+			}
+			var got report
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("could not decode report: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantReport, &got, cmpopts.IgnoreFields(checkResult{}, "LatencyMS")); diff != "" {
+				t.Errorf("report differs (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadyCachesReports(t *testing.T) {
+	now := time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	h := &Ready{
+		Checks: []Check{{"database", func(context.Context) error {
+			calls++
+			return nil
+		}}},
+		CacheFor: 10 * time.Second,
+		now:      func() time.Time { return now },
+	}
+	for _, advance := range []time.Duration{0, 5 * time.Second, 5 * time.Second} {
+		now = now.Add(advance)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	}
+	if calls != 2 {
+		t.Errorf("checks ran %d times, want 2", calls)
+	}
+}
+
+func TestReadyIgnoresCancelledProbe(t *testing.T) {
+	h := &Ready{
+		Checks: []Check{{"database", func(ctx context.Context) error {
+			return ctx.Err()
+		}}},
+		CacheFor: 10 * time.Second,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(ctx))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() after cancelled probe = %d want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+type fakeCycles struct {
+	err error
+}
+
+func (f *fakeCycles) Get(context.Context, string) (*db.Cycle, error) {
+	return nil, f.err
+}
+
+type fakeStorage struct {
+	objects  map[string][]byte
+	readErr  error
+	corrupts bool
+}
+
+type objectWriter struct {
+	bytes.Buffer
+	close func([]byte)
+}
+
+func (w *objectWriter) Close() error {
+	w.close(w.Bytes())
+	return nil
+}
+
+func (f *fakeStorage) NewObject(_ context.Context, fileName string) io.WriteCloser {
+	return &objectWriter{close: func(b []byte) {
+		if f.corrupts {
+			b = []byte("corrupted")
+		}
+		f.objects[fileName] = b
+	}}
+}
+
+func (f *fakeStorage) NewReader(_ context.Context, fileName string) (io.ReadCloser, error) {
+	if f.readErr != nil {
+		return nil, f.readErr
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.objects[fileName])), nil
+}
+
+func TestChecks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			http.Error(w, "down", http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name    string
+		check   Check
+		wantErr bool
+	}{
+		{name: "DatabaseOK", check: Database(&fakeCycles{})},
+		{name: "DatabaseError", check: Database(&fakeCycles{err: errors.New("unavailable")}), wantErr: true},
+		{name: "StorageOK", check: Storage(&fakeStorage{objects: map[string][]byte{}}, "health/readyz")},
+		{name: "StorageReadError", check: Storage(&fakeStorage{objects: map[string][]byte{}, readErr: errors.New("forbidden")}, "health/readyz"), wantErr: true},
+		{name: "StorageMismatch", check: Storage(&fakeStorage{objects: map[string][]byte{}, corrupts: true}, "health/readyz"), wantErr: true},
+		{name: "EndpointOK", check: Endpoint("faa", srv.URL+"/up", nil)},
+		{name: "EndpointBadStatus", check: Endpoint("faa", srv.URL+"/down", nil), wantErr: true},
+		{name: "EndpointUnreachable", check: Endpoint("faa", "http://127.0.0.1:0/", nil), wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check.Check(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,10 +3,10 @@ package index
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/templates"
 )
 
@@ -17,11 +17,15 @@ type cyclesLister interface {
 type Handler struct {
 	BucketName string
 	Cycles     cyclesLister
+	// AirportsPath is the path of the airport search page. The page has no
+	// search box if it is empty.
+	AirportsPath string
 }
 
 type baseValues struct {
 	BucketName   string
 	Cycles       []*db.Cycle
+	AirportsPath string
 	DisplayError string
 }
 
@@ -36,19 +40,24 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	bv := &baseValues{
-		BucketName: h.BucketName,
-		Cycles:     []*db.Cycle{},
+		BucketName:   h.BucketName,
+		Cycles:       []*db.Cycle{},
+		AirportsPath: h.AirportsPath,
 	}
 	cycles, err := h.Cycles.List(r.Context())
 	if err != nil {
-		log.Printf("could not list cycles: %v", err)
+		logging.Errorf(r.Context(), "Could not list cycles: %v", err)
 		bv.DisplayError = "The enhanced FAA CIFP data U/S. We apologize for the inconvenience."
 	} else {
-		bv.Cycles = cycles
+		for _, c := range cycles {
+			if !c.Hidden {
+				bv.Cycles = append(bv.Cycles, c)
+			}
+		}
 	}
 
 	w.Header().Set("content-type", "text/html")
 	if err := templates.Base.Execute(w, bv); err != nil {
-		log.Printf("could not execute template: %v", err)
+		logging.Errorf(r.Context(), "Could not execute template: %v", err)
 	}
 }
@@ -43,6 +43,20 @@ func TestIndexHandler(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "HiddenCycle",
+			cyclesLister: &fakeCyclesLister{
+				Cycles: []*db.Cycle{
+					{Name: "first-cycle", Processed: "some/path/to/file-1", Hidden: true},
+					{Name: "second-cycle", Processed: "some/path/to/file-2"},
+				},
+			},
+			wantBaseValues: &baseValues{
+				Cycles: []*db.Cycle{
+					{Name: "second-cycle", Processed: "some/path/to/file-2"},
+				},
+			},
+		},
 		{
 			name: "ListError",
 			cyclesLister: &fakeCyclesLister{
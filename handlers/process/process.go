@@ -1,233 +1,121 @@
 package process
 
 import (
-	"archive/zip"
 	"context"
-	"encoding/json"
-	"io"
-	"io/ioutil"
-	"log"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
-	"time"
 
-	"github.com/wallaceicy06/enhance-faa-cifp/enhance"
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/auth"
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/metrics"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/webhook"
+	"go.opentelemetry.io/otel/label"
 )
 
-type googleVerifier interface {
-	VerifyGoogle(context.Context, string) (string, error)
-}
-
-type cyclesAdderGetter interface {
-	Add(context.Context, *db.Cycle) error
-	Get(context.Context, string) (*db.Cycle, error)
-}
-
-type storageClient interface {
-	NewObject(_ context.Context, fileName string) io.WriteCloser
-	AllowPublicAccess(_ context.Context, fileName string) error
+type eventPublisher interface {
+	Publish(context.Context, webhook.Event)
 }
 
+// Handler processes the latest CIFP data with a pipeline, reporting the
+// outcome to subscribers. It does not authenticate callers, so it must be
+// wrapped in auth.Middleware.
 type Handler struct {
-	ServiceAccountEmail string
-	Verifier            googleVerifier
-	Cycles              cyclesAdderGetter
-	DisableAuth         bool
-	CifpURL             string
-	StorageClient       storageClient
-}
-
-type faaCIFPInfoResponse struct {
-	Edition []struct {
-		Name    string `json:"editionName"`
-		Format  string `json:"format"`
-		Date    string `json:"editionDate"`
-		Number  int    `json:"editionNumber"`
-		Product struct {
-			Name string `json:"productName"`
-			URL  string `json:"url"`
-		} `json:"product"`
-	} `json:"edition"`
+	Pipeline *pipeline.Pipeline
+	// Runs, if set, records the progress of runs requested over HTTP.
+	Runs *runs.Tracker
+	// Events, if set, is notified when cycles are published or skipped and
+	// when processing fails.
+	Events eventPublisher
 }
 
 // Handle processes the latest CIFP data and saves it to Google Cloud Storage.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !h.DisableAuth {
-		a := r.Header.Get("Authorization")
-		if a == "" {
-			http.Error(w, "Must provide credentials.", http.StatusUnauthorized)
-			return
-		}
-		email, err := h.Verifier.VerifyGoogle(r.Context(), auth.ParseAuthHeader(a))
-		if err != nil {
-			http.Error(w, "Invalid credentials.", http.StatusForbidden)
-			return
-		}
-		log.Printf("got user with email: %q", email)
-		if email != h.ServiceAccountEmail {
-			http.Error(w, "Invalid credentials.", http.StatusForbidden)
-			return
-		}
-	}
-	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, h.CifpURL, nil)
-	if err != nil {
-		log.Printf("Could not create request: %v", err)
-		http.Error(w, "Problem making request to FAA.", http.StatusInternalServerError)
-		return
-	}
-	req.Header.Set("accept", "application/json")
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Printf("Could not fetch FAA data: %v", err)
-		http.Error(w, "Could not fetch FAA data.", http.StatusInternalServerError)
-		return
-	}
-	if res.StatusCode != http.StatusOK {
-		log.Printf("Could not fetch FAA data, got status %s", res.Status)
-		http.Error(w, "Could not fetch FAA data.", http.StatusInternalServerError)
-		return
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		log.Printf("Could not read FAA data body: %v", err)
-		http.Error(w, "Could not read FAA data.", http.StatusInternalServerError)
-		return
-	}
-	var resCIFPInfo faaCIFPInfoResponse
-	log.Printf("raw: %s", b)
-	if err := json.Unmarshal(b, &resCIFPInfo); err != nil {
-		log.Printf("Could not unmarshal data: %v", err)
-		http.Error(w, "Could not read FAA data.", http.StatusInternalServerError)
-		return
-	}
-
-	if len(resCIFPInfo.Edition) == 0 {
-		log.Printf("Received no editions from FAA, want at least 1.")
-		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
-	}
-	edition := resCIFPInfo.Edition[0]
-	c, err := h.Cycles.Get(r.Context(), edition.Date)
+	trigger := "unauthenticated request"
+	if id := auth.IdentityFrom(r.Context()); id != nil {
+		logging.Infof(r.Context(), "Processing requested by %s.", id)
+		trigger = id.String()
+	}
+	ctx, run := h.Runs.Start(r.Context(), trigger)
+	err := h.Process(ctx)
+	run.Finish(err)
 	if err != nil {
-		log.Printf("Problem getting cycles: %v", err)
+		logging.Errorf(ctx, "Could not process FAA data: %v", err)
 		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
-	}
-	if c != nil {
-		log.Printf("Data already processed for %q, skipping.", edition.Date)
-		return
 	}
+}
 
-	fileReq, err := http.NewRequestWithContext(r.Context(), http.MethodGet, edition.Product.URL, nil)
-	if err != nil {
-		log.Printf("Could not create request: %v", err)
-		http.Error(w, "Problem making request to FAA.", http.StatusInternalServerError)
-		return
-	}
-	fileRes, err := http.DefaultClient.Do(fileReq)
-	if err != nil {
-		log.Printf("Could not fetch CIFP file: %v", err)
-		http.Error(w, "Could not fetch FAA data.", http.StatusInternalServerError)
-		return
-	}
-	defer fileRes.Body.Close()
-	tmpData, err := ioutil.TempFile("", "tempfaadata.zip")
-	if err != nil {
-		log.Printf("Could not create temp file: %v", err)
-		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
-	}
-	defer os.Remove(tmpData.Name())
-	originalName := "original/FAACIFP18_original_" + convertDateToFilename(edition.Date) + ".zip"
-	originalWriter := h.StorageClient.NewObject(r.Context(), originalName)
-	defer func() {
-		if err := originalWriter.Close(); err != nil {
-			log.Printf("Could not close orignal writer: %v", err)
-		}
-		log.Printf("Closed original writer.")
-	}()
-	mw := io.MultiWriter(originalWriter, tmpData)
-	bufSize, err := io.Copy(mw, fileRes.Body)
-	if err != nil {
-		log.Printf("Could not copy data: %v", err)
-		http.Error(w, "Could not fetch FAA data.", http.StatusInternalServerError)
-		return
-	}
-	log.Print("Copied original data.")
+// Process processes the latest CIFP data and saves it to Google Cloud
+// Storage, unless it has already been processed. Progress is reported to the
+// run carried by ctx, if any.
+func (h *Handler) Process(ctx context.Context) error {
+	ctx = withRun(ctx)
+	ctx, span := tracing.Start(ctx, "process.Handler.Process", label.String("run.id", runs.FromContext(ctx).ID()))
+	res, err := h.Pipeline.Run(ctx)
+	tracing.End(span, err)
+	switch {
+	case err != nil:
+		h.publish(ctx, webhook.Event{Type: webhook.EventFailed, Reason: err.Error()}, err)
+	case res.Skipped != "":
+		h.publish(ctx, webhook.Event{Type: webhook.EventSkipped, Cycle: res.Cycle, Reason: res.Skipped}, nil)
+	default:
+		h.publish(ctx, webhook.Event{Type: webhook.EventPublished, Cycle: res.Cycle, Processed: res.Artifact.Processed}, nil)
+	}
+	return err
+}
 
-	zipReader, err := zip.NewReader(tmpData, bufSize)
-	if err != nil {
-		log.Printf("Could not unzip data: %v", err)
-		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
-	}
-	var cifpZipFileReader io.ReadCloser
-	for _, zipFile := range zipReader.File {
-		log.Printf("file: %q", zipFile.Name)
-		if strings.HasSuffix(zipFile.Name, "FAACIFP18") {
-			if cifpZipFileReader, err = zipFile.Open(); err != nil {
-				log.Printf("Could not open file from zip archive: %v", err)
-				http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-				return
-			}
-			break
-		}
-	}
-	if cifpZipFileReader == nil {
-		log.Print("Could not find FAACIFP18 file in zip archive.")
-		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
-	}
-	defer cifpZipFileReader.Close()
-	tmpCifpData, err := ioutil.TempFile("", "tempcifpdata")
+// Reprocess processes the stored original data of c again and replaces its
+// processed data, for example after the enhancements have changed.
+func (h *Handler) Reprocess(ctx context.Context, c *db.Cycle) error {
+	ctx = withRun(ctx)
+	ctx, span := tracing.Start(ctx, "process.Handler.Reprocess", label.String("run.id", runs.FromContext(ctx).ID()), label.String("cycle", c.Name))
+	_, err := h.Pipeline.Reprocess(ctx, c)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("Could not create temp file: %v", err)
-		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
-	}
-	defer os.Remove(tmpCifpData.Name())
-	if _, err := io.Copy(tmpCifpData, cifpZipFileReader); err != nil {
-		log.Printf("Could not copy data: %v", err)
-		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
-	}
-
-	processedName := "processed/FAACIFP18_processed_" + convertDateToFilename(edition.Date)
-	processedWriter := h.StorageClient.NewObject(r.Context(), processedName)
-	if err := enhance.Process(tmpCifpData, processedWriter, enhance.RemoveDuplicateLocalizers(true)); err != nil {
-		log.Printf("Could not process data: %v", err)
-		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
-	}
-	processedWriter.Close()
-	if err := h.StorageClient.AllowPublicAccess(r.Context(), processedName); err != nil {
-		log.Printf("Could not set public access: %v", err)
-		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
+		h.publish(ctx, webhook.Event{Type: webhook.EventFailed, Cycle: c.Name, Reason: err.Error()}, err)
+		return err
 	}
+	h.publish(ctx, webhook.Event{Type: webhook.EventPublished, Cycle: c.Name, Processed: c.Processed, Reprocessed: true}, nil)
+	return nil
+}
 
-	parsedDate, err := time.Parse("01/02/2006", resCIFPInfo.Edition[0].Date)
-	if err != nil {
-		log.Printf("Could not parse date: %v", err)
-		http.Error(w, "Could not process FAA data.", http.StatusInternalServerError)
-		return
+// publish counts the outcome e describes and notifies subscribers of it. err
+// is the error a failed run returned.
+func (h *Handler) publish(ctx context.Context, e webhook.Event, err error) {
+	var outcome, reason string
+	switch e.Type {
+	case webhook.EventPublished:
+		outcome, reason = metrics.OutcomePublished, "new cycle"
+		if e.Reprocessed {
+			reason = "reprocessed"
+		}
+	case webhook.EventSkipped:
+		outcome, reason = metrics.OutcomeSkipped, e.Reason
+	case webhook.EventFailed:
+		// Error messages are too varied to be labels, so failures are
+		// counted by the stage they happened in.
+		outcome, reason = metrics.OutcomeFailed, runs.FromContext(ctx).Run().Stage
+		var stageErr *pipeline.Error
+		if errors.As(err, &stageErr) {
+			reason = string(stageErr.Stage)
+		}
 	}
-
-	if err := h.Cycles.Add(r.Context(), &db.Cycle{
-		Name:      resCIFPInfo.Edition[0].Date,
-		Original:  originalName,
-		Processed: processedName,
-		Date:      parsedDate,
-	}); err != nil {
-		log.Printf("Could not add cycle: %v", err)
-		http.Error(w, "Could not add cycle.", http.StatusInternalServerError)
-		return
+	metrics.ProcessingOutcomes.WithLabelValues(outcome, strings.Replace(reason, " ", "_", -1)).Inc()
+	if h.Events != nil {
+		h.Events.Publish(ctx, e)
 	}
 }
 
-func convertDateToFilename(date string) string {
-	return strings.Replace(date, "/", "-", -1)
+// withRun returns ctx carrying a run, starting an unrecorded one if it does
+// not carry one already, so that the stage of a failure is always known.
+func withRun(ctx context.Context) context.Context {
+	if runs.FromContext(ctx) != nil {
+		return ctx
+	}
+	ctx, _ = (*runs.Tracker)(nil).Start(ctx, "")
+	return ctx
 }
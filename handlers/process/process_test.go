@@ -3,6 +3,8 @@ package process
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -15,25 +17,25 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/metrics"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type fakeVerifier struct {
-	GotToken string
-	Email    string
-	Err      error
-}
-
-func (fv *fakeVerifier) VerifyGoogle(_ context.Context, token string) (string, error) {
-	fv.GotToken = token
-	return fv.Email, fv.Err
-}
-
 type fakeCyclesAdderGetter struct {
 	AddedCycle *db.Cycle
 	AddErr     error
 	GetCycle   *db.Cycle
 	GetErr     error
+	Checksums  map[string]string
 }
 
 func (ag *fakeCyclesAdderGetter) Add(_ context.Context, c *db.Cycle) error {
@@ -45,6 +47,28 @@ func (ag *fakeCyclesAdderGetter) Get(context.Context, string) (*db.Cycle, error)
 	return ag.GetCycle, ag.GetErr
 }
 
+func (ag *fakeCyclesAdderGetter) SetChecksum(_ context.Context, name, checksum string, _ time.Time) error {
+	if ag.Checksums == nil {
+		ag.Checksums = make(map[string]string)
+	}
+	ag.Checksums[name] = checksum
+	return nil
+}
+
+// checksum returns the hex encoded SHA-256 hash of b.
+func checksum(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+type fakeEvents struct {
+	published []webhook.Event
+}
+
+func (f *fakeEvents) Publish(_ context.Context, e webhook.Event) {
+	f.published = append(f.published, e)
+}
+
 type nopWriteCloser struct {
 	io.Writer
 }
@@ -66,6 +90,13 @@ func (fs *fakeGCSClient) NewObject(_ context.Context, fileName string) io.WriteC
 	return nil
 }
 
+func (fs *fakeGCSClient) NewReader(_ context.Context, fileName string) (io.ReadCloser, error) {
+	if !strings.Contains(fileName, "original") {
+		return nil, fmt.Errorf("object %q does not exist", fileName)
+	}
+	return ioutil.NopCloser(bytes.NewReader(fs.Original.Bytes())), nil
+}
+
 func (fs *fakeGCSClient) AllowPublicAccess(_ context.Context, fileName string) error {
 	fs.AllowPublicAccessFiles = append(fs.AllowPublicAccessFiles, fileName)
 	return nil
@@ -114,8 +145,8 @@ func newFakeCifpServer(config *fakeCifpServerConfig) *httptest.Server {
 }
 
 const (
-	testDataZipFile           = "original.zip"
-	wantTestDataProcessedFile = "want_processed.txt"
+	testDataZipFile           = "../../pipeline/original.zip"
+	wantTestDataProcessedFile = "../../pipeline/want_processed.txt"
 )
 
 const goodEditionResTmpl = `{
@@ -133,78 +164,22 @@ const goodEditionResTmpl = `{
 
 func goodEditionsRes(url string) string { return fmt.Sprintf(goodEditionResTmpl, url) }
 
-func TestHandleAuth(t *testing.T) {
-	const serviceAccountEmail = "some-email@example.com"
-	cifpZipData, err := ioutil.ReadFile(testDataZipFile)
-	if err != nil {
-		t.Fatalf("Could not read data file: %v", err)
+// newPipeline returns a pipeline that fetches editions from the fake CIFP
+// server at srvURL.
+func newPipeline(srvURL string, cycles pipeline.Recorder, storage pipeline.Storage) *pipeline.Pipeline {
+	faa := &pipeline.FAA{EditionsURL: srvURL + "/apra/cifp/chart"}
+	return &pipeline.Pipeline{
+		Metadata:   faa,
+		Downloader: faa,
+		Storage:    storage,
+		Recorder:   cycles,
 	}
+}
 
-	for _, tt := range []struct {
-		name         string
-		authHeader   string
-		fakeVerifier *fakeVerifier
-		wantStatus   int
-	}{
-		{
-			name:       "Good",
-			authHeader: "Bearer token",
-			fakeVerifier: &fakeVerifier{
-				Email: "some-email@example.com",
-			},
-			wantStatus: http.StatusOK,
-		},
-		{
-			name:       "NoAuthorization",
-			wantStatus: http.StatusUnauthorized,
-		},
-		{
-			name: "BadEmail",
-
-			authHeader: "Bearer token",
-			fakeVerifier: &fakeVerifier{
-				Email: "some-email@evil.com",
-			},
-			wantStatus: http.StatusForbidden,
-		},
-		{
-			name:       "VerificationError",
-			authHeader: "Bearer token",
-			fakeVerifier: &fakeVerifier{
-				Err: errors.New("problem verifying token"),
-			},
-			wantStatus: http.StatusForbidden,
-		},
-	} {
-		t.Run(tt.name, func(t *testing.T) {
-			srv := newFakeCifpServer(
-				&fakeCifpServerConfig{
-					EditionsRes:  goodEditionsRes,
-					CifpFileData: cifpZipData,
-				},
-			)
-			defer srv.Close()
-
-			rr := httptest.NewRecorder()
-			handler := &Handler{
-				ServiceAccountEmail: serviceAccountEmail,
-				Verifier:            tt.fakeVerifier,
-				Cycles:              &fakeCyclesAdderGetter{},
-				CifpURL:             srv.URL + "/apra/cifp/chart",
-				StorageClient:       &fakeGCSClient{},
-			}
-			req := httptest.NewRequest(http.MethodPost, "/", &bytes.Buffer{})
-			req.Header.Set("Authorization", tt.authHeader)
-			handler.ServeHTTP(rr, req)
-			if status := rr.Code; status != tt.wantStatus {
-				t.Errorf("handler returned wrong status code: got %d want %d",
-					status, tt.wantStatus)
-			}
-			if tt.wantStatus == http.StatusOK && tt.fakeVerifier.GotToken != "token" {
-				t.Errorf(`verifier received token %q want "token"`, tt.fakeVerifier.GotToken)
-			}
-		})
-	}
+var outcomeOf = map[string]string{
+	webhook.EventPublished: metrics.OutcomePublished,
+	webhook.EventSkipped:   metrics.OutcomeSkipped,
+	webhook.EventFailed:    metrics.OutcomeFailed,
 }
 
 func TestHandle(t *testing.T) {
@@ -224,6 +199,8 @@ func TestHandle(t *testing.T) {
 		wantStatus           int
 		wantSkipProcess      bool
 		wantAddCycle         *db.Cycle
+		wantEvent            string
+		wantReason           string
 	}{
 		{
 			name: "Good",
@@ -233,11 +210,14 @@ func TestHandle(t *testing.T) {
 			},
 			fakeCycles: &fakeCyclesAdderGetter{},
 			wantStatus: http.StatusOK,
+			wantEvent:  webhook.EventPublished,
+			wantReason: "new_cycle",
 			wantAddCycle: &db.Cycle{
 				Name:      "06/18/2020",
 				Original:  "original/FAACIFP18_original_06-18-2020.zip",
 				Processed: "processed/FAACIFP18_processed_06-18-2020",
 				Date:      time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				Checksum:  checksum(wantProcessedData),
 			},
 		},
 		{
@@ -249,6 +229,8 @@ func TestHandle(t *testing.T) {
 				CifpFileData: cifpZipData,
 			},
 			wantStatus: http.StatusInternalServerError,
+			wantEvent:  webhook.EventFailed,
+			wantReason: "fetching_editions",
 		},
 		{
 			name: "NoEditions",
@@ -259,6 +241,8 @@ func TestHandle(t *testing.T) {
 				CifpFileData: cifpZipData,
 			},
 			wantStatus: http.StatusInternalServerError,
+			wantEvent:  webhook.EventFailed,
+			wantReason: "fetching_editions",
 		},
 		{
 			name: "InvalidZipData",
@@ -268,6 +252,8 @@ func TestHandle(t *testing.T) {
 			},
 			fakeCycles: &fakeCyclesAdderGetter{},
 			wantStatus: http.StatusInternalServerError,
+			wantEvent:  webhook.EventFailed,
+			wantReason: "extracting",
 		},
 		{
 			name: "CycleExists",
@@ -279,6 +265,8 @@ func TestHandle(t *testing.T) {
 				GetCycle: &db.Cycle{Name: "06/18/2020"},
 			},
 			wantStatus:      http.StatusOK,
+			wantEvent:       webhook.EventSkipped,
+			wantReason:      "already_processed",
 			wantSkipProcess: true,
 		},
 		{
@@ -291,6 +279,8 @@ func TestHandle(t *testing.T) {
 				GetErr: errors.New("problem fetching cycles"),
 			},
 			wantStatus:      http.StatusInternalServerError,
+			wantEvent:       webhook.EventFailed,
+			wantReason:      "fetching_editions",
 			wantSkipProcess: true,
 		},
 		{
@@ -303,6 +293,28 @@ func TestHandle(t *testing.T) {
 				AddErr: errors.New("problem adding cycle"),
 			},
 			wantStatus: http.StatusInternalServerError,
+			wantEvent:  webhook.EventFailed,
+			wantReason: "recording",
+		},
+		{
+			name: "CycleAddedConcurrently",
+			fakeCifpServerConfig: &fakeCifpServerConfig{
+				EditionsRes:  goodEditionsRes,
+				CifpFileData: cifpZipData,
+			},
+			fakeCycles: &fakeCyclesAdderGetter{
+				AddErr: fmt.Errorf("could not add cycle: %w", db.ErrCycleExists),
+			},
+			wantStatus: http.StatusOK,
+			wantEvent:  webhook.EventSkipped,
+			wantReason: "processed_by_another_run",
+			wantAddCycle: &db.Cycle{
+				Name:      "06/18/2020",
+				Original:  "original/FAACIFP18_original_06-18-2020.zip",
+				Processed: "processed/FAACIFP18_processed_06-18-2020",
+				Date:      time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				Checksum:  checksum(wantProcessedData),
+			},
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
@@ -311,22 +323,34 @@ func TestHandle(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 			fakeGCS := &fakeGCSClient{}
+			tracker := &runs.Tracker{}
+			events := &fakeEvents{}
 			handler := &Handler{
-				ServiceAccountEmail: "some-email@example.com",
-				Verifier: &fakeVerifier{
-					Email: "some-email@example.com",
-				},
-				Cycles:        tt.fakeCycles,
-				CifpURL:       srv.URL + "/apra/cifp/chart",
-				StorageClient: fakeGCS,
+				Pipeline: newPipeline(srv.URL, tt.fakeCycles, fakeGCS),
+				Runs:     tracker,
+				Events:   events,
 			}
+			outcomes := metrics.ProcessingOutcomes.WithLabelValues(outcomeOf[tt.wantEvent], tt.wantReason)
+			wantOutcomes := testutil.ToFloat64(outcomes) + 1
 			req := httptest.NewRequest(http.MethodPost, "/", &bytes.Buffer{})
-			req.Header.Set("Authorization", "Bearer token")
 			handler.ServeHTTP(rr, req)
 			if status := rr.Code; status != tt.wantStatus {
 				t.Errorf("handler returned wrong status code: got %d want %d",
 					status, tt.wantStatus)
 			}
+			recent := tracker.Recent()
+			if len(recent) != 1 || !recent[0].Done() {
+				t.Fatalf("recorded runs = %+v want one finished run", recent)
+			}
+			if failed := recent[0].Err != ""; failed != (tt.wantStatus != http.StatusOK) {
+				t.Errorf("run error = %q, want failure %t", recent[0].Err, tt.wantStatus != http.StatusOK)
+			}
+			if len(events.published) != 1 || events.published[0].Type != tt.wantEvent {
+				t.Errorf("published events %+v want one %s event", events.published, tt.wantEvent)
+			}
+			if got := testutil.ToFloat64(outcomes); got != wantOutcomes {
+				t.Errorf("processing_outcomes_total{reason=%q} = %v want %v", tt.wantReason, got, wantOutcomes)
+			}
 			if tt.wantStatus != http.StatusOK {
 				return
 			}
@@ -351,9 +375,123 @@ func TestHandle(t *testing.T) {
 			if !strings.Contains(fakeGCS.AllowPublicAccessFiles[0], "processed") {
 				t.Error("expected processed file to be marked public")
 			}
-			if diff := cmp.Diff(tt.wantAddCycle, tt.fakeCycles.AddedCycle); diff != "" {
+			if diff := cmp.Diff(tt.wantAddCycle, tt.fakeCycles.AddedCycle, cmpopts.IgnoreFields(db.Cycle{}, "Updated")); diff != "" {
 				t.Errorf("added cycle differs: %v", diff)
 			}
 		})
 	}
 }
+
+func TestReprocess(t *testing.T) {
+	cifpZipData, err := ioutil.ReadFile(testDataZipFile)
+	if err != nil {
+		t.Fatalf("Could not read data file: %v", err)
+	}
+	wantProcessedData, err := ioutil.ReadFile(wantTestDataProcessedFile)
+	if err != nil {
+		t.Fatalf("Could not read data file: %v", err)
+	}
+	fakeGCS := &fakeGCSClient{}
+	fakeGCS.Original.Write(cifpZipData)
+	events := &fakeEvents{}
+	cycles := &fakeCyclesAdderGetter{}
+	handler := &Handler{Pipeline: newPipeline("", cycles, fakeGCS), Events: events}
+
+	c := &db.Cycle{
+		Name:      "06/18/2020",
+		Original:  "original/FAACIFP18_original_06-18-2020.zip",
+		Processed: "processed/FAACIFP18_processed_06-18-2020",
+	}
+	if err := handler.Reprocess(context.Background(), c); err != nil {
+		t.Fatalf("Reprocess() = %v want <nil>", err)
+	}
+	if diff := cmp.Diff(wantProcessedData, fakeGCS.Processed.Bytes()); diff != "" {
+		t.Errorf("processed file data had diffs: %s", diff)
+	}
+	if diff := cmp.Diff([]string{c.Processed}, fakeGCS.AllowPublicAccessFiles); diff != "" {
+		t.Errorf("public files differ: %s", diff)
+	}
+	if got, want := cycles.Checksums[c.Name], checksum(wantProcessedData); got != want {
+		t.Errorf("recorded checksum %q want %q", got, want)
+	}
+
+	c.Original = "missing"
+	if err := handler.Reprocess(context.Background(), c); err == nil {
+		t.Error("Reprocess() of missing original = <nil> want <non-nil>")
+	}
+	var gotTypes []string
+	for _, e := range events.published {
+		gotTypes = append(gotTypes, e.Type)
+	}
+	if diff := cmp.Diff([]string{webhook.EventPublished, webhook.EventFailed}, gotTypes); diff != "" {
+		t.Errorf("published events diff (-want +got): %s", diff)
+	}
+	if !events.published[0].Reprocessed {
+		t.Error("published event does not say the cycle was reprocessed")
+	}
+}
+
+func TestProcessSpans(t *testing.T) {
+	cifpZipData, err := ioutil.ReadFile(testDataZipFile)
+	if err != nil {
+		t.Fatalf("Could not read data file: %v", err)
+	}
+	for _, tt := range []struct {
+		name       string
+		fileData   []byte
+		wantErr    bool
+		wantFailed []string
+	}{
+		{name: "Good", fileData: cifpZipData},
+		{name: "BadZip", fileData: []byte("not a zip"), wantErr: true, wantFailed: []string{"extracting", "process.Handler.Process"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := &oteltest.StandardSpanRecorder{}
+			otel.SetTracerProvider(oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)))
+			defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+			srv := newFakeCifpServer(&fakeCifpServerConfig{
+				EditionsRes:  goodEditionsRes,
+				CifpFileData: tt.fileData,
+			})
+			defer srv.Close()
+			handler := &Handler{Pipeline: newPipeline(srv.URL, &fakeCyclesAdderGetter{}, &fakeGCSClient{})}
+
+			if err := handler.Process(context.Background()); (err != nil) != tt.wantErr {
+				t.Fatalf("Process() = %v, want error %t", err, tt.wantErr)
+			}
+
+			spans := sr.Completed()
+			names := make(map[trace.SpanID]string)
+			for _, s := range spans {
+				names[s.SpanContext().SpanID] = s.Name()
+			}
+			parents := make(map[string]string)
+			var failed []string
+			for _, s := range spans {
+				parents[s.Name()] = names[s.ParentSpanID()]
+				if s.StatusCode() == codes.Error {
+					failed = append(failed, s.Name())
+				}
+			}
+			wantParents := map[string]string{
+				"process.Handler.Process": "",
+				"fetching editions":       "process.Handler.Process",
+				"downloading":             "process.Handler.Process",
+				"extracting":              "process.Handler.Process",
+			}
+			if !tt.wantErr {
+				wantParents["enhancing"] = "process.Handler.Process"
+				wantParents["publishing"] = "process.Handler.Process"
+				wantParents["recording"] = "process.Handler.Process"
+			}
+			for name, want := range wantParents {
+				if got, ok := parents[name]; !ok || got != want {
+					t.Errorf("span %q has parent %q (recorded: %t) want %q", name, got, ok, want)
+				}
+			}
+			if diff := cmp.Diff(tt.wantFailed, failed); diff != "" {
+				t.Errorf("failed spans differ (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader is the response header carrying the ID of a request, so
+// that callers can quote it when reporting problems.
+const RequestIDHeader = "X-Request-Id"
+
+// Middleware gives each request an ID, which is carried by its context and
+// sent back in the X-Request-Id header, and logs each request once it has
+// been served. If projectID is set, entries are also grouped under the Cloud
+// Trace trace of their request.
+func Middleware(projectID string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newID()
+		ctx := With(r.Context(), RequestIDField, id)
+		if trace := traceID(r); projectID != "" && trace != "" {
+			ctx = With(ctx, traceField, "projects/"+projectID+"/traces/"+trace)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		h.ServeHTTP(rec, r.WithContext(ctx))
+		Debugf(ctx, "Served %s %s with status %d in %v.", r.Method, r.URL.Path, rec.status, time.Since(start))
+	})
+}
+
+// traceID returns the trace ID from the X-Cloud-Trace-Context header that
+// Google's front ends add, which has the form TRACE_ID/SPAN_ID;o=OPTIONS.
+func traceID(r *http.Request) string {
+	h := r.Header.Get("X-Cloud-Trace-Context")
+	if i := strings.Index(h, "/"); i >= 0 {
+		h = h[:i]
+	}
+	return h
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// LevelHandler reports the log level on GET and changes it on PUT, with a
+// body such as {"level": "debug"}. It does not authenticate callers, so it
+// must be wrapped in auth.Middleware.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Level Level `json:"level"`
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid log level: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			SetLevel(body.Level)
+			Infof(r.Context(), "Log level set to %s.", body.Level)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+		body.Level = GetLevel()
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	})
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
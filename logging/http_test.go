@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	capture(t)
+	var gotID, gotTrace string
+	h := Middleware("project", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = Field(r.Context(), RequestIDField)
+		gotTrace = Field(r.Context(), traceField)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if gotID == "" || rr.Header().Get(RequestIDHeader) != gotID {
+		t.Errorf("request ID = %q, header %q want the same non-empty ID", gotID, rr.Header().Get(RequestIDHeader))
+	}
+	if want := "projects/project/traces/105445aa7843bc8bf206b12000100000"; gotTrace != want {
+		t.Errorf("trace = %q want %q", gotTrace, want)
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	capture(t)
+	for _, tt := range []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantLevel  Level
+	}{
+		{name: "Get", method: http.MethodGet, wantStatus: http.StatusOK, wantBody: `{"level":"info"}`, wantLevel: Info},
+		{name: "Set", method: http.MethodPut, body: `{"level": "debug"}`, wantStatus: http.StatusOK, wantBody: `{"level":"debug"}`, wantLevel: Debug},
+		{name: "Unknown", method: http.MethodPut, body: `{"level": "loud"}`, wantStatus: http.StatusBadRequest, wantLevel: Debug},
+		{name: "WrongMethod", method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed, wantLevel: Debug},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			LevelHandler().ServeHTTP(rr, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(rr.Body.String()) != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %q want %q", rr.Body.String(), tt.wantBody)
+			}
+			if got := GetLevel(); got != tt.wantLevel {
+				t.Errorf("GetLevel() = %v want %v", got, tt.wantLevel)
+			}
+		})
+	}
+}
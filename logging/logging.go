@@ -0,0 +1,239 @@
+// Package logging writes leveled, structured log entries as JSON lines in the
+// format Cloud Logging parses from the output of App Engine and Cloud Run.
+// Entries include the request and run IDs carried by the context they are
+// logged with.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warning
+	Error
+	Critical
+)
+
+// Fields added to the context by the app.
+const (
+	RequestIDField = "request_id"
+	RunIDField     = "run_id"
+	traceField     = "logging.googleapis.com/trace"
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARNING", "ERROR", "CRITICAL"}
+
+// String returns the Cloud Logging severity of l.
+func (l Level) String() string {
+	if l < Debug || l > Critical {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s, such as "info" or "WARNING".
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToUpper(s)
+	if s == "WARN" {
+		return Warning, nil
+	}
+	for l, name := range levelNames {
+		if s == name {
+			return Level(l), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(strings.ToLower(l.String())), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (l *Level) UnmarshalText(b []byte) error {
+	parsed, err := ParseLevel(string(b))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// Logger writes entries at or above its level to an output.
+type Logger struct {
+	level int32 // Accessed atomically.
+
+	mu  sync.Mutex
+	out io.Writer
+	now func() time.Time
+}
+
+// New returns a logger that writes entries at Info or above to out.
+func New(out io.Writer) *Logger {
+	return &Logger{level: int32(Info), out: out}
+}
+
+// SetLevel sets the lowest level of entries the logger writes.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// Level returns the lowest level of entries the logger writes.
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.level))
+}
+
+type sourceLocation struct {
+	File     string `json:"file"`
+	Line     string `json:"line"`
+	Function string `json:"function"`
+}
+
+// Log writes msg at level with the fields carried by ctx. depth is the
+// number of stack frames between the caller to attribute the entry to and
+// Log.
+func (l *Logger) Log(ctx context.Context, depth int, level Level, msg string) {
+	if level < l.Level() {
+		return
+	}
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	entry := map[string]interface{}{
+		"severity": level.String(),
+		"message":  msg,
+		"time":     now.UTC().Format(time.RFC3339Nano),
+	}
+	for _, f := range fieldsFrom(ctx) {
+		entry[f.key] = f.value
+	}
+	if pc, file, line, ok := runtime.Caller(depth + 1); ok {
+		loc := sourceLocation{File: file, Line: fmt.Sprint(line)}
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			loc.Function = fn.Name()
+		}
+		entry["logging.googleapis.com/sourceLocation"] = loc
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"severity":"ERROR","message":%q}`, "Could not encode log entry: "+err.Error()))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(b, '\n'))
+}
+
+var std = New(os.Stdout)
+
+// SetLevel sets the lowest level of entries logged by the package functions.
+func SetLevel(level Level) {
+	std.SetLevel(level)
+}
+
+// GetLevel returns the lowest level of entries logged by the package
+// functions.
+func GetLevel() Level {
+	return std.Level()
+}
+
+// Debugf logs a debug entry with the fields carried by ctx.
+func Debugf(ctx context.Context, format string, args ...interface{}) {
+	std.Log(ctx, 1, Debug, fmt.Sprintf(format, args...))
+}
+
+// Infof logs an informational entry with the fields carried by ctx.
+func Infof(ctx context.Context, format string, args ...interface{}) {
+	std.Log(ctx, 1, Info, fmt.Sprintf(format, args...))
+}
+
+// Warningf logs a warning with the fields carried by ctx.
+func Warningf(ctx context.Context, format string, args ...interface{}) {
+	std.Log(ctx, 1, Warning, fmt.Sprintf(format, args...))
+}
+
+// Errorf logs an error with the fields carried by ctx.
+func Errorf(ctx context.Context, format string, args ...interface{}) {
+	std.Log(ctx, 1, Error, fmt.Sprintf(format, args...))
+}
+
+// Fatalf logs a critical entry with the fields carried by ctx and exits.
+func Fatalf(ctx context.Context, format string, args ...interface{}) {
+	std.Log(ctx, 1, Critical, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// Writer returns a writer that logs each line written to it at level, for
+// redirecting the standard library's log package.
+func Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+			std.Log(context.Background(), 4, level, line)
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+type field struct {
+	key, value string
+}
+
+type fieldsKey struct{}
+
+// With returns a copy of ctx whose log entries include key with value,
+// replacing any value key already had.
+func With(ctx context.Context, key, value string) context.Context {
+	old := fieldsFrom(ctx)
+	fields := make([]field, 0, len(old)+1)
+	for _, f := range old {
+		if f.key != key {
+			fields = append(fields, f)
+		}
+	}
+	return context.WithValue(ctx, fieldsKey{}, append(fields, field{key, value}))
+}
+
+// Detach returns a background context carrying the log fields of ctx, for
+// work that outlives ctx but should still be logged as part of it.
+func Detach(ctx context.Context) context.Context {
+	return context.WithValue(context.Background(), fieldsKey{}, fieldsFrom(ctx))
+}
+
+// Field returns the value of key carried by ctx, or empty if there is none.
+func Field(ctx context.Context, key string) string {
+	for _, f := range fieldsFrom(ctx) {
+		if f.key == key {
+			return f.value
+		}
+	}
+	return ""
+}
+
+func fieldsFrom(ctx context.Context) []field {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]field)
+	return fields
+}
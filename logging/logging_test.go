@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// capture replaces the standard logger with one writing to the returned
+// buffer until the test ends.
+func capture(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	old := std
+	std = New(&buf)
+	std.now = func() time.Time { return time.Date(2020, 7, 16, 12, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() { std = old })
+	return &buf
+}
+
+func decodeEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("json.Unmarshal(%q) = %v want <nil>", line, err)
+		}
+		delete(e, "logging.googleapis.com/sourceLocation")
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestLog(t *testing.T) {
+	buf := capture(t)
+	ctx := With(context.Background(), RequestIDField, "req")
+	ctx = With(ctx, RunIDField, "run-1")
+	ctx = With(ctx, RunIDField, "run-2")
+
+	Debugf(ctx, "Hidden %d.", 1)
+	Infof(ctx, "Processed %q.", "06/18/2020")
+	Errorf(context.Background(), "Could not process: %v", "timeout")
+
+	want := []map[string]interface{}{
+		{"severity": "INFO", "message": `Processed "06/18/2020".`, "time": "2020-07-16T12:00:00Z", "request_id": "req", "run_id": "run-2"},
+		{"severity": "ERROR", "message": "Could not process: timeout", "time": "2020-07-16T12:00:00Z"},
+	}
+	if diff := cmp.Diff(want, decodeEntries(t, buf)); diff != "" {
+		t.Errorf("unexpected entries diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSourceLocation(t *testing.T) {
+	buf := capture(t)
+	Infof(context.Background(), "Here.")
+	var e struct {
+		Loc sourceLocation `json:"logging.googleapis.com/sourceLocation"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("json.Unmarshal() = %v want <nil>", err)
+	}
+	if !strings.HasSuffix(e.Loc.File, "logging_test.go") || !strings.HasSuffix(e.Loc.Function, "TestSourceLocation") {
+		t.Errorf("source location = %+v want TestSourceLocation in logging_test.go", e.Loc)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	buf := capture(t)
+	SetLevel(Warning)
+	Infof(context.Background(), "Hidden.")
+	Warningf(context.Background(), "Shown.")
+	SetLevel(Debug)
+	Debugf(context.Background(), "Also shown.")
+
+	var got []string
+	for _, e := range decodeEntries(t, buf) {
+		got = append(got, e["message"].(string))
+	}
+	if diff := cmp.Diff([]string{"Shown.", "Also shown."}, got); diff != "" {
+		t.Errorf("unexpected messages diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, tt := range []struct {
+		s       string
+		want    Level
+		wantErr bool
+	}{
+		{s: "debug", want: Debug},
+		{s: "INFO", want: Info},
+		{s: "warn", want: Warning},
+		{s: "Warning", want: Warning},
+		{s: "error", want: Error},
+		{s: "loud", wantErr: true},
+	} {
+		got, err := ParseLevel(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v want %v, error %t", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	buf := capture(t)
+	Writer(Warning).Write([]byte("first\nsecond\n"))
+	want := []map[string]interface{}{
+		{"severity": "WARNING", "message": "first", "time": "2020-07-16T12:00:00Z"},
+		{"severity": "WARNING", "message": "second", "time": "2020-07-16T12:00:00Z"},
+	}
+	if diff := cmp.Diff(want, decodeEntries(t, buf)); diff != "" {
+		t.Errorf("unexpected entries diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	ctx, cancel := context.WithCancel(With(context.Background(), RunIDField, "run"))
+	cancel()
+	detached := Detach(ctx)
+	if detached.Err() != nil {
+		t.Errorf("Detach() returned a context with error %v want <nil>", detached.Err())
+	}
+	if got := Field(detached, RunIDField); got != "run" {
+		t.Errorf("Field() = %q want %q", got, "run")
+	}
+}
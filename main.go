@@ -3,28 +3,50 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/airports"
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/auth"
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/blob"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/config"
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db/sqlite"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/admin"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/airport"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/api"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/apikeys"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/deadletters"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/download"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/feed"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/health"
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/index"
 	"github.com/wallaceicy06/webapp-enhance-faa-cifp/handlers/process"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/metrics"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/processing"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/scheduler"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/webhook"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 )
 
-var (
-	serviceAccountEmail = flag.String("service_account_email", os.Getenv("SERVICE_ACCOUNT"), "Service account email to verify when processing data.")
-	projectID           = flag.String("project_id", os.Getenv("PROJECT_ID"), "Project ID that contains the Firestore database.")
-	gcsBucket           = flag.String("gcs_bucket", "faa-cifp-data", "The Google Cloud Storage bucket to write data to.")
-	disableAuth         = flag.Bool("noauth", false, "Disable authentication for testng purposes.")
-	port                = flag.String("port", os.Getenv("PORT"), "port to start server on")
-)
+var configPath = flag.String("config", os.Getenv("CONFIG"), "Path to a JSON configuration file. Settings it does not give are taken from the environment or their defaults.")
 
 func handlerWithTimeout(h http.Handler, d time.Duration) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -35,50 +57,297 @@ func handlerWithTimeout(h http.Handler, d time.Duration) http.Handler {
 	})
 }
 
+// routeHandler limits h to the timeout of route, if it has one.
+func routeHandler(route config.Route, h http.Handler) http.Handler {
+	if route.Timeout == 0 {
+		return h
+	}
+	return handlerWithTimeout(h, time.Duration(route.Timeout))
+}
+
+// handleTree serves h at the path of route and every path below it.
+func handleTree(route config.Route, h http.Handler) {
+	h = routeHandler(route, h)
+	http.Handle(route.Path, h)
+	http.Handle(route.Path+"/", h)
+}
+
+// stores are the collections of a database.
+type stores struct {
+	cycles      db.Store
+	apiKeys     db.APIKeyStore
+	deadLetters db.DeadLetterStore
+	leases      db.LeaseStore
+	nonces      db.NonceStore
+}
+
+// openStores opens the database described by rawURL, which is either
+// firestore://project or sqlite:///path/to/file.db.
+func openStores(ctx context.Context, rawURL string) (*stores, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database URL: %v", err)
+	}
+	switch u.Scheme {
+	case "firestore":
+		if u.Host == "" {
+			return nil, fmt.Errorf("database URL %q must name a project", rawURL)
+		}
+		fsClient, err := firestore.NewClient(ctx, u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("could not create firestore client: %v", err)
+		}
+		return &stores{
+			cycles:      &db.Cycles{Client: fsClient},
+			apiKeys:     &db.APIKeys{Client: fsClient},
+			deadLetters: &db.DeadLetters{Client: fsClient},
+			leases:      &db.Leases{Client: fsClient},
+			nonces:      &db.Nonces{Client: fsClient},
+		}, nil
+	case "sqlite":
+		if u.Path == "" {
+			return nil, fmt.Errorf("database URL %q must name a file", rawURL)
+		}
+		cycles, err := sqlite.Open(ctx, u.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &stores{
+			cycles:      cycles,
+			apiKeys:     &sqlite.APIKeys{DB: cycles.DB},
+			deadLetters: &sqlite.DeadLetters{DB: cycles.DB},
+			leases:      &sqlite.Leases{DB: cycles.DB},
+			nonces:      &sqlite.Nonces{DB: cycles.DB},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database %q, want firestore or sqlite", u.Scheme)
+	}
+}
+
+// newAdminHandler returns the admin console served at prefix, where admins
+// sign in with their Google account.
+func newAdminHandler(cfg *config.Auth, prefix string, m *auth.Middleware, cycles db.Store, p *process.Handler, t *runs.Tracker) (*admin.Handler, error) {
+	key := []byte(cfg.SessionKey)
+	if len(key) == 0 {
+		logging.Warningf(context.Background(), "No session key provided, admin sessions will not survive restarts.")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("could not generate session key: %v", err)
+		}
+	}
+	h := &admin.Handler{
+		Prefix:     prefix,
+		Identities: m,
+		Sessions:   &auth.Sessions{Key: key, Path: prefix, Insecure: m.Insecure},
+		Cycles:     cycles,
+		Processor:  p,
+		Runs:       t,
+	}
+	if cfg.OAuth.ClientID == "" {
+		return h, nil
+	}
+	if cfg.OAuth.RedirectURL == "" {
+		return nil, errors.New("must provide an OAuth redirect URL")
+	}
+	verifier, err := auth.NewOIDCVerifier(auth.GoogleIssuer(auth.Policy{Audience: cfg.OAuth.ClientID}))
+	if err != nil {
+		return nil, err
+	}
+	h.Verifier = verifier
+	h.OAuth = &oauth2.Config{
+		ClientID:     cfg.OAuth.ClientID,
+		ClientSecret: cfg.OAuth.ClientSecret,
+		Endpoint:     google.Endpoint,
+		RedirectURL:  cfg.OAuth.RedirectURL,
+		Scopes:       []string{"openid", "email"},
+	}
+	return h, nil
+}
+
 func main() {
 	ctx := context.Background()
 	flag.Parse()
-
-	if *serviceAccountEmail == "" {
-		log.Fatal("Must provide a service account email.")
+	// Route anything logged through the standard library, such as errors
+	// from the HTTP server, through the structured logger.
+	log.SetFlags(0)
+	log.SetOutput(logging.Writer(logging.Info))
+	cfg, err := config.Load(*configPath, os.Getenv)
+	if err != nil {
+		logging.Fatalf(ctx, "Could not load configuration: %v", err)
 	}
-	if *projectID == "" {
-		log.Fatal("Must provide a project ID.")
+	logging.SetLevel(cfg.Logging.Level)
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		ServiceName: "webapp-enhance-faa-cifp",
+	})
+	if err != nil {
+		logging.Fatalf(ctx, "Could not set up tracing: %v", err)
 	}
+	defer shutdownTracing(ctx)
 
-	fsClient, err := firestore.NewClient(ctx, *projectID)
+	issuers := append([]*auth.Issuer{auth.GoogleIssuer(auth.Policy{
+		Audience:          cfg.Auth.Audience,
+		AuthorizedParties: cfg.Auth.AuthorizedParties,
+		HostedDomains:     cfg.Auth.HostedDomains,
+	})}, cfg.Auth.TrustedIssuers...)
+	verifier, err := auth.NewOIDCVerifier(issuers...)
+	if err != nil && !cfg.Auth.Disabled {
+		logging.Fatalf(ctx, "Invalid trusted issuers: %v", err)
+	}
+	bindings := append([]auth.RoleBinding{{
+		Role:   auth.RoleScheduler,
+		Issuer: auth.GoogleIssuerURL,
+		Claims: map[string][]string{"email": {cfg.Auth.ServiceAccountEmail}},
+	}}, cfg.Auth.RoleBindings...)
+	dbStores, err := openStores(ctx, cfg.Database.URL)
 	if err != nil {
-		log.Fatalf("Could not create firestore client: %v", err)
+		logging.Fatalf(ctx, "Could not open database: %v", err)
 	}
-	gcsClient, err := storage.NewClient(ctx)
+	cyclesDb := dbStores.cycles
+	dispatcher, err := webhook.NewDispatcher(dbStores.deadLetters, cfg.Webhooks...)
 	if err != nil {
-		log.Fatalf("Could not create Google Cloud Storage client: %v", err)
+		logging.Fatalf(ctx, "Invalid webhooks: %v", err)
 	}
-
-	cyclesDb := &db.Cycles{
-		Client: fsClient,
+	authMiddleware := &auth.Middleware{
+		Verifier: verifier,
+		Bindings: bindings,
+		Insecure: cfg.Auth.Disabled,
+	}
+	pepper := []byte(cfg.Auth.APIKeyPepper)
+	if len(pepper) == 0 {
+		logging.Warningf(ctx, "No API key pepper provided, API keys are disabled.")
+	} else {
+		authMiddleware.APIKeys = &auth.APIKeyVerifier{
+			Keys:   dbStores.apiKeys,
+			Nonces: dbStores.nonces,
+			Pepper: pepper,
+		}
+	}
+	gcsClient, err := storage.NewClient(ctx)
+	if err != nil {
+		logging.Fatalf(ctx, "Could not create Google Cloud Storage client: %v", err)
 	}
 
-	http.Handle("/", handlerWithTimeout(&index.Handler{
-		BucketName: *gcsBucket,
+	routes := cfg.Routes
+	http.Handle(routes.Index.Path, metrics.InstrumentHandler("index", routeHandler(routes.Index, &index.Handler{
+		BucketName:   cfg.Storage.Bucket,
+		Cycles:       cyclesDb,
+		AirportsPath: routes.Airports.Path,
+	})))
+	http.Handle(routes.AtomFeed.Path, routeHandler(routes.AtomFeed, &feed.Handler{
+		Format:     feed.Atom,
+		BucketName: cfg.Storage.Bucket,
 		Cycles:     cyclesDb,
-	}, 5*time.Second))
-	http.Handle("/process", handlerWithTimeout(&process.Handler{
-		ServiceAccountEmail: *serviceAccountEmail,
-		Cycles:              cyclesDb,
-		DisableAuth:         *disableAuth,
-		Verifier:            auth.NewVerifier(),
-		CifpURL:             "https://soa.smext.faa.gov/apra/cifp/chart?edition=next",
-		StorageClient:       &blob.GCSClient{Client: gcsClient, BucketName: *gcsBucket},
-	}, 120*time.Second))
-
-	if *port == "" {
-		*port = "8080"
-		log.Printf("Defaulting to port %s", *port)
+		SiteURL:    cfg.Server.SiteURL,
+	}))
+	http.Handle(routes.RSSFeed.Path, routeHandler(routes.RSSFeed, &feed.Handler{
+		Format:     feed.RSS,
+		BucketName: cfg.Storage.Bucket,
+		Cycles:     cyclesDb,
+		SiteURL:    cfg.Server.SiteURL,
+	}))
+	blobClient := &blob.GCSClient{Client: gcsClient, BucketName: cfg.Storage.Bucket}
+	airportStore := &airports.Store{Storage: blobClient}
+	runTracker := &runs.Tracker{}
+	faa := &pipeline.FAA{EditionsURL: cfg.FAA.EditionsURL}
+	processor := processing.NewPipeline(cfg, blobClient)
+	processor.Metadata = faa
+	processor.Downloader = faa
+	processor.Recorder = cyclesDb
+	processHandler := &process.Handler{
+		Pipeline: processor,
+		Runs:     runTracker,
+		Events:   dispatcher,
+	}
+	http.Handle(routes.Process.Path, metrics.InstrumentHandler("process", routeHandler(routes.Process, authMiddleware.Require(auth.RoleScheduler, processHandler))))
+	handleTree(routes.Download, metrics.InstrumentHandler("download", &download.Handler{
+		Cycles:  cyclesDb,
+		Storage: blobClient,
+		Prefix:  routes.Download.Path,
+	}))
+	handleTree(routes.API, metrics.InstrumentHandler("api", &api.Handler{
+		Cycles:   cyclesDb,
+		Airports: airportStore,
+		Prefix:   routes.API.Path,
+	}))
+	http.Handle(routes.Airports.Path, metrics.InstrumentHandler("airports", routeHandler(routes.Airports, &airport.Handler{
+		Cycles:   cyclesDb,
+		Airports: airportStore,
+	})))
+	http.Handle(routes.Metrics.Path, routeHandler(routes.Metrics, authMiddleware.Require(auth.RoleReader, promhttp.Handler())))
+	http.Handle(routes.Healthz.Path, routeHandler(routes.Healthz, health.Live()))
+	http.Handle(routes.Readyz.Path, routeHandler(routes.Readyz, &health.Ready{
+		Checks: []health.Check{
+			health.Database(cyclesDb),
+			health.Storage(blobClient, cfg.Storage.HealthObject),
+			health.Endpoint("faa", cfg.FAA.EditionsURL, nil),
+		},
+		Timeout:  time.Duration(cfg.Health.CheckTimeout),
+		CacheFor: time.Duration(cfg.Health.CacheFor),
+	}))
+	schedulerCtx, stopScheduler := context.WithCancel(ctx)
+	defer stopScheduler()
+	schedulerDone := make(chan struct{})
+	if cfg.Scheduler.Schedule != "" {
+		sched, err := scheduler.New(cfg.Scheduler.Schedule, dbStores.leases, processHandler)
+		if err != nil {
+			logging.Fatalf(ctx, "Could not create scheduler: %v", err)
+		}
+		sched.Runs = runTracker
+		sched.LeaseTTL = time.Duration(cfg.Scheduler.LeaseTTL)
+		sched.RunTimeout = time.Duration(cfg.RunTimeout)
+		go func() {
+			defer close(schedulerDone)
+			sched.Run(schedulerCtx)
+		}()
+	} else {
+		close(schedulerDone)
+	}
+	handleTree(routes.APIKeys, authMiddleware.Require(auth.RoleAdmin, &apikeys.Handler{
+		Keys:   dbStores.apiKeys,
+		Prefix: routes.APIKeys.Path,
+		Pepper: pepper,
+	}))
+	handleTree(routes.DeadLetters, authMiddleware.Require(auth.RoleAdmin, &deadletters.Handler{
+		DeadLetters: dbStores.deadLetters,
+		Replayer:    dispatcher,
+		Prefix:      routes.DeadLetters.Path,
+	}))
+	http.Handle(routes.LogLevel.Path, routeHandler(routes.LogLevel, authMiddleware.Require(auth.RoleAdmin, logging.LevelHandler())))
+	if cfg.Auth.OAuth.ClientID != "" || cfg.Auth.Disabled {
+		adminHandler, err := newAdminHandler(&cfg.Auth, routes.Admin.Path, authMiddleware, cyclesDb, processHandler, runTracker)
+		if err != nil {
+			logging.Fatalf(ctx, "Could not set up admin console: %v", err)
+		}
+		adminHandler.RunTimeout = time.Duration(cfg.RunTimeout)
+		handleTree(routes.Admin, adminHandler)
 	}
 
-	log.Printf("Listening on port %s", *port)
-	if err := http.ListenAndServe(":"+*port, nil); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{
+		Addr:    ":" + cfg.Server.Port,
+		Handler: logging.Middleware(cfg.Server.ProjectID, tracing.Middleware(http.DefaultServeMux)),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	logging.Infof(ctx, "Listening on port %s", cfg.Server.Port)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		logging.Fatalf(ctx, "Could not serve: %v", err)
+	case sig := <-stop:
+		logging.Infof(ctx, "Received %v.", sig)
 	}
+	(&graceful{
+		server:        srv,
+		runs:          runTracker,
+		stopScheduler: stopScheduler,
+		schedulerDone: schedulerDone,
+		events:        dispatcher,
+		drainPeriod:   time.Duration(cfg.Server.DrainPeriod),
+	}).shutdown(ctx)
 }
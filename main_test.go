@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -26,3 +30,50 @@ func TestHandlerWithTimeout(t *testing.T) {
 		t.Errorf("handler returned status %d want 200", status)
 	}
 }
+
+func TestOpenStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintest")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{
+			name: "SQLite",
+			url:  "sqlite://" + filepath.Join(dir, "cycles.db"),
+		},
+		{
+			name:    "SQLiteNoPath",
+			url:     "sqlite://",
+			wantErr: true,
+		},
+		{
+			name:    "FirestoreNoProject",
+			url:     "firestore://",
+			wantErr: true,
+		},
+		{
+			name:    "UnknownScheme",
+			url:     "postgres://localhost/cycles",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := openStores(context.Background(), tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("openStores(_, %q) = _, <nil> want _, <non-nil>", tt.url)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("openStores(_, %q) = _, %v want _, <nil>", tt.url, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Package metrics defines the Prometheus metrics of the app. They are
+// registered with the default registry, which is served at /metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cifp"
+
+// Outcomes of processing.
+const (
+	OutcomePublished = "published"
+	OutcomeSkipped   = "skipped"
+	OutcomeFailed    = "failed"
+)
+
+var (
+	// FAARequestDuration observes requests to the FAA until their headers
+	// are received, by request ("editions" or "download") and status code,
+	// or "error" if there was no response.
+	FAARequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "faa_request_duration_seconds",
+		Help:      "Latency of requests to the FAA by request and status code.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"request", "code"})
+
+	// DownloadedBytes counts the original data read, by source ("faa" or
+	// "storage" when reprocessing).
+	DownloadedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Bytes of original CIFP data downloaded by source.",
+	}, []string{"source"})
+
+	// UploadedBytes counts the data written to storage, by object
+	// ("original" or "processed").
+	UploadedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Bytes written to storage by object.",
+	}, []string{"object"})
+
+	// EnhanceDuration observes how long enhancing the CIFP data takes.
+	EnhanceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "enhance_duration_seconds",
+		Help:      "Duration of enhancing the CIFP data.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	// ProcessingOutcomes counts processing runs by outcome and reason. The
+	// reason of a failure is the stage it happened in.
+	ProcessingOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "processing_outcomes_total",
+		Help:      "Processing runs by outcome and reason.",
+	}, []string{"outcome", "reason"})
+
+	// PublishFailures counts publishers that failed to publish the
+	// processed data of a run that still succeeded, by publisher.
+	PublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "publish_failures_total",
+		Help:      "Failures of publishers of derived data by publisher.",
+	}, []string{"publisher"})
+
+	// DBCallDuration observes calls to the database by store and method.
+	DBCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_call_duration_seconds",
+		Help:      "Latency of database calls by store and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"store", "method"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by handler, status code and method.",
+	}, []string{"handler", "code", "method"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by handler, status code and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "code", "method"})
+)
+
+// InstrumentHandler counts and times the requests served by h, labeling them
+// with name.
+func InstrumentHandler(name string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(httpRequestDuration.MustCurryWith(labels), h))
+}
+
+// ObserveDBCall starts timing a database call and returns a function that
+// records it, to be deferred by the caller.
+func ObserveDBCall(store, method string) func() {
+	start := time.Now()
+	return func() {
+		DBCallDuration.WithLabelValues(store, method).Observe(time.Since(start).Seconds())
+	}
+}
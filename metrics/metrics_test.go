@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	h := InstrumentHandler("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not found.", http.StatusNotFound)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("test", "404", "get")); got != 1 {
+		t.Errorf("http_requests_total = %v want 1", got)
+	}
+	if got := testutil.CollectAndCount(httpRequestDuration); got != 1 {
+		t.Errorf("http_request_duration_seconds has %d series want 1", got)
+	}
+}
+
+func TestObserveDBCall(t *testing.T) {
+	ObserveDBCall("test", "get")()
+	if got := testutil.CollectAndCount(DBCallDuration); got != 1 {
+		t.Errorf("db_call_duration_seconds has %d series want 1", got)
+	}
+}
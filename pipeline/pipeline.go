@@ -0,0 +1,424 @@
+// Package pipeline processes CIFP data in stages: it fetches the metadata of
+// the latest edition, downloads and extracts it, transforms it, publishes the
+// result and records the cycle. Each stage is an interface, so callers can
+// replace stages or register more transformers and publishers.
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/metrics"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Stage names a stage of the pipeline. Runs report their stage by name, and
+// failures are counted by the stage they happened in.
+type Stage string
+
+// The stages of the pipeline, in order.
+const (
+	StageFetchMetadata Stage = "fetching editions"
+	StageDownload      Stage = "downloading"
+	StageExtract       Stage = "extracting"
+	StageTransform     Stage = "enhancing"
+	StagePublish       Stage = "publishing"
+	StageRecord        Stage = "recording"
+)
+
+var (
+	// ErrNoEditions is returned when the FAA lists no editions.
+	ErrNoEditions = errors.New("received no editions from FAA, want at least 1")
+	// ErrNoCIFPFile is returned when an archive does not contain the
+	// FAACIFP18 file.
+	ErrNoCIFPFile = errors.New("could not find FAACIFP18 file in zip archive")
+)
+
+// Error is returned when a stage fails.
+type Error struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Edition describes a CIFP edition published by the FAA.
+type Edition struct {
+	// Cycle is the name of the cycle, such as "06/18/2020".
+	Cycle string
+	Date  time.Time
+	// URL is where the zip archive of the edition can be downloaded.
+	URL string
+}
+
+// MetadataFetcher finds the edition to process.
+type MetadataFetcher interface {
+	FetchMetadata(context.Context) (*Edition, error)
+}
+
+// Downloader downloads the zip archive of an edition to w, returning the
+// number of bytes written.
+type Downloader interface {
+	Download(_ context.Context, e *Edition, w io.Writer) (int64, error)
+}
+
+// Extractor returns the CIFP file in a zip archive.
+type Extractor interface {
+	Extract(_ context.Context, archive io.ReaderAt, size int64) (io.ReadCloser, error)
+}
+
+// Transformer writes a transformed copy of the CIFP data in in to out.
+type Transformer interface {
+	Transform(_ context.Context, in io.ReadSeeker, out io.Writer) error
+}
+
+// Publisher makes processed data available, for example by uploading it.
+type Publisher interface {
+	Publish(context.Context, *Artifact) error
+}
+
+// Recorder records processed cycles. It is implemented by db.Store.
+type Recorder interface {
+	Get(context.Context, string) (*db.Cycle, error)
+	Add(context.Context, *db.Cycle) error
+	SetChecksum(_ context.Context, name, checksum string, updated time.Time) error
+}
+
+// Storage stores original and processed data.
+type Storage interface {
+	NewObject(_ context.Context, fileName string) io.WriteCloser
+	NewReader(_ context.Context, fileName string) (io.ReadCloser, error)
+	AllowPublicAccess(_ context.Context, fileName string) error
+}
+
+// Artifact is the processed data of a cycle, as given to publishers.
+type Artifact struct {
+	// Cycle is the name of the cycle, or empty if the data was not
+	// processed from an FAA edition.
+	Cycle string
+	// Original and Processed are the names of the original and processed
+	// objects in storage.
+	Original  string
+	Processed string
+	// Checksum is the hex encoded SHA-256 hash of the processed data.
+	Checksum string
+	// Data holds the Size bytes of processed data. It is only valid while
+	// the publishers run.
+	Data io.ReaderAt
+	Size int64
+	// OriginalData holds the OriginalSize bytes of original data, as
+	// extracted from its archive. It is only valid while the publishers run.
+	OriginalData io.ReaderAt
+	OriginalSize int64
+}
+
+// Reader returns a reader of the processed data.
+func (a *Artifact) Reader() io.ReadSeeker {
+	return io.NewSectionReader(a.Data, 0, a.Size)
+}
+
+// OriginalReader returns a reader of the original data.
+func (a *Artifact) OriginalReader() io.ReadSeeker {
+	return io.NewSectionReader(a.OriginalData, 0, a.OriginalSize)
+}
+
+// Result describes the outcome of a run that did not fail.
+type Result struct {
+	// Cycle is the name of the cycle that was processed or skipped.
+	Cycle string
+	// Skipped explains why the cycle was not processed, if it was not.
+	Skipped string
+	// Artifact is the processed data, if the cycle was processed.
+	Artifact *Artifact
+}
+
+// Pipeline processes CIFP data. Metadata, Downloader, Storage and Recorder
+// must be set; the other stages have defaults.
+type Pipeline struct {
+	Metadata   MetadataFetcher
+	Downloader Downloader
+	// Extractor defaults to Zip.
+	Extractor Extractor
+	// Transformers are applied in order, each to the output of the one
+	// before. If there are none, the data is enhanced with Enhancer's
+	// defaults.
+	Transformers []Transformer
+	// Storage keeps the original archives, and the processed data is
+	// published to it before it is given to Publishers.
+	Storage Storage
+	// Publishers publish data derived from the processed data, such as
+	// indexes. A failing publisher is logged and counted without failing
+	// the run, which would otherwise leave the processed data unrecorded
+	// and fail the same way on every retry.
+	Publishers []Publisher
+	Recorder   Recorder
+
+	now func() time.Time
+}
+
+// OriginalName returns the name of the original archive of cycle in storage.
+func OriginalName(cycle string) string {
+	return "original/FAACIFP18_original_" + fileCycle(cycle) + ".zip"
+}
+
+// ProcessedName returns the name of the processed data of cycle in storage.
+func ProcessedName(cycle string) string {
+	return "processed/FAACIFP18_processed_" + fileCycle(cycle)
+}
+
+func fileCycle(cycle string) string {
+	return strings.Replace(cycle, "/", "-", -1)
+}
+
+// Run processes the latest edition, unless it has already been recorded.
+// Its stages are reported to the run carried by ctx, if any, and traced as
+// children of the span in ctx. Failures are returned as *Error.
+func (p *Pipeline) Run(parent context.Context) (_ *Result, err error) {
+	st := &stager{parent: parent}
+	defer func() { err = st.end(err) }()
+
+	ctx := st.next(StageFetchMetadata)
+	e, err := p.Metadata.FetchMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.Recorder.Get(ctx, e.Cycle)
+	if err != nil {
+		return nil, fmt.Errorf("problem getting cycles: %v", err)
+	}
+	if c != nil {
+		logging.Infof(ctx, "Data already processed for %q, skipping.", e.Cycle)
+		return &Result{Cycle: e.Cycle, Skipped: "already processed"}, nil
+	}
+
+	ctx = st.next(StageDownload)
+	tmpData, err := ioutil.TempFile("", "tempfaadata.zip")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temp file: %v", err)
+	}
+	defer os.Remove(tmpData.Name())
+	defer tmpData.Close()
+	originalName := OriginalName(e.Cycle)
+	originalWriter := p.Storage.NewObject(ctx, originalName)
+	defer func() {
+		if err := originalWriter.Close(); err != nil {
+			logging.Errorf(ctx, "Could not close original writer: %v", err)
+		}
+		logging.Debugf(ctx, "Closed original writer.")
+	}()
+	size, err := p.Downloader.Download(ctx, e, io.MultiWriter(originalWriter, tmpData))
+	if err != nil {
+		return nil, err
+	}
+	metrics.UploadedBytes.WithLabelValues("original").Add(float64(size))
+	logging.Debugf(ctx, "Copied original data.")
+
+	a := &Artifact{Cycle: e.Cycle, Original: originalName, Processed: ProcessedName(e.Cycle)}
+	if err := p.process(st, tmpData, size, a); err != nil {
+		return nil, err
+	}
+
+	ctx = st.next(StageRecord)
+	if err := p.Recorder.Add(ctx, &db.Cycle{
+		Name:      e.Cycle,
+		Original:  originalName,
+		Processed: a.Processed,
+		Date:      e.Date,
+		Checksum:  a.Checksum,
+		Updated:   p.timeNow(),
+	}); err != nil {
+		if errors.Is(err, db.ErrCycleExists) {
+			logging.Infof(ctx, "Cycle %q was recorded by another run, skipping.", e.Cycle)
+			return &Result{Cycle: e.Cycle, Skipped: "processed by another run"}, nil
+		}
+		return nil, fmt.Errorf("could not add cycle: %v", err)
+	}
+	return &Result{Cycle: e.Cycle, Artifact: a}, nil
+}
+
+// Reprocess processes the stored original data of c again and replaces its
+// processed data, for example after the transformers have changed.
+func (p *Pipeline) Reprocess(parent context.Context, c *db.Cycle) (_ *Artifact, err error) {
+	st := &stager{parent: parent}
+	defer func() { err = st.end(err) }()
+
+	ctx := st.next(StageDownload)
+	originalReader, err := p.Storage.NewReader(ctx, c.Original)
+	if err != nil {
+		return nil, fmt.Errorf("could not open original data: %v", err)
+	}
+	defer originalReader.Close()
+	tmpData, err := ioutil.TempFile("", "tempfaadata.zip")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temp file: %v", err)
+	}
+	defer os.Remove(tmpData.Name())
+	defer tmpData.Close()
+	size, err := io.Copy(tmpData, originalReader)
+	if err != nil {
+		return nil, fmt.Errorf("could not copy data: %v", err)
+	}
+	metrics.DownloadedBytes.WithLabelValues("storage").Add(float64(size))
+
+	a := &Artifact{Cycle: c.Name, Original: c.Original, Processed: c.Processed}
+	if err := p.process(st, tmpData, size, a); err != nil {
+		return nil, err
+	}
+
+	ctx = st.next(StageRecord)
+	if err := p.Recorder.SetChecksum(ctx, c.Name, a.Checksum, p.timeNow()); err != nil {
+		return nil, fmt.Errorf("could not record checksum: %v", err)
+	}
+	return a, nil
+}
+
+// Process extracts, transforms and publishes the zip archive in archive, of
+// the given size, as processedName, without fetching metadata or recording
+// cycle. It is used to process archives that were downloaded by other means.
+func (p *Pipeline) Process(parent context.Context, cycle string, archive io.ReaderAt, size int64, processedName string) (_ *Artifact, err error) {
+	st := &stager{parent: parent}
+	defer func() { err = st.end(err) }()
+	a := &Artifact{Cycle: cycle, Processed: processedName}
+	if err := p.process(st, archive, size, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// process runs the extract, transform and publish stages, which are started
+// with st, filling in the processed data of a.
+func (p *Pipeline) process(st *stager, archive io.ReaderAt, size int64, a *Artifact) error {
+	ctx := st.next(StageExtract)
+	extractor := p.Extractor
+	if extractor == nil {
+		extractor = Zip{}
+	}
+	cifp, err := extractor.Extract(ctx, archive, size)
+	if err != nil {
+		return err
+	}
+	defer cifp.Close()
+	data, err := ioutil.TempFile("", "tempcifpdata")
+	if err != nil {
+		return fmt.Errorf("could not create temp file: %v", err)
+	}
+	defer os.Remove(data.Name())
+	defer data.Close()
+	originalSize, err := io.Copy(data, cifp)
+	if err != nil {
+		return fmt.Errorf("could not copy data: %v", err)
+	}
+	original := data
+
+	ctx = st.next(StageTransform)
+	transformers := p.Transformers
+	if len(transformers) == 0 {
+		transformers = []Transformer{&Enhancer{}}
+	}
+	start := time.Now()
+	var sum []byte
+	var written countingWriter
+	for _, t := range transformers {
+		if _, err := data.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("could not rewind data: %v", err)
+		}
+		out, err := ioutil.TempFile("", "tempcifpdata")
+		if err != nil {
+			return fmt.Errorf("could not create temp file: %v", err)
+		}
+		defer os.Remove(out.Name())
+		defer out.Close()
+		hash := sha256.New()
+		written = 0
+		if err := t.Transform(ctx, data, io.MultiWriter(out, hash, &written)); err != nil {
+			return err
+		}
+		data, sum = out, hash.Sum(nil)
+	}
+	metrics.EnhanceDuration.Observe(time.Since(start).Seconds())
+
+	ctx = st.next(StagePublish)
+	a.Checksum, a.Data, a.Size = hex.EncodeToString(sum), data, int64(written)
+	a.OriginalData, a.OriginalSize = original, originalSize
+	if err := (&storagePublisher{p.Storage}).Publish(ctx, a); err != nil {
+		return err
+	}
+	for _, pub := range p.Publishers {
+		if err := pub.Publish(ctx, a); err != nil {
+			name := fmt.Sprintf("%T", pub)
+			logging.Errorf(ctx, "Could not publish %s with %s: %v", a.Processed, name, err)
+			metrics.PublishFailures.WithLabelValues(name).Inc()
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) timeNow() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// stager moves a run through its stages, reporting each stage to the run
+// carried by the parent context and tracing it as a child span of the parent.
+type stager struct {
+	parent context.Context
+	stage  Stage
+	span   trace.Span
+}
+
+// next ends the current stage and starts stage, returning the context to do
+// its work in.
+func (s *stager) next(stage Stage) context.Context {
+	s.endSpan(nil)
+	s.stage = stage
+	runs.Stage(s.parent, string(stage))
+	var ctx context.Context
+	ctx, s.span = tracing.Start(s.parent, string(stage))
+	return ctx
+}
+
+// end ends the current stage, marking it as failed if err is not nil. It
+// returns err as an *Error of the current stage, unless it already is one.
+func (s *stager) end(err error) error {
+	s.endSpan(err)
+	var stageErr *Error
+	if err == nil || errors.As(err, &stageErr) {
+		return err
+	}
+	return &Error{Stage: s.stage, Err: err}
+}
+
+func (s *stager) endSpan(err error) {
+	if s.span != nil {
+		tracing.End(s.span, err)
+		s.span = nil
+	}
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
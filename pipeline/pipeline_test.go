@@ -0,0 +1,429 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/metrics"
+)
+
+const (
+	testDataZipFile           = "original.zip"
+	wantTestDataProcessedFile = "want_processed.txt"
+)
+
+var testNow = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// checksum returns the hex encoded SHA-256 hash of b.
+func checksum(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+type fakeRecorder struct {
+	added     *db.Cycle
+	addErr    error
+	get       *db.Cycle
+	getErr    error
+	checksums map[string]string
+}
+
+func (f *fakeRecorder) Get(context.Context, string) (*db.Cycle, error) {
+	return f.get, f.getErr
+}
+
+func (f *fakeRecorder) Add(_ context.Context, c *db.Cycle) error {
+	f.added = c
+	return f.addErr
+}
+
+func (f *fakeRecorder) SetChecksum(_ context.Context, name, checksum string, _ time.Time) error {
+	if f.checksums == nil {
+		f.checksums = make(map[string]string)
+	}
+	f.checksums[name] = checksum
+	return nil
+}
+
+type fakeStorage struct {
+	objects map[string][]byte
+	public  []string
+}
+
+type objectWriter struct {
+	bytes.Buffer
+	close func([]byte)
+}
+
+func (w *objectWriter) Close() error {
+	w.close(w.Bytes())
+	return nil
+}
+
+func (f *fakeStorage) NewObject(_ context.Context, fileName string) io.WriteCloser {
+	return &objectWriter{close: func(b []byte) { f.objects[fileName] = b }}
+}
+
+func (f *fakeStorage) NewReader(_ context.Context, fileName string) (io.ReadCloser, error) {
+	b, ok := f.objects[fileName]
+	if !ok {
+		return nil, fmt.Errorf("object %q does not exist", fileName)
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (f *fakeStorage) AllowPublicAccess(_ context.Context, fileName string) error {
+	f.public = append(f.public, fileName)
+	return nil
+}
+
+// newFAA serves editions as the FAA does, replacing {{url}} with the URL of
+// archive, which is not found if it is nil.
+func newFAA(t *testing.T, editions string, archive []byte) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("/editions", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Replace(editions, "{{url}}", srv.URL+"/archive.zip", 1))
+	})
+	mux.HandleFunc("/archive.zip", func(w http.ResponseWriter, r *http.Request) {
+		if archive == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(archive)
+	})
+	return srv
+}
+
+const goodEditions = `{"edition": [{"editionDate": "06/18/2020", "product": {"url": "{{url}}"}}]}`
+
+func readTestData(t *testing.T) (archive, processed []byte) {
+	t.Helper()
+	archive, err := ioutil.ReadFile(testDataZipFile)
+	if err != nil {
+		t.Fatalf("Could not read data file: %v", err)
+	}
+	processed, err = ioutil.ReadFile(wantTestDataProcessedFile)
+	if err != nil {
+		t.Fatalf("Could not read data file: %v", err)
+	}
+	return archive, processed
+}
+
+// zipOf returns a zip archive holding files.
+func zipOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, contents := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("could not create %s: %v", name, err)
+		}
+		io.WriteString(w, contents)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("could not write archive: %v", err)
+	}
+	return b.Bytes()
+}
+
+func TestRun(t *testing.T) {
+	archive, processed := readTestData(t)
+
+	for _, tt := range []struct {
+		name        string
+		editions    string
+		archive     []byte
+		recorder    *fakeRecorder
+		publishers  []Publisher
+		wantSkipped string
+		wantAdded   *db.Cycle
+		wantStage   Stage
+		wantErr     error
+	}{
+		{
+			name:     "Good",
+			editions: goodEditions,
+			archive:  archive,
+			recorder: &fakeRecorder{},
+			wantAdded: &db.Cycle{
+				Name:      "06/18/2020",
+				Original:  "original/FAACIFP18_original_06-18-2020.zip",
+				Processed: "processed/FAACIFP18_processed_06-18-2020",
+				Date:      time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				Checksum:  checksum(processed),
+				Updated:   testNow,
+			},
+		},
+		{
+			name:       "PublisherError",
+			editions:   goodEditions,
+			archive:    archive,
+			recorder:   &fakeRecorder{},
+			publishers: []Publisher{&recordingPublisher{published: map[string][]byte{}, err: errors.New("unparsable")}},
+			wantAdded: &db.Cycle{
+				Name:      "06/18/2020",
+				Original:  "original/FAACIFP18_original_06-18-2020.zip",
+				Processed: "processed/FAACIFP18_processed_06-18-2020",
+				Date:      time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC),
+				Checksum:  checksum(processed),
+				Updated:   testNow,
+			},
+		},
+		{
+			name:        "AlreadyProcessed",
+			editions:    goodEditions,
+			archive:     archive,
+			recorder:    &fakeRecorder{get: &db.Cycle{Name: "06/18/2020"}},
+			wantSkipped: "already processed",
+		},
+		{
+			name:        "RecordedConcurrently",
+			editions:    goodEditions,
+			archive:     archive,
+			recorder:    &fakeRecorder{addErr: fmt.Errorf("could not add cycle: %w", db.ErrCycleExists)},
+			wantSkipped: "processed by another run",
+		},
+		{
+			name:      "InvalidEditions",
+			editions:  `}{`,
+			recorder:  &fakeRecorder{},
+			wantStage: StageFetchMetadata,
+		},
+		{
+			name:      "NoEditions",
+			editions:  `{"edition": []}`,
+			recorder:  &fakeRecorder{},
+			wantStage: StageFetchMetadata,
+			wantErr:   ErrNoEditions,
+		},
+		{
+			name:      "GetError",
+			editions:  goodEditions,
+			recorder:  &fakeRecorder{getErr: errors.New("unavailable")},
+			wantStage: StageFetchMetadata,
+		},
+		{
+			name:      "ArchiveNotFound",
+			editions:  goodEditions,
+			recorder:  &fakeRecorder{},
+			wantStage: StageDownload,
+		},
+		{
+			name:      "InvalidZip",
+			editions:  goodEditions,
+			archive:   []byte("bleh"),
+			recorder:  &fakeRecorder{},
+			wantStage: StageExtract,
+		},
+		{
+			name:      "NoCIFPFile",
+			editions:  goodEditions,
+			archive:   zipOf(t, map[string]string{"README": "no data"}),
+			recorder:  &fakeRecorder{},
+			wantStage: StageExtract,
+			wantErr:   ErrNoCIFPFile,
+		},
+		{
+			name:      "AddError",
+			editions:  goodEditions,
+			archive:   archive,
+			recorder:  &fakeRecorder{addErr: errors.New("unavailable")},
+			wantStage: StageRecord,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFAA(t, tt.editions, tt.archive)
+			defer srv.Close()
+			faa := &FAA{EditionsURL: srv.URL + "/editions"}
+			storage := &fakeStorage{objects: map[string][]byte{}}
+			p := &Pipeline{
+				Metadata:   faa,
+				Downloader: faa,
+				Storage:    storage,
+				Publishers: tt.publishers,
+				Recorder:   tt.recorder,
+				now:        func() time.Time { return testNow },
+			}
+
+			res, err := p.Run(context.Background())
+			if tt.wantStage != "" {
+				var stageErr *Error
+				if !errors.As(err, &stageErr) || stageErr.Stage != tt.wantStage {
+					t.Fatalf("Run() = _, %v want error in stage %q", err, tt.wantStage)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("Run() = _, %v want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() = _, %v want _, <nil>", err)
+			}
+			if res.Cycle != "06/18/2020" || res.Skipped != tt.wantSkipped {
+				t.Errorf("Run() = %+v, <nil> want cycle 06/18/2020 skipped %q", res, tt.wantSkipped)
+			}
+			if tt.wantAdded == nil {
+				return
+			}
+			if diff := cmp.Diff(tt.wantAdded, tt.recorder.added); diff != "" {
+				t.Errorf("added cycle diff (-want +got):\n%s", diff)
+			}
+			if !bytes.Equal(archive, storage.objects[tt.wantAdded.Original]) {
+				t.Error("stored original differs from the downloaded archive")
+			}
+			if diff := cmp.Diff(processed, storage.objects[tt.wantAdded.Processed]); diff != "" {
+				t.Errorf("processed data diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]string{tt.wantAdded.Processed}, storage.public); diff != "" {
+				t.Errorf("public objects diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReprocess(t *testing.T) {
+	archive, processed := readTestData(t)
+	c := &db.Cycle{
+		Name:      "06/18/2020",
+		Original:  "original/FAACIFP18_original_06-18-2020.zip",
+		Processed: "processed/FAACIFP18_processed_06-18-2020",
+	}
+	storage := &fakeStorage{objects: map[string][]byte{c.Original: archive}}
+	recorder := &fakeRecorder{}
+	p := &Pipeline{Storage: storage, Recorder: recorder}
+
+	a, err := p.Reprocess(context.Background(), c)
+	if err != nil {
+		t.Fatalf("Reprocess() = _, %v want _, <nil>", err)
+	}
+	if diff := cmp.Diff(processed, storage.objects[c.Processed]); diff != "" {
+		t.Errorf("processed data diff (-want +got):\n%s", diff)
+	}
+	if got, want := recorder.checksums[c.Name], checksum(processed); got != want || a.Checksum != want {
+		t.Errorf("recorded checksum %q, artifact checksum %q want %q", got, a.Checksum, want)
+	}
+
+	c.Original = "missing"
+	var stageErr *Error
+	if _, err := p.Reprocess(context.Background(), c); !errors.As(err, &stageErr) || stageErr.Stage != StageDownload {
+		t.Errorf("Reprocess() of missing original = _, %v want error in stage %q", err, StageDownload)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	archive, processed := readTestData(t)
+	storage := &fakeStorage{objects: map[string][]byte{}}
+	p := &Pipeline{Storage: storage}
+
+	a, err := p.Process(context.Background(), "", bytes.NewReader(archive), int64(len(archive)), "FAACIFP18_processed")
+	if err != nil {
+		t.Fatalf("Process() = _, %v want _, <nil>", err)
+	}
+	if diff := cmp.Diff(processed, storage.objects["FAACIFP18_processed"]); diff != "" {
+		t.Errorf("processed data diff (-want +got):\n%s", diff)
+	}
+	if want := checksum(processed); a.Checksum != want {
+		t.Errorf("Process() checksum = %q want %q", a.Checksum, want)
+	}
+
+	var stageErr *Error
+	if _, err := p.Process(context.Background(), "", bytes.NewReader([]byte("not a zip")), 9, "FAACIFP18_processed"); !errors.As(err, &stageErr) || stageErr.Stage != StageExtract {
+		t.Errorf("Process() of invalid archive = _, %v want error in stage %q", err, StageExtract)
+	}
+}
+
+// suffixer appends a line to the data.
+type suffixer string
+
+func (s suffixer) Transform(_ context.Context, in io.ReadSeeker, out io.Writer) error {
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, string(s))
+	return err
+}
+
+// recordingPublisher keeps the data of the artifacts it is given.
+type recordingPublisher struct {
+	published map[string][]byte
+	err       error
+}
+
+func (r *recordingPublisher) Publish(_ context.Context, a *Artifact) error {
+	b, err := ioutil.ReadAll(a.Reader())
+	if err != nil {
+		return err
+	}
+	r.published[a.Processed] = b
+	return r.err
+}
+
+func TestRegisteredStages(t *testing.T) {
+	archive := zipOf(t, map[string]string{"FAACIFP18": "HDR\n"})
+	storage := &fakeStorage{objects: map[string][]byte{}}
+	pub := &recordingPublisher{published: map[string][]byte{}}
+	p := &Pipeline{
+		Transformers: []Transformer{suffixer("A\n"), suffixer("B\n")},
+		Storage:      storage,
+		Publishers:   []Publisher{pub},
+	}
+
+	a, err := p.Process(context.Background(), "", bytes.NewReader(archive), int64(len(archive)), "out")
+	if err != nil {
+		t.Fatalf("Process() = _, %v want _, <nil>", err)
+	}
+	want := []byte("HDR\nA\nB\n")
+	if diff := cmp.Diff(want, storage.objects["out"]); diff != "" {
+		t.Errorf("stored data diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, pub.published["out"]); diff != "" {
+		t.Errorf("published data diff (-want +got):\n%s", diff)
+	}
+	if a.Checksum != checksum(want) || a.Size != int64(len(want)) {
+		t.Errorf("Process() = %+v want checksum %q and size %d", a, checksum(want), len(want))
+	}
+
+	// Publishers of derived data do not fail the run, unlike publishing the
+	// processed data itself.
+	pub.err = errors.New("unreachable")
+	failures := metrics.PublishFailures.WithLabelValues("*pipeline.recordingPublisher")
+	before := testutil.ToFloat64(failures)
+	if _, err := p.Process(context.Background(), "", bytes.NewReader(archive), int64(len(archive)), "out"); err != nil {
+		t.Errorf("Process() with failing publisher = _, %v want _, <nil>", err)
+	}
+	if got := testutil.ToFloat64(failures) - before; got != 1 {
+		t.Errorf("Process() with failing publisher counted %v failures want 1", got)
+	}
+	p.Storage = failingStorage{storage}
+	var stageErr *Error
+	if _, err := p.Process(context.Background(), "", bytes.NewReader(archive), int64(len(archive)), "out"); !errors.As(err, &stageErr) || stageErr.Stage != StagePublish {
+		t.Errorf("Process() with failing storage = _, %v want error in stage %q", err, StagePublish)
+	}
+}
+
+// failingStorage cannot make objects public.
+type failingStorage struct {
+	*fakeStorage
+}
+
+func (failingStorage) AllowPublicAccess(context.Context, string) error {
+	return errors.New("permission denied")
+}
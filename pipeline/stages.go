@@ -0,0 +1,173 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wallaceicy06/enhance-faa-cifp/enhance"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/metrics"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/tracing"
+)
+
+// faaClient sends requests to the FAA, passing on the trace context.
+var faaClient = &http.Client{Transport: &tracing.Transport{}}
+
+// FAA fetches editions from the FAA's API.
+type FAA struct {
+	// EditionsURL lists the edition to process.
+	EditionsURL string
+}
+
+type faaCIFPInfoResponse struct {
+	Edition []struct {
+		Name    string `json:"editionName"`
+		Format  string `json:"format"`
+		Date    string `json:"editionDate"`
+		Number  int    `json:"editionNumber"`
+		Product struct {
+			Name string `json:"productName"`
+			URL  string `json:"url"`
+		} `json:"product"`
+	} `json:"edition"`
+}
+
+// FetchMetadata returns the first edition listed at EditionsURL.
+func (f *FAA) FetchMetadata(ctx context.Context) (*Edition, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.EditionsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %v", err)
+	}
+	req.Header.Set("accept", "application/json")
+	res, err := fetch(req, "editions")
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch FAA data: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch FAA data, got status %s", res.Status)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read FAA data body: %v", err)
+	}
+	var resCIFPInfo faaCIFPInfoResponse
+	if err := json.Unmarshal(b, &resCIFPInfo); err != nil {
+		return nil, fmt.Errorf("could not unmarshal data: %v", err)
+	}
+	if len(resCIFPInfo.Edition) == 0 {
+		return nil, ErrNoEditions
+	}
+	edition := resCIFPInfo.Edition[0]
+	date, err := time.Parse("01/02/2006", edition.Date)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse date: %v", err)
+	}
+	return &Edition{Cycle: edition.Date, Date: date, URL: edition.Product.URL}, nil
+}
+
+// Download downloads the zip archive of e.
+func (f *FAA) Download(ctx context.Context, e *Edition, w io.Writer) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.URL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %v", err)
+	}
+	res, err := fetch(req, "download")
+	if err != nil {
+		return 0, fmt.Errorf("could not fetch CIFP file: %v", err)
+	}
+	defer res.Body.Close()
+	// Error pages are not archives, and would otherwise fail to extract.
+	if res.StatusCode != http.StatusOK {
+		return 0, &Error{Stage: StageDownload, Err: fmt.Errorf("could not fetch CIFP file, got status %s", res.Status)}
+	}
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
+		return n, fmt.Errorf("could not copy data: %v", err)
+	}
+	metrics.DownloadedBytes.WithLabelValues("faa").Add(float64(n))
+	return n, nil
+}
+
+// fetch sends req to the FAA, recording its latency by request name and
+// status code.
+func fetch(req *http.Request, name string) (*http.Response, error) {
+	start := time.Now()
+	res, err := faaClient.Do(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	metrics.FAARequestDuration.WithLabelValues(name, code).Observe(time.Since(start).Seconds())
+	return res, err
+}
+
+// Zip extracts the FAACIFP18 file from the archives the FAA publishes.
+type Zip struct{}
+
+// Extract returns the FAACIFP18 file in archive, or ErrNoCIFPFile.
+func (Zip) Extract(ctx context.Context, archive io.ReaderAt, size int64) (io.ReadCloser, error) {
+	zipReader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, fmt.Errorf("could not unzip data: %v", err)
+	}
+	for _, zipFile := range zipReader.File {
+		logging.Debugf(ctx, "Found %q in zip archive.", zipFile.Name)
+		if strings.HasSuffix(zipFile.Name, "FAACIFP18") {
+			r, err := zipFile.Open()
+			if err != nil {
+				return nil, fmt.Errorf("could not open file from zip archive: %v", err)
+			}
+			return r, nil
+		}
+	}
+	return nil, ErrNoCIFPFile
+}
+
+// Enhancer enhances CIFP data with enhance.Process.
+type Enhancer struct {
+	// Options configure the enhancements. If nil, duplicate localizers
+	// are removed.
+	Options []enhance.Option
+}
+
+// Transform enhances the CIFP data in in.
+func (e *Enhancer) Transform(_ context.Context, in io.ReadSeeker, out io.Writer) error {
+	opts := e.Options
+	if opts == nil {
+		opts = []enhance.Option{enhance.RemoveDuplicateLocalizers(true)}
+	}
+	if err := enhance.Process(in, out, opts...); err != nil {
+		return fmt.Errorf("could not process data: %v", err)
+	}
+	return nil
+}
+
+// storagePublisher uploads processed data to storage and makes it public.
+type storagePublisher struct {
+	storage Storage
+}
+
+func (s *storagePublisher) Publish(ctx context.Context, a *Artifact) error {
+	w := s.storage.NewObject(ctx, a.Processed)
+	if _, err := io.Copy(w, a.Reader()); err != nil {
+		w.Close()
+		return fmt.Errorf("could not write processed data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("could not write processed data: %v", err)
+	}
+	metrics.UploadedBytes.WithLabelValues("processed").Add(float64(a.Size))
+	if err := s.storage.AllowPublicAccess(ctx, a.Processed); err != nil {
+		return fmt.Errorf("could not set public access: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// Package processing assembles the pipeline that CIFP data is processed with
+// from the app's configuration, so that the app and cifp-process produce the
+// same output.
+package processing
+
+import (
+	"github.com/wallaceicy06/enhance-faa-cifp/enhance"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/airports"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/config"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/geojson"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/subset"
+)
+
+// NewPipeline returns a pipeline that enhances CIFP data as configured by
+// cfg and publishes it, with its indexes, GeoJSON and subsets, to storage.
+// The caller sets the stages that depend on where the data comes from and
+// is recorded, if any.
+func NewPipeline(cfg *config.Config, storage pipeline.Storage) *pipeline.Pipeline {
+	publishers := []pipeline.Publisher{
+		&airports.Publisher{Storage: storage},
+		&geojson.Publisher{Storage: storage},
+	}
+	if len(cfg.Subsets) > 0 {
+		publishers = append(publishers, &subset.Publisher{Storage: storage, Specs: cfg.Subsets})
+	}
+	return &pipeline.Pipeline{
+		Transformers: []pipeline.Transformer{&pipeline.Enhancer{
+			Options: []enhance.Option{enhance.RemoveDuplicateLocalizers(cfg.Enhance.RemoveDuplicateLocalizers)},
+		}},
+		Storage:    storage,
+		Publishers: publishers,
+	}
+}
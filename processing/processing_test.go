@@ -0,0 +1,53 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/airports"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/blob"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/config"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/subset"
+)
+
+func TestNewPipeline(t *testing.T) {
+	archive, err := ioutil.ReadFile("../pipeline/original.zip")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	want, err := ioutil.ReadFile("../pipeline/want_processed.txt")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "processing")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := config.Default()
+	cfg.Subsets = []subset.Spec{{Name: "hayward", Airports: []string{"KHWD"}}}
+	p := NewPipeline(cfg, blob.Dir(dir))
+	a, err := p.Process(context.Background(), "06/18/2020", bytes.NewReader(archive), int64(len(archive)), "FAACIFP18_processed")
+	if err != nil {
+		t.Fatalf("Process() = _, %v want _, <nil>", err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "FAACIFP18_processed"))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Process() wrote processed data that differs from %s: %v", "want_processed.txt", err)
+	}
+	for _, name := range []string{
+		airports.ObjectName("06/18/2020", a.Checksum),
+		"index/06-18-2020_original.json",
+		"geojson/06-18-2020.geojson",
+		"subsets/06-18-2020/hayward",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Process() did not publish %s: %v", name, err)
+		}
+	}
+}
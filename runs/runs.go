@@ -0,0 +1,237 @@
+// Package runs tracks the progress of processing runs so that operators can
+// watch them and see why recent runs failed.
+package runs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+// defaultHistory is the number of finished runs a Tracker remembers.
+const defaultHistory = 50
+
+// Run is a snapshot of a processing run.
+type Run struct {
+	ID string
+	// Trigger describes what started the run, such as the caller identity.
+	Trigger  string
+	Stage    string
+	Started  time.Time
+	Finished time.Time
+	// Err is the reason the run failed, or empty if it did not.
+	Err string
+}
+
+// Done reports whether the run has finished.
+func (r *Run) Done() bool {
+	return !r.Finished.IsZero()
+}
+
+// Tracker records the runs of this replica in memory. A nil *Tracker is valid
+// and records nothing.
+type Tracker struct {
+	// History is the number of runs to remember. It defaults to 50.
+	History int
+
+	mu   sync.Mutex
+	runs []*Run // Oldest first.
+	// active maps the unfinished runs to the functions that cancel them.
+	active  map[*Handle]context.CancelFunc
+	aborted bool
+	// finished is closed and replaced whenever a run finishes.
+	finished chan struct{}
+}
+
+// Handle updates a run started by Tracker.Start.
+type Handle struct {
+	t   *Tracker
+	run *Run
+}
+
+type handleKey struct{}
+
+// Start records a new run and returns a copy of ctx carrying it, so that
+// Stage can report progress from deeper in the call stack. Entries logged
+// with the returned context include the run ID. The returned context is
+// cancelled by Abort.
+func (t *Tracker) Start(ctx context.Context, trigger string) (context.Context, *Handle) {
+	h := &Handle{t: t, run: &Run{ID: newID(), Trigger: trigger, Stage: "starting", Started: time.Now()}}
+	if t != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		t.mu.Lock()
+		t.runs = append(t.runs, h.run)
+		t.trim()
+		if t.active == nil {
+			t.active = make(map[*Handle]context.CancelFunc)
+		}
+		t.active[h] = cancel
+		if t.aborted {
+			cancel()
+		}
+		t.mu.Unlock()
+	}
+	ctx = logging.With(ctx, logging.RunIDField, h.run.ID)
+	return context.WithValue(ctx, handleKey{}, h), h
+}
+
+// trim forgets the oldest finished runs beyond History. t.mu must be held.
+func (t *Tracker) trim() {
+	max := t.History
+	if max == 0 {
+		max = defaultHistory
+	}
+	for i := 0; len(t.runs) > max && i < len(t.runs); {
+		if t.runs[i].Done() {
+			t.runs = append(t.runs[:i], t.runs[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+// Recent returns copies of the remembered runs, newest first.
+func (t *Tracker) Recent() []Run {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	recent := make([]Run, 0, len(t.runs))
+	for i := len(t.runs) - 1; i >= 0; i-- {
+		recent = append(recent, *t.runs[i])
+	}
+	return recent
+}
+
+// Active returns the number of unfinished runs.
+func (t *Tracker) Active() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.active)
+}
+
+// Wait waits until every run has finished, or returns the error of ctx if it
+// is done first.
+func (t *Tracker) Wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		t.mu.Lock()
+		n := len(t.active)
+		if t.finished == nil {
+			t.finished = make(chan struct{})
+		}
+		finished := t.finished
+		t.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Abort cancels the contexts of the unfinished runs, and of runs started
+// afterwards, so that they stop as soon as they can. It is used when the
+// replica shuts down before its runs have finished.
+func (t *Tracker) Abort() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.aborted = true
+	for _, cancel := range t.active {
+		cancel()
+	}
+}
+
+// ID returns the ID of the run.
+func (h *Handle) ID() string {
+	return h.run.ID
+}
+
+// Run returns a copy of the run as it is now.
+func (h *Handle) Run() Run {
+	var r Run
+	h.update(func(run *Run) { r = *run })
+	return r
+}
+
+// Stage records that the run has reached a new stage.
+func (h *Handle) Stage(stage string) {
+	h.update(func(r *Run) { r.Stage = stage })
+}
+
+// Finish records that the run has ended, and why if err is not nil.
+func (h *Handle) Finish(err error) {
+	h.update(func(r *Run) {
+		r.Finished = time.Now()
+		if err != nil {
+			r.Stage = "failed"
+			r.Err = err.Error()
+		} else {
+			r.Stage = "done"
+		}
+	})
+	if h.t == nil {
+		return
+	}
+	h.t.mu.Lock()
+	defer h.t.mu.Unlock()
+	if cancel, ok := h.t.active[h]; ok {
+		cancel()
+		delete(h.t.active, h)
+		if h.t.finished != nil {
+			close(h.t.finished)
+			h.t.finished = nil
+		}
+	}
+}
+
+func (h *Handle) update(f func(*Run)) {
+	if h.t == nil {
+		f(h.run)
+		return
+	}
+	h.t.mu.Lock()
+	defer h.t.mu.Unlock()
+	f(h.run)
+}
+
+// Stage records that the run carried by ctx has reached a new stage. It does
+// nothing if ctx carries no run.
+func Stage(ctx context.Context, stage string) {
+	if h, ok := ctx.Value(handleKey{}).(*Handle); ok {
+		h.Stage(stage)
+	}
+}
+
+// FromContext returns the run carried by ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Handle {
+	h, _ := ctx.Value(handleKey{}).(*Handle)
+	return h
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails if the OS has no entropy source, in which
+		// case a time based ID is still unique enough for display.
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
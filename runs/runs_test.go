@@ -0,0 +1,116 @@
+package runs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	tr := &Tracker{History: 2}
+
+	ctx, first := tr.Start(context.Background(), "first")
+	Stage(ctx, "download")
+	if got := tr.Recent()[0].Stage; got != "download" {
+		t.Errorf("Stage() recorded %q want %q", got, "download")
+	}
+	first.Finish(errors.New("FAA unavailable"))
+
+	_, second := tr.Start(context.Background(), "second")
+	second.Finish(nil)
+	_, third := tr.Start(context.Background(), "third")
+
+	recent := tr.Recent()
+	if len(recent) != 2 {
+		t.Fatalf("Recent() returned %d runs want 2", len(recent))
+	}
+	if recent[0].ID != third.ID() || recent[1].ID != second.ID() {
+		t.Errorf("Recent() = %+v want third then second run", recent)
+	}
+	if recent[0].Done() {
+		t.Errorf("unfinished run reported done")
+	}
+	if !recent[1].Done() || recent[1].Err != "" || recent[1].Stage != "done" {
+		t.Errorf("finished run = %+v want done without error", recent[1])
+	}
+}
+
+func TestTrackerKeepsUnfinishedRuns(t *testing.T) {
+	tr := &Tracker{History: 1}
+	_, a := tr.Start(context.Background(), "a")
+	_, b := tr.Start(context.Background(), "b")
+	if got := len(tr.Recent()); got != 2 {
+		t.Errorf("Recent() returned %d runs want 2 while both are running", got)
+	}
+	a.Finish(nil)
+	b.Finish(errors.New("failed"))
+	_, _ = tr.Start(context.Background(), "c")
+	if got := len(tr.Recent()); got != 1 {
+		t.Errorf("Recent() returned %d runs want 1", got)
+	}
+}
+
+func TestTrackerWait(t *testing.T) {
+	tr := &Tracker{}
+	_, a := tr.Start(context.Background(), "a")
+	_, b := tr.Start(context.Background(), "b")
+	if got := tr.Active(); got != 2 {
+		t.Errorf("Active() = %d want 2", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tr.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() with unfinished runs = %v want %v", err, context.DeadlineExceeded)
+	}
+
+	done := make(chan error)
+	go func() { done <- tr.Wait(context.Background()) }()
+	a.Finish(nil)
+	b.Finish(errors.New("failed"))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait() = %v want <nil>", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() did not return after the runs finished")
+	}
+	if got := tr.Active(); got != 0 {
+		t.Errorf("Active() = %d want 0", got)
+	}
+}
+
+func TestTrackerAbort(t *testing.T) {
+	tr := &Tracker{}
+	running, h := tr.Start(context.Background(), "running")
+	tr.Abort()
+	if running.Err() != context.Canceled {
+		t.Errorf("running run context error = %v want %v", running.Err(), context.Canceled)
+	}
+	late, _ := tr.Start(context.Background(), "late")
+	if late.Err() != context.Canceled {
+		t.Errorf("run started after Abort() has context error %v want %v", late.Err(), context.Canceled)
+	}
+	h.Finish(running.Err())
+	if got := tr.Recent()[1].Err; got != "context canceled" {
+		t.Errorf("aborted run error = %q want %q", got, "context canceled")
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tr *Tracker
+	ctx, h := tr.Start(context.Background(), "untracked")
+	Stage(ctx, "download")
+	if got := h.Run().Stage; got != "download" {
+		t.Errorf("Run().Stage = %q want %q", got, "download")
+	}
+	h.Finish(nil)
+	if got := tr.Recent(); got != nil {
+		t.Errorf("Recent() = %v want <nil>", got)
+	}
+	if FromContext(ctx) != h {
+		t.Errorf("FromContext() did not return the started run")
+	}
+}
@@ -0,0 +1,201 @@
+// Package scheduler processes the latest CIFP data on a cron schedule from
+// inside the app, for deployments without an external scheduler. Replicas
+// share a lease in the database so that only one of them runs the schedule.
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+)
+
+const (
+	leaseName = "scheduler"
+	// Trigger is the trigger of runs started by the scheduler.
+	Trigger = "scheduler"
+
+	defaultLeaseTTL   = time.Minute
+	defaultRunTimeout = 120 * time.Second
+)
+
+type leaseStore interface {
+	Acquire(_ context.Context, name, holder string, now time.Time, ttl time.Duration) (*db.Lease, error)
+	SetLastRun(_ context.Context, name, holder string, at time.Time) error
+	Release(_ context.Context, name, holder string) error
+}
+
+type processor interface {
+	Process(context.Context) error
+}
+
+// Scheduler runs processing whenever its schedule is due while it holds the
+// lease. If runs were missed, for example because no replica was up, it runs
+// once to catch up.
+type Scheduler struct {
+	Schedule  cron.Schedule
+	Leases    leaseStore
+	Processor processor
+	// Holder identifies this replica in the lease.
+	Holder string
+	// Runs, if set, records the progress of scheduled runs.
+	Runs *runs.Tracker
+	// LeaseTTL is how long the lease lasts if it is not renewed. It is
+	// renewed every third of it. Defaults to one minute.
+	LeaseTTL time.Duration
+	// RunTimeout limits each run. Defaults to two minutes.
+	RunTimeout time.Duration
+
+	now func() time.Time
+}
+
+// New returns a scheduler for spec, a standard five field cron expression
+// that may be prefixed with a time zone such as "CRON_TZ=America/New_York".
+// It identifies this replica by its host name and a random suffix.
+func New(spec string, leases leaseStore, p processor) (*Scheduler, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("could not generate holder ID: %v", err)
+	}
+	return &Scheduler{
+		Schedule:  schedule,
+		Leases:    leases,
+		Processor: p,
+		Holder:    host + "-" + hex.EncodeToString(suffix),
+	}, nil
+}
+
+// Run runs the schedule until ctx is done, then releases the lease. A run in
+// progress when ctx is done is left to finish first; Runs.Abort cancels it.
+func (s *Scheduler) Run(ctx context.Context) {
+	logging.Infof(ctx, "Scheduler started as %s.", s.Holder)
+	for {
+		wait := s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.Leases.Release(releaseCtx, leaseName, s.Holder); err != nil {
+				logging.Errorf(ctx, "Could not release scheduler lease: %v", err)
+			}
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// tick takes or renews the lease and, if this replica holds it and a run is
+// due, runs processing. It returns how long to wait before the next tick.
+func (s *Scheduler) tick(ctx context.Context) time.Duration {
+	renew := s.leaseTTL() / 3
+	now := s.timeNow()
+	lease, err := s.Leases.Acquire(ctx, leaseName, s.Holder, now, s.leaseTTL())
+	if errors.Is(err, db.ErrLeaseHeld) {
+		return renew
+	}
+	if err != nil {
+		logging.Errorf(ctx, "Could not acquire scheduler lease: %v", err)
+		return renew
+	}
+	if lease.LastRun.IsZero() {
+		// Nothing has been scheduled yet, so start the schedule now
+		// rather than catching up on every run since the beginning of
+		// time.
+		if err := s.Leases.SetLastRun(ctx, leaseName, s.Holder, now); err != nil {
+			logging.Errorf(ctx, "Could not start schedule: %v", err)
+			return renew
+		}
+		lease.LastRun = now
+	}
+	next := s.Schedule.Next(lease.LastRun)
+	if next.After(now) {
+		if wait := next.Sub(now); wait < renew {
+			return wait
+		}
+		return renew
+	}
+	if s.Schedule.Next(next).Before(now) {
+		logging.Infof(ctx, "Catching up on scheduled runs missed since %v.", next)
+	}
+	s.run(ctx, now)
+	return 0
+}
+
+// run processes the latest data, renewing the lease until it finishes, and
+// records now as the last run. Failed runs are not retried until the next
+// scheduled time, unless they failed while the scheduler was stopping, in
+// which case the next holder of the lease runs again.
+func (s *Scheduler) run(ctx context.Context, now time.Time) {
+	// The run and the lease outlive ctx so that stopping the scheduler
+	// does not interrupt a run.
+	bg := logging.Detach(ctx)
+	runCtx, cancel := context.WithTimeout(bg, s.runTimeout())
+	defer cancel()
+	runCtx, run := s.Runs.Start(runCtx, Trigger)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Processor.Process(runCtx)
+	}()
+	renew := time.NewTicker(s.leaseTTL() / 3)
+	defer renew.Stop()
+	for {
+		select {
+		case err := <-done:
+			run.Finish(err)
+			if err != nil {
+				logging.Errorf(runCtx, "Scheduled processing failed: %v", err)
+				if ctx.Err() != nil {
+					logging.Warningf(runCtx, "Scheduler is stopping, leaving the run to the next lease holder.")
+					return
+				}
+			}
+			if err := s.Leases.SetLastRun(bg, leaseName, s.Holder, now); err != nil {
+				logging.Errorf(runCtx, "Could not record scheduled run: %v", err)
+			}
+			return
+		case <-renew.C:
+			if _, err := s.Leases.Acquire(bg, leaseName, s.Holder, s.timeNow(), s.leaseTTL()); err != nil {
+				logging.Errorf(runCtx, "Could not renew scheduler lease, cancelling run: %v", err)
+				renew.Stop()
+				cancel()
+			}
+		}
+	}
+}
+
+func (s *Scheduler) leaseTTL() time.Duration {
+	if s.LeaseTTL > 0 {
+		return s.LeaseTTL
+	}
+	return defaultLeaseTTL
+}
+
+func (s *Scheduler) runTimeout() time.Duration {
+	if s.RunTimeout > 0 {
+		return s.RunTimeout
+	}
+	return defaultRunTimeout
+}
+
+func (s *Scheduler) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
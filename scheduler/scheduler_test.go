@@ -0,0 +1,230 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/robfig/cron/v3"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+)
+
+type fakeLeases struct {
+	Lease *db.Lease
+	Err   error
+}
+
+func (fl *fakeLeases) Acquire(_ context.Context, name, holder string, now time.Time, ttl time.Duration) (*db.Lease, error) {
+	if fl.Err != nil {
+		return nil, fl.Err
+	}
+	if fl.Lease == nil {
+		fl.Lease = &db.Lease{Name: name}
+	}
+	if fl.Lease.Holder != "" && fl.Lease.Holder != holder && now.Before(fl.Lease.Expires) {
+		return nil, fmt.Errorf("could not acquire lease: %w", db.ErrLeaseHeld)
+	}
+	fl.Lease.Holder = holder
+	fl.Lease.Expires = now.Add(ttl)
+	l := *fl.Lease
+	return &l, nil
+}
+
+func (fl *fakeLeases) SetLastRun(_ context.Context, name, holder string, at time.Time) error {
+	if fl.Lease == nil || fl.Lease.Holder != holder {
+		return db.ErrLeaseHeld
+	}
+	fl.Lease.LastRun = at
+	return nil
+}
+
+func (fl *fakeLeases) Release(_ context.Context, name, holder string) error {
+	if fl.Lease != nil && fl.Lease.Holder == holder {
+		fl.Lease.Holder = ""
+	}
+	return nil
+}
+
+type fakeProcessor struct {
+	Err   error
+	Calls int
+}
+
+func (fp *fakeProcessor) Process(ctx context.Context) error {
+	fp.Calls++
+	if runs.FromContext(ctx) == nil {
+		return errors.New("no run in context")
+	}
+	return fp.Err
+}
+
+func TestTick(t *testing.T) {
+	// The schedule is due at 06:00 every day.
+	now := time.Date(2020, 7, 16, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name        string
+		leases      *fakeLeases
+		processErr  error
+		wantCalls   int
+		wantLastRun time.Time
+		wantWait    time.Duration
+	}{
+		{
+			name:        "FirstTick",
+			leases:      &fakeLeases{},
+			wantLastRun: now,
+			wantWait:    20 * time.Second,
+		},
+		{
+			name:        "Due",
+			leases:      &fakeLeases{Lease: &db.Lease{Name: leaseName, LastRun: now.Add(-7 * time.Hour)}},
+			wantCalls:   1,
+			wantLastRun: now,
+		},
+		{
+			name:        "MissedSeveral",
+			leases:      &fakeLeases{Lease: &db.Lease{Name: leaseName, LastRun: now.Add(-72 * time.Hour)}},
+			wantCalls:   1,
+			wantLastRun: now,
+		},
+		{
+			name:        "ProcessError",
+			leases:      &fakeLeases{Lease: &db.Lease{Name: leaseName, LastRun: now.Add(-7 * time.Hour)}},
+			processErr:  errors.New("process error"),
+			wantCalls:   1,
+			wantLastRun: now,
+		},
+		{
+			name:        "NotDue",
+			leases:      &fakeLeases{Lease: &db.Lease{Name: leaseName, LastRun: now.Add(-time.Hour)}},
+			wantLastRun: now.Add(-time.Hour),
+			wantWait:    20 * time.Second,
+		},
+		{
+			name:        "HeldByOther",
+			leases:      &fakeLeases{Lease: &db.Lease{Name: leaseName, Holder: "other", Expires: now.Add(time.Minute), LastRun: now.Add(-7 * time.Hour)}},
+			wantLastRun: now.Add(-7 * time.Hour),
+			wantWait:    20 * time.Second,
+		},
+		{
+			name:        "ExpiredOther",
+			leases:      &fakeLeases{Lease: &db.Lease{Name: leaseName, Holder: "other", Expires: now.Add(-time.Second), LastRun: now.Add(-7 * time.Hour)}},
+			wantCalls:   1,
+			wantLastRun: now,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := cron.ParseStandard("0 6 * * *")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &fakeProcessor{Err: tt.processErr}
+			tracker := &runs.Tracker{}
+			s := &Scheduler{
+				Schedule:  schedule,
+				Leases:    tt.leases,
+				Processor: p,
+				Holder:    "me",
+				Runs:      tracker,
+				now:       func() time.Time { return now },
+			}
+
+			if got := s.tick(context.Background()); got != tt.wantWait {
+				t.Errorf("tick() = %v want %v", got, tt.wantWait)
+			}
+			if p.Calls != tt.wantCalls {
+				t.Errorf("Process() called %d times want %d", p.Calls, tt.wantCalls)
+			}
+			if diff := cmp.Diff(tt.wantLastRun, tt.leases.Lease.LastRun); diff != "" {
+				t.Errorf("unexpected last run diff (-want +got):\n%s", diff)
+			}
+			recent := tracker.Recent()
+			if len(recent) != tt.wantCalls {
+				t.Fatalf("Recent() returned %d runs want %d", len(recent), tt.wantCalls)
+			}
+			for _, r := range recent {
+				if r.Trigger != Trigger || !r.Done() || (r.Err != "") != (tt.processErr != nil) {
+					t.Errorf("unexpected run %+v", r)
+				}
+			}
+		})
+	}
+}
+
+type processorFunc func(context.Context) error
+
+func (f processorFunc) Process(ctx context.Context) error { return f(ctx) }
+
+func TestRunWhileStopping(t *testing.T) {
+	now := time.Date(2020, 7, 16, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name        string
+		processErr  error
+		wantLastRun time.Time
+	}{
+		{name: "Finishes", wantLastRun: now},
+		{name: "Fails", processErr: errors.New("aborted"), wantLastRun: now.Add(-7 * time.Hour)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := cron.ParseStandard("0 6 * * *")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			leases := &fakeLeases{Lease: &db.Lease{Name: leaseName, LastRun: now.Add(-7 * time.Hour)}}
+			s := &Scheduler{
+				Schedule: schedule,
+				Leases:   leases,
+				Processor: processorFunc(func(runCtx context.Context) error {
+					stop()
+					if err := runCtx.Err(); err != nil {
+						t.Errorf("stopping the scheduler cancelled the run: %v", err)
+					}
+					return tt.processErr
+				}),
+				Holder: "me",
+				now:    func() time.Time { return now },
+			}
+			s.tick(ctx)
+			if diff := cmp.Diff(tt.wantLastRun, leases.Lease.LastRun); diff != "" {
+				t.Errorf("unexpected last run diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTickWaitsUntilDue(t *testing.T) {
+	schedule, err := cron.ParseStandard("0 6 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 7, 16, 5, 59, 50, 0, time.UTC)
+	s := &Scheduler{
+		Schedule:  schedule,
+		Leases:    &fakeLeases{Lease: &db.Lease{Name: leaseName, LastRun: now.Add(-time.Hour)}},
+		Processor: &fakeProcessor{},
+		Holder:    "me",
+		now:       func() time.Time { return now },
+	}
+	if got, want := s.tick(context.Background()), 10*time.Second; got != want {
+		t.Errorf("tick() = %v want %v", got, want)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("not a schedule", &fakeLeases{}, &fakeProcessor{}); err == nil {
+		t.Errorf("New() with invalid schedule = _, <nil> want error")
+	}
+	s, err := New("CRON_TZ=America/New_York 0 6 * * *", &fakeLeases{}, &fakeProcessor{})
+	if err != nil {
+		t.Fatalf("New() = _, %v want _, <nil>", err)
+	}
+	if s.Holder == "" {
+		t.Errorf("New() returned a scheduler without a holder")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+)
+
+// defaultAbortGrace is how long runs that are cancelled at the end of the
+// drain period get to clean up, for example to delete their temporary files
+// and release the scheduler lease.
+const defaultAbortGrace = 10 * time.Second
+
+type eventDispatcher interface {
+	Stop()
+	Wait()
+}
+
+// graceful shuts the app down, letting work in progress finish if it can.
+type graceful struct {
+	server *http.Server
+	runs   *runs.Tracker
+	// stopScheduler stops the scheduler from starting runs, and
+	// schedulerDone is closed once it has released its lease.
+	stopScheduler context.CancelFunc
+	schedulerDone <-chan struct{}
+	// events delivers webhook events, and is stopped so that deliveries
+	// waiting to be retried are recorded as dead letters.
+	events eventDispatcher
+	// drainPeriod is how long requests and runs in progress get to finish.
+	drainPeriod time.Duration
+	// abortGrace defaults to defaultAbortGrace.
+	abortGrace time.Duration
+}
+
+// shutdown stops accepting requests and starting scheduled runs, then waits
+// for the requests and runs in progress to finish. Runs still going at the
+// end of the drain period are cancelled, and the remaining connections are
+// closed once they have stopped.
+func (g *graceful) shutdown(ctx context.Context) {
+	logging.Infof(ctx, "Shutting down, waiting up to %v for %d runs to finish.", g.drainPeriod, g.runs.Active())
+	g.stopScheduler()
+	drainCtx, cancel := context.WithTimeout(ctx, g.drainPeriod)
+	defer cancel()
+	if err := g.server.Shutdown(drainCtx); err != nil {
+		logging.Warningf(ctx, "Requests still in progress after %v: %v", g.drainPeriod, err)
+	}
+	drained := g.runs.Wait(drainCtx) == nil
+
+	grace := g.abortGrace
+	if grace == 0 {
+		grace = defaultAbortGrace
+	}
+	abortCtx, cancel := context.WithTimeout(ctx, grace)
+	defer cancel()
+	if !drained {
+		logging.Warningf(ctx, "Cancelling %d runs still in progress after %v.", g.runs.Active(), g.drainPeriod)
+		g.runs.Abort()
+		if err := g.runs.Wait(abortCtx); err != nil {
+			logging.Errorf(ctx, "Exiting with %d runs that did not stop in %v.", g.runs.Active(), grace)
+		}
+	}
+	g.server.Close()
+	select {
+	case <-g.schedulerDone:
+	case <-abortCtx.Done():
+		logging.Errorf(ctx, "Scheduler did not stop in time, its lease will expire instead.")
+	}
+	if g.events != nil {
+		g.events.Stop()
+		delivered := make(chan struct{})
+		go func() {
+			g.events.Wait()
+			close(delivered)
+		}()
+		select {
+		case <-delivered:
+		case <-abortCtx.Done():
+			logging.Warningf(ctx, "Exiting before webhook deliveries finished.")
+		}
+	}
+	logging.Infof(ctx, "Shut down.")
+}
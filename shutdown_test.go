@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/runs"
+)
+
+func TestShutdown(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		runTime     time.Duration
+		drainPeriod time.Duration
+		wantErr     string
+	}{
+		{name: "RunFinishes", runTime: 50 * time.Millisecond, drainPeriod: 5 * time.Second},
+		{name: "RunAborted", runTime: 5 * time.Second, drainPeriod: 50 * time.Millisecond, wantErr: "context canceled"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &runs.Tracker{}
+			started := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx, run := tracker.Start(r.Context(), "test")
+				close(started)
+				select {
+				case <-time.After(tt.runTime):
+					run.Finish(nil)
+				case <-ctx.Done():
+					run.Finish(ctx.Err())
+				}
+			}))
+			defer srv.Close()
+			go http.Get(srv.URL)
+			<-started
+
+			schedulerStopped := false
+			schedulerDone := make(chan struct{})
+			g := &graceful{
+				server: srv.Config,
+				runs:   tracker,
+				stopScheduler: func() {
+					schedulerStopped = true
+					close(schedulerDone)
+				},
+				schedulerDone: schedulerDone,
+				drainPeriod:   tt.drainPeriod,
+				abortGrace:    time.Second,
+			}
+			start := time.Now()
+			g.shutdown(context.Background())
+
+			if took := time.Since(start); took > 2*time.Second {
+				t.Errorf("shutdown() took %v, want less than 2s", took)
+			}
+			if !schedulerStopped {
+				t.Error("shutdown() did not stop the scheduler")
+			}
+			recent := tracker.Recent()
+			if len(recent) != 1 || !recent[0].Done() || recent[0].Err != tt.wantErr {
+				t.Errorf("runs after shutdown() = %+v want one finished run with error %q", recent, tt.wantErr)
+			}
+			if _, err := http.Get(srv.URL); err == nil {
+				t.Error("server still accepts requests after shutdown()")
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package subset
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+)
+
+type storageClient interface {
+	NewObject(_ context.Context, fileName string) io.WriteCloser
+	AllowPublicAccess(_ context.Context, fileName string) error
+}
+
+// ObjectName returns the name in storage of the subset named name of cycle.
+func ObjectName(cycle, name string) string {
+	return "subsets/" + strings.Replace(cycle, "/", "-", -1) + "/" + name
+}
+
+// AirportObjectName returns the name in storage of the subset of cycle with
+// only the airport icao, generated from the processed data with checksum. The
+// checksum changes when the cycle is reprocessed, so subsets of the data it
+// replaced are not served.
+func AirportObjectName(cycle, checksum, icao string) string {
+	return ObjectName(cycle, path.Join("airports", checksum, icao))
+}
+
+// Publisher is a pipeline.Publisher that stores a public subset of the
+// processed data for each of Specs.
+type Publisher struct {
+	Storage storageClient
+	Specs   []Spec
+}
+
+// Publish stores the subsets of a. Subsets that match nothing in a are
+// skipped.
+func (p *Publisher) Publish(ctx context.Context, a *pipeline.Artifact) error {
+	for i := range p.Specs {
+		s := &p.Specs[i]
+		// Extract into memory first so that no object is created for an empty
+		// or failed subset.
+		var b bytes.Buffer
+		n, err := Extract(a.Reader(), &b, s)
+		if err == ErrNoRecords {
+			logging.Warningf(ctx, "Subset %q of %s matches no records, not publishing it.", s.Name, a.Cycle)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not extract subset %q: %v", s.Name, err)
+		}
+		name := ObjectName(a.Cycle, s.Name)
+		if err := write(ctx, p.Storage, name, b.Bytes()); err != nil {
+			return fmt.Errorf("could not write subset %q: %v", s.Name, err)
+		}
+		if err := p.Storage.AllowPublicAccess(ctx, name); err != nil {
+			return fmt.Errorf("could not set public access on subset %q: %v", s.Name, err)
+		}
+		logging.Infof(ctx, "Published subset %q of %s with %d records.", s.Name, a.Cycle, n)
+	}
+	return nil
+}
+
+func write(ctx context.Context, s storageClient, name string, data []byte) error {
+	w := s.NewObject(ctx, name)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,40 @@
+package subset
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/blob/blobtest"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/pipeline"
+)
+
+func TestPublish(t *testing.T) {
+	data := testData(t)
+	s := &blobtest.Storage{}
+	p := &Publisher{
+		Storage: s,
+		Specs: []Spec{
+			{Name: "hwd", Airports: []string{"KHWD"}},
+			{Name: "jfk", Airports: []string{"KJFK"}},
+			{Name: "k1", Regions: []string{"K1"}},
+		},
+	}
+	a := &pipeline.Artifact{Cycle: "06/18/2020", Data: strings.NewReader(data), Size: int64(len(data))}
+	if err := p.Publish(context.Background(), a); err != nil {
+		t.Fatalf("Publish() = %v want <nil>", err)
+	}
+	want := []string{"subsets/06-18-2020/hwd", "subsets/06-18-2020/k1"}
+	if diff := cmp.Diff(want, s.Public); diff != "" {
+		t.Errorf("Publish() made unexpected objects public (-want +got):\n%s", diff)
+	}
+	for _, name := range want {
+		if got := strings.Count(s.Objects[name], "\n"); got < 6 {
+			t.Errorf("Publish() wrote %d lines to %s want at least 6", got, name)
+		}
+	}
+	if _, ok := s.Objects["subsets/06-18-2020/jfk"]; ok {
+		t.Errorf("Publish() wrote empty subset jfk")
+	}
+}
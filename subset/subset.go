@@ -0,0 +1,334 @@
+// Package subset extracts the records of some airports, regions or areas
+// from CIFP data, producing a smaller file in the same format that tools
+// which cannot handle the national file can load.
+package subset
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/arinc424"
+)
+
+// ErrNoRecords is returned when nothing in the data matches a Spec.
+var ErrNoRecords = errors.New("no records match")
+
+// Box is an area bounded by latitudes and longitudes in decimal degrees,
+// north and east positive.
+type Box struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// Contains reports whether lat, lon is inside b.
+func (b *Box) Contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// Spec describes a subset. Airports match if they are listed in Airports,
+// are in one of Regions or have their reference point inside Box. Navaids and
+// enroute waypoints match if they are in one of Regions, inside Box or used
+// by the procedures of a matching airport.
+type Spec struct {
+	// Name identifies the subset, such as "west-coast". It is part of the
+	// name of its object in storage.
+	Name string `json:"name"`
+	// Regions are ICAO region codes, such as "K2".
+	Regions []string `json:"regions,omitempty"`
+	Box     *Box     `json:"box,omitempty"`
+	// Airports are ICAO airport identifiers, such as "KHWD".
+	Airports []string `json:"airports,omitempty"`
+}
+
+var (
+	namePattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	regionPattern  = regexp.MustCompile(`^[A-Z0-9]{2}$`)
+	airportPattern = regexp.MustCompile(`^[A-Z0-9]{3,4}$`)
+)
+
+// ValidAirport reports whether icao is an airport identifier as it appears
+// in CIFP records.
+func ValidAirport(icao string) bool {
+	return airportPattern.MatchString(icao)
+}
+
+// Validate checks that s selects something and that its settings are valid.
+func (s *Spec) Validate() error {
+	switch {
+	case !namePattern.MatchString(s.Name):
+		return fmt.Errorf("name %q must be lower case letters, digits, - and _", s.Name)
+	case len(s.Regions) == 0 && s.Box == nil && len(s.Airports) == 0:
+		return fmt.Errorf("subset %q must list regions, airports or a box", s.Name)
+	}
+	for _, r := range s.Regions {
+		if !regionPattern.MatchString(r) {
+			return fmt.Errorf("region %q must be a two character ICAO region code, such as K2", r)
+		}
+	}
+	for _, a := range s.Airports {
+		if !ValidAirport(a) {
+			return fmt.Errorf("airport %q must be an ICAO identifier, such as KHWD", a)
+		}
+	}
+	if b := s.Box; b != nil {
+		switch {
+		case b.MinLat < -90 || b.MaxLat > 90 || b.MinLat >= b.MaxLat:
+			return fmt.Errorf("box latitudes must satisfy -90 <= min_lat < max_lat <= 90, not %v and %v", b.MinLat, b.MaxLat)
+		case b.MinLon < -180 || b.MaxLon > 180 || b.MinLon >= b.MaxLon:
+			return fmt.Errorf("box longitudes must satisfy -180 <= min_lon < max_lon <= 180, not %v and %v", b.MinLon, b.MaxLon)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// field returns the 1-indexed columns first to last of line, or "" if the
+// line is too short.
+func field(line string, first, last int) string {
+	if len(line) < last {
+		return ""
+	}
+	return line[first-1 : last]
+}
+
+// Columns of the fields used to select records, 1-indexed as in ARINC 424.
+const (
+	sectionCol        = 5
+	airportFirst      = 7
+	airportLast       = 10
+	airportRegionCol  = 11
+	fixRegionCol      = 20
+	latFirst          = 33
+	latLast           = 41
+	lonFirst          = 42
+	lonLast           = 51
+	dmeLatFirst       = 56
+	dmeLonLast        = 74
+	headerCountFirst  = 29
+	headerCountLast   = 35
+	headerCRCFirst    = 125
+	headerCRCLast     = 132
+	fixIdentFirst     = 14
+	fixIdentLast      = 18
+	recordNumberFirst = 124
+	recordNumberLast  = 128
+)
+
+// airportOf returns the airport identifier and region of airport and
+// heliport records.
+func airportOf(line string) (icao, region string, ok bool) {
+	if len(line) < airportRegionCol+1 || line[0] != 'S' {
+		return "", "", false
+	}
+	switch line[sectionCol-1] {
+	case 'P', 'H':
+		return strings.TrimSpace(field(line, airportFirst, airportLast)), field(line, airportRegionCol, airportRegionCol+1), true
+	}
+	return "", "", false
+}
+
+// isFix reports whether line is a navaid or enroute waypoint record.
+func isFix(line string) bool {
+	if len(line) < fixRegionCol+1 || line[0] != 'S' {
+		return false
+	}
+	section := field(line, sectionCol, sectionCol+1)
+	return section == "D " || section == "DB" || section == "EA"
+}
+
+// fixKey returns the key of a navaid or enroute waypoint record, which is
+// the same as that of the references to it in procedure legs.
+func fixKey(line string) string {
+	return field(line, sectionCol, sectionCol+1) + strings.TrimSpace(field(line, fixIdentFirst, fixIdentLast)) + field(line, fixRegionCol, fixRegionCol+1)
+}
+
+// References to fixes in procedure legs, as the columns of their identifier,
+// region and section, which is followed by the subsection.
+var legReferences = []struct{ identFirst, identLast, region, section int }{
+	{identFirst: 30, identLast: 34, region: 35, section: 37},     // fix
+	{identFirst: 51, identLast: 54, region: 55, section: 79},     // recommended navaid
+	{identFirst: 107, identLast: 111, region: 113, section: 115}, // center fix
+}
+
+// referencedFixes returns the keys of the navaids and enroute waypoints that
+// the procedure leg line refers to.
+func referencedFixes(line string) []string {
+	if len(line) < 40 || line[12] < 'D' || line[12] > 'F' || (line[38] != '0' && line[38] != '1') {
+		return nil
+	}
+	var keys []string
+	for _, r := range legReferences {
+		section := field(line, r.section, r.section+1)
+		if section != "D " && section != "DB" && section != "EA" {
+			continue
+		}
+		ident := strings.TrimSpace(field(line, r.identFirst, r.identLast))
+		keys = append(keys, section+ident+field(line, r.region, r.region+1))
+	}
+	return keys
+}
+
+// isAirportReference reports whether line is the primary reference point
+// record of an airport or heliport.
+func isAirportReference(line string) bool {
+	if len(line) < 22 || (line[sectionCol-1] != 'P' && line[sectionCol-1] != 'H') || line[12] != 'A' {
+		return false
+	}
+	// Primary records are numbered 0 or 1; continuations have other fields
+	// where the coordinates would be.
+	return line[21] == '0' || line[21] == '1'
+}
+
+// position returns the coordinates in line. Navaids without a VOR are
+// located by their DME.
+func position(line string) (lat, lon float64, ok bool) {
+	lat, err1 := arinc424.ParseLatitude(field(line, latFirst, latLast))
+	lon, err2 := arinc424.ParseLongitude(field(line, lonFirst, lonLast))
+	if err1 == nil && err2 == nil {
+		return lat, lon, true
+	}
+	lat, err1 = arinc424.ParseLatitude(field(line, dmeLatFirst, dmeLatFirst+8))
+	lon, err2 = arinc424.ParseLongitude(field(line, dmeLatFirst+9, dmeLonLast))
+	return lat, lon, err1 == nil && err2 == nil
+}
+
+// Extract writes the header records of the CIFP data in in, followed by the
+// records that match s, to out. The record count in the first header and
+// the record numbers are rewritten so that the subset is valid on its own.
+// The file CRC in the first header is blanked, since it is that of the
+// national file. It returns the number of records written besides the
+// headers, or ErrNoRecords if there are none.
+func Extract(in io.ReadSeeker, out io.Writer, s *Spec) (int, error) {
+	airports := make(map[string]bool)
+	for _, a := range s.Airports {
+		airports[a] = true
+	}
+	isAirport := func(line string) bool {
+		icao, region, ok := airportOf(line)
+		return ok && (airports[icao] || contains(s.Regions, region))
+	}
+	fixes := make(map[string]bool)
+	match := func(line string) bool {
+		if _, _, ok := airportOf(line); ok {
+			return isAirport(line)
+		}
+		if isFix(line) {
+			if fixes[fixKey(line)] || contains(s.Regions, field(line, fixRegionCol, fixRegionCol+1)) {
+				return true
+			}
+			if s.Box != nil {
+				lat, lon, ok := position(line)
+				return ok && s.Box.Contains(lat, lon)
+			}
+		}
+		return false
+	}
+
+	// The first pass finds the airports inside the box, whose reference
+	// points need not come before their other records.
+	if s.Box != nil {
+		if err := scan(in, func(line string) error {
+			if icao, _, ok := airportOf(line); ok && isAirportReference(line) {
+				if lat, lon, ok := position(line); ok && s.Box.Contains(lat, lon) {
+					airports[icao] = true
+				}
+			}
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	// The next finds the navaids and waypoints used by the procedures of the
+	// airports, which come before them in the data, so that the last one can
+	// count the records to write.
+	if err := scan(in, func(line string) error {
+		if isAirport(line) {
+			for _, k := range referencedFixes(line) {
+				fixes[k] = true
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	count := 0
+	if err := scan(in, func(line string) error {
+		if match(line) {
+			count++
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, ErrNoRecords
+	}
+
+	w := bufio.NewWriter(out)
+	n := 0
+	if err := scan(in, func(line string) error {
+		switch {
+		case strings.HasPrefix(line, "HDR01"):
+			line = replace(line, headerCountFirst, headerCountLast, count)
+			if len(line) >= headerCRCLast {
+				line = line[:headerCRCFirst-1] + strings.Repeat(" ", headerCRCLast-headerCRCFirst+1) + line[headerCRCLast:]
+			}
+		case strings.HasPrefix(line, "HDR"):
+		case match(line):
+			n++
+			line = replace(line, recordNumberFirst, recordNumberLast, n)
+		default:
+			return nil
+		}
+		_, err := w.WriteString(line + "\n")
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// replace returns line with the 1-indexed columns first to last replaced by
+// n, zero padded. Numbers too large for the field wrap around.
+func replace(line string, first, last, n int) string {
+	if len(line) < last {
+		return line
+	}
+	width := last - first + 1
+	digits := fmt.Sprintf("%0*d", width, n)
+	return line[:first-1] + digits[len(digits)-width:] + line[last:]
+}
+
+// scan calls f with each line of in, from the start.
+func scan(in io.ReadSeeker, f func(line string) error) error {
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("could not rewind data: %v", err)
+	}
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		if err := f(sc.Text()); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("could not read data: %v", err)
+	}
+	return nil
+}
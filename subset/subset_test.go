@@ -0,0 +1,167 @@
+package subset
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testData returns the processed data of KHWD, in region K2, followed by
+// two records of KBFI, in region K1 near Seattle.
+func testData(t *testing.T) string {
+	t.Helper()
+	b, err := ioutil.ReadFile("../pipeline/want_processed.txt")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	data := string(b)
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	bfi := []string{
+		strings.Replace(strings.Replace(lines[5], "KHWDK2", "KBFIK1", 1), "N37393214W122071825", "N47315700W122181200", 1),
+		strings.Replace(lines[len(lines)-1], "KHWDK2", "KBFIK1", 1),
+	}
+	return data + strings.Join(bfi, "\n") + "\n"
+}
+
+func TestExtract(t *testing.T) {
+	data := testData(t)
+	for _, tc := range []struct {
+		name         string
+		spec         *Spec
+		wantAirports map[string]int
+	}{
+		{
+			name:         "airport",
+			spec:         &Spec{Name: "hwd", Airports: []string{"KHWD"}},
+			wantAirports: map[string]int{"KHWD": 98},
+		},
+		{
+			name:         "region",
+			spec:         &Spec{Name: "k1", Regions: []string{"K1"}},
+			wantAirports: map[string]int{"KBFI": 2},
+		},
+		{
+			name:         "box",
+			spec:         &Spec{Name: "bay-area", Box: &Box{MinLat: 37, MinLon: -123, MaxLat: 38.5, MaxLon: -121.5}},
+			wantAirports: map[string]int{"KHWD": 98},
+		},
+		{
+			name:         "combined",
+			spec:         &Spec{Name: "west", Regions: []string{"K1"}, Airports: []string{"KHWD"}},
+			wantAirports: map[string]int{"KHWD": 98, "KBFI": 2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			n, err := Extract(strings.NewReader(data), &out, tc.spec)
+			if err != nil {
+				t.Fatalf("Extract() = _, %v want _, <nil>", err)
+			}
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			wantCount := 0
+			for _, c := range tc.wantAirports {
+				wantCount += c
+			}
+			if n != wantCount {
+				t.Errorf("Extract() = %d, _ want %d, _", n, wantCount)
+			}
+			if got, want := len(lines), wantCount+5; got != want {
+				t.Fatalf("Extract() wrote %d lines want %d", got, want)
+			}
+			if got, want := lines[0][28:35], fmt.Sprintf("%07d", wantCount); got != want {
+				t.Errorf("Extract() wrote header record count %q want %q", got, want)
+			}
+			if got, want := lines[0][124:132], strings.Repeat(" ", 8); got != want {
+				t.Errorf("Extract() wrote header CRC %q want %q", got, want)
+			}
+			gotAirports := make(map[string]int)
+			for i, l := range lines[5:] {
+				gotAirports[l[6:10]]++
+				if got, want := l[123:128], fmt.Sprintf("%05d", i+1); got != want {
+					t.Errorf("Extract() wrote record number %q want %q in %q", got, want, l)
+				}
+			}
+			if diff := cmp.Diff(tc.wantAirports, gotAirports); diff != "" {
+				t.Errorf("Extract() wrote unexpected airports (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// fixRecord returns an enroute record of section, such as "EA", for the fix
+// ident in region.
+func fixRecord(section, ident, region string) string {
+	b := []byte(strings.Repeat(" ", 132))
+	copy(b, "SUSA"+section+"ENRT")
+	copy(b[13:], ident)
+	copy(b[19:], region+"0")
+	return string(b)
+}
+
+func TestExtractReferencedFixes(t *testing.T) {
+	data := testData(t)
+	lines := strings.SplitAfterN(data, "\n", 6)
+	fixes := []string{
+		fixRecord("D ", "OAK", "K2"),
+		fixRecord("D ", "OAK", "K1"),
+		fixRecord("EA", "SHARR", "K2"),
+		fixRecord("EA", "ZZZZZ", "K2"),
+	}
+	data = strings.Join(lines[:5], "") + strings.Join(fixes, "\n") + "\n" + lines[5]
+
+	var out bytes.Buffer
+	n, err := Extract(strings.NewReader(data), &out, &Spec{Name: "hwd", Airports: []string{"KHWD"}})
+	if err != nil {
+		t.Fatalf("Extract() = _, %v want _, <nil>", err)
+	}
+	if n != 100 {
+		t.Errorf("Extract() = %d, _ want 100, _", n)
+	}
+	var got []string
+	for _, l := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(l, "SUSAD") || strings.HasPrefix(l, "SUSAE") {
+			got = append(got, strings.TrimSpace(l[13:18])+" "+l[19:21])
+		}
+	}
+	if diff := cmp.Diff([]string{"OAK K2", "SHARR K2"}, got); diff != "" {
+		t.Errorf("Extract() wrote unexpected fixes (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtractNoRecords(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := Extract(strings.NewReader(testData(t)), &out, &Spec{Name: "jfk", Airports: []string{"KJFK"}}); err != ErrNoRecords {
+		t.Errorf("Extract() = _, %v want _, %v", err, ErrNoRecords)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Extract() wrote %d bytes want 0", out.Len())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		spec    *Spec
+		wantErr string
+	}{
+		{spec: &Spec{Name: "west", Regions: []string{"K1", "K2"}, Airports: []string{"KHWD", "O69"}, Box: &Box{MinLat: 30, MinLon: -125, MaxLat: 49, MaxLon: -114}}},
+		{spec: &Spec{Name: "West", Regions: []string{"K1"}}, wantErr: `name "West" must be lower case letters, digits, - and _`},
+		{spec: &Spec{Name: "west"}, wantErr: `subset "west" must list regions, airports or a box`},
+		{spec: &Spec{Name: "west", Regions: []string{"K"}}, wantErr: `region "K" must be a two character ICAO region code, such as K2`},
+		{spec: &Spec{Name: "west", Airports: []string{"hwd"}}, wantErr: `airport "hwd" must be an ICAO identifier, such as KHWD`},
+		{spec: &Spec{Name: "west", Box: &Box{MinLat: 49, MaxLat: 30, MinLon: -125, MaxLon: -114}}, wantErr: "box latitudes must satisfy -90 <= min_lat < max_lat <= 90, not 49 and 30"},
+		{spec: &Spec{Name: "west", Box: &Box{MinLat: 30, MaxLat: 49, MinLon: -200, MaxLon: -114}}, wantErr: "box longitudes must satisfy -180 <= min_lon < max_lon <= 180, not -200 and -114"},
+	} {
+		err := tc.spec.Validate()
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tc.wantErr {
+			t.Errorf("%+v.Validate() = %q want %q", tc.spec, got, tc.wantErr)
+		}
+	}
+}
@@ -8,3 +8,9 @@ import (
 )
 
 var Base = template.Must(template.ParseFiles(filepath.Join("templates/base.html")))
+
+// Admin is the admin console page.
+var Admin = template.Must(template.ParseFiles(filepath.Join("templates/admin.html")))
+
+// Airport is the airport search page.
+var Airport = template.Must(template.ParseFiles(filepath.Join("templates/airport.html")))
@@ -0,0 +1,169 @@
+// Package tracing traces requests and processing runs with OpenTelemetry,
+// propagating W3C trace context to and from other services.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/wallaceicy06/webapp-enhance-faa-cifp"
+
+// Exporters that spans can be sent to.
+const (
+	// ExporterNone does not record spans.
+	ExporterNone = ""
+	// ExporterOTLP sends spans to an OpenTelemetry collector over gRPC.
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes spans as JSON, for local use.
+	ExporterStdout = "stdout"
+)
+
+// Config configures where spans are exported to.
+type Config struct {
+	// Exporter is one of the Exporter constants.
+	Exporter string
+	// Endpoint is the host:port of the collector spans are sent to with
+	// ExporterOTLP.
+	Endpoint string
+	// Insecure sends spans to the collector without TLS.
+	Insecure bool
+	// ServiceName names the service in the exported spans.
+	ServiceName string
+
+	// out is where ExporterStdout writes to. It defaults to os.Stderr,
+	// which keeps spans apart from the logs on os.Stdout.
+	out io.Writer
+}
+
+// Setup installs a global tracer provider that exports spans as configured,
+// and propagates W3C trace context. The returned function flushes the spans
+// that have not been exported yet and must be called before exiting.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	var exporter exporttrace.SpanExporter
+	switch cfg.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("the %s exporter needs an endpoint", cfg.Exporter)
+		}
+		opts := []otlp.ExporterOption{otlp.WithAddress(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlp.WithInsecure())
+		}
+		exp, err := otlp.NewExporter(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("could not create OTLP exporter: %v", err)
+		}
+		exporter = exp
+	case ExporterStdout:
+		out := cfg.out
+		if out == nil {
+			out = os.Stderr
+		}
+		exp, err := stdout.NewExporter(stdout.WithWriter(out), stdout.WithoutMetricExport())
+		if err != nil {
+			return nil, fmt.Errorf("could not create stdout exporter: %v", err)
+		}
+		exporter = exp
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, want %q, %q or none", cfg.Exporter, ExporterOTLP, ExporterStdout)
+	}
+	var attrs []label.KeyValue
+	if cfg.ServiceName != "" {
+		attrs = append(attrs, semconv.ServiceNameKey.String(cfg.ServiceName))
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(attrs...)),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Start starts a span named name as a child of the span in ctx, if any.
+func Start(ctx context.Context, name string, attrs ...label.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it as failed if err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Middleware traces the requests served by h, continuing the trace in the
+// traceparent header of the request if it has one.
+func Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), r.Header)
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", "", r)...))
+		defer span.End()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(rec.status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(rec.status))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Transport traces the requests it sends and passes the trace context on in
+// their traceparent header.
+type Transport struct {
+	// Base sends the requests. It defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := otel.Tracer(instrumentationName).Start(req.Context(), req.Method+" "+req.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPClientAttributesFromHTTPRequest(req)...))
+	// RoundTrippers must not modify the request they are given.
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, req.Header)
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		End(span, err)
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(res.StatusCode)...)
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(res.StatusCode))
+	span.End()
+	return res, nil
+}
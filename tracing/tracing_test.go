@@ -0,0 +1,172 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// record installs a tracer provider that records the spans started during
+// the test.
+func record(t *testing.T) *oteltest.StandardSpanRecorder {
+	sr := &oteltest.StandardSpanRecorder{}
+	otel.SetTracerProvider(oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+	return sr
+}
+
+func TestMiddleware(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		status     int
+		wantStatus codes.Code
+	}{
+		{name: "OK", status: http.StatusOK, wantStatus: codes.Unset},
+		{name: "ServerError", status: http.StatusInternalServerError, wantStatus: codes.Error},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := record(t)
+			var inner trace.SpanContext
+			h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				inner = trace.SpanContextFromContext(r.Context())
+				w.WriteHeader(tt.status)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/process", nil)
+			req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := sr.Completed()
+			if len(spans) != 1 {
+				t.Fatalf("Middleware() recorded %d spans, want 1", len(spans))
+			}
+			s := spans[0]
+			if got, want := s.Name(), "GET /process"; got != want {
+				t.Errorf("span name = %q want %q", got, want)
+			}
+			if got, want := s.SpanContext().TraceID.String(), "0af7651916cd43dd8448eb211c80319c"; got != want {
+				t.Errorf("span trace ID = %s want %s", got, want)
+			}
+			if got, want := s.ParentSpanID().String(), "b7ad6b7169203331"; got != want {
+				t.Errorf("span parent ID = %s want %s", got, want)
+			}
+			if got, want := s.SpanKind(), trace.SpanKindServer; got != want {
+				t.Errorf("span kind = %v want %v", got, want)
+			}
+			if got := s.StatusCode(); got != tt.wantStatus {
+				t.Errorf("span status = %v want %v", got, tt.wantStatus)
+			}
+			if inner.SpanID != s.SpanContext().SpanID {
+				t.Errorf("handler got span %s want %s", inner.SpanID, s.SpanContext().SpanID)
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	sr := record(t)
+	var gotHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	ctx, parent := Start(context.Background(), "parent")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &Transport{}}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() = _, %v want _, <nil>", err)
+	}
+	res.Body.Close()
+	parent.End()
+
+	if req.Header.Get("traceparent") != "" {
+		t.Errorf("RoundTrip() modified the request headers")
+	}
+	spans := sr.Completed()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	s := spans[0]
+	if got, want := s.ParentSpanID(), parent.SpanContext().SpanID; got != want {
+		t.Errorf("client span parent = %s want %s", got, want)
+	}
+	if got, want := s.SpanKind(), trace.SpanKindClient; got != want {
+		t.Errorf("client span kind = %v want %v", got, want)
+	}
+	want := "00-" + s.SpanContext().TraceID.String() + "-" + s.SpanContext().SpanID.String() + "-"
+	if !strings.HasPrefix(gotHeader, want) {
+		t.Errorf("server got traceparent %q want prefix %q", gotHeader, want)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	sr := record(t)
+	_, ok := Start(context.Background(), "ok")
+	End(ok, nil)
+	_, failed := Start(context.Background(), "failed")
+	End(failed, errors.New("broken"))
+
+	got := make(map[string]codes.Code)
+	for _, s := range sr.Completed() {
+		got[s.Name()] = s.StatusCode()
+	}
+	want := map[string]codes.Code{"ok": codes.Unset, "failed": codes.Error}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("span statuses differ (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "None", cfg: Config{}},
+		{name: "Stdout", cfg: Config{Exporter: ExporterStdout}},
+		{name: "OTLPWithoutEndpoint", cfg: Config{Exporter: ExporterOTLP}, wantErr: true},
+		{name: "Unknown", cfg: Config{Exporter: "zipkin"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+			var out bytes.Buffer
+			tt.cfg.out = &out
+			shutdown, err := Setup(context.Background(), tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Setup() = _, <nil> want _, error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Setup() = _, %v want _, <nil>", err)
+			}
+			_, span := Start(context.Background(), "test span")
+			span.End()
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("shutdown() = %v want <nil>", err)
+			}
+			if got, want := strings.Contains(out.String(), "test span"), tt.cfg.Exporter == ExporterStdout; got != want {
+				t.Errorf("exported %q, want span exported: %t", out.String(), want)
+			}
+		})
+	}
+}
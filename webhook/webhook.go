@@ -0,0 +1,353 @@
+// Package webhook notifies subscribers when cycles are published or
+// processing fails, by POSTing signed JSON events to their URLs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/logging"
+)
+
+// Event types.
+const (
+	// EventPublished is sent when a cycle's processed data is published.
+	EventPublished = "cycle.published"
+	// EventSkipped is sent when the latest cycle was already processed.
+	EventSkipped = "cycle.skipped"
+	// EventFailed is sent when processing fails.
+	EventFailed = "processing.failed"
+)
+
+const (
+	// SignatureHeader carries "sha256=<hex HMAC>" of the timestamp, a dot
+	// and the body, keyed by the subscription secret.
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader carries the Unix time the request was sent at.
+	TimestampHeader = "X-Webhook-Timestamp"
+	// IDHeader carries the event ID, which stays the same across retries
+	// and replays so that subscribers can ignore duplicates.
+	IDHeader = "X-Webhook-ID"
+
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	deliveryTimeout    = 10 * time.Second
+)
+
+var eventTypes = []string{EventPublished, EventSkipped, EventFailed}
+
+// Event is the JSON body sent to subscribers.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Cycle is the name of the cycle the event is about, if known.
+	Cycle string `json:"cycle,omitempty"`
+	// Processed is the name of the processed data object of a published
+	// cycle.
+	Processed string `json:"processed,omitempty"`
+	// Reprocessed is set if a published cycle had been published before.
+	Reprocessed bool `json:"reprocessed,omitempty"`
+	// Reason explains why a cycle was skipped or processing failed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Subscription is a URL that events are sent to.
+type Subscription struct {
+	URL string `json:"url"`
+	// Secret signs the events sent to the subscription.
+	Secret string `json:"secret"`
+	// Events are the event types to send. Every type is sent if empty.
+	Events []string `json:"events"`
+}
+
+func (s *Subscription) wants(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type deadLetterStore interface {
+	Add(context.Context, *db.DeadLetter) error
+	Get(context.Context, string) (*db.DeadLetter, error)
+	MarkReplayed(_ context.Context, id string, at time.Time) error
+}
+
+// Dispatcher delivers events to subscriptions in the background, retrying
+// with exponential backoff. Events that cannot be delivered, or are waiting
+// to be retried when the dispatcher is stopped, are recorded as dead letters
+// so that they can be replayed. A nil *Dispatcher drops every event.
+type Dispatcher struct {
+	// Client sends events. It defaults to http.DefaultClient.
+	Client *http.Client
+	// MaxAttempts is the number of times delivery is tried before giving
+	// up. It defaults to 5.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled after each
+	// retry. It defaults to one second.
+	Backoff time.Duration
+
+	subs        []Subscription
+	deadLetters deadLetterStore
+	now         func() time.Time
+	wg          sync.WaitGroup
+
+	mu       sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewDispatcher returns a dispatcher for the specified subscriptions that
+// records undelivered events in deadLetters.
+func NewDispatcher(deadLetters deadLetterStore, subs ...Subscription) (*Dispatcher, error) {
+	seen := make(map[string]bool)
+	for _, s := range subs {
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("subscription URL %q must be an absolute HTTP(S) URL", s.URL)
+		}
+		if seen[s.URL] {
+			return nil, fmt.Errorf("subscription %q is listed more than once", s.URL)
+		}
+		seen[s.URL] = true
+		if s.Secret == "" {
+			return nil, fmt.Errorf("subscription %q must have a secret", s.URL)
+		}
+		for _, e := range s.Events {
+			if !contains(eventTypes, e) {
+				return nil, fmt.Errorf("subscription %q has unknown event type %q, want one of %v", s.URL, e, eventTypes)
+			}
+		}
+	}
+	return &Dispatcher{subs: subs, deadLetters: deadLetters}, nil
+}
+
+// Sign returns the signature of an event body sent at timestamp, as sent in
+// SignatureHeader.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, timestamp)
+	io.WriteString(mac, ".")
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish sends e to every subscription that wants it, without waiting for
+// delivery. The ID and time of e are set if they are empty. Events that no ID
+// can be generated for are dropped, since subscribers would ignore events
+// sharing an ID as duplicates.
+func (d *Dispatcher) Publish(ctx context.Context, e Event) {
+	if d == nil {
+		return
+	}
+	if e.ID == "" {
+		id, err := newID()
+		if err != nil {
+			logging.Errorf(ctx, "Could not publish %s event: %v", e.Type, err)
+			return
+		}
+		e.ID = id
+	}
+	if e.Time.IsZero() {
+		e.Time = d.timeNow()
+	}
+	body, err := json.Marshal(&e)
+	if err != nil {
+		logging.Errorf(ctx, "Could not encode %s event %s: %v", e.Type, e.ID, err)
+		return
+	}
+	for i := range d.subs {
+		sub := &d.subs[i]
+		if !sub.wants(e.Type) {
+			continue
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			// Deliveries outlive the request that published the event.
+			d.deliverWithRetry(logging.Detach(ctx), sub, &e, body)
+		}()
+	}
+}
+
+// Wait waits for the deliveries in progress to finish.
+func (d *Dispatcher) Wait() {
+	if d != nil {
+		d.wg.Wait()
+	}
+}
+
+// Stop stops retrying deliveries, recording the events waiting to be retried
+// as dead letters instead, so that Wait does not have to wait for backoffs.
+func (d *Dispatcher) Stop() {
+	if d != nil {
+		d.stopOnce.Do(func() { close(d.stopped()) })
+	}
+}
+
+// stopped returns a channel that is closed once the dispatcher is stopped.
+func (d *Dispatcher) stopped() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop == nil {
+		d.stop = make(chan struct{})
+	}
+	return d.stop
+}
+
+func (d *Dispatcher) deliverWithRetry(ctx context.Context, sub *Subscription, e *Event, body []byte) {
+	attempts := d.MaxAttempts
+	if attempts == 0 {
+		attempts = defaultMaxAttempts
+	}
+	backoff := d.Backoff
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+	var err error
+	tried := 0
+retry:
+	for tried < attempts {
+		tried++
+		if err = d.deliver(ctx, sub, e.ID, body); err == nil {
+			logging.Infof(ctx, "Delivered %s event %s to %s.", e.Type, e.ID, sub.URL)
+			return
+		}
+		logging.Warningf(ctx, "Could not deliver %s event %s to %s (attempt %d of %d): %v", e.Type, e.ID, sub.URL, tried, attempts, err)
+		if tried == attempts {
+			break
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+			backoff *= 2
+		case <-d.stopped():
+			timer.Stop()
+			logging.Warningf(ctx, "Not retrying %s event %s to %s, since webhooks are stopping.", e.Type, e.ID, sub.URL)
+			break retry
+		}
+	}
+	if d.deadLetters == nil {
+		return
+	}
+	id, idErr := newID()
+	if idErr != nil {
+		logging.Errorf(ctx, "Could not record undelivered %s event %s: %v", e.Type, e.ID, idErr)
+		return
+	}
+	if err := d.deadLetters.Add(ctx, &db.DeadLetter{
+		ID:        id,
+		EventID:   e.ID,
+		EventType: e.Type,
+		URL:       sub.URL,
+		Payload:   string(body),
+		Attempts:  tried,
+		LastError: err.Error(),
+		Created:   d.timeNow(),
+	}); err != nil {
+		logging.Errorf(ctx, "Could not record undelivered %s event %s: %v", e.Type, e.ID, err)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, sub *Subscription, id string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create request: %v", err)
+	}
+	ts := strconv.FormatInt(d.timeNow().Unix(), 10)
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set(IDHeader, id)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, ts, body))
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("got status %s", res.Status)
+	}
+	return nil
+}
+
+// Replay delivers the dead letter with the specified ID again, once, and
+// records that it was replayed if delivery succeeds. It returns an error
+// wrapping db.ErrNotFound if there is no such dead letter.
+func (d *Dispatcher) Replay(ctx context.Context, id string) error {
+	if d == nil || d.deadLetters == nil {
+		return errors.New("webhooks are not configured")
+	}
+	dl, err := d.deadLetters.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if dl == nil {
+		return fmt.Errorf("could not replay dead letter %q: %w", id, db.ErrNotFound)
+	}
+	var sub *Subscription
+	for i := range d.subs {
+		if d.subs[i].URL == dl.URL {
+			sub = &d.subs[i]
+		}
+	}
+	if sub == nil {
+		return fmt.Errorf("no subscription for %s any more", dl.URL)
+	}
+	if err := d.deliver(ctx, sub, dl.EventID, []byte(dl.Payload)); err != nil {
+		return fmt.Errorf("could not deliver %s event %s to %s: %v", dl.EventType, dl.EventID, dl.URL, err)
+	}
+	logging.Infof(ctx, "Replayed %s event %s to %s.", dl.EventType, dl.EventID, dl.URL)
+	return d.deadLetters.MarkReplayed(ctx, id, d.timeNow())
+}
+
+func (d *Dispatcher) timeNow() time.Time {
+	if d.now != nil {
+		return d.now()
+	}
+	return time.Now()
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate ID: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wallaceicy06/webapp-enhance-faa-cifp/db"
+)
+
+type fakeDeadLetters struct {
+	mu      sync.Mutex
+	letters map[string]*db.DeadLetter
+}
+
+func (f *fakeDeadLetters) Add(_ context.Context, dl *db.DeadLetter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.letters == nil {
+		f.letters = make(map[string]*db.DeadLetter)
+	}
+	f.letters[dl.ID] = dl
+	return nil
+}
+
+func (f *fakeDeadLetters) Get(_ context.Context, id string) (*db.DeadLetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.letters[id], nil
+}
+
+func (f *fakeDeadLetters) MarkReplayed(_ context.Context, id string, at time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dl, ok := f.letters[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	dl.ReplayedAt = at
+	return nil
+}
+
+// subscriber is a fake webhook subscriber that rejects its first failures
+// deliveries and records the events it accepts after that.
+type subscriber struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	failures int
+	attempts int
+	events   []Event
+	badSigs  int
+}
+
+func newSubscriber(secret string, failures int) *subscriber {
+	s := &subscriber{secret: secret, failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.attempts++
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign(s.secret, r.Header.Get(TimestampHeader), body) {
+			s.badSigs++
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		if s.attempts <= s.failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil || e.ID != r.Header.Get(IDHeader) {
+			http.Error(w, "bad event", http.StatusBadRequest)
+			return
+		}
+		s.events = append(s.events, e)
+	}))
+	return s
+}
+
+func TestNewDispatcher(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		subs    []Subscription
+		wantErr bool
+	}{
+		{name: "Good", subs: []Subscription{{URL: "https://example.com/hook", Secret: "s", Events: []string{EventPublished}}}},
+		{name: "NoSubscriptions"},
+		{name: "RelativeURL", subs: []Subscription{{URL: "/hook", Secret: "s"}}, wantErr: true},
+		{name: "NoSecret", subs: []Subscription{{URL: "https://example.com/hook"}}, wantErr: true},
+		{name: "UnknownEvent", subs: []Subscription{{URL: "https://example.com/hook", Secret: "s", Events: []string{"cycle.deleted"}}}, wantErr: true},
+		{
+			name: "Duplicate",
+			subs: []Subscription{
+				{URL: "https://example.com/hook", Secret: "s"},
+				{URL: "https://example.com/hook", Secret: "t"},
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDispatcher(nil, tt.subs...)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("NewDispatcher() = _, %v want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	healthy := newSubscriber("healthy secret", 0)
+	defer healthy.Close()
+	flaky := newSubscriber("flaky secret", 2)
+	defer flaky.Close()
+	down := newSubscriber("down secret", 100)
+	defer down.Close()
+	failuresOnly := newSubscriber("failures secret", 0)
+	defer failuresOnly.Close()
+
+	dls := &fakeDeadLetters{}
+	d, err := NewDispatcher(dls,
+		Subscription{URL: healthy.URL, Secret: healthy.secret},
+		Subscription{URL: flaky.URL, Secret: flaky.secret},
+		Subscription{URL: down.URL, Secret: down.secret},
+		Subscription{URL: failuresOnly.URL, Secret: failuresOnly.secret, Events: []string{EventFailed}},
+	)
+	if err != nil {
+		t.Fatalf("NewDispatcher() = _, %v want _, <nil>", err)
+	}
+	d.MaxAttempts = 3
+	d.Backoff = time.Millisecond
+
+	d.Publish(context.Background(), Event{Type: EventPublished, Cycle: "06/18/2020", Processed: "processed/FAACIFP18_processed_06-18-2020"})
+	d.Wait()
+
+	for _, s := range []*subscriber{healthy, flaky} {
+		if len(s.events) != 1 || s.events[0].Cycle != "06/18/2020" || s.events[0].Type != EventPublished {
+			t.Errorf("subscriber received %+v want one published event", s.events)
+		}
+	}
+	if flaky.attempts != 3 {
+		t.Errorf("flaky subscriber got %d attempts want 3", flaky.attempts)
+	}
+	if len(failuresOnly.events) != 0 {
+		t.Errorf("subscriber to failures received %+v want none", failuresOnly.events)
+	}
+	if down.attempts != 3 {
+		t.Errorf("unavailable subscriber got %d attempts want 3", down.attempts)
+	}
+	for _, s := range []*subscriber{healthy, flaky, down} {
+		if s.badSigs != 0 {
+			t.Errorf("subscriber received %d badly signed events", s.badSigs)
+		}
+	}
+
+	if len(dls.letters) != 1 {
+		t.Fatalf("recorded %d dead letters want 1", len(dls.letters))
+	}
+	for _, dl := range dls.letters {
+		if dl.URL != down.URL || dl.EventType != EventPublished || dl.Attempts != 3 || dl.EventID != healthy.events[0].ID {
+			t.Errorf("dead letter = %+v want undelivered event %s for %s", dl, healthy.events[0].ID, down.URL)
+		}
+	}
+}
+
+func TestStopDuringBackoff(t *testing.T) {
+	down := newSubscriber("down secret", 100)
+	defer down.Close()
+	dls := &fakeDeadLetters{}
+	d, err := NewDispatcher(dls, Subscription{URL: down.URL, Secret: down.secret})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = _, %v want _, <nil>", err)
+	}
+	d.MaxAttempts = 3
+	d.Backoff = time.Hour
+
+	d.Publish(context.Background(), Event{Type: EventFailed, Reason: "FAA unavailable"})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		down.mu.Lock()
+		attempts := down.attempts
+		down.mu.Unlock()
+		if attempts > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("event was not delivered before stopping")
+		}
+	}
+	d.Stop()
+	stopped := make(chan struct{})
+	go func() {
+		d.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() did not return after Stop()")
+	}
+
+	if len(dls.letters) != 1 {
+		t.Fatalf("recorded %d dead letters want 1", len(dls.letters))
+	}
+	for _, dl := range dls.letters {
+		if dl.URL != down.URL || dl.EventType != EventFailed || dl.Attempts != 1 {
+			t.Errorf("dead letter = %+v want event for %s after 1 attempt", dl, down.URL)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	sub := newSubscriber("secret", 1)
+	defer sub.Close()
+
+	body := `{"id":"e1","type":"processing.failed","time":"2020-07-16T00:00:00Z","reason":"FAA unavailable"}`
+	dls := &fakeDeadLetters{letters: map[string]*db.DeadLetter{
+		"dl":   {ID: "dl", EventID: "e1", EventType: EventFailed, URL: sub.URL, Payload: body},
+		"gone": {ID: "gone", EventID: "e2", EventType: EventFailed, URL: "https://example.com/removed", Payload: body},
+	}}
+	d, err := NewDispatcher(dls, Subscription{URL: sub.URL, Secret: sub.secret})
+	if err != nil {
+		t.Fatalf("NewDispatcher() = _, %v want _, <nil>", err)
+	}
+	now := time.Date(2020, 7, 16, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := d.Replay(ctx, "dl"); err == nil {
+		t.Error("Replay() to failing subscriber = <nil> want <non-nil>")
+	}
+	if !dls.letters["dl"].ReplayedAt.IsZero() {
+		t.Error("failed replay marked dead letter replayed")
+	}
+	if err := d.Replay(ctx, "dl"); err != nil {
+		t.Fatalf("Replay() = %v want <nil>", err)
+	}
+	if got := dls.letters["dl"].ReplayedAt; !got.Equal(now) {
+		t.Errorf("dead letter replayed at %v want %v", got, now)
+	}
+	want := []Event{{ID: "e1", Type: EventFailed, Time: time.Date(2020, 7, 16, 0, 0, 0, 0, time.UTC), Reason: "FAA unavailable"}}
+	if diff := cmp.Diff(want, sub.events); diff != "" {
+		t.Errorf("replayed events diff (-want +got): %s", diff)
+	}
+
+	if err := d.Replay(ctx, "missing"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("Replay() of missing dead letter = %v want %v", err, db.ErrNotFound)
+	}
+	if err := d.Replay(ctx, "gone"); err == nil {
+		t.Error("Replay() to removed subscription = <nil> want <non-nil>")
+	}
+}